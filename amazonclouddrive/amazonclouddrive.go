@@ -28,6 +28,7 @@ import (
 	"github.com/ncw/rclone/oauthutil"
 	"github.com/ncw/rclone/pacer"
 	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
@@ -162,7 +163,7 @@ func NewFs(name, root string) (fs.Fs, error) {
 		name:         name,
 		root:         root,
 		c:            c,
-		pacer:        pacer.New().SetMinSleep(minSleep).SetPacer(pacer.AmazonCloudDrivePacer),
+		pacer:        pacer.New().SetMinSleep(minSleep).SetPacer(pacer.AmazonCloudDrivePacer).SetName("amazon cloud drive"),
 		noAuthClient: fs.Config.Client(),
 	}
 
@@ -297,7 +298,10 @@ type listAllFn func(*acd.Node) bool
 // Lists the directory required calling the user function on each item found
 //
 // If the user fn ever returns true then it early exits with found = true
-func (f *Fs) listAll(dirID string, title string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
+//
+// If ctx is cancelled it stops waiting for the pacer and returns the
+// error
+func (f *Fs) listAll(ctx context.Context, dirID string, title string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
 	query := "parents:" + dirID
 	if directoriesOnly {
 		query += " AND kind:" + folderKind
@@ -316,14 +320,12 @@ func (f *Fs) listAll(dirID string, title string, directoriesOnly bool, filesOnly
 OUTER:
 	for {
 		var resp *http.Response
-		err = f.pacer.Call(func() (bool, error) {
+		err = f.pacer.CallContext(ctx, func() (bool, error) {
 			nodes, resp, err = f.c.Nodes.GetNodes(&opts)
 			return shouldRetry(resp, err)
 		})
 		if err != nil {
-			fs.Stats.Error()
-			fs.ErrorLog(f, "Couldn't list files: %v", err)
-			break
+			return found, fmt.Errorf("couldn't list files: %v", err)
 		}
 		if nodes == nil {
 			break
@@ -354,7 +356,7 @@ func (f *Fs) listDirRecursive(dirID string, path string, out fs.ObjectsChan) err
 	var subError error
 	// Make the API request
 	var wg sync.WaitGroup
-	_, err := f.listAll(dirID, "", false, false, func(node *acd.Node) bool {
+	_, err := f.listAll(context.Background(), dirID, "", false, false, func(node *acd.Node) bool {
 		// Recurse on directories
 		switch *node.Kind {
 		case folderKind:
@@ -414,7 +416,7 @@ func (f *Fs) listDirNonRecursive(dirID string, path string, out fs.ObjectsChan)
 				var jobs []dirListJob
 				fs.Debug(f, "Reading %q", job.path)
 				// Make the API request
-				_, err := f.listAll(job.dirID, "", false, false, func(node *acd.Node) bool {
+				_, err := f.listAll(context.Background(), job.dirID, "", false, false, func(node *acd.Node) bool {
 					// Recurse on directories
 					switch *node.Kind {
 					case folderKind:
@@ -488,6 +490,51 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// with a single listing of its folder
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	err = f.dirCache.FindRoot(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	_, err = f.listAll(ctx, directoryID, "", false, false, func(node *acd.Node) bool {
+		remote := prefix + *node.Name
+		switch *node.Kind {
+		case folderKind:
+			// cache the ID so listing the directory doesn't look it up
+			f.dirCache.Put(remote, *node.Id)
+			d := &fs.Dir{
+				Name:  remote,
+				Bytes: -1,
+				Count: -1,
+			}
+			if node.ModifiedDate != nil {
+				d.When, _ = time.Parse(timeFormat, *node.ModifiedDate)
+			}
+			dirs = append(dirs, d)
+		case fileKind:
+			if o := f.newFsObjectWithInfo(remote, node); o != nil {
+				objects = append(objects, o)
+			}
+		default:
+			// ignore ASSET etc
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, dirs, nil
+}
+
 // ListDir lists the directories
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -498,7 +545,7 @@ func (f *Fs) ListDir() fs.DirChan {
 			fs.Stats.Error()
 			fs.ErrorLog(f, "Couldn't find root: %s", err)
 		} else {
-			_, err := f.listAll(f.dirCache.RootID(), "", true, false, func(item *acd.Node) bool {
+			_, err := f.listAll(context.Background(), f.dirCache.RootID(), "", true, false, func(item *acd.Node) bool {
 				dir := &fs.Dir{
 					Name:  *item.Name,
 					Bytes: -1,
@@ -523,6 +570,12 @@ func (f *Fs) ListDir() fs.DirChan {
 //
 // The new object may have been created if an error is returned
 func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but gives up waiting for the pacer if ctx is
+// cancelled.  The upload itself is aborted by the reader failing.
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	remote := src.Remote()
 	size := src.Size()
 	// Temporary Object under construction
@@ -540,7 +593,7 @@ func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	folder := acd.FolderFromId(directoryID, o.fs.c.Nodes)
 	var info *acd.File
 	var resp *http.Response
-	err = f.pacer.CallNoRetry(func() (bool, error) {
+	err = f.pacer.CallNoRetryContext(ctx, func() (bool, error) {
 		if src.Size() != 0 {
 			info, resp, err = folder.Put(in, leaf)
 		} else {
@@ -571,12 +624,22 @@ func (f *Fs) purgeCheck(check bool) error {
 	if err != nil {
 		return err
 	}
-	rootID := dc.RootID()
+	err = f.trashDir(dc.RootID(), check)
+	if err != nil {
+		return err
+	}
+
+	f.dirCache.ResetRoot()
+	return nil
+}
 
+// trashDir trashes the directory with directoryID, if check is set
+// then it refuses to do so if it has anything in
+func (f *Fs) trashDir(directoryID string, check bool) error {
 	if check {
 		// check directory is empty
 		empty := true
-		_, err = f.listAll(rootID, "", false, false, func(node *acd.Node) bool {
+		_, err := f.listAll(context.Background(), directoryID, "", false, false, func(node *acd.Node) bool {
 			switch *node.Kind {
 			case folderKind:
 				empty = false
@@ -597,28 +660,48 @@ func (f *Fs) purgeCheck(check bool) error {
 		}
 	}
 
-	node := acd.NodeFromId(rootID, f.c.Nodes)
-	var resp *http.Response
-	err = f.pacer.Call(func() (bool, error) {
-		resp, err = node.Trash()
+	node := acd.NodeFromId(directoryID, f.c.Nodes)
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := node.Trash()
 		return shouldRetry(resp, err)
 	})
-	if err != nil {
-		return err
-	}
+}
 
-	f.dirCache.ResetRoot()
+// Rmdir deletes the root folder
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir() error {
+	return f.purgeCheck(true)
+}
+
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	err := f.dirCache.FindRoot(true)
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = f.dirCache.FindDir(dir, true)
+	return err
 }
 
-// Rmdir deletes the root folder
+// RmdirPath removes the directory dir
 //
 // Returns an error if it isn't empty
-func (f *Fs) Rmdir() error {
-	return f.purgeCheck(true)
+func (f *Fs) RmdirPath(dir string) error {
+	err := f.dirCache.FindRoot(false)
+	if err != nil {
+		return err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return err
+	}
+	err = f.trashDir(directoryID, true)
+	if err != nil {
+		return err
+	}
+	f.dirCache.FlushDir(dir)
+	return nil
 }
 
 // Precision return the precision of this Fs
@@ -757,6 +840,19 @@ func (o *Object) Storable() bool {
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
+	return o.open(nil)
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	return o.open(map[string]string{
+		"Range": fs.RangeHeader(offset, length),
+	})
+}
+
+// open the object for read with the extra headers passed in
+func (o *Object) open(headers map[string]string) (in io.ReadCloser, err error) {
 	bigObject := o.Size() >= int64(tempLinkThreshold)
 	if bigObject {
 		fs.Debug(o, "Dowloading large object via tempLink")
@@ -765,9 +861,9 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	var resp *http.Response
 	err = o.fs.pacer.Call(func() (bool, error) {
 		if !bigObject {
-			in, resp, err = file.Open()
+			in, resp, err = file.OpenHeaders(headers)
 		} else {
-			in, resp, err = file.OpenTempURL(o.fs.noAuthClient)
+			in, resp, err = file.OpenTempURLHeaders(o.fs.noAuthClient, headers)
 		}
 		return shouldRetry(resp, err)
 	})
@@ -778,12 +874,19 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but gives up waiting for the pacer if
+// ctx is cancelled.  The upload itself is aborted by the reader
+// failing.
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) error {
 	size := src.Size()
 	file := acd.File{Node: o.info}
 	var info *acd.File
 	var resp *http.Response
 	var err error
-	err = o.fs.pacer.CallNoRetry(func() (bool, error) {
+	err = o.fs.pacer.CallNoRetryContext(ctx, func() (bool, error) {
 		if size != 0 {
 			info, resp, err = file.OverwriteSized(in, size)
 		} else {
@@ -811,10 +914,15 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = (*Fs)(nil)
-	_ fs.Purger = (*Fs)(nil)
+	_ fs.Fs            = (*Fs)(nil)
+	_ fs.Purger        = (*Fs)(nil)
+	_ fs.DirLister     = (*Fs)(nil)
+	_ fs.DirMaker      = (*Fs)(nil)
+	_ fs.ContextPutter = (*Fs)(nil)
 	//	_ fs.Copier   = (*Fs)(nil)
 	//	_ fs.Mover    = (*Fs)(nil)
 	//	_ fs.DirMover = (*Fs)(nil)
-	_ fs.Object = (*Object)(nil)
+	_ fs.Object         = (*Object)(nil)
+	_ fs.RangeOpener    = (*Object)(nil)
+	_ fs.ContextUpdater = (*Object)(nil)
 )
@@ -47,6 +47,7 @@ func TestObjectModTime(t *testing.T)         { fstests.TestObjectModTime(t) }
 func TestObjectSetModTime(t *testing.T)      { fstests.TestObjectSetModTime(t) }
 func TestObjectSize(t *testing.T)            { fstests.TestObjectSize(t) }
 func TestObjectOpen(t *testing.T)            { fstests.TestObjectOpen(t) }
+func TestObjectOpenRange(t *testing.T)       { fstests.TestObjectOpenRange(t) }
 func TestObjectUpdate(t *testing.T)          { fstests.TestObjectUpdate(t) }
 func TestObjectStorable(t *testing.T)        { fstests.TestObjectStorable(t) }
 func TestLimitedFs(t *testing.T)             { fstests.TestLimitedFs(t) }
@@ -27,6 +27,7 @@ import (
 	"github.com/ncw/rclone/b2/api"
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/rclone/rest"
+	"golang.org/x/net/context"
 )
 
 const (
@@ -35,6 +36,8 @@ const (
 	timeKey         = "src_last_modified_millis"
 	timeHeader      = headerPrefix + timeKey
 	sha1Header      = "X-Bz-Content-Sha1"
+	sha1AtEnd       = "hex_digits_at_end" // value of sha1Header if the SHA1 follows the data
+	sha1HexLength   = 40                  // length of a SHA1 in hex
 )
 
 // Register with Fs
@@ -267,11 +270,15 @@ var errEndList = errors.New("end list")
 // If prefix is set then startFileName is used as a prefix which all
 // files must have
 //
+// If delimited is set then only the files directly under prefix are
+// listed, and the folders under it are passed to fn with an Action
+// of "folder" and a name ending in "/"
+//
 // If limit is > 0 then it limits to that many files (must be less
 // than 1000)
 //
 // If hidden is set then it will list the hidden (deleted) files too.
-func (f *Fs) list(prefix string, limit int, hidden bool, fn listFn) error {
+func (f *Fs) list(ctx context.Context, prefix string, delimited bool, limit int, hidden bool, fn listFn) error {
 	bucketID, err := f.getBucketID()
 	if err != nil {
 		return err
@@ -288,6 +295,10 @@ func (f *Fs) list(prefix string, limit int, hidden bool, fn listFn) error {
 	if prefix != "" {
 		request.StartFileName = prefix
 	}
+	if delimited {
+		request.Prefix = prefix
+		request.Delimiter = "/"
+	}
 	var response api.ListFileNamesResponse
 	opts := rest.Opts{
 		Method: "POST",
@@ -297,7 +308,7 @@ func (f *Fs) list(prefix string, limit int, hidden bool, fn listFn) error {
 		opts.Path = "/b2_list_file_versions"
 	}
 	for {
-		_, err = f.srv.CallJSON(&opts, &request, &response)
+		_, err = f.srv.CallJSONContext(ctx, &opts, &request, &response)
 		if err != nil {
 			if err == errEndList {
 				return nil
@@ -339,7 +350,7 @@ func (f *Fs) List() fs.ObjectsChan {
 		// List the objects
 		go func() {
 			defer close(out)
-			err := f.list("", 0, false, func(remote string, object *api.File) error {
+			err := f.list(context.Background(), "", false, 0, false, func(remote string, object *api.File) error {
 				if o := f.newFsObjectWithInfo(remote, object); o != nil {
 					out <- o
 				}
@@ -354,6 +365,34 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// using a delimited listing of its prefix
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	if f.bucket == "" {
+		return nil, nil, errors.New("can't list objects at root - choose a bucket using lsd")
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	err = f.list(ctx, prefix, true, 0, false, func(remote string, object *api.File) error {
+		if object.Action == "folder" {
+			dirs = append(dirs, &fs.Dir{
+				Name:  strings.TrimSuffix(remote, "/"),
+				Bytes: -1,
+				Count: -1,
+			})
+		} else if o := f.newFsObjectWithInfo(remote, object); o != nil {
+			objects = append(objects, o)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't list bucket %q: %v", f.bucket, err)
+	}
+	return objects, dirs, nil
+}
+
 // listBucketFn is called from listBuckets to handle a bucket
 type listBucketFn func(*api.Bucket)
 
@@ -432,7 +471,7 @@ func (f *Fs) ListDir() fs.DirChan {
 		go func() {
 			defer close(out)
 			lastDir := ""
-			err := f.list("", 0, false, func(remote string, object *api.File) error {
+			err := f.list(context.Background(), "", false, 0, false, func(remote string, object *api.File) error {
 				slash := strings.IndexRune(remote, '/')
 				if slash < 0 {
 					return nil
@@ -464,12 +503,17 @@ func (f *Fs) ListDir() fs.DirChan {
 //
 // The new object may have been created if an error is returned
 func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but the upload is aborted if ctx is cancelled
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	// Temporary Object under construction
 	fs := &Object{
 		fs:     f,
 		remote: src.Remote(),
 	}
-	return fs, fs.Update(in, src)
+	return fs, fs.UpdateContext(ctx, in, src)
 }
 
 // Mkdir creates the bucket if it doesn't exist
@@ -580,7 +624,7 @@ func (f *Fs) Purge() error {
 			}
 		}()
 	}
-	checkErr(f.list("", 0, true, func(remote string, object *api.File) error {
+	checkErr(f.list(context.Background(), "", false, 0, true, func(remote string, object *api.File) error {
 		fs.Debug(remote, "Deleting (id %q)", object.ID)
 		toBeDeleted <- object
 		return nil
@@ -644,7 +688,7 @@ func (o *Object) readMetaData() (err error) {
 	if o.info.ID != "" {
 		return nil
 	}
-	err = o.fs.list(o.remote, 1, false, func(remote string, object *api.File) error {
+	err = o.fs.list(context.Background(), o.remote, false, 1, false, func(remote string, object *api.File) error {
 		if remote == o.remote {
 			o.info = *object
 		}
@@ -814,12 +858,37 @@ var _ io.ReadCloser = &openFile{}
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
+	resp, err := o.download(nil)
+	if err != nil {
+		return nil, err
+	}
+	return newOpenFile(o, resp), nil
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+//
+// The SHA1 can't be checked as only part of the object is read
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	resp, err := o.download(map[string]string{
+		"Range": fs.RangeHeader(offset, length),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// download starts a GET of the object with the extra headers passed
+// in, reading the metadata from the response
+func (o *Object) download(headers map[string]string) (resp *http.Response, err error) {
 	opts := rest.Opts{
-		Method:   "GET",
-		Absolute: true,
-		Path:     o.fs.info.DownloadURL + "/file/" + urlEncode(o.fs.bucket) + "/" + urlEncode(o.fs.root+o.remote),
+		Method:       "GET",
+		Absolute:     true,
+		Path:         o.fs.info.DownloadURL + "/file/" + urlEncode(o.fs.bucket) + "/" + urlEncode(o.fs.root+o.remote),
+		ExtraHeaders: headers,
 	}
-	resp, err := o.fs.srv.Call(&opts)
+	resp, err = o.fs.srv.Call(&opts)
 	if err != nil {
 		return nil, fmt.Errorf("Failed to open for download: %v", err)
 	}
@@ -835,7 +904,7 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	if o.sha1 == "" {
 		o.sha1 = resp.Header.Get(sha1Header)
 	}
-	return newOpenFile(o, resp), nil
+	return resp, nil
 }
 
 // dontEncode is the characters that do not need percent-encoding
@@ -873,18 +942,67 @@ func urlEncode(in string) string {
 	return out.String()
 }
 
+// sha1AtEndReader reads the input followed by its SHA1 in hex
+type sha1AtEndReader struct {
+	in   io.Reader
+	sha1 func() (string, error) // returns the SHA1 once in is read
+	tail io.Reader              // the SHA1 once in is read
+}
+
+// Read the input then the SHA1
+func (r *sha1AtEndReader) Read(p []byte) (n int, err error) {
+	if r.tail == nil {
+		n, err = r.in.Read(p)
+		if err != io.EOF {
+			return n, err
+		}
+		sha1, err := r.sha1()
+		if err != nil {
+			return n, err
+		}
+		if len(sha1) != sha1HexLength {
+			return n, fmt.Errorf("bad SHA1 %q", sha1)
+		}
+		r.tail = strings.NewReader(sha1)
+		if n > 0 {
+			return n, nil
+		}
+	}
+	return r.tail.Read(p)
+}
+
 // Update the object with the contents of the io.Reader, modTime and size
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but the upload is aborted if ctx is
+// cancelled
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (err error) {
 	size := src.Size()
+	contentLength := size
 	modTime := src.ModTime()
 	calculatedSha1, _ := src.Hash(fs.HashSHA1)
-
-	// If source cannot provide the hash, copy to a temporary file
-	// and calculate the hash while doing so.
-	// Then we serve the temporary file.
-	if calculatedSha1 == "" {
+	streamHasher, streaming := src.(fs.StreamHasher)
+	streaming = streaming && streamHasher.StreamHashes().Contains(fs.HashSHA1)
+
+	if calculatedSha1 == "" && streaming {
+		// If the SHA1 is being calculated as the input is read
+		// then send it after the data.
+		calculatedSha1 = sha1AtEnd
+		contentLength += sha1HexLength
+		in = &sha1AtEndReader{
+			in: in,
+			sha1: func() (string, error) {
+				return streamHasher.StreamHash(fs.HashSHA1)
+			},
+		}
+	} else if calculatedSha1 == "" {
+		// If source cannot provide the hash, copy to a temporary file
+		// and calculate the hash while doing so.
+		// Then we serve the temporary file.
 		// Open a temp file to copy the input
 		fd, err := ioutil.TempFile("", "rclone-b2-")
 		if err != nil {
@@ -988,10 +1106,10 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
 			sha1Header:       calculatedSha1,
 			timeHeader:       timeString(modTime),
 		},
-		ContentLength: &size,
+		ContentLength: &contentLength,
 	}
 	var response api.FileInfo
-	_, err = o.fs.srv.CallJSON(&opts, nil, &response)
+	_, err = o.fs.srv.CallJSONContext(ctx, &opts, nil, &response)
 	if err != nil {
 		return fmt.Errorf("Failed to upload: %v", err)
 	}
@@ -1000,7 +1118,13 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
 	o.info.Action = "upload"
 	o.info.Size = response.Size
 	o.info.UploadTimestamp = api.Timestamp(time.Now()) // FIXME not quite right
+	// Use the SHA1 B2 reports rather than the one sent so the
+	// transfer can be checked against it.  If it isn't reported
+	// then it is read from B2 when it is needed.
 	o.sha1 = response.SHA1
+	if len(o.sha1) != sha1HexLength {
+		o.sha1 = ""
+	}
 	return nil
 }
 
@@ -1028,7 +1152,11 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = &Fs{}
-	_ fs.Purger = &Fs{}
-	_ fs.Object = &Object{}
+	_ fs.Fs             = &Fs{}
+	_ fs.Purger         = &Fs{}
+	_ fs.ContextPutter  = &Fs{}
+	_ fs.DirLister      = &Fs{}
+	_ fs.Object         = &Object{}
+	_ fs.RangeOpener    = &Object{}
+	_ fs.ContextUpdater = &Object{}
 )
@@ -1,6 +1,11 @@
 package b2
 
 import (
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 	"testing"
 	"time"
 
@@ -166,3 +171,23 @@ func TestParseTimeString(t *testing.T) {
 	}
 
 }
+
+func TestSha1AtEndReader(t *testing.T) {
+	for _, content := range []string{"", "potato", strings.Repeat("carrot", 10000)} {
+		hash := sha1.New()
+		r := &sha1AtEndReader{
+			in: io.TeeReader(strings.NewReader(content), hash),
+			sha1: func() (string, error) {
+				return fmt.Sprintf("%x", hash.Sum(nil)), nil
+			},
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll failed: %v", err)
+		}
+		want := content + fmt.Sprintf("%x", sha1.Sum([]byte(content)))
+		if string(got) != want {
+			t.Errorf("want %q got %q", want, got)
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package crypt
+
+import (
+	"bytes"
+	"encoding/base32"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewNameEncryptionMode(t *testing.T) {
+	for _, test := range []struct {
+		in          string
+		expected    NameEncryptionMode
+		expectedErr string
+	}{
+		{"off", NameEncryptionOff, ""},
+		{"standard", NameEncryptionStandard, ""},
+		{"Standard", NameEncryptionStandard, ""},
+		{"potato", NameEncryptionOff, "Unknown file name encryption mode \"potato\""},
+	} {
+		actual, actualErr := NewNameEncryptionMode(test.in)
+		assert.Equal(t, actual, test.expected)
+		if test.expectedErr == "" {
+			assert.NoError(t, actualErr)
+		} else {
+			assert.Error(t, actualErr, test.expectedErr)
+		}
+	}
+}
+
+func TestNameEncryptionModeString(t *testing.T) {
+	assert.Equal(t, NameEncryptionOff.String(), "off")
+	assert.Equal(t, NameEncryptionStandard.String(), "standard")
+	assert.Equal(t, NameEncryptionMode(2).String(), "Unknown mode #2")
+}
+
+func TestEncodeFileName(t *testing.T) {
+	for _, test := range []struct {
+		in       string
+		expected string
+	}{
+		{"", ""},
+		{"1", "64"},
+		{"12", "64p0"},
+		{"123", "64p36"},
+		{"1234", "64p36d0"},
+		{"12345", "64p36d1l"},
+		{"123456", "64p36d1l6o"},
+	} {
+		actual := encodeFileName([]byte(test.in))
+		assert.Equal(t, actual, test.expected, test.in)
+		recovered, err := decodeFileName(test.expected)
+		assert.NoError(t, err)
+		assert.Equal(t, string(recovered), test.in, test.expected)
+	}
+}
+
+func TestDecodeFileName(t *testing.T) {
+	for _, test := range []struct {
+		in          string
+		expectedErr error
+	}{
+		{"64=", ErrorBadBase32Encoding},
+		{"!", base32.CorruptInputError(0)},
+		{"hello=hello", base32.CorruptInputError(5)},
+	} {
+		_, actualErr := decodeFileName(test.in)
+		assert.Equal(t, actualErr, test.expectedErr, test.in)
+	}
+}
+
+func TestPKCS7Pad(t *testing.T) {
+	for _, test := range []struct {
+		n        int
+		in       string
+		expected string
+	}{
+		{8, "", "\x08\x08\x08\x08\x08\x08\x08\x08"},
+		{8, "1", "1\x07\x07\x07\x07\x07\x07\x07"},
+		{8, "1234567", "1234567\x01"},
+		{8, "12345678", "12345678\x08\x08\x08\x08\x08\x08\x08\x08"},
+	} {
+		actual := pkcs7Pad(test.n, []byte(test.in))
+		assert.Equal(t, test.expected, string(actual), test.in)
+		recovered, err := pkcs7Unpad(test.n, actual)
+		assert.NoError(t, err)
+		assert.Equal(t, test.in, string(recovered), test.in)
+	}
+}
+
+func TestPKCS7Unpad(t *testing.T) {
+	for _, test := range []struct {
+		n   int
+		in  string
+		err error
+	}{
+		{8, "", ErrorBadPadding},
+		{8, "1234567", ErrorBadPadding},
+		{8, "1234567\x00", ErrorBadPadding},
+		{8, "1234567\x09", ErrorBadPadding},
+		{8, "123456\x01\x02", ErrorBadPadding},
+	} {
+		_, err := pkcs7Unpad(test.n, []byte(test.in))
+		assert.Equal(t, test.err, err, test.in)
+	}
+}
+
+func TestFileNameRoundTrip(t *testing.T) {
+	for _, mode := range []NameEncryptionMode{NameEncryptionOff, NameEncryptionStandard} {
+		c, err := newCipher(mode, "", "")
+		require.NoError(t, err)
+		for _, in := range []string{
+			"1",
+			"potato",
+			"potato/sausage",
+			"a directory/hello? sausage/êé/Hello, 世界/ \" ' @ < > & ?/z.txt",
+			strings.Repeat("a", 100),
+		} {
+			encrypted := c.EncryptFileName(in)
+			if mode == NameEncryptionStandard {
+				assert.NotEqual(t, in, encrypted)
+			}
+			decrypted, err := c.DecryptFileName(encrypted)
+			assert.NoError(t, err, in)
+			assert.Equal(t, in, decrypted)
+
+			encrypted = c.EncryptDirName(in)
+			decrypted, err = c.DecryptDirName(encrypted)
+			assert.NoError(t, err, in)
+			assert.Equal(t, in, decrypted)
+		}
+	}
+}
+
+func TestEncryptFileNameStandard(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	assert.Equal(t, "p0e52nreeaj0a5ea7s64m4j72s", c.EncryptFileName("1"))
+	assert.Equal(t, "p0e52nreeaj0a5ea7s64m4j72s/l42g6771hnv3an9cgc8cr2n1ng", c.EncryptFileName("1/12"))
+	assert.Equal(t, c.EncryptFileName("1/12"), c.EncryptDirName("1/12"))
+}
+
+func TestEncryptFileNameOff(t *testing.T) {
+	c, _ := newCipher(NameEncryptionOff, "", "")
+	assert.Equal(t, "1/12.bin", c.EncryptFileName("1/12"))
+	assert.Equal(t, "1/12", c.EncryptDirName("1/12"))
+	_, err := c.DecryptFileName("1/12")
+	assert.Equal(t, ErrorNotAnEncryptedFile, err)
+	_, err = c.DecryptFileName(".bin")
+	assert.Equal(t, ErrorNotAnEncryptedFile, err)
+}
+
+func TestDecryptFileNameBad(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	for _, test := range []struct {
+		in          string
+		expectedErr error
+	}{
+		{"64=", ErrorBadBase32Encoding},
+		{"!", base32.CorruptInputError(0)},
+		{"1", base32.CorruptInputError(1)},
+		{"64", ErrorNotAMultipleOfBlocksize},
+		{"p0e52nreeaj0a5ea7s64m4j72s/!", base32.CorruptInputError(0)},
+	} {
+		_, actualErr := c.DecryptFileName(test.in)
+		assert.Equal(t, test.expectedErr, actualErr, test.in)
+	}
+}
+
+func TestKeyDifferentPasswords(t *testing.T) {
+	c1, err := newCipher(NameEncryptionStandard, "potato", "")
+	require.NoError(t, err)
+	c2, err := newCipher(NameEncryptionStandard, "potato", "salt")
+	require.NoError(t, err)
+	c3, err := newCipher(NameEncryptionStandard, "sausage", "")
+	require.NoError(t, err)
+	assert.NotEqual(t, c1.dataKey, c2.dataKey)
+	assert.NotEqual(t, c1.dataKey, c3.dataKey)
+	assert.NotEqual(t, c1.EncryptFileName("potato"), c2.EncryptFileName("potato"))
+	assert.NotEqual(t, c1.EncryptFileName("potato"), c3.EncryptFileName("potato"))
+}
+
+func TestEncryptedSize(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	for _, test := range []struct {
+		in       int64
+		expected int64
+	}{
+		{0, 32},
+		{1, 32 + 16 + 1},
+		{65536, 32 + 16 + 65536},
+		{65537, 32 + 16 + 65536 + 16 + 1},
+		{1 << 20, 32 + 16*(16+65536)},
+	} {
+		actual := c.EncryptedSize(test.in)
+		assert.Equal(t, test.expected, actual, test.in)
+		recovered, err := c.DecryptedSize(test.expected)
+		assert.NoError(t, err, test.in)
+		assert.Equal(t, test.in, recovered, test.in)
+	}
+}
+
+func TestDecryptedSizeBad(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	for _, test := range []struct {
+		in          int64
+		expectedErr error
+	}{
+		{0, ErrorEncryptedFileTooShort},
+		{31, ErrorEncryptedFileTooShort},
+		{32 + 1, ErrorEncryptedFileBadHeader},
+		{32 + 16, ErrorEncryptedFileBadHeader},
+	} {
+		_, err := c.DecryptedSize(test.in)
+		assert.Equal(t, test.expectedErr, err, test.in)
+	}
+}
+
+func TestEncryptDecryptData(t *testing.T) {
+	c, err := newCipher(NameEncryptionStandard, "potato", "")
+	require.NoError(t, err)
+	for _, size := range []int{0, 1, 100, blockDataSize - 1, blockDataSize, blockDataSize + 1, 3*blockDataSize + 17} {
+		plaintext := make([]byte, size)
+		for i := range plaintext {
+			plaintext[i] = byte(i * 7)
+		}
+		in, err := c.EncryptData(bytes.NewBuffer(plaintext))
+		require.NoError(t, err)
+		ciphertext, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		assert.Equal(t, c.EncryptedSize(int64(size)), int64(len(ciphertext)), size)
+
+		out, err := c.DecryptData(ioutil.NopCloser(bytes.NewBuffer(ciphertext)))
+		require.NoError(t, err)
+		recovered, err := ioutil.ReadAll(out)
+		require.NoError(t, err)
+		require.NoError(t, out.Close())
+		assert.Equal(t, plaintext, recovered, size)
+	}
+}
+
+func TestEncryptDataDifferentNonce(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	encrypt := func() []byte {
+		in, err := c.EncryptData(bytes.NewBufferString("potato"))
+		require.NoError(t, err)
+		out, err := ioutil.ReadAll(in)
+		require.NoError(t, err)
+		return out
+	}
+	assert.NotEqual(t, encrypt(), encrypt())
+}
+
+func TestDecryptDataBad(t *testing.T) {
+	c, _ := newCipher(NameEncryptionStandard, "", "")
+	newDecrypter := func(in []byte) error {
+		_, err := c.DecryptData(ioutil.NopCloser(bytes.NewBuffer(in)))
+		return err
+	}
+	assert.Equal(t, ErrorEncryptedFileTooShort, newDecrypter([]byte("RCLONE")))
+	assert.Equal(t, ErrorEncryptedBadMagic, newDecrypter(make([]byte, fileHeaderSize)))
+
+	// Corrupt a byte of the data and check it fails to authenticate
+	in, err := c.EncryptData(bytes.NewBufferString("potato"))
+	require.NoError(t, err)
+	ciphertext, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	ciphertext[len(ciphertext)-1] ^= 1
+	out, err := c.DecryptData(ioutil.NopCloser(bytes.NewBuffer(ciphertext)))
+	require.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, out)
+	assert.Equal(t, ErrorEncryptedBadBlock, err)
+
+	// Truncated block header
+	out, err = c.DecryptData(ioutil.NopCloser(bytes.NewBuffer(ciphertext[:fileHeaderSize+1])))
+	require.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, out)
+	assert.Equal(t, ErrorEncryptedFileBadHeader, err)
+}
@@ -0,0 +1,430 @@
+// Package crypt provides wrappers for Fs and Object which implement encryption
+package crypt
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "crypt",
+		Description: "Encrypt/Decrypt a remote",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "remote",
+			Help: "Remote to encrypt/decrypt.",
+		}, {
+			Name: "filename_encryption",
+			Help: "How to encrypt the filenames.",
+			Examples: []fs.OptionExample{
+				{
+					Value: "off",
+					Help:  "Don't encrypt the file names.  Adds a \".bin\" extension only.",
+				}, {
+					Value: "standard",
+					Help:  "Encrypt the filenames see the docs for the details.",
+				},
+			},
+		}, {
+			Name:       "password",
+			Help:       "Password or pass phrase for encryption.",
+			IsPassword: true,
+		}, {
+			Name:       "password2",
+			Help:       "Password or pass phrase for salt. Optional but recommended.\nShould be different to the previous password.",
+			IsPassword: true,
+			Optional:   true,
+		}},
+	})
+}
+
+// NewFs contstructs an Fs from the path, container:path
+func NewFs(name, rpath string) (fs.Fs, error) {
+	mode, err := NewNameEncryptionMode(fs.ConfigFile.MustValue(name, "filename_encryption", "standard"))
+	if err != nil {
+		return nil, err
+	}
+	password := fs.ConfigFile.MustValue(name, "password", "")
+	if password == "" {
+		return nil, fmt.Errorf("password not set in config file")
+	}
+	password = fs.Reveal(password)
+	salt := fs.ConfigFile.MustValue(name, "password2", "")
+	if salt != "" {
+		salt = fs.Reveal(salt)
+	}
+	cipher, err := newCipher(mode, password, salt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make cipher: %v", err)
+	}
+	remote := fs.ConfigFile.MustValue(name, "remote")
+	var wrappedFs fs.Fs
+	if rpath != "" {
+		// Try the path as a file first - if it is one then the
+		// wrapped Fs will be a fs.Limited
+		wrappedFs, err = fs.NewFs(joinPath(remote, cipher.EncryptFileName(rpath)))
+		if err != nil {
+			return nil, err
+		}
+		if _, isLimited := wrappedFs.(*fs.Limited); !isLimited && mode == NameEncryptionOff {
+			// With no name encryption files and directories are
+			// named differently so look for the directory instead
+			wrappedFs = nil
+		}
+	}
+	if wrappedFs == nil {
+		wrappedFs, err = fs.NewFs(joinPath(remote, cipher.EncryptDirName(rpath)))
+		if err != nil {
+			return nil, err
+		}
+	}
+	f := &Fs{
+		Fs:     wrappedFs,
+		cipher: cipher,
+		mode:   mode,
+		name:   name,
+		root:   rpath,
+	}
+	return f, nil
+}
+
+// joinPath joins rpath onto the remote which may be either a bare
+// remote name "remote:" or a path
+func joinPath(remote, rpath string) string {
+	switch {
+	case rpath == "":
+		return remote
+	case strings.HasSuffix(remote, ":"):
+		return remote + rpath
+	}
+	return path.Join(remote, rpath)
+}
+
+// Fs represents a wrapped fs.Fs
+type Fs struct {
+	fs.Fs
+	cipher Cipher
+	mode   NameEncryptionMode
+	name   string
+	root   string
+}
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return fmt.Sprintf("Encrypted %s", f.Fs.String())
+}
+
+// Hashes returns the supported hash sets.
+//
+// The hashes of the wrapped remote are of the encrypted data so
+// there is no way of knowing the hash of the plain text.
+func (f *Fs) Hashes() fs.HashSet {
+	return fs.HashSet(fs.HashNone)
+}
+
+// List the Fs into a channel
+func (f *Fs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		for o := range f.Fs.List() {
+			remote := o.Remote()
+			decryptedRemote, err := f.cipher.DecryptFileName(remote)
+			if err != nil {
+				fs.Debug(remote, "Skipping undecryptable file name: %v", err)
+				continue
+			}
+			out <- f.newObject(o, decryptedRemote)
+		}
+	}()
+	return out
+}
+
+// ListDir lists the Fs directories/buckets/containers into a channel
+func (f *Fs) ListDir() fs.DirChan {
+	out := make(fs.DirChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		for dir := range f.Fs.ListDir() {
+			name, err := f.cipher.DecryptDirName(dir.Name)
+			if err != nil {
+				fs.Debug(dir.Name, "Skipping undecryptable dir name: %v", err)
+				continue
+			}
+			newDir := *dir
+			newDir.Name = name
+			out <- &newDir
+		}
+	}()
+	return out
+}
+
+// NewFsObject finds the Object at remote.  Returns nil if can't be found
+func (f *Fs) NewFsObject(remote string) fs.Object {
+	o := f.Fs.NewFsObject(f.cipher.EncryptFileName(remote))
+	if o == nil {
+		return nil
+	}
+	return f.newObject(o, remote)
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but the upload is aborted if ctx is cancelled
+// and the wrapped Fs can do that
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	wrappedIn, err := f.cipher.EncryptData(in)
+	if err != nil {
+		return nil, err
+	}
+	o, err := fs.PutContext(ctx, f.Fs, wrappedIn, f.newObjectInfo(src))
+	if o == nil {
+		return nil, err
+	}
+	return f.newObject(o, src.Remote()), err
+}
+
+// Purge all files in the root and the root directory
+//
+// Implement this if you have a way of deleting all the files
+// quicker than just running Remove() on the result of List()
+//
+// Return an error if it doesn't exist
+func (f *Fs) Purge() error {
+	do, ok := f.Fs.(fs.Purger)
+	if !ok {
+		return fs.ErrorCantPurge
+	}
+	return do.Purge()
+}
+
+// Copy src to this remote using server side copy operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(src fs.Object, remote string) (fs.Object, error) {
+	do, ok := f.Fs.(fs.Copier)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantCopy
+	}
+	oResult, err := do.Copy(o.Object, f.cipher.EncryptFileName(remote))
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult, remote), nil
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(src fs.Object, remote string) (fs.Object, error) {
+	do, ok := f.Fs.(fs.Mover)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	o, ok := src.(*Object)
+	if !ok {
+		return nil, fs.ErrorCantMove
+	}
+	oResult, err := do.Move(o.Object, f.cipher.EncryptFileName(remote))
+	if err != nil {
+		return nil, err
+	}
+	return f.newObject(oResult, remote), nil
+}
+
+// DirMove moves src to this remote using server side move
+// operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(src fs.Fs) error {
+	do, ok := f.Fs.(fs.DirMover)
+	if !ok {
+		return fs.ErrorCantDirMove
+	}
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debug(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	return do.DirMove(srcFs.Fs)
+}
+
+// UnWrap returns the Fs that this Fs is wrapping
+func (f *Fs) UnWrap() fs.Fs {
+	return f.Fs
+}
+
+// Object describes a wrapped for being read from the Fs
+//
+// This decrypts the remote name and decrypts the data
+type Object struct {
+	fs.Object
+	f      *Fs
+	remote string
+}
+
+func (f *Fs) newObject(o fs.Object, remote string) *Object {
+	return &Object{
+		Object: o,
+		f:      f,
+		remote: remote,
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *Object) Fs() fs.Info {
+	return o.f
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Size returns the size of the file
+func (o *Object) Size() int64 {
+	size, err := o.f.cipher.DecryptedSize(o.Object.Size())
+	if err != nil {
+		fs.Debug(o, "Bad size for decrypt: %v", err)
+	}
+	return size
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *Object) Hash(hash fs.HashType) (string, error) {
+	return "", fs.ErrHashUnsupported
+}
+
+// Open opens the file for read.  Call Close() on the returned io.ReadCloser
+func (o *Object) Open() (io.ReadCloser, error) {
+	in, err := o.Object.Open()
+	if err != nil {
+		return nil, err
+	}
+	return o.f.cipher.DecryptData(in)
+}
+
+// Update in to the object with the modTime given of the given size
+func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but the upload is aborted if ctx is
+// cancelled and the wrapped Object can do that
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) error {
+	wrappedIn, err := o.f.cipher.EncryptData(in)
+	if err != nil {
+		return err
+	}
+	return fs.UpdateContext(ctx, o.Object, wrappedIn, o.f.newObjectInfo(src))
+}
+
+// newObjectInfo makes a new ObjectInfo describing the encrypted
+// version of src for passing to the wrapped Fs
+func (f *Fs) newObjectInfo(src fs.ObjectInfo) *ObjectInfo {
+	return &ObjectInfo{
+		ObjectInfo: src,
+		f:          f,
+	}
+}
+
+// ObjectInfo describes a wrapped fs.ObjectInfo for being the source
+//
+// This encrypts the remote name and adjusts the size
+type ObjectInfo struct {
+	fs.ObjectInfo
+	f *Fs
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (o *ObjectInfo) Fs() fs.Info {
+	return o.f
+}
+
+// Remote returns the remote path
+func (o *ObjectInfo) Remote() string {
+	return o.f.cipher.EncryptFileName(o.ObjectInfo.Remote())
+}
+
+// Size returns the size of the file
+func (o *ObjectInfo) Size() int64 {
+	size := o.ObjectInfo.Size()
+	if size < 0 {
+		// size unknown
+		return size
+	}
+	return o.f.cipher.EncryptedSize(size)
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *ObjectInfo) Hash(hash fs.HashType) (string, error) {
+	return "", fs.ErrHashUnsupported
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs             = (*Fs)(nil)
+	_ fs.Purger         = (*Fs)(nil)
+	_ fs.Copier         = (*Fs)(nil)
+	_ fs.Mover          = (*Fs)(nil)
+	_ fs.DirMover       = (*Fs)(nil)
+	_ fs.UnWrapper      = (*Fs)(nil)
+	_ fs.ContextPutter  = (*Fs)(nil)
+	_ fs.ObjectInfo     = (*ObjectInfo)(nil)
+	_ fs.Object         = (*Object)(nil)
+	_ fs.ContextUpdater = (*Object)(nil)
+)
@@ -76,6 +76,19 @@ func (dc *DirCache) Flush() {
 	dc.cacheMu.Unlock()
 }
 
+// FlushDir removes the path dir and the paths inside it from the
+// cache, which should be done after the directory is removed
+func (dc *DirCache) FlushDir(dir string) {
+	dc.cacheMu.Lock()
+	for path, id := range dc.cache {
+		if path == dir || strings.HasPrefix(path, dir+"/") {
+			delete(dc.cache, path)
+			delete(dc.invCache, id)
+		}
+	}
+	dc.cacheMu.Unlock()
+}
+
 // SplitPath splits a path into directory, leaf
 //
 // Path shouldn't start or end with a /
@@ -16,6 +16,7 @@ import (
 	"sync"
 	"time"
 
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/drive/v2"
@@ -192,8 +193,10 @@ type listAllFn func(*drive.File) bool
 //
 // If the user fn ever returns true then it early exits with found = true
 //
+// If ctx is cancelled then it gives up with ctx.Err()
+//
 // Search params: https://developers.google.com/drive/search-parameters
-func (f *Fs) listAll(dirID string, title string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
+func (f *Fs) listAll(ctx context.Context, dirID string, title string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
 	query := fmt.Sprintf("trashed=false")
 	if dirID != "" {
 		query += fmt.Sprintf(" and '%s' in parents", dirID)
@@ -215,8 +218,8 @@ func (f *Fs) listAll(dirID string, title string, directoriesOnly bool, filesOnly
 OUTER:
 	for {
 		var files *drive.FileList
-		err = f.pacer.Call(func() (bool, error) {
-			files, err = list.Do()
+		err = f.pacer.CallContext(ctx, func() (bool, error) {
+			files, err = list.Context(ctx).Do()
 			return shouldRetry(err)
 		})
 		if err != nil {
@@ -291,7 +294,7 @@ func NewFs(name, path string) (fs.Fs, error) {
 	f := &Fs{
 		name:  name,
 		root:  root,
-		pacer: pacer.New().SetMinSleep(minSleep).SetMaxSleep(maxSleep).SetDecayConstant(decayConstant),
+		pacer: pacer.New().SetMinSleep(minSleep).SetMaxSleep(maxSleep).SetDecayConstant(decayConstant).SetName("drive"),
 	}
 
 	// Create a new authorized Drive client.
@@ -385,7 +388,7 @@ func (f *Fs) NewFsObject(remote string) fs.Object {
 // FindLeaf finds a directory of name leaf in the folder with ID pathID
 func (f *Fs) FindLeaf(pathID, leaf string) (pathIDOut string, found bool, err error) {
 	// Find the leaf in pathID
-	found, err = f.listAll(pathID, leaf, true, false, func(item *drive.File) bool {
+	found, err = f.listAll(context.Background(), pathID, leaf, true, false, func(item *drive.File) bool {
 		if item.Title == leaf {
 			pathIDOut = item.Id
 			return true
@@ -451,6 +454,33 @@ func (f *Fs) findExportFormat(filepath string, item *drive.File) (extension, lin
 	return "", ""
 }
 
+// newFsObjectFromItem makes an Object from a file found in a listing
+// returning nil if it isn't something which can be read
+func (f *Fs) newFsObjectFromItem(remote string, item *drive.File) fs.Object {
+	switch {
+	case item.Md5Checksum != "":
+		// If item has MD5 sum it is a file stored on drive
+		return f.newFsObjectWithInfo(remote, item)
+	case len(item.ExportLinks) != 0:
+		// If item has export links then it is a google doc
+		extension, link := f.findExportFormat(remote, item)
+		if extension == "" {
+			fs.Debug(remote, "No export formats found")
+			return nil
+		}
+		o := f.newFsObjectWithInfo(remote+"."+extension, item)
+		if o != nil {
+			obj := o.(*Object)
+			obj.isDocument = true
+			obj.url = link
+			obj.bytes = -1
+		}
+		return o
+	}
+	fs.Debug(remote, "Ignoring unknown object")
+	return nil
+}
+
 // Path should be directory path either "" or "path/"
 //
 // List the directory using a recursive list from the root
@@ -461,7 +491,7 @@ func (f *Fs) listDirRecursive(dirID string, path string, out fs.ObjectsChan) err
 	var subError error
 	// Make the API request
 	var wg sync.WaitGroup
-	_, err := f.listAll(dirID, "", false, false, func(item *drive.File) bool {
+	_, err := f.listAll(context.Background(), dirID, "", false, false, func(item *drive.File) bool {
 		filepath := path + item.Title
 		switch {
 		case *driveAuthOwnerOnly && !isAuthOwned(item):
@@ -480,27 +510,10 @@ func (f *Fs) listDirRecursive(dirID string, path string, out fs.ObjectsChan) err
 				}
 
 			}()
-		case item.Md5Checksum != "":
-			// If item has MD5 sum it is a file stored on drive
-			if o := f.newFsObjectWithInfo(filepath, item); o != nil {
+		default:
+			if o := f.newFsObjectFromItem(filepath, item); o != nil {
 				out <- o
 			}
-		case len(item.ExportLinks) != 0:
-			// If item has export links then it is a google doc
-			extension, link := f.findExportFormat(filepath, item)
-			if extension == "" {
-				fs.Debug(filepath, "No export formats found")
-			} else {
-				if o := f.newFsObjectWithInfo(filepath+"."+extension, item); o != nil {
-					obj := o.(*Object)
-					obj.isDocument = true
-					obj.url = link
-					obj.bytes = -1
-					out <- o
-				}
-			}
-		default:
-			fs.Debug(filepath, "Ignoring unknown object")
 		}
 		return false
 	})
@@ -535,6 +548,49 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// with a single listing of its folder
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	err = f.dirCache.FindRoot(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	_, err = f.listAll(ctx, directoryID, "", false, false, func(item *drive.File) bool {
+		remote := prefix + item.Title
+		switch {
+		case *driveAuthOwnerOnly && !isAuthOwned(item):
+			// ignore object or directory
+		case item.MimeType == driveFolderType:
+			// cache the ID so listing the directory doesn't look it up
+			f.dirCache.Put(remote, item.Id)
+			d := &fs.Dir{
+				Name:  remote,
+				Bytes: -1,
+				Count: -1,
+			}
+			d.When, _ = time.Parse(timeFormatIn, item.ModifiedDate)
+			dirs = append(dirs, d)
+		default:
+			if o := f.newFsObjectFromItem(remote, item); o != nil {
+				objects = append(objects, o)
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, dirs, nil
+}
+
 // ListDir walks the path returning a channel of directories
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -545,7 +601,7 @@ func (f *Fs) ListDir() fs.DirChan {
 			fs.Stats.Error()
 			fs.ErrorLog(f, "Couldn't find root: %s", err)
 		} else {
-			_, err := f.listAll(f.dirCache.RootID(), "", true, false, func(item *drive.File) bool {
+			_, err := f.listAll(context.Background(), f.dirCache.RootID(), "", true, false, func(item *drive.File) bool {
 				dir := &fs.Dir{
 					Name:  item.Title,
 					Bytes: -1,
@@ -602,6 +658,11 @@ func (f *Fs) createFileInfo(remote string, modTime time.Time, size int64) (*Obje
 //
 // The new object may have been created if an error is returned
 func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but the upload is aborted if ctx is cancelled
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	remote := src.Remote()
 	size := src.Size()
 	modTime := src.ModTime()
@@ -615,8 +676,8 @@ func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	if size == 0 || size < int64(driveUploadCutoff) {
 		// Make the API request to upload metadata and file data.
 		// Don't retry, return a retry error instead
-		err = f.pacer.CallNoRetry(func() (bool, error) {
-			info, err = f.svc.Files.Insert(createInfo).Media(in).Do()
+		err = f.pacer.CallNoRetryContext(ctx, func() (bool, error) {
+			info, err = f.svc.Files.Insert(createInfo).Media(in).Context(ctx).Do()
 			return shouldRetry(err)
 		})
 		if err != nil {
@@ -624,7 +685,7 @@ func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 		}
 	} else {
 		// Upload the file in chunks
-		info, err = f.Upload(in, size, createInfo.MimeType, createInfo, remote)
+		info, err = f.Upload(ctx, in, size, createInfo.MimeType, createInfo, remote, src)
 		if err != nil {
 			return o, err
 		}
@@ -638,6 +699,37 @@ func (f *Fs) Mkdir() error {
 	return f.dirCache.FindRoot(true)
 }
 
+// checkEmpty returns an error if the directory with directoryID
+// isn't empty
+func (f *Fs) checkEmpty(directoryID string) error {
+	var children *drive.ChildList
+	err := f.pacer.Call(func() (bool, error) {
+		var err error
+		children, err = f.svc.Children.List(directoryID).MaxResults(10).Do()
+		return shouldRetry(err)
+	})
+	if err != nil {
+		return err
+	}
+	if len(children.Items) > 0 {
+		return fmt.Errorf("Directory not empty: %#v", children.Items)
+	}
+	return nil
+}
+
+// deleteDir trashes or deletes the directory with directoryID
+func (f *Fs) deleteDir(directoryID string) error {
+	return f.pacer.Call(func() (bool, error) {
+		var err error
+		if *driveUseTrash {
+			_, err = f.svc.Files.Trash(directoryID).Do()
+		} else {
+			err = f.svc.Files.Delete(directoryID).Do()
+		}
+		return shouldRetry(err)
+	})
+}
+
 // Rmdir deletes the container
 //
 // Returns an error if it isn't empty
@@ -646,27 +738,13 @@ func (f *Fs) Rmdir() error {
 	if err != nil {
 		return err
 	}
-	var children *drive.ChildList
-	err = f.pacer.Call(func() (bool, error) {
-		children, err = f.svc.Children.List(f.dirCache.RootID()).MaxResults(10).Do()
-		return shouldRetry(err)
-	})
+	err = f.checkEmpty(f.dirCache.RootID())
 	if err != nil {
 		return err
 	}
-	if len(children.Items) > 0 {
-		return fmt.Errorf("Directory not empty: %#v", children.Items)
-	}
 	// Delete the directory if it isn't the root
 	if f.root != "" {
-		err = f.pacer.Call(func() (bool, error) {
-			if *driveUseTrash {
-				_, err = f.svc.Files.Trash(f.dirCache.RootID()).Do()
-			} else {
-				err = f.svc.Files.Delete(f.dirCache.RootID()).Do()
-			}
-			return shouldRetry(err)
-		})
+		err = f.deleteDir(f.dirCache.RootID())
 		if err != nil {
 			return err
 		}
@@ -675,6 +753,59 @@ func (f *Fs) Rmdir() error {
 	return nil
 }
 
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	err := f.dirCache.FindRoot(true)
+	if err != nil {
+		return err
+	}
+	_, err = f.dirCache.FindDir(dir, true)
+	return err
+}
+
+// RmdirPath removes the directory dir
+//
+// Returns an error if it isn't empty
+func (f *Fs) RmdirPath(dir string) error {
+	err := f.dirCache.FindRoot(false)
+	if err != nil {
+		return err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return err
+	}
+	err = f.checkEmpty(directoryID)
+	if err != nil {
+		return err
+	}
+	err = f.deleteDir(directoryID)
+	if err != nil {
+		return err
+	}
+	f.dirCache.FlushDir(dir)
+	return nil
+}
+
+// SetDirModTime sets the modification time of the directory dir
+func (f *Fs) SetDirModTime(dir string, modTime time.Time) error {
+	err := f.dirCache.FindRoot(false)
+	if err != nil {
+		return err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return err
+	}
+	updateInfo := &drive.File{
+		ModifiedDate: modTime.Format(timeFormatOut),
+	}
+	return f.pacer.Call(func() (bool, error) {
+		_, err := f.svc.Files.Update(directoryID, updateInfo).SetModifiedDate(true).Do()
+		return shouldRetry(err)
+	})
+}
+
 // Precision of the object storage system
 func (f *Fs) Precision() time.Duration {
 	return time.Millisecond
@@ -853,7 +984,7 @@ func (o *Object) Size() int64 {
 	if o.isDocument && o.bytes < 0 {
 		// If it is a google doc then we must HEAD it to see
 		// how big it is
-		res, err := o.httpResponse("HEAD")
+		res, err := o.httpResponse("HEAD", nil)
 		if err != nil {
 			fs.ErrorLog(o, "Error reading size: %v", err)
 			return 0
@@ -885,7 +1016,7 @@ func (o *Object) readMetaData() (err error) {
 		return err
 	}
 
-	found, err := o.fs.listAll(directoryID, leaf, false, true, func(item *drive.File) bool {
+	found, err := o.fs.listAll(context.Background(), directoryID, leaf, false, true, func(item *drive.File) bool {
 		if item.Title == leaf {
 			o.setMetaData(item)
 			return true
@@ -954,8 +1085,8 @@ func (o *Object) Storable() bool {
 }
 
 // httpResponse gets an http.Response object for the object o.url
-// using the method passed in
-func (o *Object) httpResponse(method string) (res *http.Response, err error) {
+// using the method and extra headers passed in
+func (o *Object) httpResponse(method string, headers map[string]string) (res *http.Response, err error) {
 	if o.url == "" {
 		return nil, fmt.Errorf("Forbidden to download - check sharing permission")
 	}
@@ -964,6 +1095,9 @@ func (o *Object) httpResponse(method string) (res *http.Response, err error) {
 		return nil, err
 	}
 	req.Header.Set("User-Agent", fs.UserAgent)
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
 	err = o.fs.pacer.Call(func() (bool, error) {
 		res, err = o.fs.client.Do(req)
 		return shouldRetry(err)
@@ -1007,7 +1141,7 @@ var _ io.ReadCloser = &openFile{}
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
-	res, err := o.httpResponse("GET")
+	res, err := o.httpResponse("GET", nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1025,12 +1159,38 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	return res.Body, nil
 }
 
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	// Exported documents can't be read in ranges
+	if o.isDocument {
+		return fs.OpenAndSkip(o, offset, length)
+	}
+	res, err := o.httpResponse("GET", map[string]string{
+		"Range": fs.RangeHeader(offset, length),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 206 {
+		_ = res.Body.Close() // ignore error
+		return nil, fmt.Errorf("Bad response: %d: %s", res.StatusCode, res.Status)
+	}
+	return res.Body, nil
+}
+
 // Update the already existing object
 //
 // Copy the reader into the object updating modTime and size
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but the upload is aborted if ctx is
+// cancelled
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) error {
 	size := src.Size()
 	modTime := src.ModTime()
 	if o.isDocument {
@@ -1046,8 +1206,8 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
 	var info *drive.File
 	if size == 0 || size < int64(driveUploadCutoff) {
 		// Don't retry, return a retry error instead
-		err = o.fs.pacer.CallNoRetry(func() (bool, error) {
-			info, err = o.fs.svc.Files.Update(updateInfo.Id, updateInfo).SetModifiedDate(true).Media(in).Do()
+		err = o.fs.pacer.CallNoRetryContext(ctx, func() (bool, error) {
+			info, err = o.fs.svc.Files.Update(updateInfo.Id, updateInfo).SetModifiedDate(true).Media(in).Context(ctx).Do()
 			return shouldRetry(err)
 		})
 		if err != nil {
@@ -1055,7 +1215,7 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
 		}
 	} else {
 		// Upload the file in chunks
-		info, err = o.fs.Upload(in, size, fs.MimeType(o), updateInfo, o.remote)
+		info, err = o.fs.Upload(ctx, in, size, fs.MimeType(o), updateInfo, o.remote, src)
 		if err != nil {
 			return err
 		}
@@ -1083,10 +1243,17 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs       = (*Fs)(nil)
-	_ fs.Purger   = (*Fs)(nil)
-	_ fs.Copier   = (*Fs)(nil)
-	_ fs.Mover    = (*Fs)(nil)
-	_ fs.DirMover = (*Fs)(nil)
-	_ fs.Object   = (*Object)(nil)
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.Mover            = (*Fs)(nil)
+	_ fs.DirMover         = (*Fs)(nil)
+	_ fs.DirMaker         = (*Fs)(nil)
+	_ fs.DirLister        = (*Fs)(nil)
+	_ fs.DirModTimeSetter = (*Fs)(nil)
+	_ fs.ContextPutter    = (*Fs)(nil)
+	_ fs.UploadAborter    = (*Fs)(nil)
+	_ fs.Object           = (*Object)(nil)
+	_ fs.RangeOpener      = (*Object)(nil)
+	_ fs.ContextUpdater   = (*Object)(nil)
 )
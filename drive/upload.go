@@ -21,6 +21,8 @@ import (
 	"strconv"
 
 	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
 	"google.golang.org/api/drive/v2"
 	"google.golang.org/api/googleapi"
 )
@@ -36,6 +38,7 @@ const (
 // resumableUpload is used by the generated APIs to provide resumable uploads.
 // It is not used by developers directly.
 type resumableUpload struct {
+	ctx    context.Context // aborts the upload if cancelled
 	f      *Fs
 	remote string
 	// URI is the resumable resource destination provided by the server after specifying "&uploadType=resumable".
@@ -48,15 +51,101 @@ type resumableUpload struct {
 	ContentLength int64
 	// Return value
 	ret *drive.File
+	// start is the offset to start uploading from
+	start int64
+	// state is saved as the upload progresses so it can be resumed
+	state *fs.UploadState
 }
 
 // Upload the io.Reader in of size bytes with contentType and info
-func (f *Fs) Upload(in io.Reader, size int64, contentType string, info *drive.File, remote string) (*drive.File, error) {
+//
+// The upload session is saved so that if the upload is interrupted it
+// can be resumed by a later upload of the same src, otherwise it is
+// cancelled if the upload fails
+//
+// The upload is aborted if ctx is cancelled
+func (f *Fs) Upload(ctx context.Context, in io.Reader, size int64, contentType string, info *drive.File, remote string, src fs.ObjectInfo) (_ *drive.File, err error) {
+	rx := &resumableUpload{
+		ctx:           ctx,
+		f:             f,
+		remote:        remote,
+		Media:         in,
+		MediaType:     contentType,
+		ContentLength: size,
+		state:         fs.NewUploadState(f, remote, src),
+	}
+
+	// Resume the saved upload session if there is one
+	if rx.state.Resuming() {
+		rx.URI = rx.state.Session
+		start, err := rx.transferStatus()
+		if err != nil {
+			fs.Log(remote, "Can't resume upload, starting again: %v", err)
+			rx.state.Reset()
+		} else {
+			// Skip the part of the file already uploaded
+			err = fs.Skip(in, start)
+			if err != nil {
+				return nil, err
+			}
+			rx.start = start
+		}
+	}
+	if !rx.state.Resuming() {
+		err := rx.startSession(info)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Cancel the session if something went wrong unless it can be
+	// resumed
+	defer func() {
+		if err != nil && !rx.state.Resumable() {
+			fs.Debug(remote, "Cancelling upload session")
+			cancelErr := f.AbortUpload(rx.state)
+			if cancelErr != nil {
+				fs.Log(remote, "Failed to cancel upload session: %v", cancelErr)
+			}
+			rx.state.Remove()
+		}
+	}()
+	return rx.Upload()
+}
+
+// AbortUpload cancels the resumable upload session in the saved state
+// s
+func (f *Fs) AbortUpload(s *fs.UploadState) error {
+	req, err := http.NewRequest("DELETE", s.Session, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", fs.UserAgent)
+	var res *http.Response
+	err = f.pacer.Call(func() (bool, error) {
+		res, err = f.client.Do(req)
+		if err == nil {
+			defer googleapi.CloseBody(res)
+			// A cancelled session returns 499 Client Closed Request
+			// and one which has gone 404 Not Found
+			if res.StatusCode == 499 || res.StatusCode == http.StatusNotFound {
+				return false, nil
+			}
+			err = googleapi.CheckResponse(res)
+		}
+		return shouldRetry(err)
+	})
+	return err
+}
+
+// startSession starts a new resumable upload session for info
+func (rx *resumableUpload) startSession(info *drive.File) error {
+	f := rx.f
 	fileID := info.Id
 	var body io.Reader
 	body, err := googleapi.WithoutDataWrapper.JSONReader(info)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	params := make(url.Values)
 	params.Set("alt", "json")
@@ -74,12 +163,12 @@ func (f *Fs) Upload(in io.Reader, size int64, contentType string, info *drive.Fi
 		"fileId": fileID,
 	})
 	req.Header.Set("Content-Type", "application/json; charset=UTF-8")
-	req.Header.Set("X-Upload-Content-Type", contentType)
-	req.Header.Set("X-Upload-Content-Length", fmt.Sprintf("%v", size))
+	req.Header.Set("X-Upload-Content-Type", rx.MediaType)
+	req.Header.Set("X-Upload-Content-Length", fmt.Sprintf("%v", rx.ContentLength))
 	req.Header.Set("User-Agent", fs.UserAgent)
 	var res *http.Response
-	err = f.pacer.Call(func() (bool, error) {
-		res, err = f.client.Do(req)
+	err = f.pacer.CallContext(rx.ctx, func() (bool, error) {
+		res, err = ctxhttp.Do(rx.ctx, f.client, req)
 		if err == nil {
 			defer googleapi.CloseBody(res)
 			err = googleapi.CheckResponse(res)
@@ -87,18 +176,22 @@ func (f *Fs) Upload(in io.Reader, size int64, contentType string, info *drive.Fi
 		return shouldRetry(err)
 	})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	loc := res.Header.Get("Location")
-	rx := &resumableUpload{
-		f:             f,
-		remote:        remote,
-		URI:           loc,
-		Media:         in,
-		MediaType:     contentType,
-		ContentLength: size,
+	rx.URI = res.Header.Get("Location")
+	rx.start = 0
+	rx.state.Session = rx.URI
+	rx.saveState()
+	return nil
+}
+
+// saveState saves the progress of the upload
+func (rx *resumableUpload) saveState() {
+	rx.state.Offset = rx.start
+	err := rx.state.Save()
+	if err != nil {
+		fs.Log(rx.remote, "Failed to save upload state: %v", err)
 	}
-	return rx.Upload()
 }
 
 // Make an http.Request for the range passed in
@@ -118,19 +211,23 @@ func (rx *resumableUpload) makeRequest(start int64, body []byte) *http.Request {
 
 // rangeRE matches the transfer status response from the server. $1 is
 // the last byte index uploaded.
-var rangeRE = regexp.MustCompile(`^0\-(\d+)$`)
+var rangeRE = regexp.MustCompile(`^(?:bytes=)?0\-(\d+)$`)
 
 // Query drive for the amount transferred so far
 //
-// If error is nil, then start should be valid
+// If error is nil, then start should be valid.  If the upload has
+// already finished then rx.ret is set too.
 func (rx *resumableUpload) transferStatus() (start int64, err error) {
 	req := rx.makeRequest(0, nil)
-	res, err := rx.f.client.Do(req)
+	res, err := ctxhttp.Do(rx.ctx, rx.f.client, req)
 	if err != nil {
 		return 0, err
 	}
 	defer googleapi.CloseBody(res)
 	if res.StatusCode == http.StatusCreated || res.StatusCode == http.StatusOK {
+		if err = json.NewDecoder(res.Body).Decode(&rx.ret); err != nil {
+			return 0, err
+		}
 		return rx.ContentLength, nil
 	}
 	if res.StatusCode != statusResumeIncomplete {
@@ -141,10 +238,14 @@ func (rx *resumableUpload) transferStatus() (start int64, err error) {
 		return 0, fmt.Errorf("unexpected http return code %v", res.StatusCode)
 	}
 	Range := res.Header.Get("Range")
+	if Range == "" {
+		// Nothing has been uploaded yet
+		return 0, nil
+	}
 	if m := rangeRE.FindStringSubmatch(Range); len(m) == 2 {
 		start, err = strconv.ParseInt(m[1], 10, 64)
 		if err == nil {
-			return start, nil
+			return start + 1, nil
 		}
 	}
 	return 0, fmt.Errorf("unable to parse range %q", Range)
@@ -153,7 +254,7 @@ func (rx *resumableUpload) transferStatus() (start int64, err error) {
 // Transfer a chunk - caller must call googleapi.CloseBody(res) if err == nil || res != nil
 func (rx *resumableUpload) transferChunk(start int64, body []byte) (int, error) {
 	req := rx.makeRequest(start, body)
-	res, err := rx.f.client.Do(req)
+	res, err := ctxhttp.Do(rx.ctx, rx.f.client, req)
 	if err != nil {
 		return 599, err
 	}
@@ -186,7 +287,7 @@ func (rx *resumableUpload) transferChunk(start int64, body []byte) (int, error)
 // Upload uploads the chunks from the input
 // It retries each chunk maxTries times (with a pause of uploadPause between attempts).
 func (rx *resumableUpload) Upload() (*drive.File, error) {
-	start := int64(0)
+	start := rx.start
 	buf := make([]byte, chunkSize)
 	var StatusCode int
 	for start < rx.ContentLength {
@@ -204,7 +305,7 @@ func (rx *resumableUpload) Upload() (*drive.File, error) {
 		}
 
 		// Transfer the chunk
-		err = rx.f.pacer.Call(func() (bool, error) {
+		err = rx.f.pacer.CallContext(rx.ctx, func() (bool, error) {
 			fs.Debug(rx.remote, "Sending chunk %d length %d", start, reqSize)
 			StatusCode, err = rx.transferChunk(start, buf)
 			again, err := shouldRetry(err)
@@ -219,6 +320,8 @@ func (rx *resumableUpload) Upload() (*drive.File, error) {
 		}
 
 		start += reqSize
+		rx.start = start
+		rx.saveState()
 	}
 	// Resume or retry uploads that fail due to connection interruptions or
 	// any 5xx errors, including:
@@ -243,5 +346,6 @@ func (rx *resumableUpload) Upload() (*drive.File, error) {
 	if rx.ret == nil {
 		return nil, fs.RetryErrorf("Incomplete upload - retry, last error %d", StatusCode)
 	}
+	rx.state.Remove()
 	return rx.ret, nil
 }
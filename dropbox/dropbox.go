@@ -23,6 +23,7 @@ import (
 	"github.com/ncw/rclone/oauthutil"
 	"github.com/spf13/pflag"
 	"github.com/stacktic/dropbox"
+	"golang.org/x/net/context"
 )
 
 // Constants
@@ -328,6 +329,38 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// with a single metadata call on it
+//
+// The names are made from dir and the last part of the paths returned
+// as dropbox may return the directories in the path in a different
+// case to the one they were made with.
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	dirPath, prefix := f.slashRoot, ""
+	if dir != "" {
+		dirPath, prefix = f.slashRootSlash+dir, dir+"/"
+	}
+	entry, err := f.db.Metadata(dirPath, true, false, "", "", metadataLimit)
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't list %q: %v", dirPath, err)
+	}
+	for i := range entry.Contents {
+		entry := &entry.Contents[i]
+		remote := prefix + path.Base(entry.Path)
+		if entry.IsDir {
+			dirs = append(dirs, &fs.Dir{
+				Name:  remote,
+				When:  time.Time(entry.ClientMtime),
+				Bytes: -1,
+				Count: -1,
+			})
+		} else {
+			objects = append(objects, f.newFsObjectWithInfo(remote, entry))
+		}
+	}
+	return objects, dirs, nil
+}
+
 // ListDir walks the path returning a channel of FsObjects
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -389,31 +422,56 @@ func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	return o, o.Update(in, src)
 }
 
-// Mkdir creates the container if it doesn't exist
-func (f *Fs) Mkdir() error {
-	entry, err := f.db.Metadata(f.slashRoot, false, false, "", "", metadataLimit)
+// mkdir creates the directory at dirPath if it doesn't exist
+func (f *Fs) mkdir(dirPath string) error {
+	entry, err := f.db.Metadata(dirPath, false, false, "", "", metadataLimit)
 	if err == nil {
 		if entry.IsDir {
 			return nil
 		}
-		return fmt.Errorf("%q already exists as file", f.root)
+		return fmt.Errorf("%q already exists as file", dirPath)
 	}
-	_, err = f.db.CreateFolder(f.slashRoot)
+	_, err = f.db.CreateFolder(dirPath)
 	return err
 }
 
-// Rmdir deletes the container
+// rmdir deletes the directory at dirPath
 //
 // Returns an error if it isn't empty
-func (f *Fs) Rmdir() error {
-	entry, err := f.db.Metadata(f.slashRoot, true, false, "", "", 16)
+func (f *Fs) rmdir(dirPath string) error {
+	entry, err := f.db.Metadata(dirPath, true, false, "", "", 16)
 	if err != nil {
 		return err
 	}
 	if len(entry.Contents) != 0 {
 		return errors.New("Directory not empty")
 	}
-	return f.Purge()
+	_, err = f.db.Delete(dirPath)
+	return err
+}
+
+// Mkdir creates the container if it doesn't exist
+func (f *Fs) Mkdir() error {
+	return f.mkdir(f.slashRoot)
+}
+
+// Rmdir deletes the container
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir() error {
+	return f.rmdir(f.slashRoot)
+}
+
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	return f.mkdir(f.slashRootSlash + dir)
+}
+
+// RmdirPath removes the directory dir
+//
+// Returns an error if it isn't empty
+func (f *Fs) RmdirPath(dir string) error {
+	return f.rmdir(f.slashRootSlash + dir)
 }
 
 // Precision returns the precision
@@ -679,10 +737,12 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs       = (*Fs)(nil)
-	_ fs.Copier   = (*Fs)(nil)
-	_ fs.Purger   = (*Fs)(nil)
-	_ fs.Mover    = (*Fs)(nil)
-	_ fs.DirMover = (*Fs)(nil)
-	_ fs.Object   = (*Object)(nil)
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.Copier    = (*Fs)(nil)
+	_ fs.Purger    = (*Fs)(nil)
+	_ fs.Mover     = (*Fs)(nil)
+	_ fs.DirMover  = (*Fs)(nil)
+	_ fs.DirLister = (*Fs)(nil)
+	_ fs.DirMaker  = (*Fs)(nil)
+	_ fs.Object    = (*Object)(nil)
 )
@@ -18,15 +18,77 @@ import (
 
 // Globals
 var (
-	Stats       = NewStats()
-	tokenBucket *tb.Bucket
+	Stats = NewStats()
+
+	// The token buckets are read locked while they are waited on so
+	// they can only be replaced when nothing is waiting on them
+	tokenBucketMu   sync.RWMutex
+	tokenBucket     *tb.Bucket // limits all transfers if the upload and download limits are the same
+	uploadBucket    *tb.Bucket // otherwise limits the uploads
+	downloadBucket  *tb.Bucket // and the downloads
+	currentBwLimit  BwPair     // the limits the buckets are set to
+	bwTimetableOnce sync.Once
 )
 
+// newTokenBucket makes a token bucket for limit or returns nil if
+// there is no limit
+func newTokenBucket(limit SizeSuffix) *tb.Bucket {
+	if limit <= 0 {
+		return nil
+	}
+	return tb.NewBucket(int64(limit), 100*time.Millisecond)
+}
+
+// setBwLimit replaces the token buckets with ones for limit
+func setBwLimit(limit BwPair) {
+	tokenBucketMu.Lock()
+	defer tokenBucketMu.Unlock()
+	for _, bucket := range []*tb.Bucket{tokenBucket, uploadBucket, downloadBucket} {
+		if bucket != nil {
+			_ = bucket.Close()
+		}
+	}
+	tokenBucket, uploadBucket, downloadBucket = nil, nil, nil
+	if limit.Tx == limit.Rx {
+		tokenBucket = newTokenBucket(limit.Tx)
+	} else {
+		uploadBucket = newTokenBucket(limit.Tx)
+		downloadBucket = newTokenBucket(limit.Rx)
+	}
+	currentBwLimit = limit
+}
+
 // Start the token bucket if necessary
+//
+// If the bandwidth limit changes through the day then the buckets
+// are checked against the timetable every minute and replaced when
+// it changes.
 func startTokenBucket() {
-	if bwLimit > 0 {
-		tokenBucket = tb.NewBucket(int64(bwLimit), 100*time.Millisecond)
-		Log(nil, "Starting bandwidth limiter at %vBytes/s", &bwLimit)
+	limit := bwLimit.LimitAt(time.Now())
+	if limit != (BwPair{}) {
+		Log(nil, "Starting bandwidth limiter at %vBytes/s", limit)
+	}
+	setBwLimit(limit)
+	if len(bwLimit) > 1 {
+		bwTimetableOnce.Do(func() {
+			go bwTimetableLoop()
+		})
+	}
+}
+
+// bwTimetableLoop changes the bandwidth limits as the timetable says
+func bwTimetableLoop() {
+	tick := time.NewTicker(time.Minute)
+	defer tick.Stop()
+	for now := range tick.C {
+		limit := bwLimit.LimitAt(now)
+		tokenBucketMu.RLock()
+		changed := limit != currentBwLimit
+		tokenBucketMu.RUnlock()
+		if changed {
+			Log(nil, "Changing bandwidth limit to %vBytes/s", limit)
+			setBwLimit(limit)
+		}
 	}
 }
 
@@ -145,6 +207,52 @@ Elapsed time:  %10v
 	return buf.String()
 }
 
+// StatsSnapshot is a copy of the StatsInfo at a point in time
+// suitable for encoding as JSON
+type StatsSnapshot struct {
+	Bytes        int64              `json:"bytes"`
+	Speed        float64            `json:"speed"` // average Bytes/s since start
+	Errors       int64              `json:"errors"`
+	Checks       int64              `json:"checks"`
+	Transfers    int64              `json:"transfers"`
+	ElapsedTime  float64            `json:"elapsedTime"` // seconds since start
+	Checking     []string           `json:"checking"`
+	Transferring []TransferSnapshot `json:"transferring"`
+}
+
+// Snapshot returns the current state of the StatsInfo including the
+// progress of each transfer
+func (s *StatsInfo) Snapshot() StatsSnapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	dt := time.Now().Sub(s.start)
+	snap := StatsSnapshot{
+		Bytes:        s.bytes,
+		Errors:       s.errors,
+		Checks:       s.checks,
+		Transfers:    s.transfers,
+		ElapsedTime:  dt.Seconds(),
+		Checking:     make([]string, 0, len(s.checking)),
+		Transferring: make([]TransferSnapshot, 0, len(s.transferring)),
+	}
+	if dt > 0 {
+		snap.Speed = float64(s.bytes) / dt.Seconds()
+	}
+	for name := range s.checking {
+		snap.Checking = append(snap.Checking, name)
+	}
+	sort.Strings(snap.Checking)
+	for name := range s.transferring {
+		if acc := s.inProgress.get(name); acc != nil {
+			snap.Transferring = append(snap.Transferring, acc.Snapshot())
+		} else {
+			snap.Transferring = append(snap.Transferring, TransferSnapshot{Name: name})
+		}
+	}
+	sort.Sort(transferSnapshots(snap.Transferring))
+	return snap
+}
+
 // Log outputs the StatsInfo to the log
 func (s *StatsInfo) Log() {
 	log.Printf("%v\n", s)
@@ -173,8 +281,8 @@ func (s *StatsInfo) GetErrors() int64 {
 
 // ResetCounters sets the counters (bytes, checks, errors, transfers) to 0
 func (s *StatsInfo) ResetCounters() {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.bytes = 0
 	s.errors = 0
 	s.checks = 0
@@ -183,8 +291,8 @@ func (s *StatsInfo) ResetCounters() {
 
 // ResetErrors sets the errors count to 0
 func (s *StatsInfo) ResetErrors() {
-	s.lock.RLock()
-	defer s.lock.RUnlock()
+	s.lock.Lock()
+	defer s.lock.Unlock()
 	s.errors = 0
 }
 
@@ -258,6 +366,8 @@ type Account struct {
 	avg     ewma.MovingAverage // Moving average of last few measurements
 	closed  bool               // set if the file is closed
 	exit    chan struct{}      // channel that will be closed when transfer is finished
+	tx      bool               // set if the upload limit applies
+	rx      bool               // set if the download limit applies
 }
 
 // NewAccount makes a Account reader for an object
@@ -269,6 +379,8 @@ func NewAccount(in io.ReadCloser, obj Object) *Account {
 		exit:   make(chan struct{}),
 		avg:    ewma.NewMovingAverage(),
 		lpTime: time.Now(),
+		tx:     true,
+		rx:     true,
 	}
 	go acc.averageLoop()
 	Stats.inProgress.set(acc.name, acc)
@@ -319,10 +431,50 @@ func (file *Account) Read(p []byte) (n int, err error) {
 	Stats.Bytes(int64(n))
 
 	// Limit the transfer speed if required
-	if tokenBucket != nil {
+	file.waitBandwidth(n)
+	return
+}
+
+// isLocalDisk returns true if f or the Fs it wraps stores its objects
+// on a local disk
+func isLocalDisk(f Info) bool {
+	for {
+		if local, ok := f.(LocalDisk); ok {
+			return local.LocalDisk()
+		}
+		unwrap, ok := f.(UnWrapper)
+		if !ok {
+			return false
+		}
+		f = unwrap.UnWrap()
+	}
+}
+
+// limitBandwidth sets which bandwidth limits apply to a transfer from
+// fsrc to fdst.  Reading from a remote is a download and writing to a
+// remote is an upload, so a transfer between remotes is both and
+// one between local disks is neither and isn't limited at all.
+func (file *Account) limitBandwidth(fsrc, fdst Info) {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	file.rx = !isLocalDisk(fsrc)
+	file.tx = !isLocalDisk(fdst)
+}
+
+// waitBandwidth waits until n bytes can be transferred within the
+// bandwidth limits.  Call with file.mu held.
+func (file *Account) waitBandwidth(n int) {
+	tokenBucketMu.RLock()
+	defer tokenBucketMu.RUnlock()
+	if tokenBucket != nil && (file.tx || file.rx) {
 		tokenBucket.Wait(int64(n))
 	}
-	return
+	if file.tx && uploadBucket != nil {
+		uploadBucket.Wait(int64(n))
+	}
+	if file.rx && downloadBucket != nil {
+		downloadBucket.Wait(int64(n))
+	}
 }
 
 // Progress returns bytes read as well as the size.
@@ -407,6 +559,56 @@ func (file *Account) String() string {
 	return fmt.Sprintf("%45s: %2d%% done. avg: %6.1f, cur: %6.1f kByte/s. ETA: %s", string(name), int(100*float64(a)/float64(b)), avg/1024, cur/1024, etas)
 }
 
+// TransferSnapshot is a copy of the progress of an Account at a
+// point in time suitable for encoding as JSON
+type TransferSnapshot struct {
+	Name       string  `json:"name"`
+	Size       int64   `json:"size"` // <= 0 if unknown
+	Bytes      int64   `json:"bytes"`
+	Percentage int     `json:"percentage"`
+	Speed      float64 `json:"speed"`    // average Bytes/s since start
+	SpeedAvg   float64 `json:"speedAvg"` // moving average of Bytes/s
+	ETA        *int64  `json:"eta"`      // seconds or nil if unknown
+}
+
+// transferSnapshots implements sort.Interface sorting by Name
+type transferSnapshots []TransferSnapshot
+
+func (ts transferSnapshots) Len() int           { return len(ts) }
+func (ts transferSnapshots) Swap(i, j int)      { ts[i], ts[j] = ts[j], ts[i] }
+func (ts transferSnapshots) Less(i, j int) bool { return ts[i].Name < ts[j].Name }
+
+// Snapshot returns the current progress of this transfer
+func (file *Account) Snapshot() TransferSnapshot {
+	bytes, size := file.Progress()
+	speed, speedAvg := file.Speed()
+	snap := TransferSnapshot{
+		Name:     file.name,
+		Size:     size,
+		Bytes:    bytes,
+		Speed:    speed,
+		SpeedAvg: speedAvg,
+	}
+	if size > 0 {
+		snap.Percentage = int(100 * float64(bytes) / float64(size))
+	}
+	if eta, ok := file.ETA(); ok {
+		seconds := int64(eta / time.Second)
+		snap.ETA = &seconds
+	}
+	return snap
+}
+
+// setReader replaces the reader with in, closing the old one.  This
+// skips data without counting it.
+func (file *Account) setReader(in io.ReadCloser) error {
+	file.mu.Lock()
+	defer file.mu.Unlock()
+	err := file.in.Close()
+	file.in = in
+	return err
+}
+
 // Close the object
 func (file *Account) Close() error {
 	file.mu.Lock()
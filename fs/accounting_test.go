@@ -0,0 +1,47 @@
+package fs
+
+import (
+	"testing"
+)
+
+// testFs is an Fs which isn't on a local disk
+type testFs struct {
+	Fs
+}
+
+// testLocalFs is an Fs on a local disk, named like a config remote
+type testLocalFs struct {
+	Fs
+}
+
+func (f testLocalFs) Name() string    { return "mydisk" }
+func (f testLocalFs) LocalDisk() bool { return true }
+
+// testWrapFs wraps another Fs
+type testWrapFs struct {
+	Fs
+	wrapped Fs
+}
+
+func (f testWrapFs) UnWrap() Fs { return f.wrapped }
+
+func TestLimitBandwidth(t *testing.T) {
+	remote, local := testFs{}, testLocalFs{}
+	for i, test := range []struct {
+		fsrc, fdst Info
+		rx, tx     bool
+	}{
+		{remote, remote, true, true},
+		{local, remote, false, true},
+		{remote, local, true, false},
+		{local, local, false, false},
+		{testWrapFs{wrapped: local}, remote, false, true},
+		{testWrapFs{wrapped: remote}, local, true, false},
+	} {
+		acc := &Account{}
+		acc.limitBandwidth(test.fsrc, test.fdst)
+		if acc.rx != test.rx || acc.tx != test.tx {
+			t.Errorf("%d: want rx=%v tx=%v got rx=%v tx=%v", i, test.rx, test.tx, acc.rx, acc.tx)
+		}
+	}
+}
@@ -0,0 +1,445 @@
+// Bidirectional sync using listing snapshots
+
+package fs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// Values for --bisync-conflict
+const (
+	BisyncNewer    = "newer"     // the most recently modified file wins
+	BisyncKeepBoth = "keep-both" // both files are kept under new names
+)
+
+// bisyncEntry is a file in a bisync snapshot
+type bisyncEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// bisyncSnapshot is the listing of both sides saved after a
+// successful bisync
+type bisyncSnapshot struct {
+	Path1  string                 `json:"path1"`
+	Path2  string                 `json:"path2"`
+	Files1 map[string]bisyncEntry `json:"files1"`
+	Files2 map[string]bisyncEntry `json:"files2"`
+}
+
+// bisyncChange is how a file has changed on one side since the
+// snapshot
+type bisyncChange int
+
+// bisyncChange values
+const (
+	bisyncAbsent    bisyncChange = iota // not there now or in the snapshot
+	bisyncUnchanged                     // the same as in the snapshot
+	bisyncNew                           // not in the snapshot
+	bisyncModified                      // different to the snapshot
+	bisyncDeleted                       // in the snapshot but not there now
+)
+
+// bisyncSide is one side of a bisync
+type bisyncSide struct {
+	f     Fs
+	files map[string]Object      // the files there now
+	prev  map[string]bisyncEntry // the files in the snapshot
+}
+
+// bisyncRemote returns the remote:path of f
+func bisyncRemote(f Fs) string {
+	return f.Name() + ":" + f.Root()
+}
+
+// BisyncStatePath returns the path of the file holding the snapshot
+// for bisyncing f1 and f2
+func BisyncStatePath(f1, f2 Fs) string {
+	sum := md5.Sum([]byte(bisyncRemote(f1) + "\x00" + bisyncRemote(f2)))
+	return filepath.Join(Config.BisyncStateDir, hex.EncodeToString(sum[:])+".json")
+}
+
+// loadBisyncSnapshot reads the snapshot from statePath returning nil
+// if there isn't one
+func loadBisyncSnapshot(statePath string) (*bisyncSnapshot, error) {
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bisync state: %v", err)
+	}
+	snap := new(bisyncSnapshot)
+	err = json.Unmarshal(data, snap)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode bisync state %q: %v", statePath, err)
+	}
+	return snap, nil
+}
+
+// save writes the snapshot to statePath, replacing the old one
+// atomically
+func (snap *bisyncSnapshot) save(statePath string) error {
+	data, err := json.MarshalIndent(snap, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode bisync state: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(statePath), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make bisync state directory: %v", err)
+	}
+	tmpPath := statePath + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write bisync state: %v", err)
+	}
+	err = os.Rename(tmpPath, statePath)
+	if err != nil {
+		return fmt.Errorf("failed to write bisync state: %v", err)
+	}
+	return nil
+}
+
+// newBisyncEntry makes the snapshot entry for o
+func newBisyncEntry(o ObjectInfo) bisyncEntry {
+	return bisyncEntry{
+		Size:    o.Size(),
+		ModTime: o.ModTime(),
+	}
+}
+
+// bisyncEntries makes the snapshot entries for files
+func bisyncEntries(files map[string]Object) map[string]bisyncEntry {
+	entries := make(map[string]bisyncEntry, len(files))
+	for remote, o := range files {
+		entries[remote] = newBisyncEntry(o)
+	}
+	return entries
+}
+
+// bisyncKept is a conflict resolved by keeping the files from both
+// sides under new names
+type bisyncKept struct {
+	o1 Object // the file from side 1
+	o2 Object // the file from side 2
+}
+
+// entries makes the snapshot entries for the side after the
+// transfers in to have been copied to it, the files in deleted
+// removed from it and the conflicts kept under both names
+func (s *bisyncSide) entries(to []ObjectPair, deleted []Object, kept []bisyncKept) map[string]bisyncEntry {
+	entries := bisyncEntries(s.files)
+	for _, pair := range to {
+		entries[pair.src.Remote()] = newBisyncEntry(pair.src)
+	}
+	for _, o := range deleted {
+		delete(entries, o.Remote())
+	}
+	for _, k := range kept {
+		remote := k.o1.Remote()
+		delete(entries, remote)
+		entries[bisyncConflictName(remote, 1)] = newBisyncEntry(k.o1)
+		entries[bisyncConflictName(remote, 2)] = newBisyncEntry(k.o2)
+	}
+	return entries
+}
+
+// bisyncList reads the files in f which pass the filters
+func bisyncList(f Fs) (map[string]Object, error) {
+	var mu sync.Mutex
+	files := make(map[string]Object)
+	err := ListFn(f, func(o Object) {
+		mu.Lock()
+		files[o.Remote()] = o
+		mu.Unlock()
+	})
+	return files, err
+}
+
+// change returns how remote has changed since the snapshot
+func (s *bisyncSide) change(remote string) bisyncChange {
+	entry, inPrev := s.prev[remote]
+	o, inNow := s.files[remote]
+	switch {
+	case !inPrev && !inNow:
+		return bisyncAbsent
+	case !inPrev:
+		return bisyncNew
+	case !inNow:
+		return bisyncDeleted
+	}
+	if o.Size() != entry.Size {
+		return bisyncModified
+	}
+	if precision := s.f.Precision(); precision != ModTimeNotSupported {
+		dt := o.ModTime().Sub(entry.ModTime)
+		if dt > precision || dt < -precision {
+			return bisyncModified
+		}
+	}
+	return bisyncUnchanged
+}
+
+// changed returns whether c means the file was added or modified
+func (c bisyncChange) changed() bool {
+	return c == bisyncNew || c == bisyncModified
+}
+
+// bisyncConflictName returns the name a conflicting file from side n
+// is kept under, eg "dir/file.conflict1.txt"
+func bisyncConflictName(remote string, n int) string {
+	ext := path.Ext(remote)
+	return fmt.Sprintf("%s.conflict%d%s", strings.TrimSuffix(remote, ext), n, ext)
+}
+
+// keepBoth resolves a conflict by renaming the file on each side to
+// its conflict name and copying each to the other side
+func keepBoth(ctx context.Context, s1, s2 *bisyncSide, remote string) {
+	o1, o2 := s1.files[remote], s2.files[remote]
+	name1, name2 := bisyncConflictName(remote, 1), bisyncConflictName(remote, 2)
+	Log(o1, "Changed on both sides - keeping both as %q and %q", name1, name2)
+	if Config.DryRun {
+		Log(o1, "Not renaming as --dry-run")
+		return
+	}
+	for _, c := range []struct {
+		o      Object
+		f      Fs
+		fOther Fs
+		name   string
+	}{
+		{o1, s1.f, s2.f, name1},
+		{o2, s2.f, s1.f, name2},
+	} {
+		Stats.Transferring(c.o)
+		Copy(ctx, c.fOther, nil, &renamedObject{Object: c.o, remote: c.name})
+		Stats.DoneTransferring(c.o)
+		err := moveObject(ctx, c.f, c.o, c.name)
+		if err != nil {
+			Stats.Error()
+			ErrorLog(c.o, "Couldn't rename to %q: %v", c.name, err)
+		}
+	}
+}
+
+// Bisync makes the files in f1 and f2 the same by copying new and
+// modified files and deleting deleted files in both directions.
+//
+// The changes are found by comparing the files with the snapshot of
+// both sides saved after the last successful run.  If there isn't
+// one then nothing is deleted and the files on each side are copied
+// to the other.  If a file was changed on both sides then it is
+// resolved with Config.BisyncConflict.  A file changed on one side
+// and deleted on the other is copied.
+//
+// The snapshot is made from the listings taken at the start with the
+// changes made applied, so changes made while it runs are found next
+// time.  It isn't updated if there were errors or a limit was
+// reached, in which case an error is returned and the next run will
+// try the same changes again.
+//
+// If ctx is cancelled then no new transfers or deletions are
+// started, transfers in progress are aborted and ctx.Err() returned.
+func Bisync(ctx context.Context, f1, f2 Fs) error {
+	if Same(f1, f2) {
+		ErrorLog(f1, "Nothing to do as the paths are the same")
+		return nil
+	}
+	for _, f := range []Fs{f1, f2} {
+		err := f.Mkdir()
+		if err != nil {
+			Stats.Error()
+			return err
+		}
+	}
+
+	statePath := BisyncStatePath(f1, f2)
+	snap, err := loadBisyncSnapshot(statePath)
+	if err != nil {
+		Stats.Error()
+		return err
+	}
+	if snap == nil {
+		Log(nil, "No bisync state found in %q - merging both sides without deleting", statePath)
+		snap = &bisyncSnapshot{}
+	}
+
+	Log(nil, "Building file lists")
+	s1 := &bisyncSide{f: f1, prev: snap.Files1}
+	s2 := &bisyncSide{f: f2, prev: snap.Files2}
+	var listWg sync.WaitGroup
+	var listErr1, listErr2 error
+	listWg.Add(2)
+	go func() {
+		defer listWg.Done()
+		s1.files, listErr1 = bisyncList(f1)
+	}()
+	go func() {
+		defer listWg.Done()
+		s2.files, listErr2 = bisyncList(f2)
+	}()
+	listWg.Wait()
+	for _, err := range []error{listErr1, listErr2} {
+		if err != nil {
+			Stats.Error()
+			return err
+		}
+	}
+	if Stats.Errored() {
+		return fmt.Errorf("not syncing as there were errors reading the file lists")
+	}
+	for _, s := range []*bisyncSide{s1, s2} {
+		if len(s.files) == 0 && len(s.prev) != 0 {
+			Stats.Error()
+			return fmt.Errorf("all files were deleted from %v - refusing to delete them from the other side - remove %q to start again", s.f, statePath)
+		}
+	}
+
+	// Work out what to do with each file
+	remotes := make(map[string]struct{})
+	for _, s := range []*bisyncSide{s1, s2} {
+		for remote := range s.files {
+			remotes[remote] = struct{}{}
+		}
+		for remote := range s.prev {
+			remotes[remote] = struct{}{}
+		}
+	}
+	var to1, to2 []ObjectPair
+	var delete1, delete2 []Object
+	var conflicts []string
+	for remote := range remotes {
+		o1, o2 := s1.files[remote], s2.files[remote]
+		c1, c2 := s1.change(remote), s2.change(remote)
+		switch {
+		case c1.changed() && c2.changed():
+			if Equal(o1, o2) {
+				Debug(o1, "Changed on both sides but identical")
+				// Equal may have set the modification time of
+				// o2 so read it again for the snapshot
+				if o := f2.NewFsObject(remote); o != nil {
+					s2.files[remote] = o
+				}
+			} else {
+				conflicts = append(conflicts, remote)
+			}
+		case c1.changed():
+			to2 = append(to2, ObjectPair{o1, o2})
+		case c2.changed():
+			to1 = append(to1, ObjectPair{o2, o1})
+		case c1 == bisyncDeleted && c2 == bisyncUnchanged:
+			delete2 = append(delete2, o2)
+		case c2 == bisyncDeleted && c1 == bisyncUnchanged:
+			delete1 = append(delete1, o1)
+		}
+	}
+
+	// Resolve the conflicts
+	var kept []bisyncKept
+	for _, remote := range conflicts {
+		o1, o2 := s1.files[remote], s2.files[remote]
+		switch Config.BisyncConflict {
+		case BisyncKeepBoth:
+			if ctx.Err() == nil {
+				keepBoth(ctx, s1, s2, remote)
+				kept = append(kept, bisyncKept{o1, o2})
+			}
+		default:
+			if o2.ModTime().After(o1.ModTime()) {
+				Log(o2, "Changed on both sides - keeping the newer one from %v", f2)
+				to1 = append(to1, ObjectPair{o2, o1})
+			} else {
+				Log(o1, "Changed on both sides - keeping the newer one from %v", f1)
+				to2 = append(to2, ObjectPair{o1, o2})
+			}
+		}
+	}
+
+	// Do the transfers in both directions
+	var copierWg sync.WaitGroup
+	for _, transfer := range []struct {
+		pairs []ObjectPair
+		fdst  Fs
+	}{
+		{to1, f1},
+		{to2, f2},
+	} {
+		in := make(ObjectPairChan, Config.Transfers)
+		copierWg.Add(Config.Transfers)
+		for i := 0; i < Config.Transfers; i++ {
+			go PairCopier(ctx, in, transfer.fdst, nil, nil, &copierWg)
+		}
+		go func(pairs []ObjectPair) {
+			for _, pair := range pairs {
+				in <- pair
+			}
+			close(in)
+		}(transfer.pairs)
+	}
+	Log(nil, "Waiting for transfers to finish")
+	copierWg.Wait()
+
+	// Do the deletions
+	if ctx.Err() == nil && (len(delete1) > 0 || len(delete2) > 0) {
+		if Stats.Errored() {
+			ErrorLog(nil, "Not deleting files as there were IO errors")
+		} else if Limits.Err() != nil {
+			ErrorLog(nil, "Not deleting files as a limit was reached")
+		} else if Limits.AllowDeletes(len(delete1)+len(delete2), len(s1.files)+len(s2.files)) {
+			toDelete := make(ObjectsChan, Config.Transfers)
+			go func() {
+				for _, o := range append(delete1, delete2...) {
+					toDelete <- o
+				}
+				close(toDelete)
+			}()
+			DeleteFiles(ctx, toDelete)
+		}
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if Config.DryRun {
+		Log(nil, "Not saving bisync state as --dry-run")
+		return nil
+	}
+	if Stats.Errored() {
+		ErrorLog(nil, "Not saving bisync state as there were errors - the next run will retry")
+		return fmt.Errorf("not saving bisync state as there were errors")
+	}
+	if err := Limits.Err(); err != nil {
+		ErrorLog(nil, "Not saving bisync state as a limit was reached - the next run will retry")
+		return err
+	}
+
+	// Save the state of both sides for next time.  As there were no
+	// errors all the transfers and deletions succeeded, so this is
+	// the initial listings with them applied rather than a fresh
+	// listing which could pick up changes made while bisync ran.
+	Log(nil, "Saving bisync state")
+	snap = &bisyncSnapshot{
+		Path1:  bisyncRemote(f1),
+		Path2:  bisyncRemote(f2),
+		Files1: s1.entries(to1, delete1, kept),
+		Files2: s2.entries(to2, delete2, kept),
+	}
+	err = snap.save(statePath)
+	if err != nil {
+		Stats.Error()
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,290 @@
+// Test bisync
+
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fstest"
+	"golang.org/x/net/context"
+)
+
+// bisyncSetup points the bisync state at a temporary directory and
+// sets the conflict mode returning a function to restore them
+func bisyncSetup(t *testing.T, conflict string) func() {
+	oldStateDir, oldConflict := fs.Config.BisyncStateDir, fs.Config.BisyncConflict
+	stateDir, err := ioutil.TempDir("", "rclone-bisync")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	fs.Config.BisyncStateDir = stateDir
+	fs.Config.BisyncConflict = conflict
+	return func() {
+		fs.Config.BisyncStateDir, fs.Config.BisyncConflict = oldStateDir, oldConflict
+		err := os.RemoveAll(stateDir)
+		if err != nil {
+			t.Errorf("Failed to remove %q: %v", stateDir, err)
+		}
+	}
+}
+
+// bisync runs Bisync between local and remote checking for errors
+func bisync(t *testing.T, r *Run) {
+	fs.Stats.ResetCounters()
+	err := fs.Bisync(context.Background(), r.flocal, r.fremote)
+	if err != nil {
+		t.Fatalf("Bisync failed: %v", err)
+	}
+	if fs.Stats.Errored() {
+		t.Fatalf("Bisync had %d errors", fs.Stats.GetErrors())
+	}
+}
+
+// Remove a file from local
+func (r *Run) removeFile(t *testing.T, filePath string) {
+	err := os.Remove(path.Join(r.localName, filePath))
+	if err != nil {
+		t.Fatalf("Failed to remove %q: %v", filePath, err)
+	}
+}
+
+// Remove an object from the remote
+func (r *Run) removeObject(t *testing.T, remote string) {
+	o := r.fremote.NewFsObject(remote)
+	if o == nil {
+		t.Fatalf("Failed to find %q", remote)
+	}
+	err := o.Remove()
+	if err != nil {
+		t.Fatalf("Failed to remove %q: %v", remote, err)
+	}
+}
+
+func TestBisyncFirstRun(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	file1 := r.WriteFile("local only", "potato", t1)
+	file2 := r.WriteObject("remote only", "carrot", t2)
+	file3 := r.WriteBoth("both", "turnip", t3)
+	fstest.CheckItems(t, r.flocal, file1, file3)
+	fstest.CheckItems(t, r.fremote, file2, file3)
+
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, file1, file2, file3)
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+	_, err := os.Stat(fs.BisyncStatePath(r.flocal, r.fremote))
+	if err != nil {
+		t.Errorf("State not saved: %v", err)
+	}
+}
+
+func TestBisyncChanges(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	same := r.WriteBoth("unchanged", "potato", t1)
+	r.WriteBoth("modified local", "carrot", t1)
+	r.WriteBoth("deleted local", "turnip", t1)
+	r.WriteBoth("modified remote", "parsnip", t1)
+	r.WriteBoth("deleted remote", "swede", t1)
+	r.WriteBoth("deleted local modified remote", "leek", t1)
+
+	bisync(t, r)
+
+	modifiedLocal := r.WriteFile("modified local", "carrot cake", t2)
+	r.removeFile(t, "deleted local")
+	newLocal := r.WriteFile("new local", "onion", t2)
+	modifiedRemote := r.WriteObject("modified remote", "parsnip soup", t2)
+	r.removeObject(t, "deleted remote")
+	newRemote := r.WriteObject("sub/new remote", "garlic", t2)
+	r.removeFile(t, "deleted local modified remote")
+	kept := r.WriteObject("deleted local modified remote", "leek and potato", t2)
+	fstest.CheckItems(t, r.fremote, same, fstest.NewItem("modified local", "carrot", t1), fstest.NewItem("deleted local", "turnip", t1), modifiedRemote, newRemote, kept)
+
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, same, modifiedLocal, newLocal, modifiedRemote, newRemote, kept)
+	fstest.CheckItems(t, r.fremote, same, modifiedLocal, newLocal, modifiedRemote, newRemote, kept)
+
+	// Running again should do nothing
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, same, modifiedLocal, newLocal, modifiedRemote, newRemote, kept)
+	fstest.CheckItems(t, r.fremote, same, modifiedLocal, newLocal, modifiedRemote, newRemote, kept)
+}
+
+func TestBisyncConflictNewer(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	r.WriteBoth("conflict", "potato", t1)
+
+	bisync(t, r)
+
+	r.WriteFile("conflict", "local potato", t2)
+	file2 := r.WriteObject("conflict", "remote potato", t3)
+
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, file2)
+	fstest.CheckItems(t, r.fremote, file2)
+}
+
+func TestBisyncConflictKeepBoth(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncKeepBoth)()
+	r.WriteBoth("dir/conflict.txt", "potato", t1)
+
+	bisync(t, r)
+
+	r.WriteFile("dir/conflict.txt", "local potato", t2)
+	r.WriteObject("dir/conflict.txt", "remote potato", t3)
+
+	bisync(t, r)
+
+	file1 := fstest.NewItem("dir/conflict.conflict1.txt", "local potato", t2)
+	file2 := fstest.NewItem("dir/conflict.conflict2.txt", "remote potato", t3)
+	fstest.CheckItems(t, r.flocal, file1, file2)
+	fstest.CheckItems(t, r.fremote, file1, file2)
+
+	// The renamed files should be in the state so deleting one
+	// is copied across
+	r.removeFile(t, "dir/conflict.conflict1.txt")
+
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, file2)
+	fstest.CheckItems(t, r.fremote, file2)
+}
+
+// countListFs counts the calls to List
+type countListFs struct {
+	fs.Fs
+	lists *int32
+}
+
+// List counts the call and lists the wrapped Fs
+func (f countListFs) List() fs.ObjectsChan {
+	atomic.AddInt32(f.lists, 1)
+	return f.Fs.List()
+}
+
+// The state should be made from the listings at the start, not by
+// listing again after the transfers
+func TestBisyncListsOnce(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	same := r.WriteBoth("unchanged", "potato", t1)
+	r.WriteBoth("deleted local", "turnip", t1)
+
+	bisync(t, r)
+
+	newLocal := r.WriteFile("new local", "onion", t2)
+	newRemote := r.WriteObject("new remote", "garlic", t2)
+	r.removeFile(t, "deleted local")
+	var lists1, lists2 int32
+	fs.Stats.ResetCounters()
+	err := fs.Bisync(context.Background(), countListFs{r.flocal, &lists1}, countListFs{r.fremote, &lists2})
+	if err != nil {
+		t.Fatalf("Bisync failed: %v", err)
+	}
+	if lists1 != 1 || lists2 != 1 {
+		t.Errorf("Expecting each side to be listed once but got %d and %d", lists1, lists2)
+	}
+	fstest.CheckItems(t, r.flocal, same, newLocal, newRemote)
+	fstest.CheckItems(t, r.fremote, same, newLocal, newRemote)
+
+	// The state should match both sides so only this change is
+	// copied
+	modifiedRemote := r.WriteObject("new local", "onion soup", t3)
+
+	bisync(t, r)
+
+	fstest.CheckItems(t, r.flocal, same, modifiedRemote, newRemote)
+	fstest.CheckItems(t, r.fremote, same, modifiedRemote, newRemote)
+}
+
+// staleFs lists objects whose ModTime doesn't change when
+// SetModTime is called, like remotes which don't read it back
+type staleFs struct {
+	fs.Fs
+}
+
+// staleObject is an Object with a cached ModTime
+type staleObject struct {
+	fs.Object
+	modTime time.Time
+}
+
+// ModTime returns the modification time when the object was listed
+func (o staleObject) ModTime() time.Time {
+	return o.modTime
+}
+
+// List wraps the objects listed so their ModTime is cached
+func (f staleFs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		for o := range f.Fs.List() {
+			out <- staleObject{Object: o, modTime: o.ModTime()}
+		}
+		close(out)
+	}()
+	return out
+}
+
+// When identical files have their modification times made the same
+// the snapshot should have the time which was set
+func TestBisyncEqualSetsModTime(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	file1 := r.WriteFile("same", "potato", t1)
+	r.WriteObject("same", "potato", t2)
+
+	fs.Stats.ResetCounters()
+	err := fs.Bisync(context.Background(), r.flocal, staleFs{r.fremote})
+	if err != nil {
+		t.Fatalf("Bisync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.flocal, file1)
+	fstest.CheckItems(t, r.fremote, file1)
+
+	// Nothing has changed so nothing should be transferred
+	fs.Stats.ResetCounters()
+	err = fs.Bisync(context.Background(), r.flocal, staleFs{r.fremote})
+	if err != nil {
+		t.Fatalf("Bisync failed: %v", err)
+	}
+	if n := fs.Stats.GetTransfers(); n != 0 {
+		t.Errorf("Expecting no transfers but got %d", n)
+	}
+}
+
+func TestBisyncAllDeleted(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer bisyncSetup(t, fs.BisyncNewer)()
+	file1 := r.WriteBoth("potato", "potato", t1)
+
+	bisync(t, r)
+
+	r.removeFile(t, "potato")
+	fs.Stats.ResetCounters()
+	err := fs.Bisync(context.Background(), r.flocal, r.fremote)
+	if err == nil {
+		t.Fatalf("Expecting error when all files deleted")
+	}
+	fstest.CheckItems(t, r.fremote, file1)
+	fs.Stats.ResetCounters()
+}
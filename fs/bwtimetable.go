@@ -0,0 +1,175 @@
+// Bandwidth limits which change through the day
+
+package fs
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+)
+
+// BwPair is an upload and a download bandwidth limit in bytes/s, 0
+// meaning unlimited
+type BwPair struct {
+	Tx SizeSuffix // upload limit
+	Rx SizeSuffix // download limit
+}
+
+// bwString turns a bandwidth limit into a string
+func bwString(x SizeSuffix) string {
+	if x <= 0 {
+		return "off"
+	}
+	return x.String()
+}
+
+// String turns a BwPair into a string, "RATE" if the limits are the
+// same or "UP:DOWN" if not
+func (x BwPair) String() string {
+	if x.Tx == x.Rx {
+		return bwString(x.Tx)
+	}
+	return bwString(x.Tx) + ":" + bwString(x.Rx)
+}
+
+// setBw parses a bandwidth limit which is a size or "off"
+func setBw(x *SizeSuffix, s string) error {
+	if s == "off" {
+		*x = 0
+		return nil
+	}
+	return x.Set(s)
+}
+
+// Set a BwPair from "RATE" or "UP:DOWN"
+func (x *BwPair) Set(s string) error {
+	parts := strings.Split(s, ":")
+	if len(parts) > 2 {
+		return fmt.Errorf("bad bandwidth %q - expecting RATE or UP:DOWN", s)
+	}
+	err := setBw(&x.Tx, parts[0])
+	if err != nil {
+		return err
+	}
+	if len(parts) == 1 {
+		x.Rx = x.Tx
+		return nil
+	}
+	return setBw(&x.Rx, parts[1])
+}
+
+// BwTimeSlot is a bandwidth limit which starts at a time of day
+type BwTimeSlot struct {
+	Start     int // minutes after midnight
+	Bandwidth BwPair
+}
+
+// BwTimetable is the bandwidth limits through the day sorted by
+// start time.  Each limit lasts until the next one starts, with the
+// last one lasting until the first one the next day.
+type BwTimetable []BwTimeSlot
+
+// String turns a BwTimetable into a string
+func (x BwTimetable) String() string {
+	if len(x) == 0 {
+		return "off"
+	}
+	if len(x) == 1 && x[0].Start == 0 {
+		return x[0].Bandwidth.String()
+	}
+	slots := make([]string, len(x))
+	for i, slot := range x {
+		slots[i] = fmt.Sprintf("%02d:%02d,%v", slot.Start/60, slot.Start%60, slot.Bandwidth)
+	}
+	return strings.Join(slots, " ")
+}
+
+// parseTimeOfDay parses "HH:MM" returning the minutes after midnight
+func parseTimeOfDay(s string) (int, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("bad time %q - expecting HH:MM", s)
+	}
+	hh, err := strconv.Atoi(parts[0])
+	if err != nil || hh < 0 || hh > 23 {
+		return 0, fmt.Errorf("bad hour in time %q", s)
+	}
+	mm, err := strconv.Atoi(parts[1])
+	if err != nil || mm < 0 || mm > 59 {
+		return 0, fmt.Errorf("bad minute in time %q", s)
+	}
+	return hh*60 + mm, nil
+}
+
+// Set a BwTimetable from either a single "RATE" or "UP:DOWN" for the
+// whole day or a space separated list of "HH:MM,RATE" or
+// "HH:MM,UP:DOWN"
+func (x *BwTimetable) Set(s string) error {
+	if !strings.Contains(s, ",") {
+		var bw BwPair
+		err := bw.Set(s)
+		if err != nil {
+			return err
+		}
+		*x = BwTimetable{{Bandwidth: bw}}
+		return nil
+	}
+	var timetable BwTimetable
+	seen := map[int]bool{}
+	for _, entry := range strings.Fields(s) {
+		parts := strings.SplitN(entry, ",", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("bad timetable entry %q - expecting HH:MM,RATE", entry)
+		}
+		start, err := parseTimeOfDay(parts[0])
+		if err != nil {
+			return err
+		}
+		if seen[start] {
+			return fmt.Errorf("time %q is in the timetable more than once", parts[0])
+		}
+		seen[start] = true
+		slot := BwTimeSlot{Start: start}
+		err = slot.Bandwidth.Set(parts[1])
+		if err != nil {
+			return err
+		}
+		timetable = append(timetable, slot)
+	}
+	sort.Sort(timetable)
+	*x = timetable
+	return nil
+}
+
+// Type of the value
+func (x *BwTimetable) Type() string {
+	return "BwTimetable"
+}
+
+// Check it satisfies the interface
+var _ pflag.Value = (*BwTimetable)(nil)
+
+func (x BwTimetable) Len() int           { return len(x) }
+func (x BwTimetable) Swap(i, j int)      { x[i], x[j] = x[j], x[i] }
+func (x BwTimetable) Less(i, j int) bool { return x[i].Start < x[j].Start }
+
+// LimitAt returns the bandwidth limits at time t
+func (x BwTimetable) LimitAt(t time.Time) BwPair {
+	if len(x) == 0 {
+		return BwPair{}
+	}
+	now := t.Hour()*60 + t.Minute()
+	// Before the first slot the last one from the day before applies
+	limit := x[len(x)-1].Bandwidth
+	for _, slot := range x {
+		if slot.Start > now {
+			break
+		}
+		limit = slot.Bandwidth
+	}
+	return limit
+}
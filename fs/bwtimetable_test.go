@@ -0,0 +1,118 @@
+package fs
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBwTimetableSet(t *testing.T) {
+	const k, M = 1024, 1024 * 1024
+	for i, test := range []struct {
+		in   string
+		want BwTimetable
+		str  string
+		err  bool
+	}{
+		{"", nil, "", true},
+		{"0", BwTimetable{{0, BwPair{0, 0}}}, "off", false},
+		{"off", BwTimetable{{0, BwPair{0, 0}}}, "off", false},
+		{"512k", BwTimetable{{0, BwPair{512 * k, 512 * k}}}, "512k", false},
+		{"10M:1M", BwTimetable{{0, BwPair{10 * M, 1 * M}}}, "10M:1M", false},
+		{"off:1M", BwTimetable{{0, BwPair{0, 1 * M}}}, "off:1M", false},
+		{"1M:2M:3M", nil, "", true},
+		{"1p", nil, "", true},
+		{
+			"08:00,512k 19:00,off",
+			BwTimetable{{8 * 60, BwPair{512 * k, 512 * k}}, {19 * 60, BwPair{0, 0}}},
+			"08:00,512k 19:00,off",
+			false,
+		},
+		{
+			"19:30,10M:off  07:05,1M:256k",
+			BwTimetable{{7*60 + 5, BwPair{1 * M, 256 * k}}, {19*60 + 30, BwPair{10 * M, 0}}},
+			"07:05,1M:256k 19:30,10M:off",
+			false,
+		},
+		{"08:00,1M 08:00,2M", nil, "", true},
+		{"8,1M", nil, "", true},
+		{"24:00,1M", nil, "", true},
+		{"08:60,1M", nil, "", true},
+		{"08:00,1M,2M", nil, "", true},
+		{"08:00,1M 19:00", nil, "", true},
+	} {
+		var got BwTimetable
+		err := got.Set(test.in)
+		if (err != nil) != test.err {
+			t.Errorf("%d: %q: Expecting error %v but got error %v", i, test.in, test.err, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%d: %q: Want %v got %v", i, test.in, test.want, got)
+		}
+		if str := got.String(); str != test.str {
+			t.Errorf("%d: %q: Want string %q got %q", i, test.in, test.str, str)
+		}
+	}
+}
+
+func TestBwTimetableLimitAt(t *testing.T) {
+	var timetable BwTimetable
+	err := timetable.Set("08:00,512k 12:30,1M:256k 19:00,off")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		at   string
+		want string
+	}{
+		{"00:00", "off"},
+		{"07:59", "off"},
+		{"08:00", "512k"},
+		{"12:29", "512k"},
+		{"12:30", "1M:256k"},
+		{"18:59", "1M:256k"},
+		{"19:00", "off"},
+		{"23:59", "off"},
+	} {
+		at, err := time.Parse("15:04", test.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := timetable.LimitAt(at).String()
+		if got != test.want {
+			t.Errorf("%s: want %q got %q", test.at, test.want, got)
+		}
+	}
+
+	err = timetable.Set("08:00,512k 19:00,2M")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at, _ := time.Parse("15:04", "03:00")
+	if got := timetable.LimitAt(at).String(); got != "2M" {
+		t.Errorf("before the first slot want %q got %q", "2M", got)
+	}
+	if got := (BwTimetable{}).LimitAt(at).String(); got != "off" {
+		t.Errorf("empty timetable want %q got %q", "off", got)
+	}
+}
+
+func TestSetBwLimit(t *testing.T) {
+	defer setBwLimit(BwPair{})
+	setBwLimit(BwPair{Tx: 1024 * 1024, Rx: 1024 * 1024})
+	if tokenBucket == nil || uploadBucket != nil || downloadBucket != nil {
+		t.Errorf("Same limits: expecting just the shared bucket")
+	}
+	setBwLimit(BwPair{Tx: 1024 * 1024})
+	if tokenBucket != nil || uploadBucket == nil || downloadBucket != nil {
+		t.Errorf("Upload limit: expecting just the upload bucket")
+	}
+	setBwLimit(BwPair{})
+	if tokenBucket != nil || uploadBucket != nil || downloadBucket != nil {
+		t.Errorf("No limit: expecting no buckets")
+	}
+}
@@ -80,7 +80,24 @@ var (
 	deleteBefore   = pflag.BoolP("delete-before", "", false, "When synchronizing, delete files on destination before transfering")
 	deleteDuring   = pflag.BoolP("delete-during", "", false, "When synchronizing, delete files during transfer (default)")
 	deleteAfter    = pflag.BoolP("delete-after", "", false, "When synchronizing, delete files on destination after transfering")
-	bwLimit        SizeSuffix
+	bisyncStateDir = pflag.StringP("bisync-state-dir", "", path.Join(HomeDir, ".rclone-bisync"), "Directory to keep the bisync listing snapshots in")
+	bisyncConflict = pflag.StringP("bisync-conflict", "", BisyncNewer, "How bisync resolves files changed on both sides: newer or keep-both")
+	backupDirPath  = pflag.StringP("backup-dir", "", "", "Make backups into hierarchy based in DIR when syncing")
+	suffix         = pflag.StringP("suffix", "", "", "Suffix to add to files moved into --backup-dir")
+	timeSuffix     = pflag.BoolP("suffix-timestamp", "", false, "Add the time the sync started to --suffix")
+	trackRenames   = pflag.BoolP("track-renames", "", false, "When synchronizing, track file renames and do a server side move if possible")
+	emptySrcDirs   = pflag.BoolP("create-empty-src-dirs", "", false, "Make empty source directories on the destination")
+	uploadStateDir = pflag.StringP("upload-state-dir", "", "", "Directory to save resumable upload sessions in, none if empty")
+	uploadStateAge = pflag.DurationP("upload-state-max-age", "", 24*time.Hour, "Max age of a saved upload session to resume")
+	maxDelete      = pflag.IntP("max-delete", "", -1, "When synchronizing, don't delete anything if more than this many files would be deleted")
+	maxDeletePct   = pflag.IntP("max-delete-percent", "", -1, "When synchronizing, don't delete anything if more than this percent of the destination files would be deleted")
+	maxDuration    = pflag.DurationP("max-duration", "", 0, "Don't start any new transfers after this duration")
+	maxTransfer    SizeSuffix
+	compareDest    = pflag.StringArrayP("compare-dest", "", nil, "Don't transfer files which aren't in the destination but are unchanged in these directories")
+	copyDest       = pflag.StringArrayP("copy-dest", "", nil, "Server side copy files which aren't in the destination but are unchanged in these directories")
+	orderBy        = pflag.StringP("order-by", "", "", "Order the transfers by size, modtime or name, with ,ascending or ,descending, eg size,descending")
+	maxBacklog     = pflag.IntP("max-backlog", "", 10000, "Most transfers to hold in memory to order them with --order-by or --priority")
+	bwLimit        BwTimetable
 
 	// Key to use for password en/decryption.
 	// When nil, no encryption will be used for saving.
@@ -88,7 +105,8 @@ var (
 )
 
 func init() {
-	pflag.VarP(&bwLimit, "bwlimit", "", "Bandwidth limit in kBytes/s, or use suffix k|M|G")
+	pflag.VarP(&bwLimit, "bwlimit", "", "Bandwidth limit in kBytes/s, or use suffix k|M|G, UP:DOWN or a timetable of HH:MM,LIMIT")
+	pflag.VarP(&maxTransfer, "max-transfer", "", "Don't start any new transfers after this much data in kBytes, or use suffix k|M|G")
 }
 
 // Turn SizeSuffix into a string
@@ -193,10 +211,28 @@ type ConfigInfo struct {
 	DumpHeaders        bool
 	DumpBodies         bool
 	Filter             *Filter
-	InsecureSkipVerify bool // Skip server certificate verification
-	DeleteBefore       bool // Delete before checking
-	DeleteDuring       bool // Delete during checking/transfer
-	DeleteAfter        bool // Delete after successful transfer.
+	InsecureSkipVerify bool   // Skip server certificate verification
+	DeleteBefore       bool   // Delete before checking
+	DeleteDuring       bool   // Delete during checking/transfer
+	DeleteAfter        bool   // Delete after successful transfer.
+	BisyncStateDir     string // Directory for the bisync snapshots
+	BisyncConflict     string // How bisync resolves conflicts
+	BackupDir          string // Where to move overwritten and deleted files
+	Suffix             string // Suffix for files in BackupDir
+	SuffixTimestamp    bool   // Add a timestamp to Suffix
+	TrackRenames       bool   // Rename files on the destination which were moved in the source
+	CreateEmptyDirs    bool   // Make directories on the destination which have nothing copied into them
+	UploadStateDir     string // Directory for resumable upload state
+	UploadStateMaxAge  time.Duration
+	MaxDelete          int        // Most files a sync may delete, -1 for no limit
+	MaxDeletePercent   int        // Most percent of the files a sync may delete, -1 for no limit
+	MaxTransfer        SizeSuffix // Stop starting transfers after this many bytes, 0 for no limit
+	MaxDuration        time.Duration
+	OrderBy            string   // Order to do the transfers in
+	MaxBacklog         int      // Most transfers held to order them
+	Priority           *Filter  // Files to transfer first, nil for none
+	CompareDest        []string // Directories to compare the source with as well as the destination
+	CopyDest           []string // Directories to copy unchanged files from
 }
 
 // Transport returns an http.RoundTripper with the correct timeouts
@@ -292,6 +328,41 @@ func LoadConfig() {
 	Config.DeleteDuring = *deleteDuring
 	Config.DeleteAfter = *deleteAfter
 
+	Config.BackupDir = *backupDirPath
+	Config.Suffix = *suffix
+	Config.SuffixTimestamp = *timeSuffix
+
+	Config.UploadStateDir = *uploadStateDir
+	Config.UploadStateMaxAge = *uploadStateAge
+
+	Config.CompareDest = *compareDest
+	Config.CopyDest = *copyDest
+	if len(Config.CompareDest) > 0 && len(Config.CopyDest) > 0 {
+		log.Fatalf(`Can't use --compare-dest with --copy-dest.`)
+	}
+
+	Config.TrackRenames = *trackRenames
+	Config.CreateEmptyDirs = *emptySrcDirs
+	if Config.TrackRenames && Config.DeleteBefore {
+		log.Fatalf(`--track-renames can't be used with --delete-before.`)
+	}
+
+	Config.MaxDelete = *maxDelete
+	Config.MaxDeletePercent = *maxDeletePct
+	if Config.MaxDeletePercent > 100 {
+		log.Fatalf(`--max-delete-percent must be at most 100.`)
+	}
+	Config.MaxTransfer = maxTransfer
+	Config.MaxDuration = *maxDuration
+
+	Config.BisyncStateDir = *bisyncStateDir
+	Config.BisyncConflict = *bisyncConflict
+	switch Config.BisyncConflict {
+	case BisyncNewer, BisyncKeepBoth:
+	default:
+		log.Fatalf(`--bisync-conflict must be %q or %q.`, BisyncNewer, BisyncKeepBoth)
+	}
+
 	switch {
 	case *deleteBefore && (*deleteDuring || *deleteAfter),
 		*deleteDuring && *deleteAfter:
@@ -315,6 +386,21 @@ func LoadConfig() {
 		log.Fatalf("Failed to load filters: %v", err)
 	}
 
+	// Load the transfer ordering
+	Config.OrderBy = *orderBy
+	_, err = parseOrderBy(Config.OrderBy)
+	if err != nil {
+		log.Fatalf("Failed to parse --order-by: %v", err)
+	}
+	Config.MaxBacklog = *maxBacklog
+	if Config.MaxBacklog < 1 {
+		log.Fatalf("--max-backlog must be at least 1")
+	}
+	Config.Priority, err = NewPriorityFilter()
+	if err != nil {
+		log.Fatalf("Failed to load priority rules: %v", err)
+	}
+
 	// Start the token bucket limiter
 	startTokenBucket()
 }
@@ -681,6 +767,42 @@ func RemoteConfig(name string) {
 // ChooseOption asks the user to choose an option
 func ChooseOption(o *Option) string {
 	fmt.Println(o.Help)
+	if o.IsPassword {
+		actions := []string{"yYes type in my own password", "gGenerate random password"}
+		if o.Optional {
+			actions = append(actions, "nNo leave this optional password blank")
+		}
+		var password string
+		switch i := Command(actions); i {
+		case 'y':
+			password = ChangePassword("the")
+		case 'g':
+			for {
+				fmt.Printf("Password strength in bits.\n64 is just about memorable\n128 is secure\n1024 is the maximum\n")
+				bits := ChooseNumber("Bits", 64, 1024)
+				bytes := bits / 8
+				if bits%8 != 0 {
+					bytes++
+				}
+				var pw = make([]byte, bytes)
+				n, _ := rand.Read(pw)
+				if n != bytes {
+					log.Fatalf("password short read: %d", n)
+				}
+				password = base64.RawURLEncoding.EncodeToString(pw)
+				fmt.Printf("Your password is: %s\n", password)
+				fmt.Printf("Use this password?\n")
+				if Confirm() {
+					break
+				}
+			}
+		case 'n':
+			return ""
+		default:
+			log.Fatalf("Bad choice %c", i)
+		}
+		return Obscure(password)
+	}
 	if len(o.Examples) > 0 {
 		var values []string
 		var help []string
@@ -843,6 +965,23 @@ func SetPassword() {
 	}
 }
 
+// ChangePassword will query the user twice for the named password. If
+// the same password is entered it is returned.
+func ChangePassword(name string) string {
+	for {
+		fmt.Printf("Enter %s password:\n", name)
+		fmt.Print("password:")
+		password := ReadPassword()
+		fmt.Printf("Confirm %s password:\n", name)
+		fmt.Print("password:")
+		password2 := ReadPassword()
+		if password == password2 {
+			return password
+		}
+		fmt.Printf("Passwords do not match!\n")
+	}
+}
+
 // changePassword will query the user twice
 // for a password. If the same password is entered
 // twice the key is updated.
@@ -0,0 +1,121 @@
+// Per-directory filter files read while walking the source
+
+package fs
+
+import (
+	"path"
+	"strings"
+	"sync"
+)
+
+// dirFilters holds the rules read from the --dir-filter-file files
+// found while walking the source.
+//
+// The rules in a file only apply to the objects beneath the directory
+// it is in, and are matched against the path relative to that
+// directory, so "/name" anchors to the directory with the file.  The
+// rules from the deepest file are checked first, then those from the
+// files in the directories above it, then the global rules.
+type dirFilters struct {
+	name    string // name of the filter files
+	exclude bool   // set to exclude the filter files themselves
+	mu      sync.Mutex
+	filters map[string]*Filter // rules read for each directory
+}
+
+// newDirFilters makes a dirFilters from the global Filter, returning
+// nil if --dir-filter-file isn't set
+func newDirFilters() *dirFilters {
+	if Config.Filter.DirFilterFile == "" {
+		return nil
+	}
+	return &dirFilters{
+		name:    Config.Filter.DirFilterFile,
+		exclude: Config.Filter.ExcludeDirFilterFile,
+		filters: make(map[string]*Filter),
+	}
+}
+
+// read reads the rules from the filter file in dir if there is one
+// in srcObjects, the unfiltered objects in dir.
+//
+// This must be called for dir before objects in it or below it are
+// filtered, which walkDirs ensures by walking a directory before the
+// directories in it.
+//
+// If the file can't be read then the error is counted, which stops
+// the sync deleting files which its rules might have excluded.
+func (d *dirFilters) read(dir string, srcObjects []Object) {
+	if d == nil {
+		return
+	}
+	remote := path.Join(dir, d.name)
+	for _, o := range srcObjects {
+		if o.Remote() != remote {
+			continue
+		}
+		d.mu.Lock()
+		_, done := d.filters[dir]
+		d.mu.Unlock()
+		if done {
+			return
+		}
+		f, err := readDirFilter(o)
+		if err != nil {
+			Stats.Error()
+			ErrorLog(o, "Failed to read filter file: %v", err)
+			return
+		}
+		Debug(o, "Read filter file")
+		d.mu.Lock()
+		d.filters[dir] = f
+		d.mu.Unlock()
+		return
+	}
+}
+
+// readDirFilter reads the rules from the filter file o which are in
+// the same format as --filter-from
+func readDirFilter(o Object) (f *Filter, err error) {
+	in, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer CheckClose(in, &err)
+	f = &Filter{IgnoreCase: Config.Filter.IgnoreCase}
+	err = forEachLineIn(in, f.AddRule)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// match checks remote against the rules from the filter files in its
+// directory and the directories above it, returning whether it is
+// included and whether any rule matched
+func (d *dirFilters) match(remote string) (include bool, ok bool) {
+	if d == nil {
+		return false, false
+	}
+	if d.exclude && path.Base(remote) == d.name {
+		return false, true
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for dir := parentDir(remote); ; dir = parentDir(dir) {
+		if f := d.filters[dir]; f != nil {
+			relative := remote
+			if dir != "" {
+				relative = strings.TrimPrefix(remote, dir+"/")
+			}
+			for _, rule := range f.rules {
+				if rule.Match(relative) {
+					return rule.Include, true
+				}
+			}
+		}
+		if dir == "" {
+			return false, false
+		}
+	}
+}
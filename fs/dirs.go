@@ -0,0 +1,170 @@
+// Sync the directories as well as the objects in them
+
+package fs
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// syncDirs mirrors the directories of the source on a destination
+// which has real directories, so empty directories are copied and
+// directories no longer in the source are removed
+type syncDirs struct {
+	fdst     Fs
+	maker    DirMaker // nil if fdst hasn't got real directories
+	mu       sync.Mutex
+	modTimes map[string]time.Time // modification times of the source directories
+	changed  map[string]bool      // directories whose modification time needs setting
+	missing  map[string]bool      // source directories not made on the destination yet
+	dstOnly  []string             // directories only in the destination
+}
+
+// newSyncDirs makes a syncDirs for fdst
+func newSyncDirs(fdst Fs) *syncDirs {
+	maker, _ := fdst.(DirMaker)
+	return &syncDirs{
+		fdst:     fdst,
+		maker:    maker,
+		modTimes: make(map[string]time.Time),
+		changed:  make(map[string]bool),
+		missing:  make(map[string]bool),
+	}
+}
+
+// add is called with the sorted sub directories of dir as it is
+// walked.
+//
+// Directories only in the source are made straight away if
+// Config.CreateEmptyDirs is set, otherwise they are made by copying
+// objects into them, so directories with nothing included by the
+// filters aren't made.  If removeDstOnly is set the directories only
+// in the destination are remembered to be removed.
+func (d *syncDirs) add(dir string, srcDirs, dstDirs []*Dir, removeDstOnly bool) {
+	if d.maker == nil {
+		return
+	}
+	matchDirs(srcDirs, dstDirs, func(src, dst *Dir) {
+		switch {
+		case src == nil:
+			if removeDstOnly {
+				d.remove(dir, dst)
+			}
+			return
+		case dst == nil && !Config.CreateEmptyDirs:
+			d.mu.Lock()
+			d.missing[src.Name] = true
+			d.mu.Unlock()
+		case dst == nil:
+			if Config.DryRun {
+				Log(d.fdst, "%s: Not making directory as --dry-run", src.Name)
+				return
+			}
+			err := d.maker.MkdirPath(src.Name)
+			if err != nil {
+				Stats.Error()
+				ErrorLog(d.fdst, "%s: Couldn't make directory: %v", src.Name, err)
+				return
+			}
+			Debug(d.fdst, "%s: Made directory", src.Name)
+			d.touch(dir)
+			d.touch(src.Name)
+		case dst.When.IsZero() || !equalModTime(src.When, dst.When):
+			d.touch(src.Name)
+		}
+		if !src.When.IsZero() {
+			d.mu.Lock()
+			d.modTimes[src.Name] = src.When
+			d.mu.Unlock()
+		}
+	})
+}
+
+// remove remembers that dst, which is in dir, is only in the
+// destination so should be removed
+func (d *syncDirs) remove(dir string, dst *Dir) {
+	if d.maker == nil {
+		return
+	}
+	d.mu.Lock()
+	d.dstOnly = append(d.dstOnly, dst.Name)
+	d.mu.Unlock()
+	d.touch(dir)
+}
+
+// touch marks dir as having its modification time changed, either
+// because it differs from the source or because something is being
+// made or removed in it.
+//
+// If dir hasn't been made on the destination yet then putting
+// something in it makes it, so its parent is marked too.
+func (d *syncDirs) touch(dir string) {
+	if d.maker == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for {
+		d.changed[dir] = true
+		if !d.missing[dir] {
+			return
+		}
+		delete(d.missing, dir)
+		dir = parentDir(dir)
+	}
+}
+
+// equalModTime returns whether t1 and t2 are the same to within
+// Config.ModifyWindow
+func equalModTime(t1, t2 time.Time) bool {
+	dt := t1.Sub(t2)
+	return dt < Config.ModifyWindow && dt > -Config.ModifyWindow
+}
+
+// removeDirs removes the directories only in the destination,
+// deepest first so the directories inside a directory are removed
+// before it.
+//
+// A directory may still have objects in which weren't deleted, eg
+// because they were excluded, so failing to remove one isn't an
+// error.
+func (d *syncDirs) removeDirs() {
+	sort.Sort(sort.Reverse(sort.StringSlice(d.dstOnly)))
+	for _, dir := range d.dstOnly {
+		if Config.DryRun {
+			Log(d.fdst, "%s: Not removing directory as --dry-run", dir)
+			continue
+		}
+		err := d.maker.RmdirPath(dir)
+		if err != nil {
+			Debug(d.fdst, "%s: Not removing directory: %v", dir, err)
+			continue
+		}
+		Debug(d.fdst, "%s: Removed directory", dir)
+	}
+	d.dstOnly = nil
+}
+
+// setModTimes sets the modification times of the directories to
+// match the source.  This is done last as transferring the objects
+// in a directory may change its modification time.
+func (d *syncDirs) setModTimes() {
+	setter, ok := d.fdst.(DirModTimeSetter)
+	if !ok || Config.DryRun {
+		return
+	}
+	for dir := range d.changed {
+		modTime, ok := d.modTimes[dir]
+		if !ok {
+			continue
+		}
+		err := setter.SetDirModTime(dir, modTime)
+		if err != nil {
+			Stats.Error()
+			ErrorLog(d.fdst, "%s: Couldn't set directory modification time: %v", dir, err)
+			continue
+		}
+		Debug(d.fdst, "%s: Set directory modification time", dir)
+	}
+}
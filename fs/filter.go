@@ -5,6 +5,7 @@ package fs
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 	"regexp"
 	"strconv"
@@ -18,11 +19,11 @@ import (
 var (
 	// Flags
 	deleteExcluded = pflag.BoolP("delete-excluded", "", false, "Delete files on dest excluded from sync")
-	filterRule     = pflag.StringP("filter", "f", "", "Add a file-filtering rule")
+	filterRule     = pflag.StringArrayP("filter", "f", nil, "Add a file-filtering rule")
 	filterFrom     = pflag.StringP("filter-from", "", "", "Read filtering patterns from a file")
-	excludeRule    = pflag.StringP("exclude", "", "", "Exclude files matching pattern")
+	excludeRule    = pflag.StringArrayP("exclude", "", nil, "Exclude files matching pattern")
 	excludeFrom    = pflag.StringP("exclude-from", "", "", "Read exclude patterns from file")
-	includeRule    = pflag.StringP("include", "", "", "Include files matching pattern")
+	includeRule    = pflag.StringArrayP("include", "", nil, "Include files matching pattern")
 	includeFrom    = pflag.StringP("include-from", "", "", "Read include patterns from file")
 	filesFrom      = pflag.StringP("files-from", "", "", "Read list of source-file names from file")
 	minAge         = pflag.StringP("min-age", "", "", "Don't transfer any file younger than this in s or suffix ms|s|m|h|d|w|M|y")
@@ -30,6 +31,11 @@ var (
 	minSize        SizeSuffix
 	maxSize        SizeSuffix
 	dumpFilters    = pflag.BoolP("dump-filters", "", false, "Dump the filters to the output")
+	priorityRule   = pflag.StringP("priority", "", "", "Transfer files matching pattern first")
+	priorityFrom   = pflag.StringP("priority-from", "", "", "Read priority filtering patterns from a file")
+	dirFilterFile  = pflag.StringP("dir-filter-file", "", "", "Read filtering patterns from files with this name in each source directory")
+	excludeDirFile = pflag.BoolP("exclude-dir-filter-file", "", false, "Don't transfer the --dir-filter-file files")
+	ignoreCase     = pflag.BoolP("ignore-case", "", false, "Ignore case in the filter rules")
 	//cvsExclude     = pflag.BoolP("cvs-exclude", "C", false, "Exclude files in the same way CVS does")
 )
 
@@ -42,6 +48,7 @@ func init() {
 type rule struct {
 	Include bool
 	Regexp  *regexp.Regexp
+	dir     dirRule // how the rule applies to directories
 }
 
 // Match returns true if rule matches path
@@ -58,18 +65,104 @@ func (r *rule) String() string {
 	return fmt.Sprintf("%s %s", c, r.Regexp.String())
 }
 
+// dirRule describes which objects in a directory a rule can match so
+// IncludeDirectory can tell whether the directory needs listing
+type dirRule struct {
+	all      bool             // the rule matches every object
+	tree     *regexp.Regexp   // the rule matches every object below the directories this matches
+	anywhere bool             // the rule may match objects in any directory
+	segments []*regexp.Regexp // otherwise the directories the rule may match objects in
+	deep     bool             // the rule may match objects below the directories matching segments
+}
+
+// newDirRule works out which objects in a directory the rule for glob
+// can match.  Where it can't be sure it assumes the rule may match
+// some of the objects in any directory.
+func newDirRule(glob string, ignoreCase bool) (d dirRule, err error) {
+	anchored := strings.HasPrefix(glob, "/")
+	unanchored := strings.TrimPrefix(glob, "/")
+	switch {
+	case unanchored == "**" || (!anchored && unanchored == "*"):
+		d.all = true
+	case strings.HasSuffix(unanchored, "/**"):
+		d.tree, err = globToRegexp(glob[:len(glob)-len("/**")], ignoreCase)
+		if err != nil {
+			return d, err
+		}
+	}
+	// Braces, escapes and regexps may hide a "/" so don't try to
+	// split them
+	if !anchored || strings.ContainsAny(unanchored, "{\\") {
+		d.anywhere = true
+		return d, nil
+	}
+	parts := strings.Split(unanchored, "/")
+	for i, part := range parts {
+		if strings.Contains(part, "**") {
+			d.deep = true
+			break
+		}
+		if i == len(parts)-1 {
+			break
+		}
+		re, err := globToRegexp("/"+part, ignoreCase)
+		if err != nil {
+			return d, err
+		}
+		d.segments = append(d.segments, re)
+	}
+	return d, nil
+}
+
+// covers returns whether the rule matches every object in dir and
+// the directories below it
+func (d *dirRule) covers(dir string) bool {
+	if d.all {
+		return true
+	}
+	if d.tree == nil {
+		return false
+	}
+	for ; dir != ""; dir = parentDir(dir) {
+		if d.tree.MatchString(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// mayMatch returns whether the rule might match any object in dir or
+// the directories below it
+func (d *dirRule) mayMatch(dir string) bool {
+	if d.anywhere {
+		return true
+	}
+	for i, part := range strings.Split(dir, "/") {
+		if i >= len(d.segments) {
+			return d.deep
+		}
+		if !d.segments[i].MatchString(part) {
+			return false
+		}
+	}
+	return true
+}
+
 // filesMap describes the map of files to transfer
 type filesMap map[string]struct{}
 
 // Filter describes any filtering in operation
 type Filter struct {
-	DeleteExcluded bool
-	MinSize        int64
-	MaxSize        int64
-	ModTimeFrom    time.Time
-	ModTimeTo      time.Time
-	rules          []rule
-	files          filesMap
+	DeleteExcluded       bool
+	MinSize              int64
+	MaxSize              int64
+	ModTimeFrom          time.Time
+	ModTimeTo            time.Time
+	DirFilterFile        string // name of the per-directory filter files
+	ExcludeDirFilterFile bool   // don't transfer the per-directory filter files
+	IgnoreCase           bool   // match the rules added after this is set case insensitively
+	rules                []rule
+	files                filesMap
 }
 
 // We use time conventions
@@ -113,14 +206,20 @@ func ParseDuration(age string) (time.Duration, error) {
 // NewFilter parses the command line options and creates a Filter object
 func NewFilter() (f *Filter, err error) {
 	f = &Filter{
-		DeleteExcluded: *deleteExcluded,
-		MinSize:        int64(minSize),
-		MaxSize:        int64(maxSize),
+		DeleteExcluded:       *deleteExcluded,
+		MinSize:              int64(minSize),
+		MaxSize:              int64(maxSize),
+		DirFilterFile:        *dirFilterFile,
+		ExcludeDirFilterFile: *excludeDirFile,
+		IgnoreCase:           *ignoreCase,
+	}
+	if f.DirFilterFile != "" && strings.Contains(f.DirFilterFile, "/") {
+		return nil, fmt.Errorf("--dir-filter-file %q must be a file name not a path", f.DirFilterFile)
 	}
 	addImplicitExclude := false
 
-	if *includeRule != "" {
-		err = f.Add(true, *includeRule)
+	for _, rule := range *includeRule {
+		err = f.Add(true, rule)
 		if err != nil {
 			return nil, err
 		}
@@ -135,8 +234,8 @@ func NewFilter() (f *Filter, err error) {
 		}
 		addImplicitExclude = true
 	}
-	if *excludeRule != "" {
-		err = f.Add(false, *excludeRule)
+	for _, rule := range *excludeRule {
+		err = f.Add(false, rule)
 		if err != nil {
 			return nil, err
 		}
@@ -149,8 +248,8 @@ func NewFilter() (f *Filter, err error) {
 			return nil, err
 		}
 	}
-	if *filterRule != "" {
-		err = f.AddRule(*filterRule)
+	for _, rule := range *filterRule {
+		err = f.AddRule(rule)
 		if err != nil {
 			return nil, err
 		}
@@ -202,15 +301,48 @@ func NewFilter() (f *Filter, err error) {
 	return f, nil
 }
 
+// NewPriorityFilter parses the --priority and --priority-from
+// options and creates a Filter which includes the files to be
+// transferred first, or returns nil if there aren't any
+func NewPriorityFilter() (f *Filter, err error) {
+	if *priorityRule == "" && *priorityFrom == "" {
+		return nil, nil
+	}
+	f = &Filter{IgnoreCase: *ignoreCase}
+	if *priorityRule != "" {
+		err = f.Add(true, *priorityRule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if *priorityFrom != "" {
+		err = forEachLine(*priorityFrom, f.AddRule)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Files which don't match aren't a priority
+	err = f.Add(false, "*")
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
 // Add adds a filter rule with include or exclude status indicated
 func (f *Filter) Add(Include bool, glob string) error {
-	re, err := globToRegexp(glob)
+	re, err := globToRegexp(glob, f.IgnoreCase)
+	if err != nil {
+		return err
+	}
+	dir, err := newDirRule(glob, f.IgnoreCase)
 	if err != nil {
 		return err
 	}
 	rule := rule{
 		Include: Include,
 		Regexp:  re,
+		dir:     dir,
 	}
 	f.rules = append(f.rules, rule)
 	return nil
@@ -258,6 +390,13 @@ func (f *Filter) Clear() {
 // Include returns whether this object should be included into the
 // sync or not
 func (f *Filter) Include(remote string, size int64, modTime time.Time) bool {
+	return f.include(remote, size, modTime, nil)
+}
+
+// include returns whether this object should be included into the
+// sync or not, checking the rules from the per-directory filter files
+// in dirs, if any, before the global rules
+func (f *Filter) include(remote string, size int64, modTime time.Time, dirs *dirFilters) bool {
 	// filesFrom takes precedence
 	if f.files != nil {
 		_, include := f.files[remote]
@@ -275,6 +414,9 @@ func (f *Filter) Include(remote string, size int64, modTime time.Time) bool {
 	if f.MaxSize != 0 && size > f.MaxSize {
 		return false
 	}
+	if include, ok := dirs.match(remote); ok {
+		return include
+	}
 	for _, rule := range f.rules {
 		if rule.Match(remote) {
 			return rule.Include
@@ -283,10 +425,87 @@ func (f *Filter) Include(remote string, size int64, modTime time.Time) bool {
 	return true
 }
 
+// IncludeDirectory returns whether the objects in the directory
+// remote, or the directories below it, might be included by the
+// filters.  It only returns false if the rules exclude every object
+// which could be in it, so it needn't be listed.
+//
+// The rules from per-directory filter files aren't checked, so they
+// can't include objects in a directory excluded by the global rules.
+func (f *Filter) IncludeDirectory(remote string) bool {
+	remote = strings.Trim(remote, "/")
+	if remote == "" {
+		return true
+	}
+	// filesFrom takes precedence
+	if f.files != nil {
+		prefix := remote + "/"
+		for file := range f.files {
+			if strings.HasPrefix(file, prefix) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rule := range f.rules {
+		if rule.dir.covers(remote) {
+			return rule.Include
+		}
+		if rule.Include && rule.dir.mayMatch(remote) {
+			return true
+		}
+	}
+	return true
+}
+
+// prunes returns whether IncludeDirectory might return false
+func (f *Filter) prunes() bool {
+	if f.files != nil {
+		return true
+	}
+	for _, rule := range f.rules {
+		if !rule.Include && (rule.dir.all || rule.dir.tree != nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruning returns whether the sync walk may skip the directories the
+// filters exclude everything in, rather than listing them.
+//
+// Only the sync walk skips directories - the List of an Fs always
+// returns everything so operations like purge which don't use the
+// filters see all the files.
+//
+// Nothing is skipped with --delete-excluded as the excluded objects
+// are needed to delete them.
+func pruning() bool {
+	filter := Config.Filter
+	return filter != nil && !filter.DeleteExcluded && filter.prunes()
+}
+
+// pruneDirectory returns whether the sync walk of f should skip dir,
+// a directory relative to the root of f, as the filters exclude
+// everything in it
+func pruneDirectory(f Fs, dir string) bool {
+	if !pruning() || Config.Filter.IncludeDirectory(dir) {
+		return false
+	}
+	Debug(f, "%s: Excluded directory so not listing it", dir)
+	return true
+}
+
 // IncludeObject returns whether this object should be included into
 // the sync or not. This is a convenience function to avoid calling
 // o.ModTime(), which is an expensive operation.
 func (f *Filter) IncludeObject(o Object) bool {
+	return f.includeObject(o, nil)
+}
+
+// includeObject is IncludeObject checking the rules from the
+// per-directory filter files in dirs too
+func (f *Filter) includeObject(o Object, dirs *dirFilters) bool {
 	var modTime time.Time
 
 	if !f.ModTimeFrom.IsZero() || !f.ModTimeTo.IsZero() {
@@ -295,7 +514,7 @@ func (f *Filter) IncludeObject(o Object) bool {
 		modTime = time.Unix(0, 0)
 	}
 
-	return f.Include(o.Remote(), o.Size(), modTime)
+	return f.include(o.Remote(), o.Size(), modTime, dirs)
 }
 
 // forEachLine calls fn on every line in the file pointed to by path
@@ -307,6 +526,13 @@ func forEachLine(path string, fn func(string) error) (err error) {
 		return err
 	}
 	defer CheckClose(in, &err)
+	return forEachLineIn(in, fn)
+}
+
+// forEachLineIn calls fn on every line read from in
+//
+// It ignores empty lines and lines starting with '#' or ';'
+func forEachLineIn(in io.Reader, fn func(string) error) error {
 	scanner := bufio.NewScanner(in)
 	for scanner.Scan() {
 		line := scanner.Text()
@@ -334,5 +560,8 @@ func (f *Filter) DumpFilters() string {
 	for _, rule := range f.rules {
 		rules = append(rules, rule.String())
 	}
+	if f.DirFilterFile != "" {
+		rules = append(rules, fmt.Sprintf("Rules from %q in each source directory are checked first", f.DirFilterFile))
+	}
 	return strings.Join(rules, "\n")
 }
@@ -69,6 +69,11 @@ func stringP(s string) *string {
 	return &s
 }
 
+// return a pointer to the strings
+func stringsP(s ...string) *[]string {
+	return &s
+}
+
 // testFile creates a temp file with the contents
 func testFile(t *testing.T, contents string) *string {
 	out, err := ioutil.TempFile("", "filter_test")
@@ -98,11 +103,11 @@ func TestNewFilterFull(t *testing.T) {
 
 	// Set up the input
 	deleteExcluded = &isTrue
-	filterRule = stringP("- filter1")
+	filterRule = stringsP("- filter1", "- filter1a")
 	filterFrom = testFile(t, "#comment\n+ filter2\n- filter3\n")
-	excludeRule = stringP("exclude1")
+	excludeRule = stringsP("exclude1", "exclude1a")
 	excludeFrom = testFile(t, "#comment\nexclude2\nexclude3\n")
-	includeRule = stringP("include1")
+	includeRule = stringsP("include1", "include1a")
 	includeFrom = testFile(t, "#comment\ninclude2\ninclude3\n")
 	filesFrom = testFile(t, "#comment\nfiles1\nfiles2\n")
 	minSize = SizeSuffix(mins)
@@ -123,11 +128,11 @@ func TestNewFilterFull(t *testing.T) {
 		minSize = 0
 		maxSize = 0
 		deleteExcluded = &isFalse
-		filterRule = &emptyString
+		filterRule = stringsP()
 		filterFrom = &emptyString
-		excludeRule = &emptyString
+		excludeRule = stringsP()
 		excludeFrom = &emptyString
-		includeRule = &emptyString
+		includeRule = stringsP()
 		includeFrom = &emptyString
 		filesFrom = &emptyString
 	}()
@@ -147,12 +152,15 @@ func TestNewFilterFull(t *testing.T) {
 	}
 	got := f.DumpFilters()
 	want := `+ (^|/)include1$
++ (^|/)include1a$
 + (^|/)include2$
 + (^|/)include3$
 - (^|/)exclude1$
+- (^|/)exclude1a$
 - (^|/)exclude2$
 - (^|/)exclude3$
 - (^|/)filter1$
+- (^|/)filter1a$
 + (^|/)filter2$
 - (^|/)filter3$
 - (^|/)[^/]*$`
@@ -403,3 +411,209 @@ func TestFilterMatchesFromDocs(t *testing.T) {
 		}
 	}
 }
+
+func TestDirFiltersMatch(t *testing.T) {
+	f, err := NewFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.Add(false, "*.bak")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs := &dirFilters{
+		name:    ".rclonefilter",
+		exclude: true,
+		filters: make(map[string]*Filter),
+	}
+	add := func(dir string, rules ...string) {
+		dirFilter := &Filter{}
+		for _, rule := range rules {
+			err := dirFilter.AddRule(rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		dirs.filters[dir] = dirFilter
+	}
+	add("", "- *.log", "+ keep.tmp")
+	add("a", "+ *.log", "- /build", "- *.tmp")
+	add("a/b", "- /data/**", "+ *.bak")
+	for _, test := range []includeTest{
+		{"file.log", 0, 0, false},
+		{"file.bak", 0, 0, false},
+		{"file.tmp", 0, 0, true},
+		{".rclonefilter", 0, 0, false},
+		{"a/file.log", 0, 0, true},
+		{"a/build", 0, 0, false},
+		{"a/c/build", 0, 0, true},
+		{"a/keep.tmp", 0, 0, false},
+		{"a/file.bak", 0, 0, false},
+		{"a/b/keep.tmp", 0, 0, false},
+		{"a/b/file.log", 0, 0, true},
+		{"a/b/file.bak", 0, 0, true},
+		{"a/b/data/file", 0, 0, false},
+		{"a/b/c/data/file", 0, 0, true},
+		{"a/b/.rclonefilter", 0, 0, false},
+		{"c/file.log", 0, 0, false},
+		{"c/keep.tmp", 0, 0, true},
+	} {
+		got := f.include(test.in, test.size, time.Unix(test.modTime, 0), dirs)
+		if test.want != got {
+			t.Errorf("%q: want %v got %v", test.in, test.want, got)
+		}
+	}
+}
+
+func TestFilterIncludeDirectory(t *testing.T) {
+	for _, test := range []struct {
+		rules []string
+		dirs  map[string]bool
+	}{
+		{nil, map[string]bool{
+			"":  true,
+			"a": true,
+		}},
+		{[]string{"- node_modules/**"}, map[string]bool{
+			"node_modules":       false,
+			"a/node_modules":     false,
+			"a/node_modules/b":   false,
+			"a/node_modules_old": true,
+			"a":                  true,
+		}},
+		{[]string{"- /build/**"}, map[string]bool{
+			"build":   false,
+			"build/a": false,
+			"a/build": true,
+		}},
+		{[]string{"+ *.jpg", "- *"}, map[string]bool{
+			"a":   true,
+			"a/b": true,
+		}},
+		{[]string{"+ /docs/**", "- *"}, map[string]bool{
+			"docs":     true,
+			"docs/a/b": true,
+			"src":      false,
+			"src/docs": false,
+		}},
+		{[]string{"+ /a/*/c/*.jpg", "- **"}, map[string]bool{
+			"a":       true,
+			"a/b":     true,
+			"a/b/c":   true,
+			"a/b/d":   false,
+			"a/b/c/d": false,
+			"b":       false,
+		}},
+		{[]string{"+ /{a,b/c}/*.jpg", "- *"}, map[string]bool{
+			"a":   true,
+			"b/c": true,
+			"d":   true,
+		}},
+		{[]string{"+ keep/**", "- /a/**"}, map[string]bool{
+			"a":      true,
+			"a/keep": true,
+			"b":      true,
+		}},
+		{[]string{"+ /a/keep/**", "- /a/**"}, map[string]bool{
+			"a":      true,
+			"a/keep": true,
+			"a/lose": false,
+		}},
+		{[]string{"- *.bak", "- /a/*"}, map[string]bool{
+			"a":   true,
+			"a/b": true,
+		}},
+	} {
+		f, err := NewFilter()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rule := range test.rules {
+			err = f.AddRule(rule)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		for dir, want := range test.dirs {
+			got := f.IncludeDirectory(dir)
+			if got != want {
+				t.Errorf("%q: %q: want %v got %v", test.rules, dir, want, got)
+			}
+		}
+	}
+}
+
+func TestFilterIncludeDirectoryFiles(t *testing.T) {
+	f, err := NewFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = f.AddFile("a/b/file1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for dir, want := range map[string]bool{
+		"a":     true,
+		"a/b":   true,
+		"a/b/c": false,
+		"a/c":   false,
+		"b":     false,
+	} {
+		got := f.IncludeDirectory(dir)
+		if got != want {
+			t.Errorf("%q: want %v got %v", dir, want, got)
+		}
+	}
+}
+
+func TestFilterIgnoreCaseAndRegexp(t *testing.T) {
+	f, err := NewFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	add := func(s string) {
+		err := f.AddRule(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("- {{\\.(bak|tmp)$}}")
+	f.IgnoreCase = true
+	add("+ *.jpg")
+	add("+ /Photos/{{IMG_[0-9]{4}}}.png")
+	add("- *")
+	got := f.DumpFilters()
+	want := `- (^|/)(\.(bak|tmp)$)$
++ (?i)(^|/)[^/]*\.jpg$
++ (?i)^Photos/(IMG_[0-9]{4})\.png$
+- (?i)(^|/)[^/]*$`
+	if got != want {
+		t.Errorf("rules want %s got %s", want, got)
+	}
+	testInclude(t, f, []includeTest{
+		{"file.bak", 0, 0, false},
+		{"file.BAK", 0, 0, false},
+		{"file.jpg", 0, 0, true},
+		{"FILE.JPG", 0, 0, true},
+		{"photos/img_1234.PNG", 0, 0, true},
+		{"Photos/IMG_123.png", 0, 0, false},
+		{"Other/IMG_1234.png", 0, 0, false},
+	})
+
+	f, err = NewFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.IgnoreCase = true
+	add("- /Build/**")
+	for dir, want := range map[string]bool{
+		"build":   false,
+		"BUILD/a": false,
+		"src":     true,
+	} {
+		got := f.IncludeDirectory(dir)
+		if got != want {
+			t.Errorf("%q: want %v got %v", dir, want, got)
+		}
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"path"
 	"path/filepath"
 	"regexp"
 	"sort"
 	"time"
+
+	"golang.org/x/net/context"
 )
 
 // Constants
@@ -51,10 +54,11 @@ type RegInfo struct {
 
 // Option is describes an option for the config wizard
 type Option struct {
-	Name     string
-	Help     string
-	Optional bool
-	Examples OptionExamples
+	Name       string
+	Help       string
+	Optional   bool
+	IsPassword bool
+	Examples   OptionExamples
 }
 
 // OptionExamples is a slice of examples
@@ -235,6 +239,103 @@ type UnWrapper interface {
 	UnWrap() Fs
 }
 
+// LocalDisk is an optional interface for Fs
+type LocalDisk interface {
+	// LocalDisk returns true if the Fs stores its objects on a
+	// local disk rather than on a remote
+	LocalDisk() bool
+}
+
+// DirLister is an optional interface for Fs
+type DirLister interface {
+	// ListDirectory lists the objects and directories directly
+	// inside dir, which is "" for the root, without recursing.
+	//
+	// The Name of each Dir is its path relative to the root of
+	// the Fs in the same way as Object.Remote().  Neither need be
+	// in any particular order.
+	//
+	// This lets sync work on one directory at a time rather than
+	// holding the listing of the whole tree in memory, and see
+	// the directories which have no objects in.
+	//
+	// Listing should stop with ctx.Err() if ctx is cancelled.
+	ListDirectory(ctx context.Context, dir string) (objects []Object, dirs []*Dir, err error)
+}
+
+// DirMaker is an optional interface for Fs which have real
+// directories which can exist without any objects in them
+type DirMaker interface {
+	// MkdirPath makes the directory dir, which is relative to
+	// the root, and any parents it needs
+	//
+	// Shouldn't return an error if it already exists
+	MkdirPath(dir string) error
+
+	// RmdirPath removes the directory dir, which is relative to
+	// the root
+	//
+	// Return an error if it doesn't exist or isn't empty
+	RmdirPath(dir string) error
+}
+
+// DirModTimeSetter is an optional interface for Fs
+type DirModTimeSetter interface {
+	// SetDirModTime sets the modification time of the directory
+	// dir, which is relative to the root
+	SetDirModTime(dir string, modTime time.Time) error
+}
+
+// ContextPutter is an optional interface for Fs
+type ContextPutter interface {
+	// PutContext is like Put but if ctx is cancelled then the
+	// upload is aborted, and any calls waiting for the pacer or to
+	// be retried given up, returning ctx.Err()
+	PutContext(ctx context.Context, in io.Reader, src ObjectInfo) (Object, error)
+}
+
+// ContextUpdater is an optional interface for Object
+type ContextUpdater interface {
+	// UpdateContext is like Update but if ctx is cancelled then
+	// the upload is aborted, and any calls waiting for the pacer or
+	// to be retried given up, returning ctx.Err()
+	UpdateContext(ctx context.Context, in io.Reader, src ObjectInfo) error
+}
+
+// UploadAborter is an optional interface for Fs
+type UploadAborter interface {
+	// AbortUpload cancels the upload session in the saved state s
+	// of an upload of s.Object so it doesn't use up space on the
+	// remote
+	AbortUpload(s *UploadState) error
+}
+
+// StreamHasher is an optional interface for the ObjectInfo passed to
+// Put and Update
+type StreamHasher interface {
+	// StreamHashes returns the types of hash which are being
+	// calculated from the data as it is read from the input
+	StreamHashes() HashSet
+
+	// StreamHash returns the hash of the data read from the input
+	// so far, which is the hash of the object once the input has
+	// been read to the end.  Remotes which need a hash the source
+	// can't supply can use this rather than reading the data twice.
+	StreamHash(HashType) (string, error)
+}
+
+// RangeOpener is an optional interface for Object
+type RangeOpener interface {
+	// OpenRange opens the file for read starting at offset and
+	// returning at most length bytes.  If length is negative then
+	// it reads to the end of the file.  Call Close() on the
+	// returned io.ReadCloser
+	//
+	// offset will be less than the size of the object and length
+	// won't be 0 - use fs.OpenRange to deal with those cases
+	OpenRange(offset, length int64) (io.ReadCloser, error)
+}
+
 // ObjectsChan is a channel of Objects
 type ObjectsChan chan Object
 
@@ -252,7 +353,7 @@ type ObjectPairChan chan ObjectPair
 
 // Dir describes a directory for directory/container/bucket lists
 type Dir struct {
-	Name  string    // name of the directory
+	Name  string    // name of the directory, or its path for ListDirectory
 	When  time.Time // modification or creation time - IsZero for unknown
 	Bytes int64     // size of directory and contents -1 for unknown
 	Count int64     // number of objects -1 for unknown
@@ -305,6 +406,31 @@ func NewFs(path string) (Fs, error) {
 	return fs.NewFs(configName, fsPath)
 }
 
+// NewSubFs makes a new Fs for the same remote as f rooted at dir
+// within it, or returns f if dir is ""
+//
+// This is needed to use operations like Mkdir, Rmdir and DirMove
+// which only work on the root of an Fs on a directory within it.
+func NewSubFs(f Fs, dir string) (Fs, error) {
+	if dir == "" {
+		return f, nil
+	}
+	configName := f.Name()
+	fsName, err := ConfigFile.GetValue(configName, "type")
+	if err != nil {
+		if configName != "local" {
+			return nil, ErrorNotFoundInConfigFile
+		}
+		// NewFs names paths which aren't in the config file "local"
+		fsName = "local"
+	}
+	fs, err := Find(fsName)
+	if err != nil {
+		return nil, err
+	}
+	return fs.NewFs(configName, path.Join(filepath.ToSlash(f.Root()), dir))
+}
+
 // OutputLog logs for an object
 func OutputLog(o interface{}, text string, args ...interface{}) {
 	description := ""
@@ -0,0 +1,51 @@
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncw/rclone/fs"
+)
+
+func TestNewSubFs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-subfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	err = os.MkdirAll(filepath.Join(dir, "sub", "dir"), 0777)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "sub", "dir", "file"), []byte("hello"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := fs.NewFs(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	same, err := fs.NewSubFs(f, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same != f {
+		t.Errorf("Expecting f to be returned for the root")
+	}
+
+	sub, err := fs.NewSubFs(f, "sub/dir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sub.Name() != f.Name() {
+		t.Errorf("Expecting name %q got %q", f.Name(), sub.Name())
+	}
+	if o := sub.NewFsObject("file"); o == nil {
+		t.Errorf("Expecting to find file in the sub Fs")
+	}
+}
@@ -9,11 +9,18 @@ import (
 	"strings"
 )
 
-// globToRegexp converts an rsync style glob to a regexp
+// globToRegexp converts an rsync style glob to a regexp, matching
+// case insensitively if ignoreCase is set
+//
+// Anything between "{{" and "}}" is a regular expression which is
+// put into the regexp unchanged.
 //
 // documented in filtering.md
-func globToRegexp(glob string) (*regexp.Regexp, error) {
+func globToRegexp(glob string, ignoreCase bool) (*regexp.Regexp, error) {
 	var re bytes.Buffer
+	if ignoreCase {
+		_, _ = re.WriteString("(?i)")
+	}
 	if strings.HasPrefix(glob, "/") {
 		glob = glob[1:]
 		_, _ = re.WriteRune('^')
@@ -38,7 +45,11 @@ func globToRegexp(glob string) (*regexp.Regexp, error) {
 	inBraces := false
 	inBrackets := 0
 	slashed := false
-	for _, c := range glob {
+	regexpEnd := 0
+	for i, c := range glob {
+		if i < regexpEnd {
+			continue
+		}
 		if slashed {
 			_, _ = re.WriteRune(c)
 			slashed = false
@@ -74,6 +85,21 @@ func globToRegexp(glob string) (*regexp.Regexp, error) {
 		case ']':
 			return nil, fmt.Errorf("mismatched ']' in glob %q", glob)
 		case '{':
+			if !inBraces && strings.HasPrefix(glob[i:], "{{") {
+				end := strings.Index(glob[i+2:], "}}")
+				if end >= 0 {
+					regexpEnd = i + 2 + end + 2
+					// "}}}" ends with the last "}}" so the
+					// regexp can end in a repeat like "{2}"
+					for regexpEnd < len(glob) && glob[regexpEnd] == '}' {
+						regexpEnd++
+					}
+					_, _ = re.WriteRune('(')
+					_, _ = re.WriteString(glob[i+2 : regexpEnd-2])
+					_, _ = re.WriteRune(')')
+					continue
+				}
+			}
 			if inBraces {
 				return nil, fmt.Errorf("can't nest '{' '}' in glob %q", glob)
 			}
@@ -38,8 +38,12 @@ func TestGlobToRegexp(t *testing.T) {
 		{`[a--b]`, `(^|/)`, `Bad glob pattern`},
 		{`a\*b`, `(^|/)a\*b$`, ``},
 		{`a\\b`, `(^|/)a\\b$`, ``},
+		{`{{.*\.jpe?g}}`, `(^|/)(.*\.jpe?g)$`, ``},
+		{`/dir/{{[0-9]+}}.log`, `^dir/([0-9]+)\.log$`, ``},
+		{`{{a}}*{{b{2}}}`, `(^|/)(a)[^/]*(b{2})$`, ``},
+		{`{{(}}`, `(^|/)`, `Bad glob pattern`},
 	} {
-		gotRe, err := globToRegexp(test.in)
+		gotRe, err := globToRegexp(test.in, false)
 		if test.error == "" {
 			if err != nil {
 				t.Errorf("%q: not expecting error: %v", test.in, err)
@@ -62,3 +66,26 @@ func TestGlobToRegexp(t *testing.T) {
 	}
 
 }
+
+func TestGlobToRegexpIgnoreCase(t *testing.T) {
+	re, err := globToRegexp("/Dir/*.JPG", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want, got := `(?i)^Dir/[^/]*\.JPG$`, re.String(); want != got {
+		t.Errorf("want %q got %q", want, got)
+	}
+	for _, test := range []struct {
+		in   string
+		want bool
+	}{
+		{"Dir/a.JPG", true},
+		{"dir/a.jpg", true},
+		{"DIR/a.Jpg", true},
+		{"other/a.jpg", false},
+	} {
+		if got := re.MatchString(test.in); got != test.want {
+			t.Errorf("%q: want %v got %v", test.in, test.want, got)
+		}
+	}
+}
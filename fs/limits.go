@@ -0,0 +1,142 @@
+// Safety limits on how much a sync may delete and transfer
+
+package fs
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Errors returned when a limit stops the sync
+var (
+	ErrorMaxDeleteExceeded  = fmt.Errorf("Not deleting files as the deletions exceed --max-delete")
+	ErrorMaxTransferReached = fmt.Errorf("Stopped transferring files as --max-transfer was reached")
+	ErrorMaxDurationReached = fmt.Errorf("Stopped transferring files as --max-duration was reached")
+)
+
+// LimitsInfo keeps track of the --max-delete, --max-transfer and
+// --max-duration limits and the work skipped because of them
+type LimitsInfo struct {
+	mu               sync.Mutex
+	err              error     // the first limit reached, nil if none
+	start            time.Time // when the run started
+	startedBytes     int64     // size of the transfers started so far
+	skippedTransfers int64     // number of transfers not started
+	skippedBytes     int64     // size of the transfers not started
+	skippedDeletes   int64     // number of deletions not done
+}
+
+// Limits is the global LimitsInfo
+var Limits = NewLimits()
+
+// NewLimits creates an initialised LimitsInfo
+func NewLimits() *LimitsInfo {
+	return &LimitsInfo{
+		start: time.Now(),
+	}
+}
+
+// Reset clears the limits reached and the work skipped, and restarts
+// the --max-duration clock
+func (l *LimitsInfo) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.err = nil
+	l.start = time.Now()
+	l.startedBytes = 0
+	l.skippedTransfers = 0
+	l.skippedBytes = 0
+	l.skippedDeletes = 0
+}
+
+// reached records err as the limit which was reached, logging it the
+// first time.  Call with the mutex held.
+func (l *LimitsInfo) reached(err error) {
+	if l.err == nil {
+		l.err = err
+		ErrorLog(nil, "%v", err)
+	}
+}
+
+// Err returns the first limit which was reached or nil if none were
+func (l *LimitsInfo) Err() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// AllowTransfer returns whether the transfer of src may start.
+//
+// Once --max-transfer bytes of transfers have been started or the
+// run has taken longer than --max-duration no more are allowed, and
+// the ones not started are counted as skipped.
+func (l *LimitsInfo) AllowTransfer(src ObjectInfo) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case Config.MaxTransfer > 0 && l.startedBytes >= int64(Config.MaxTransfer):
+		l.reached(ErrorMaxTransferReached)
+	case Config.MaxDuration > 0 && time.Since(l.start) >= Config.MaxDuration:
+		l.reached(ErrorMaxDurationReached)
+	default:
+		if size := src.Size(); size > 0 {
+			l.startedBytes += size
+		}
+		return true
+	}
+	Debug(src, "Not transferring as a limit was reached")
+	l.skippedTransfers++
+	if size := src.Size(); size > 0 {
+		l.skippedBytes += size
+	}
+	return false
+}
+
+// AllowDeletes returns whether n files may be deleted from a
+// destination with total files in.
+//
+// If n is more than --max-delete or --max-delete-percent of total
+// then none of them are allowed and they are counted as skipped.
+func (l *LimitsInfo) AllowDeletes(n, total int) bool {
+	if n == 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch {
+	case Config.MaxDelete >= 0 && n > Config.MaxDelete:
+		ErrorLog(nil, "Planned to delete %d files which is more than --max-delete %d", n, Config.MaxDelete)
+	case Config.MaxDeletePercent >= 0 && total > 0 && n*100 > Config.MaxDeletePercent*total:
+		ErrorLog(nil, "Planned to delete %d of %d files which is more than --max-delete-percent %d", n, total, Config.MaxDeletePercent)
+	default:
+		return true
+	}
+	l.reached(ErrorMaxDeleteExceeded)
+	l.skippedDeletes += int64(n)
+	return false
+}
+
+// String returns a summary of the work skipped because of the limits
+func (l *LimitsInfo) String() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, `
+Limit reached: %v
+Skipped:       %10d Transfers (%d Bytes)
+Skipped:       %10d Deletes
+`,
+		l.err,
+		l.skippedTransfers, l.skippedBytes,
+		l.skippedDeletes)
+	return buf.String()
+}
+
+// deleteLimited returns whether the deletions must be collected so
+// they can be checked against --max-delete or --max-delete-percent
+// before any are done
+func deleteLimited() bool {
+	return Config.MaxDelete >= 0 || Config.MaxDeletePercent >= 0
+}
@@ -3,15 +3,20 @@
 package fs
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"mime"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"golang.org/x/net/context"
 	"golang.org/x/text/unicode/norm"
 )
 
@@ -104,6 +109,12 @@ func CheckHashes(src, dst Object) (equal bool, hash HashType, err error) {
 // Otherwise the file is considered to be not equal including if there
 // were errors reading info.
 func Equal(src, dst Object) bool {
+	return equal(src, dst, true)
+}
+
+// equal is Equal which only updates the mtime on the dst if
+// setModTime is set
+func equal(src, dst Object, setModTime bool) bool {
 	if src.Size() != dst.Size() {
 		Debug(src, "Sizes differ")
 		return false
@@ -140,7 +151,7 @@ func Equal(src, dst Object) bool {
 		return false
 	}
 
-	if !Config.CheckSum {
+	if !Config.CheckSum && setModTime {
 		// Size and hash the same but mtime different so update the
 		// mtime of the dst object here
 		dst.SetModTime(srcModTime)
@@ -163,6 +174,108 @@ func MimeType(o Object) string {
 	return mimeType
 }
 
+// readCloser joins an io.Reader and an io.Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes.  If length is negative then it
+// reads to the end of the object.
+//
+// If the object implements RangeOpener then only the bytes needed are
+// fetched, otherwise it uses OpenAndSkip.
+func OpenRange(o Object, offset, length int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("Can't open %v at negative offset %d", o, offset)
+	}
+	if length == 0 {
+		return ioutil.NopCloser(strings.NewReader("")), nil
+	}
+	if size := o.Size(); size >= 0 {
+		if offset >= size {
+			return ioutil.NopCloser(strings.NewReader("")), nil
+		}
+		if length < 0 || offset+length > size {
+			length = size - offset
+		}
+	}
+	if do, ok := o.(RangeOpener); ok {
+		return do.OpenRange(offset, length)
+	}
+	return OpenAndSkip(o, offset, length)
+}
+
+// OpenAndSkip opens the object from the start and discards the
+// first offset bytes, returning at most length bytes or to the end if
+// length is negative.
+//
+// This can be used by objects which can't always read a range.
+func OpenAndSkip(o Object, offset, length int64) (io.ReadCloser, error) {
+	in, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	_, err = io.CopyN(ioutil.Discard, in, offset)
+	if err == io.EOF {
+		err = nil
+	}
+	if err != nil {
+		_ = in.Close() // ignore error
+		return nil, err
+	}
+	if length < 0 {
+		return in, nil
+	}
+	return &readCloser{Reader: io.LimitReader(in, length), Closer: in}, nil
+}
+
+// Skip discards the first n bytes of in, which mustn't have been read
+// from yet.
+//
+// If in is an io.Seeker, as the reader Copy passes to Put and Update
+// is, then it is seeked instead which reopens the source at n so the
+// skipped data isn't read again.
+func Skip(in io.Reader, n int64) error {
+	if seeker, ok := in.(io.Seeker); ok {
+		_, err := seeker.Seek(n, 0)
+		return err
+	}
+	_, err := io.CopyN(ioutil.Discard, in, n)
+	return err
+}
+
+// RangeHeader returns the value of an HTTP Range header to read
+// length bytes starting at offset, or to the end of the file if
+// length is negative
+func RangeHeader(offset, length int64) string {
+	if length < 0 {
+		return fmt.Sprintf("bytes=%d-", offset)
+	}
+	return fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)
+}
+
+// PutContext uploads in to f as src.  If f implements ContextPutter
+// then the upload is aborted if ctx is cancelled, otherwise it is
+// the same as f.Put.
+func PutContext(ctx context.Context, f Fs, in io.Reader, src ObjectInfo) (Object, error) {
+	if do, ok := f.(ContextPutter); ok {
+		return do.PutContext(ctx, in, src)
+	}
+	return f.Put(in, src)
+}
+
+// UpdateContext updates o with in as src.  If o implements
+// ContextUpdater then the upload is aborted if ctx is cancelled,
+// otherwise it is the same as o.Update.
+func UpdateContext(ctx context.Context, o Object, in io.Reader, src ObjectInfo) error {
+	if do, ok := o.(ContextUpdater); ok {
+		return do.UpdateContext(ctx, in, src)
+	}
+	return o.Update(in, src)
+}
+
 // Used to remove a failed copy
 //
 // Returns whether the file was succesfully removed or not
@@ -179,17 +292,102 @@ func removeFailedCopy(dst Object) bool {
 	return true
 }
 
+// contextReader wraps an io.ReadCloser so that reads fail once the
+// context is cancelled
+type contextReader struct {
+	ctx context.Context
+	in  io.ReadCloser
+}
+
+// Read bytes returning ctx.Err() if the context is cancelled
+func (r *contextReader) Read(p []byte) (n int, err error) {
+	if err = r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.in.Read(p)
+}
+
+// Close the underlying reader
+func (r *contextReader) Close() error {
+	return r.in.Close()
+}
+
+// openSource adds the buffering and cancelling Copy needs to in, the
+// data of src read from offset
+func openSource(ctx context.Context, src Object, in io.ReadCloser, offset int64) io.ReadCloser {
+	// On big files add a buffer
+	if src.Size()-offset > 10<<20 {
+		in, _ = newAsyncReader(in, 4, 4<<20)
+	}
+
+	// abort the transfer if the context is cancelled
+	return &contextReader{ctx: ctx, in: in}
+}
+
+// uploadReader is the data of src being uploaded by Copy, with the
+// hashes the destination supports calculated as it is read if hasher
+// is set.
+//
+// A remote resuming an upload can skip the data it has already by
+// seeking before reading.  The source is reopened at the offset so the
+// skipped data isn't read, counted or rate limited again.
+type uploadReader struct {
+	ctx    context.Context
+	src    Object
+	acc    *Account
+	hasher *MultiHasher
+	read   bool // set once Read has been called
+}
+
+// Read bytes calculating the hashes of them
+func (r *uploadReader) Read(p []byte) (n int, err error) {
+	r.read = true
+	n, err = r.acc.Read(p)
+	if n > 0 && r.hasher != nil {
+		_, _ = r.hasher.Write(p[:n])
+	}
+	return n, err
+}
+
+// Seek to offset from the start which can only be done before
+// reading.  The hashes of the data are no longer calculated.
+func (r *uploadReader) Seek(offset int64, whence int) (int64, error) {
+	if whence != 0 || offset < 0 || r.read {
+		return 0, errors.New("can only seek forwards from the start before reading")
+	}
+	if offset == 0 {
+		return 0, nil
+	}
+	in, err := OpenRange(r.src, offset, -1)
+	if err != nil {
+		return 0, err
+	}
+	err = r.acc.setReader(openSource(r.ctx, r.src, in, offset))
+	if err != nil {
+		return 0, err
+	}
+	r.hasher = nil
+	Debug(r.src, "Skipped to offset %d", offset)
+	return offset, nil
+}
+
 // Copy src object to dst or f if nil
 //
 // If dst is nil then the object must not exist already.  If you do
 // call Copy() with dst nil on a pre-existing file then some filing
 // systems (eg Drive) may duplicate the file.
-func Copy(f Fs, dst, src Object) {
+//
+// If ctx is cancelled then the transfer is aborted and any partially
+// transferred dst removed.
+func Copy(ctx context.Context, f Fs, dst, src Object) {
 	const maxTries = 10
 	tries := 0
 	doUpdate := dst != nil
 	var err, inErr error
+	var streamed HashSet               // hashes calculated while sending
+	var streamSums map[HashType]string // and their values
 tryAgain:
+	streamed, streamSums = HashSet(HashNone), nil
 	// Try server side copy first - if has optional interface and
 	// is same underlying remote
 	actionTaken := "Copied (server side copy)"
@@ -211,22 +409,37 @@ tryAgain:
 			ErrorLog(src, "Failed to open: %s", err)
 			return
 		}
-
-		// On big files add a buffer
-		if src.Size() > 10<<20 {
-			in0, _ = newAsyncReader(in0, 4, 4<<20)
-		}
+		in0 = openSource(ctx, src, in0, 0)
 
 		in := NewAccount(in0, src) // account the transfer
+		in.limitBandwidth(src.Fs(), f)
+
+		// Calculate the hashes the destination supports as the
+		// data is sent to check the transfer with
+		upload := &uploadReader{ctx: ctx, src: src, acc: in}
+		var uploadSrc ObjectInfo = src
+		if hashes := f.Hashes(); !Config.SizeOnly && hashes.Count() > 0 {
+			upload.hasher, err = NewMultiHasherTypes(hashes)
+			if err == nil {
+				uploadSrc = &streamHashedObject{Object: src, hashes: hashes, upload: upload}
+			}
+		}
 
 		if doUpdate {
 			actionTaken = "Copied (updated existing)"
-			err = dst.Update(in, src)
+			err = UpdateContext(ctx, dst, upload, uploadSrc)
 		} else {
 			actionTaken = "Copied (new)"
-			dst, err = f.Put(in, src)
+			dst, err = PutContext(ctx, f, upload, uploadSrc)
 		}
 		inErr = in.Close()
+		if err == nil && upload.hasher != nil {
+			streamed, streamSums = f.Hashes(), upload.hasher.Sums()
+		}
+	}
+	// Don't retry if the transfer was aborted
+	if err != nil && ctx.Err() != nil {
+		err = ctx.Err()
 	}
 	// Retry if err returned a retry error
 	if r, ok := err.(Retry); ok && r.Retry() && tries < maxTries {
@@ -258,16 +471,25 @@ tryAgain:
 		return
 	}
 
-	// Verify hashes are the same after transfer - ignoring blank hashes
-	// TODO(klauspost): This could be extended, so we always create a hash type matching
-	// the destination, and calculate it while sending.
+	// Verify hashes are the same after transfer - ignoring blank
+	// hashes.  If the source hasn't got a hash in common with the
+	// destination then use one calculated while sending.
 	common := src.Fs().Hashes().Overlap(dst.Fs().Hashes())
+	useStreamed := common.Count() == 0
+	if useStreamed {
+		common = streamed.Overlap(dst.Fs().Hashes())
+	}
 	// Debug(src, "common hashes: %v", common)
 	if !Config.SizeOnly && common.Count() > 0 {
 		// Get common hash type
 		hashType := common.GetOne()
 
-		srcSum, err := src.Hash(hashType)
+		var srcSum string
+		if useStreamed {
+			srcSum = streamSums[hashType]
+		} else {
+			srcSum, err = src.Hash(hashType)
+		}
 		if err != nil {
 			Stats.Error()
 			ErrorLog(src, "Failed to read src hash: %s", err)
@@ -289,8 +511,248 @@ tryAgain:
 	Debug(src, actionTaken)
 }
 
+// streamHashedObject is an Object being uploaded with its hashes
+// calculated from the data as it is read
+type streamHashedObject struct {
+	Object
+	hashes HashSet
+	upload *uploadReader
+}
+
+// StreamHashes returns the types of hash being calculated, which is
+// none if the upload skipped some of the data
+func (o *streamHashedObject) StreamHashes() HashSet {
+	if o.upload.hasher == nil {
+		return HashSet(HashNone)
+	}
+	return o.hashes
+}
+
+// StreamHash returns the hash of the data read so far
+func (o *streamHashedObject) StreamHash(t HashType) (string, error) {
+	if !o.StreamHashes().Contains(t) {
+		return "", ErrHashUnsupported
+	}
+	return o.upload.hasher.Sums()[t], nil
+}
+
+// renamedObject is an Object with a different Remote so it can be
+// copied under a new name
+type renamedObject struct {
+	Object
+	remote string
+}
+
+// Remote returns the new name of the object
+func (o *renamedObject) Remote() string {
+	return o.remote
+}
+
+// renameObject renames o, which must be on f, to remote using a
+// server side Move, or a server side Copy then delete, returning
+// ErrorCantMove if f can't do either
+func renameObject(f Fs, o Object, remote string) (Object, error) {
+	if fMover, ok := f.(Mover); ok {
+		newObj, err := fMover.Move(o, remote)
+		if err != ErrorCantMove {
+			return newObj, err
+		}
+	}
+	if fCopier, ok := f.(Copier); ok {
+		newObj, err := fCopier.Copy(o, remote)
+		if err == nil {
+			return newObj, o.Remove()
+		}
+		if err != ErrorCantCopy {
+			return nil, err
+		}
+	}
+	return nil, ErrorCantMove
+}
+
+// moveObject moves o to remote in f, using server side move or copy
+// if f supports it and is the same remote as o, otherwise by copying
+// and deleting
+func moveObject(ctx context.Context, f Fs, o Object, remote string) error {
+	if o.Fs().Name() == f.Name() {
+		_, err := renameObject(f, o, remote)
+		if err != ErrorCantMove {
+			return err
+		}
+	}
+	var src Object = o
+	if remote != o.Remote() {
+		src = &renamedObject{Object: o, remote: remote}
+	}
+	Copy(ctx, f, f.NewFsObject(remote), src)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	dst := f.NewFsObject(remote)
+	if dst == nil || dst.Size() != o.Size() {
+		return fmt.Errorf("failed to copy to %v", f)
+	}
+	return o.Remove()
+}
+
+// backupDir is where sync puts the files it would otherwise delete or
+// overwrite
+type backupDir struct {
+	f      Fs
+	suffix string
+}
+
+// newBackupDir makes the backupDir for syncing to fdst from
+// Config.BackupDir, returning nil if it isn't set
+func newBackupDir(fdst Fs) (*backupDir, error) {
+	if Config.BackupDir == "" {
+		return nil, nil
+	}
+	f, err := NewFs(Config.BackupDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to make --backup-dir %q: %v", Config.BackupDir, err)
+	}
+	if Overlapping(f, fdst) {
+		return nil, fmt.Errorf("--backup-dir %q can't overlap the destination", Config.BackupDir)
+	}
+	suffix := Config.Suffix
+	if Config.SuffixTimestamp {
+		suffix += time.Now().Format("-20060102-150405")
+	}
+	if !Config.DryRun {
+		err = f.Mkdir()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &backupDir{f: f, suffix: suffix}, nil
+}
+
+// backup moves o into the backup dir
+func (b *backupDir) backup(ctx context.Context, o Object) error {
+	if Config.DryRun {
+		Log(o, "Not moving into backup dir as --dry-run")
+		return nil
+	}
+	err := moveObject(ctx, b.f, o, o.Remote()+b.suffix)
+	if err != nil {
+		return err
+	}
+	Debug(o, "Moved into backup dir")
+	return nil
+}
+
+// referenceDirs are the directories given with --compare-dest or
+// --copy-dest which the source is compared against as well as the
+// destination
+type referenceDirs struct {
+	fs   []Fs
+	copy bool // server side copy matching files rather than skip them
+
+	mu    sync.Mutex
+	found map[string][]Object // reference objects by remote for source objects not in the destination
+}
+
+// newReferenceDirs makes the referenceDirs for syncing to fdst from
+// Config.CompareDest or Config.CopyDest, returning nil if neither is
+// set
+func newReferenceDirs(fdst Fs) (*referenceDirs, error) {
+	flag, paths := "--compare-dest", Config.CompareDest
+	if len(Config.CopyDest) > 0 {
+		flag, paths = "--copy-dest", Config.CopyDest
+	}
+	if len(paths) == 0 {
+		return nil, nil
+	}
+	r := &referenceDirs{
+		copy:  len(Config.CopyDest) > 0,
+		found: make(map[string][]Object),
+	}
+	for _, refPath := range paths {
+		f, err := NewFs(refPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make %s %q: %v", flag, refPath, err)
+		}
+		if Overlapping(f, fdst) {
+			return nil, fmt.Errorf("%s %q can't overlap the destination", flag, refPath)
+		}
+		r.fs = append(r.fs, f)
+	}
+	return r, nil
+}
+
+// add remembers the objects with the same remote as src, which isn't
+// in the destination, from refObjects, the sorted listings of the
+// directory src is in from each reference directory
+func (r *referenceDirs) add(src Object, refObjects [][]Object) {
+	if r == nil {
+		return
+	}
+	remote := src.Remote()
+	var found []Object
+	for _, objects := range refObjects {
+		i := sort.Search(len(objects), func(i int) bool {
+			return objects[i].Remote() >= remote
+		})
+		if i < len(objects) && objects[i].Remote() == remote {
+			found = append(found, objects[i])
+		}
+	}
+	if len(found) == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.found[remote] = found
+	r.mu.Unlock()
+}
+
+// find returns the first object remembered by add for src which is
+// the same as src or nil if there isn't one
+func (r *referenceDirs) find(src Object) Object {
+	r.mu.Lock()
+	found := r.found[src.Remote()]
+	delete(r.found, src.Remote())
+	r.mu.Unlock()
+	for _, ref := range found {
+		if equal(src, ref, false) {
+			return ref
+		}
+	}
+	return nil
+}
+
+// matched looks for src, which isn't in fdst, in the reference
+// directories.  If it is found then src is skipped, or with
+// --copy-dest the reference copy is copied into fdst, server side if
+// possible.
+//
+// It returns whether src is dealt with and doesn't need transferring
+// from the source.
+func (r *referenceDirs) matched(ctx context.Context, fdst Fs, src Object) bool {
+	if r == nil {
+		return false
+	}
+	ref := r.find(src)
+	if ref == nil {
+		return false
+	}
+	if !r.copy {
+		Debug(src, "Not copying as unchanged in %v", ref.Fs())
+		return true
+	}
+	if Config.DryRun {
+		Log(src, "Not copying from %v as --dry-run", ref.Fs())
+		return true
+	}
+	Stats.Transferring(src)
+	Debug(src, "Copying from %v", ref.Fs())
+	Copy(ctx, fdst, nil, ref)
+	Stats.DoneTransferring(src)
+	return true
+}
+
 // Check to see if src needs to be copied to dst and if so puts it in out
-func checkOne(pair ObjectPair, out ObjectPairChan) {
+func checkOne(ctx context.Context, pair ObjectPair, out ObjectPairChan) {
 	src, dst := pair.src, pair.dst
 	if dst == nil {
 		Debug(src, "Couldn't find file - need to transfer")
@@ -311,32 +773,75 @@ func checkOne(pair ObjectPair, out ObjectPairChan) {
 		Debug(src, "Unchanged skipping")
 		return
 	}
+	if ctx.Err() != nil {
+		return
+	}
 	out <- pair
 }
 
 // PairChecker reads Objects~s on in send to out if they need transferring.
 //
+// Once ctx is cancelled it reads the remaining Objects without
+// checking them.
+//
 // FIXME potentially doing lots of hashes at once
-func PairChecker(in ObjectPairChan, out ObjectPairChan, wg *sync.WaitGroup) {
+func PairChecker(ctx context.Context, in ObjectPairChan, out ObjectPairChan, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for pair := range in {
+		if ctx.Err() != nil {
+			continue
+		}
 		src := pair.src
 		Stats.Checking(src)
-		checkOne(pair, out)
+		checkOne(ctx, pair, out)
 		Stats.DoneChecking(src)
 	}
 }
 
+// backupOverwritten moves dst into the backup dir, if there is one,
+// before it is overwritten.  It returns the object to overwrite and
+// whether the transfer should go ahead.
+func backupOverwritten(ctx context.Context, backup *backupDir, dst Object) (Object, bool) {
+	if backup == nil || dst == nil {
+		return dst, true
+	}
+	err := backup.backup(ctx, dst)
+	if err != nil {
+		Stats.Error()
+		ErrorLog(dst, "Couldn't move into backup dir: %v", err)
+		return dst, false
+	}
+	return nil, true
+}
+
 // PairCopier reads Objects on in and copies them.
-func PairCopier(in ObjectPairChan, fdst Fs, wg *sync.WaitGroup) {
+//
+// If backup is set then objects which would be overwritten are moved
+// into it first.
+//
+// If refs is set then objects which aren't in fdst are looked for in
+// the reference directories first.
+//
+// Once ctx is cancelled, or a limit on the transfers is reached, it
+// reads the remaining Objects without copying them.
+func PairCopier(ctx context.Context, in ObjectPairChan, fdst Fs, backup *backupDir, refs *referenceDirs, wg *sync.WaitGroup) {
 	defer wg.Done()
 	for pair := range in {
+		if ctx.Err() != nil {
+			continue
+		}
+		if pair.dst == nil && refs.matched(ctx, fdst, pair.src) {
+			continue
+		}
+		if !Limits.AllowTransfer(pair.src) {
+			continue
+		}
 		src := pair.src
 		Stats.Transferring(src)
 		if Config.DryRun {
 			Log(src, "Not copying as --dry-run")
-		} else {
-			Copy(fdst, pair.dst, src)
+		} else if dst, ok := backupOverwritten(ctx, backup, pair.dst); ok {
+			Copy(ctx, fdst, dst, src)
 		}
 		Stats.DoneTransferring(src)
 	}
@@ -344,19 +849,36 @@ func PairCopier(in ObjectPairChan, fdst Fs, wg *sync.WaitGroup) {
 
 // PairMover reads Objects on in and moves them if possible, or copies
 // them if not
-func PairMover(in ObjectPairChan, fdst Fs, wg *sync.WaitGroup) {
+//
+// If backup is set then objects which would be overwritten are moved
+// into it first.
+//
+// Once ctx is cancelled, or a limit on the transfers is reached, it
+// reads the remaining Objects without moving them.
+func PairMover(ctx context.Context, in ObjectPairChan, fdst Fs, backup *backupDir, wg *sync.WaitGroup) {
 	defer wg.Done()
 	// See if we have Move available
 	fdstMover, haveMover := fdst.(Mover)
 	for pair := range in {
+		if ctx.Err() != nil || !Limits.AllowTransfer(pair.src) {
+			continue
+		}
 		src := pair.src
 		dst := pair.dst
 		Stats.Transferring(src)
+		if !Config.DryRun {
+			var ok bool
+			dst, ok = backupOverwritten(ctx, backup, dst)
+			if !ok {
+				Stats.DoneTransferring(src)
+				continue
+			}
+		}
 		if Config.DryRun {
 			Log(src, "Not moving as --dry-run")
 		} else if haveMover {
 			// Delete destination if it exists
-			if pair.dst != nil {
+			if dst != nil {
 				err := dst.Remove()
 				if err != nil {
 					Stats.Error()
@@ -371,32 +893,52 @@ func PairMover(in ObjectPairChan, fdst Fs, wg *sync.WaitGroup) {
 				Debug(src, "Moved")
 			}
 		} else {
-			Copy(fdst, pair.dst, src)
+			Copy(ctx, fdst, dst, src)
 		}
 		Stats.DoneTransferring(src)
 	}
 }
 
 // DeleteFiles removes all the files passed in the channel
-func DeleteFiles(toBeDeleted ObjectsChan) {
+//
+// Once ctx is cancelled it reads the remaining files without deleting
+// them.
+func DeleteFiles(ctx context.Context, toBeDeleted ObjectsChan) {
+	deleteFiles(ctx, toBeDeleted, nil)
+}
+
+// deleteFiles removes all the files passed in the channel, moving
+// them into backup instead if it is set
+func deleteFiles(ctx context.Context, toBeDeleted ObjectsChan, backup *backupDir) {
 	var wg sync.WaitGroup
 	wg.Add(Config.Transfers)
 	for i := 0; i < Config.Transfers; i++ {
 		go func() {
 			defer wg.Done()
 			for dst := range toBeDeleted {
+				if ctx.Err() != nil {
+					continue
+				}
 				if Config.DryRun {
 					Log(dst, "Not deleting as --dry-run")
 				} else {
 					Stats.Checking(dst)
-					err := dst.Remove()
-					Stats.DoneChecking(dst)
-					if err != nil {
-						Stats.Error()
-						ErrorLog(dst, "Couldn't delete: %s", err)
+					if backup != nil {
+						err := backup.backup(ctx, dst)
+						if err != nil {
+							Stats.Error()
+							ErrorLog(dst, "Couldn't move into backup dir: %v", err)
+						}
 					} else {
-						Debug(dst, "Deleted")
+						err := dst.Remove()
+						if err != nil {
+							Stats.Error()
+							ErrorLog(dst, "Couldn't delete: %s", err)
+						} else {
+							Debug(dst, "Deleted")
+						}
 					}
+					Stats.DoneChecking(dst)
 				}
 			}
 		}()
@@ -437,99 +979,315 @@ func Same(fdst, fsrc Fs) bool {
 	return fdst.Name() == fsrc.Name() && fdst.Root() == fsrc.Root()
 }
 
+// Overlapping returns true if fdst and fsrc point to the same
+// underlying Fs or one of them is inside the other
+func Overlapping(fdst, fsrc Fs) bool {
+	if fdst.Name() != fsrc.Name() {
+		return false
+	}
+	dstRoot, srcRoot := strings.Trim(fdst.Root(), "/"), strings.Trim(fsrc.Root(), "/")
+	return dstRoot == srcRoot || dstRoot == "" || srcRoot == "" ||
+		strings.HasPrefix(dstRoot+"/", srcRoot+"/") ||
+		strings.HasPrefix(srcRoot+"/", dstRoot+"/")
+}
+
+// matchObjects calls fn for each pair of objects with the same Remote
+// in the sorted srcObjects and dstObjects, with src or dst nil if it
+// is only on one side
+func matchObjects(srcObjects, dstObjects []Object, fn func(src, dst Object)) {
+	for i, j := 0, 0; i < len(srcObjects) || j < len(dstObjects); {
+		switch {
+		case j >= len(dstObjects) || (i < len(srcObjects) && srcObjects[i].Remote() < dstObjects[j].Remote()):
+			fn(srcObjects[i], nil)
+			i++
+		case i >= len(srcObjects) || dstObjects[j].Remote() < srcObjects[i].Remote():
+			fn(nil, dstObjects[j])
+			j++
+		default:
+			fn(srcObjects[i], dstObjects[j])
+			i++
+			j++
+		}
+	}
+}
+
+// trackRenamesHash returns the hash type --track-renames should match
+// files from fsrc to fdst with, or HashNone if renames can't be
+// tracked
+func trackRenamesHash(fdst, fsrc Fs) HashType {
+	_, canMove := fdst.(Mover)
+	_, canCopy := fdst.(Copier)
+	if !canMove && !canCopy {
+		ErrorLog(fdst, "Ignoring --track-renames as the destination doesn't support server side move or copy")
+		return HashNone
+	}
+	hashType := fsrc.Hashes().Overlap(fdst.Hashes()).GetOne()
+	if hashType == HashNone {
+		ErrorLog(fdst, "Ignoring --track-renames as the source and destination don't have a common hash")
+	}
+	return hashType
+}
+
+// renameKeys returns the size and hash of each of the objects as a
+// string to match renames on, or "" if the hash couldn't be read.
+// The hashes are read using Config.Checkers go routines.
+func renameKeys(ctx context.Context, objects []Object, hashType HashType) []string {
+	keys := make([]string, len(objects))
+	indexes := make(chan int, Config.Checkers)
+	var wg sync.WaitGroup
+	wg.Add(Config.Checkers)
+	for i := 0; i < Config.Checkers; i++ {
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				o := objects[i]
+				Stats.Checking(o)
+				hash, err := o.Hash(hashType)
+				Stats.DoneChecking(o)
+				if err != nil {
+					Debug(o, "Failed to read %v hash: %v", hashType, err)
+				} else if hash != "" {
+					keys[i] = fmt.Sprintf("%d,%s", o.Size(), hash)
+				}
+			}
+		}()
+	}
+	for i := range objects {
+		if ctx.Err() != nil {
+			break
+		}
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return keys
+}
+
+// matchRenames matches srcs, the source files which aren't in fdst,
+// with dsts, the files in fdst which aren't in the source, by size
+// and hash.
+//
+// Each destination file matched is renamed on fdst to the path of
+// the source file and the pair sent to toBeChecked.  The source files
+// which weren't matched are sent to toBeUploaded and the destination
+// files which weren't are passed to deleteFile.
+func matchRenames(ctx context.Context, fdst Fs, hashType HashType, srcs, dsts []Object, toBeChecked, toBeUploaded ObjectPairChan, deleteFile func(Object)) {
+	Log(fdst, "Looking for renames in %d files", len(srcs))
+	dstKeys := renameKeys(ctx, dsts, hashType)
+	byKey := make(map[string][]Object, len(dsts))
+	for i, dst := range dsts {
+		byKey[dstKeys[i]] = append(byKey[dstKeys[i]], dst)
+	}
+	srcKeys := renameKeys(ctx, srcs, hashType)
+	for i, src := range srcs {
+		if ctx.Err() != nil {
+			return
+		}
+		key := srcKeys[i]
+		matches := byKey[key]
+		if key == "" || len(matches) == 0 {
+			toBeUploaded <- ObjectPair{src, nil}
+			continue
+		}
+		dst := matches[0]
+		byKey[key] = matches[1:]
+		if Config.DryRun {
+			Log(src, "Not renaming from %q as --dry-run", dst.Remote())
+			continue
+		}
+		newDst, err := renameObject(fdst, dst, src.Remote())
+		if err != nil {
+			if err != ErrorCantMove {
+				Stats.Error()
+				ErrorLog(dst, "Couldn't rename to %q: %v", src.Remote(), err)
+			}
+			toBeUploaded <- ObjectPair{src, nil}
+			deleteFile(dst)
+			continue
+		}
+		Debug(src, "Renamed from %q", dst.Remote())
+		toBeChecked <- ObjectPair{src, newDst}
+	}
+	for _, matches := range byKey {
+		for _, dst := range matches {
+			deleteFile(dst)
+		}
+	}
+}
+
 // Syncs fsrc into fdst
 //
 // If Delete is true then it deletes any files in fdst that aren't in fsrc
 //
 // If DoMove is true then files will be moved instead of copied
-func syncCopyMove(fdst, fsrc Fs, Delete bool, DoMove bool) error {
+//
+// The source and destination are walked together a directory at a
+// time, so checks and transfers start straight away and only the
+// listings of the directories in progress are held in memory.  With
+// --delete-before the destination is walked twice, first to delete
+// and then to transfer, and with --delete-after the files to delete
+// are remembered until the transfers are finished.
+//
+// Files are only deleted while there have been no errors.
+//
+// With --track-renames the files only in the source and only in the
+// destination are remembered until the walk is finished, then
+// matched by size and hash and renamed on the destination where
+// possible rather than transferred again.
+//
+// If ctx is cancelled then no new checks, transfers or deletions are
+// started, transfers in progress are aborted and ctx.Err() returned.
+func syncCopyMove(ctx context.Context, fdst, fsrc Fs, Delete bool, DoMove bool) error {
 	if Same(fdst, fsrc) {
 		ErrorLog(fdst, "Nothing to do as source and destination are the same")
 		return nil
 	}
 
-	err := fdst.Mkdir()
+	backup, err := newBackupDir(fdst)
 	if err != nil {
 		Stats.Error()
 		return err
 	}
 
-	Log(fdst, "Building file list")
-
-	// Read the files of both source and destination
-	var listWg sync.WaitGroup
-	listWg.Add(2)
-
-	var dstFiles map[string]Object
-	var srcFiles map[string]Object
-	var srcObjects = make(ObjectsChan, Config.Transfers)
-
-	// Read dst files including excluded files if DeleteExcluded is set
-	go func() {
-		dstFiles = readFilesMap(fdst, Config.Filter.DeleteExcluded)
-		listWg.Done()
-	}()
-
-	// Read src file not including excluded files
-	go func() {
-		srcFiles = readFilesMap(fsrc, false)
-		listWg.Done()
-		for _, v := range srcFiles {
-			srcObjects <- v
+	var refs *referenceDirs
+	if DoMove {
+		if len(Config.CompareDest) > 0 || len(Config.CopyDest) > 0 {
+			ErrorLog(fdst, "Ignoring --compare-dest and --copy-dest as they can't be used with move")
 		}
-		close(srcObjects)
-	}()
-
-	startDeletion := make(chan struct{}, 0)
-
-	// Delete files if asked
-	var delWg sync.WaitGroup
-	delWg.Add(1)
-	go func() {
-		if !Delete {
-			return
+	} else {
+		refs, err = newReferenceDirs(fdst)
+		if err != nil {
+			Stats.Error()
+			return err
 		}
-		defer func() {
-			Debug(fdst, "Deletion finished")
-			delWg.Done()
-		}()
-
-		_ = <-startDeletion
-		Debug(fdst, "Starting deletion")
+	}
 
-		if Stats.Errored() {
-			ErrorLog(fdst, "Not deleting files as there were IO errors")
-			return
-		}
+	err = fdst.Mkdir()
+	if err != nil {
+		Stats.Error()
+		return err
+	}
 
-		// Delete the spare files
-		toDelete := make(ObjectsChan, Config.Transfers)
+	// filter the objects in each directory, reading any
+	// per-directory filter file first
+	dirFilters := newDirFilters()
+	filter := func(dir string, srcObjects, dstObjects []Object) ([]Object, []Object) {
+		dirFilters.read(dir, srcObjects)
+		// Read dst files including excluded files if DeleteExcluded is set
+		return filterObjects(srcObjects, false, dirFilters), filterObjects(dstObjects, Config.Filter.DeleteExcluded, dirFilters)
+	}
 
+	// Delete files if asked
+	var (
+		toDelete       = make(ObjectsChan, Config.Transfers)
+		delWg          sync.WaitGroup
+		deleteAfterMu  sync.Mutex
+		deleteAfter    []Object
+		notDeletedOnce sync.Once
+		dstCount       int64
+	)
+	if Delete {
+		delWg.Add(1)
 		go func() {
-			for key, fs := range dstFiles {
-				_, exists := srcFiles[key]
-				if !exists {
-					toDelete <- fs
-				}
-			}
-			close(toDelete)
+			defer delWg.Done()
+			deleteFiles(ctx, toDelete, backup)
 		}()
-		DeleteFiles(toDelete)
-	}()
+	}
+	// canDelete returns whether it is OK to delete files now
+	canDelete := func() bool {
+		switch {
+		case ctx.Err() != nil:
+			notDeletedOnce.Do(func() {
+				ErrorLog(fdst, "Not deleting files as the sync was cancelled")
+			})
+			return false
+		case Stats.Errored():
+			notDeletedOnce.Do(func() {
+				ErrorLog(fdst, "Not deleting files as there were IO errors")
+			})
+			return false
+		case Limits.Err() != nil:
+			notDeletedOnce.Do(func() {
+				ErrorLog(fdst, "Not deleting files as a limit was reached")
+			})
+			return false
+		}
+		return true
+	}
+	// With a limit on deletions they are all collected first so
+	// they can be counted
+	deferDeletes := Config.DeleteAfter || deleteLimited()
+	// deleteFile deletes dst now or remembers it for later
+	deleteFile := func(dst Object) {
+		if deferDeletes {
+			deleteAfterMu.Lock()
+			deleteAfter = append(deleteAfter, dst)
+			deleteAfterMu.Unlock()
+		} else if canDelete() {
+			toDelete <- dst
+		}
+	}
+	// deleteDeferred deletes the files remembered by deleteFile
+	deleteDeferred := func() {
+		deleteAfterMu.Lock()
+		defer deleteAfterMu.Unlock()
+		if canDelete() && Limits.AllowDeletes(len(deleteAfter), int(atomic.LoadInt64(&dstCount))) {
+			for _, dst := range deleteAfter {
+				toDelete <- dst
+			}
+		}
+		deleteAfter = nil
+	}
+	// countDst counts the destination files for --max-delete-percent
+	countDst := func(dstObjects []Object) {
+		atomic.AddInt64(&dstCount, int64(len(dstObjects)))
+	}
+	// waitForDeletes finishes the deletions
+	waitForDeletes := func(when string) {
+		close(toDelete)
+		Log(fdst, "Waiting for deletes to finish (%s)", when)
+		delWg.Wait()
+		Debug(fdst, "Deletion finished")
+	}
 
-	// Wait for all files to be read
-	listWg.Wait()
+	// Directories to make and remove on the destination
+	dirs := newSyncDirs(fdst)
 
-	// Start deleting, unless we must delete after transfer
-	if Delete && !Config.DeleteAfter {
-		close(startDeletion)
+	// If deletes must finish before starting transfers, walk the
+	// destination doing them first
+	if Delete && Config.DeleteBefore {
+		Log(fdst, "Deleting files (before)")
+		walkDirs(ctx, fsrc, fdst, nil, true, func(dir string, srcObjects, dstObjects []Object, srcDirs, dstDirs []*Dir, _ [][]Object) {
+			srcObjects, dstObjects = filter(dir, srcObjects, dstObjects)
+			countDst(dstObjects)
+			matchObjects(srcObjects, dstObjects, func(src, dst Object) {
+				if src == nil {
+					deleteFile(dst)
+					dirs.touch(dir)
+				}
+			})
+			matchDirs(srcDirs, dstDirs, func(src, dst *Dir) {
+				if src == nil {
+					dirs.remove(dir, dst)
+				}
+			})
+		})
+		deleteDeferred()
+		waitForDeletes("before")
 	}
+	deleteDuringWalk := Delete && !Config.DeleteBefore
 
-	// If deletes must finish before starting transfers, we must wait now.
-	if Delete && Config.DeleteBefore {
-		Log(fdst, "Waiting for deletes to finish (before)")
-		delWg.Wait()
+	// With --track-renames remember the files which don't match
+	renameHash := HashNone
+	if Config.TrackRenames && deleteDuringWalk {
+		renameHash = trackRenamesHash(fdst, fsrc)
 	}
+	trackingRenames := renameHash != HashNone
+	var (
+		renamesMu  sync.Mutex
+		renameSrcs []Object
+		renameDsts []Object
+	)
 
 	// Read source files checking them off against dest files
 	toBeChecked := make(ObjectPairChan, Config.Transfers)
@@ -538,31 +1296,82 @@ func syncCopyMove(fdst, fsrc Fs, Delete bool, DoMove bool) error {
 	var checkerWg sync.WaitGroup
 	checkerWg.Add(Config.Checkers)
 	for i := 0; i < Config.Checkers; i++ {
-		go PairChecker(toBeChecked, toBeUploaded, &checkerWg)
+		go PairChecker(ctx, toBeChecked, toBeUploaded, &checkerWg)
+	}
+
+	// With --order-by or --priority the transfers are sorted
+	// before they reach the copiers
+	toBeTransferred := toBeUploaded
+	if transfersOrdered() {
+		toBeTransferred = make(ObjectPairChan, Config.Transfers)
+		go orderTransfers(ctx, toBeUploaded, toBeTransferred)
 	}
 
 	var copierWg sync.WaitGroup
 	copierWg.Add(Config.Transfers)
 	for i := 0; i < Config.Transfers; i++ {
 		if DoMove {
-			go PairMover(toBeUploaded, fdst, &copierWg)
+			go PairMover(ctx, toBeTransferred, fdst, backup, &copierWg)
 		} else {
-			go PairCopier(toBeUploaded, fdst, &copierWg)
+			go PairCopier(ctx, toBeTransferred, fdst, backup, refs, &copierWg)
 		}
 	}
 
-	go func() {
-		for src := range srcObjects {
-			remote := src.Remote()
-			if dst, dstFound := dstFiles[remote]; dstFound {
+	// Walk the reference directories too so the files not in the
+	// destination can be compared with them
+	var refFs []Fs
+	if refs != nil {
+		refFs = refs.fs
+	}
+
+	Log(fdst, "Walking source and destination")
+	walkDirs(ctx, fsrc, fdst, refFs, deleteDuringWalk, func(dir string, srcObjects, dstObjects []Object, srcDirs, dstDirs []*Dir, refObjects [][]Object) {
+		srcObjects, dstObjects = filter(dir, srcObjects, dstObjects)
+		countDst(dstObjects)
+		dirs.add(dir, srcDirs, dstDirs, deleteDuringWalk)
+		matchObjects(srcObjects, dstObjects, func(src, dst Object) {
+			if ctx.Err() != nil {
+				return
+			}
+			if dst == nil || (src == nil && deleteDuringWalk) {
+				dirs.touch(dir)
+			}
+			if dst == nil {
+				refs.add(src, refObjects)
+			}
+			switch {
+			case src == nil:
+				if trackingRenames {
+					renamesMu.Lock()
+					renameDsts = append(renameDsts, dst)
+					renamesMu.Unlock()
+				} else if deleteDuringWalk {
+					deleteFile(dst)
+				}
+			case dst == nil:
+				if trackingRenames {
+					renamesMu.Lock()
+					renameSrcs = append(renameSrcs, src)
+					renamesMu.Unlock()
+				} else {
+					// No need to check since doesn't exist
+					toBeUploaded <- ObjectPair{src, nil}
+				}
+			default:
 				toBeChecked <- ObjectPair{src, dst}
-			} else {
-				// No need to check since doesn't exist
-				toBeUploaded <- ObjectPair{src, nil}
 			}
-		}
-		close(toBeChecked)
-	}()
+		})
+	})
+	if trackingRenames {
+		matchRenames(ctx, fdst, renameHash, renameSrcs, renameDsts, toBeChecked, toBeUploaded, deleteFile)
+	}
+	close(toBeChecked)
+
+	// Now the whole destination has been seen the deletions which
+	// were held back for the limits can be done
+	if deleteDuringWalk && !Config.DeleteAfter {
+		deleteDeferred()
+	}
 
 	Log(fdst, "Waiting for checks to finish")
 	checkerWg.Wait()
@@ -570,31 +1379,57 @@ func syncCopyMove(fdst, fsrc Fs, Delete bool, DoMove bool) error {
 	Log(fdst, "Waiting for transfers to finish")
 	copierWg.Wait()
 
-	// If deleting after, start deletion now
-	if Delete && Config.DeleteAfter {
-		close(startDeletion)
+	if deleteDuringWalk {
+		// If deleting after, start deletion now
+		if Config.DeleteAfter {
+			deleteDeferred()
+		}
+		waitForDeletes("during+after")
 	}
-	// Unless we have already waited, wait for deletion to finish.
-	if Delete && !Config.DeleteBefore {
-		Log(fdst, "Waiting for deletes to finish (during+after)")
-		delWg.Wait()
+
+	// Remove the directories left empty, then match the
+	// modification times of the directories to the source unless a
+	// limit stopped the transfers which would have made some of them
+	if Delete && canDelete() {
+		dirs.removeDirs()
+	}
+	if ctx.Err() == nil && Limits.Err() == nil {
+		dirs.setModTimes()
 	}
 
-	return nil
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return Limits.Err()
 }
 
 // Sync fsrc into fdst
 func Sync(fdst, fsrc Fs) error {
-	return syncCopyMove(fdst, fsrc, true, false)
+	return SyncContext(context.Background(), fdst, fsrc)
+}
+
+// SyncContext is like Sync but stops when ctx is cancelled
+func SyncContext(ctx context.Context, fdst, fsrc Fs) error {
+	return syncCopyMove(ctx, fdst, fsrc, true, false)
 }
 
 // CopyDir copies fsrc into fdst
 func CopyDir(fdst, fsrc Fs) error {
-	return syncCopyMove(fdst, fsrc, false, false)
+	return CopyDirContext(context.Background(), fdst, fsrc)
+}
+
+// CopyDirContext is like CopyDir but stops when ctx is cancelled
+func CopyDirContext(ctx context.Context, fdst, fsrc Fs) error {
+	return syncCopyMove(ctx, fdst, fsrc, false, false)
 }
 
 // MoveDir moves fsrc into fdst
 func MoveDir(fdst, fsrc Fs) error {
+	return MoveDirContext(context.Background(), fdst, fsrc)
+}
+
+// MoveDirContext is like MoveDir but stops when ctx is cancelled
+func MoveDirContext(ctx context.Context, fdst, fsrc Fs) error {
 	if Same(fdst, fsrc) {
 		ErrorLog(fdst, "Nothing to do as source and destination are the same")
 		return nil
@@ -618,7 +1453,7 @@ func MoveDir(fdst, fsrc Fs) error {
 	}
 
 	// Now move the files
-	err := syncCopyMove(fdst, fsrc, false, true)
+	err := syncCopyMove(ctx, fdst, fsrc, false, true)
 	if err != nil || Stats.Errored() {
 		ErrorLog(fdst, "Not deleting files as there were IO errors")
 		return err
@@ -628,6 +1463,12 @@ func MoveDir(fdst, fsrc Fs) error {
 
 // Check the files in fsrc and fdst according to Size and hash
 func Check(fdst, fsrc Fs) error {
+	return CheckContext(context.Background(), fdst, fsrc)
+}
+
+// CheckContext is like Check but stops checking files when ctx is
+// cancelled and returns ctx.Err()
+func CheckContext(ctx context.Context, fdst, fsrc Fs) error {
 	differences := int32(0)
 	var (
 		wg                 sync.WaitGroup
@@ -695,6 +1536,9 @@ func Check(fdst, fsrc Fs) error {
 		go func() {
 			defer checkerWg.Done()
 			for check := range checks {
+				if ctx.Err() != nil {
+					continue
+				}
 				dst, src := check[0], check[1]
 				Stats.Checking(src)
 				if src.Size() != dst.Size() {
@@ -721,6 +1565,9 @@ func Check(fdst, fsrc Fs) error {
 
 	Log(fdst, "Waiting for checks to finish")
 	checkerWg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
 	Log(fdst, "%d differences found", Stats.GetErrors())
 	if differences > 0 {
 		return fmt.Errorf("%d differences found", differences)
@@ -732,6 +1579,12 @@ func Check(fdst, fsrc Fs) error {
 //
 // Lists in parallel which may get them out of order
 func ListFn(f Fs, fn func(Object)) error {
+	return ListFnContext(context.Background(), f, fn)
+}
+
+// ListFnContext is like ListFn but stops calling fn when ctx is
+// cancelled and returns ctx.Err()
+func ListFnContext(ctx context.Context, f Fs, fn func(Object)) error {
 	in := f.List()
 	var wg sync.WaitGroup
 	wg.Add(Config.Checkers)
@@ -739,14 +1592,14 @@ func ListFn(f Fs, fn func(Object)) error {
 		go func() {
 			defer wg.Done()
 			for o := range in {
-				if Config.Filter.IncludeObject(o) {
+				if ctx.Err() == nil && Config.Filter.IncludeObject(o) {
 					fn(o)
 				}
 			}
 		}()
 	}
 	wg.Wait()
-	return nil
+	return ctx.Err()
 }
 
 // mutex for synchronized output
@@ -839,6 +1692,90 @@ func ListDir(f Fs, w io.Writer) error {
 	return nil
 }
 
+// ListJSONItem is an object or directory in the output of ListJSON
+// or ListDirJSON
+type ListJSONItem struct {
+	Path    string            `json:"path"`
+	Size    int64             `json:"size"` // -1 if unknown
+	ModTime *time.Time        `json:"modTime,omitempty"`
+	IsDir   bool              `json:"isDir"`
+	Hashes  map[string]string `json:"hashes,omitempty"`
+	Count   *int64            `json:"count,omitempty"` // objects in the directory if known
+	Bytes   *int64            `json:"bytes,omitempty"` // size of the directory if known
+}
+
+// writeJSON writes item to w as a single line of JSON
+func writeJSON(w io.Writer, item *ListJSONItem) {
+	out, err := json.Marshal(item)
+	if err != nil {
+		Stats.Error()
+		ErrorLog(nil, "Failed to encode %q as JSON: %v", item.Path, err)
+		return
+	}
+	syncFprintf(w, "%s\n", out)
+}
+
+// ListJSON lists the Fs to the supplied writer as one JSON object per
+// line
+//
+// Shows path and size, the mod time if modTime is set and the hash
+// of type ht unless it is HashNone - obeys includes and excludes
+//
+// Lists in parallel which may get them out of order
+func ListJSON(f Fs, w io.Writer, modTime bool, ht HashType) error {
+	return ListFn(f, func(o Object) {
+		Stats.Checking(o)
+		item := &ListJSONItem{
+			Path: o.Remote(),
+			Size: o.Size(),
+		}
+		if modTime {
+			when := o.ModTime()
+			item.ModTime = &when
+		}
+		if ht != HashNone {
+			sum, err := o.Hash(ht)
+			if err == ErrHashUnsupported {
+				// leave the hash out
+			} else if err != nil {
+				Stats.Error()
+				ErrorLog(o, "Failed to read %v: %v", ht, err)
+			} else if sum != "" {
+				item.Hashes = map[string]string{ht.String(): sum}
+			}
+		}
+		Stats.DoneChecking(o)
+		writeJSON(w, item)
+	})
+}
+
+// ListDirJSON lists the directories/buckets/containers in the Fs to
+// the supplied writer as one JSON object per line
+func ListDirJSON(f Fs, w io.Writer) error {
+	for dir := range f.ListDir() {
+		item := &ListJSONItem{
+			Path:  dir.Name,
+			Size:  -1,
+			IsDir: true,
+		}
+		if !dir.When.IsZero() {
+			when := dir.When
+			item.ModTime = &when
+		}
+		if dir.Bytes >= 0 {
+			bytes := dir.Bytes
+			item.Size = bytes
+			item.Bytes = &bytes
+		}
+		if dir.Count >= 0 {
+			count := dir.Count
+			item.Count = &count
+		}
+		writeJSON(w, item)
+	}
+	return nil
+}
+
 // Mkdir makes a destination directory or container
 func Mkdir(f Fs) error {
 	err := f.Mkdir()
@@ -867,6 +1804,13 @@ func Rmdir(f Fs) error {
 //
 // FIXME doesn't delete local directories
 func Purge(f Fs) error {
+	return PurgeContext(context.Background(), f)
+}
+
+// PurgeContext is like Purge but if the files are deleted one at a
+// time it stops deleting them when ctx is cancelled and returns
+// ctx.Err()
+func PurgeContext(ctx context.Context, f Fs) error {
 	doFallbackPurge := true
 	var err error
 	if purger, ok := f.(Purger); ok {
@@ -882,7 +1826,10 @@ func Purge(f Fs) error {
 	}
 	if doFallbackPurge {
 		// DeleteFiles and Rmdir observe --dry-run
-		DeleteFiles(f.List())
+		DeleteFiles(ctx, f.List())
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
 		err = Rmdir(f)
 	}
 	if err != nil {
@@ -895,14 +1842,20 @@ func Purge(f Fs) error {
 // Delete removes all the contents of a container.  Unlike Purge, it
 // obeys includes and excludes.
 func Delete(f Fs) error {
+	return DeleteContext(context.Background(), f)
+}
+
+// DeleteContext is like Delete but stops deleting files when ctx is
+// cancelled and returns ctx.Err()
+func DeleteContext(ctx context.Context, f Fs) error {
 	wg := new(sync.WaitGroup)
 	delete := make(ObjectsChan, Config.Transfers)
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		DeleteFiles(delete)
+		DeleteFiles(ctx, delete)
 	}()
-	err := ListFn(f, func(o Object) {
+	err := ListFnContext(ctx, f, func(o Object) {
 		delete <- o
 	})
 	close(delete)
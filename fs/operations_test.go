@@ -21,7 +21,9 @@ package fs_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -29,12 +31,14 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/ncw/rclone/fs"
 	_ "github.com/ncw/rclone/fs/all" // import all fs
 	"github.com/ncw/rclone/fstest"
+	"golang.org/x/net/context"
 )
 
 // Globals
@@ -140,7 +144,7 @@ func NewRun(t *testing.T) *Run {
 		*r = *oneRun
 		r.cleanRemote = func() {
 			oldErrors := fs.Stats.GetErrors()
-			fs.DeleteFiles(r.fremote.List())
+			fs.DeleteFiles(context.Background(), r.fremote.List())
 			errors := fs.Stats.GetErrors() - oldErrors
 			if errors != 0 {
 				t.Fatalf("%d errors while cleaning remote %v", errors, r.fremote)
@@ -270,6 +274,186 @@ func TestCopy(t *testing.T) {
 	fstest.CheckItems(t, r.fremote, file1)
 }
 
+// streamHashFs records the MD5 calculated while the data is sent to
+// Put
+type streamHashFs struct {
+	fs.Fs
+	md5 string
+}
+
+// Put records the MD5 calculated while sending
+func (f *streamHashFs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	o, err := f.Fs.Put(in, src)
+	if streamHasher, ok := src.(fs.StreamHasher); ok {
+		f.md5, _ = streamHasher.StreamHash(fs.HashMD5)
+	}
+	return o, err
+}
+
+// Check the hash of the data is calculated while copying
+func TestCopyStreamHash(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("hello world", "hello world", t1)
+
+	fs.Stats.ResetCounters()
+	fremote := &streamHashFs{Fs: r.fremote}
+	fs.Copy(context.Background(), fremote, nil, r.flocal.NewFsObject("hello world"))
+	if fs.Stats.Errored() {
+		t.Fatalf("Copy had %d errors", fs.Stats.GetErrors())
+	}
+	if fremote.md5 != file1.Hashes[fs.HashMD5] {
+		t.Errorf("Expecting streamed MD5 %q but got %q", file1.Hashes[fs.HashMD5], fremote.md5)
+	}
+	fstest.CheckItems(t, r.fremote, file1)
+}
+
+// badHashObject is an Object whose MD5 doesn't match its data
+type badHashObject struct {
+	fs.Object
+}
+
+// Hash returns the wrong MD5
+func (o badHashObject) Hash(t fs.HashType) (string, error) {
+	if t == fs.HashMD5 {
+		return "0123456789abcdef0123456789abcdef", nil
+	}
+	return o.Object.Hash(t)
+}
+
+// Check the transfer is checked against the hash of the source rather
+// than the one calculated while sending when it has one in common
+// with the destination
+func TestCopyChecksSourceHash(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	if !r.fremote.Hashes().Contains(fs.HashMD5) {
+		t.Skip("Remote doesn't support MD5")
+	}
+	r.WriteFile("hello world", "hello world", t1)
+
+	fs.Stats.ResetCounters()
+	fs.Copy(context.Background(), r.fremote, nil, badHashObject{r.flocal.NewFsObject("hello world")})
+	if !fs.Stats.Errored() {
+		t.Errorf("Expecting the hash mismatch to be an error")
+	}
+	fs.Stats.ResetCounters()
+	fstest.CheckItems(t, r.fremote)
+}
+
+// skipFs is an Fs whose Put resumes an upload which has sent prefix
+// already
+type skipFs struct {
+	fs.Fs
+	prefix string
+}
+
+// Put skips the prefix of in and sends it from the upload so far
+func (f *skipFs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	err := fs.Skip(in, int64(len(f.prefix)))
+	if err != nil {
+		return nil, err
+	}
+	return f.Fs.Put(io.MultiReader(strings.NewReader(f.prefix), in), src)
+}
+
+// Resuming an upload skips the data uploaded already without reading
+// or counting it
+func TestCopySkip(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("sub dir/hello world", "hello world", t1)
+
+	fs.Stats.ResetCounters()
+	fs.Copy(context.Background(), &skipFs{Fs: r.fremote, prefix: "hello "}, nil, r.flocal.NewFsObject(file1.Path))
+	if fs.Stats.Errored() {
+		t.Errorf("Copy failed")
+	}
+	if bytes := fs.Stats.Snapshot().Bytes; bytes != 5 {
+		t.Errorf("Expecting 5 bytes transferred but got %d", bytes)
+	}
+	fstest.CheckItems(t, r.fremote, file1)
+}
+
+// slowReader returns an endless stream of data slowly
+type slowReader struct{}
+
+// Read some data after a pause
+func (slowReader) Read(p []byte) (n int, err error) {
+	time.Sleep(10 * time.Millisecond)
+	if len(p) > 1024 {
+		p = p[:1024]
+	}
+	for i := range p {
+		p[i] = 'x'
+	}
+	return len(p), nil
+}
+
+// slowObject is an Object whose data arrives slowly and never ends
+type slowObject struct {
+	fs.Object
+}
+
+// Open the object returning the slow data
+func (o *slowObject) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(slowReader{}), nil
+}
+
+// Size returns a size which is bigger than the data read before the
+// test cancels the transfer, but small enough not to be buffered
+func (o *slowObject) Size() int64 {
+	return 1 << 20
+}
+
+// Check cancelling a copy part way through stops it promptly and
+// removes the partial file
+func TestCopyCancelledDuringTransfer(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("slow", "slow potato", t1)
+	fstest.CheckItems(t, r.flocal, file1)
+	src := r.flocal.NewFsObject("slow")
+	if src == nil {
+		t.Fatal("Failed to find source")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fs.Stats.ResetCounters()
+	done := make(chan struct{})
+	go func() {
+		fs.Copy(ctx, r.fremote, nil, &slowObject{Object: src})
+		close(done)
+	}()
+
+	// Wait for the transfer to be under way then cancel it
+	for i := 0; fs.Stats.Snapshot().Bytes < 4096; i++ {
+		if i >= 500 {
+			t.Fatal("Transfer didn't start")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	cancelled := time.Now()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("Copy didn't stop after being cancelled")
+	}
+	if dt := time.Since(cancelled); dt > 2*time.Second {
+		t.Errorf("Copy took %v to stop after being cancelled", dt)
+	}
+	if !fs.Stats.Errored() {
+		t.Errorf("Expecting the cancelled copy to count an error")
+	}
+	fs.Stats.ResetCounters()
+
+	// removeFailedCopy should have deleted the partial upload
+	fstest.CheckItems(t, r.flocal, file1)
+	fstest.CheckItems(t, r.fremote)
+}
+
 // Test a server side copy if possible, or the backup path if not
 func TestServerSideCopy(t *testing.T) {
 	r := NewRun(t)
@@ -691,6 +875,632 @@ func TestSyncWithExcludeAndDeleteExcluded(t *testing.T) {
 	fstest.CheckItems(t, r.flocal, file2)
 }
 
+// Test with per-directory filter files
+func TestSyncWithDirFilterFile(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	r.WriteFile("monorepo/.rclonefilter", "- *.log\n", t1)
+	r.WriteFile("monorepo/app.log", "app", t1)
+	r.WriteFile("monorepo/src/.rclonefilter", "+ *.log\n- /build/**\n", t1)
+	file1 := r.WriteFile("monorepo/src/debug.log", "debug", t1)
+	file2 := r.WriteFile("monorepo/src/main.go", "main", t1)
+	r.WriteFile("monorepo/src/build/main.o", "object", t1)
+	file3 := r.WriteFile("monorepo/doc/build/index.html", "index", t1)
+	file4 := r.WriteObject("monorepo/notes.log", "notes", t1)
+
+	fs.Config.Filter.DirFilterFile = ".rclonefilter"
+	fs.Config.Filter.ExcludeDirFilterFile = true
+	defer func() {
+		fs.Config.Filter.DirFilterFile = ""
+		fs.Config.Filter.ExcludeDirFilterFile = false
+	}()
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	// notes.log isn't deleted as it is excluded
+	fstest.CheckItems(t, r.fremote, file1, file2, file3, file4)
+}
+
+// recordDirsFs records the directories listed with ListDirectory
+type recordDirsFs struct {
+	fs.Fs
+	mu   sync.Mutex
+	dirs []string
+}
+
+// ListDirectory records dir and lists it in the wrapped Fs
+func (f *recordDirsFs) ListDirectory(ctx context.Context, dir string) ([]fs.Object, []*fs.Dir, error) {
+	f.mu.Lock()
+	f.dirs = append(f.dirs, dir)
+	f.mu.Unlock()
+	return f.Fs.(fs.DirLister).ListDirectory(ctx, dir)
+}
+
+// Test the directories which the filters exclude everything in aren't
+// listed or made
+func TestSyncWithPrunedDirectories(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("turnip/index.js", "index", t1)
+	r.WriteFile("turnip/node_modules/left-pad/index.js", "pad", t1)
+	file2 := r.WriteObject("turnip/node_modules/left-pad/README", "readme", t1)
+
+	err := fs.Config.Filter.Add(false, "node_modules/**")
+	if err != nil {
+		t.Fatalf("Failed to add filter rule: %v", err)
+	}
+	defer fs.Config.Filter.Clear()
+	if fs.Config.Filter.IncludeDirectory("turnip/node_modules") {
+		t.Fatalf("Expecting turnip/node_modules to be excluded")
+	}
+
+	fs.Stats.ResetCounters()
+	flocal := &recordDirsFs{Fs: r.flocal}
+	err = fs.Sync(r.fremote, flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	// Check the excluded directory isn't listed
+	for _, dir := range flocal.dirs {
+		if strings.Contains(dir, "node_modules") {
+			t.Errorf("Excluded %q was listed", dir)
+		}
+	}
+	if len(flocal.dirs) != 2 {
+		t.Errorf("Expecting 2 directories listed but got %q", flocal.dirs)
+	}
+
+	// README isn't deleted as it is excluded
+	fs.Config.Filter.Clear()
+	fstest.CheckItems(t, r.fremote, file1, file2)
+}
+
+// notPurger hides the Purge method of the Fs it wraps
+type notPurger struct {
+	fs.Fs
+}
+
+// Test purge removes the files the filters exclude too
+func TestPurgeWithFilter(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	r.WriteObject("one", "one", t1)
+	r.WriteObject("dir/two", "two", t1)
+
+	err := fs.Config.Filter.Add(false, "dir/**")
+	if err != nil {
+		t.Fatalf("Failed to add filter rule: %v", err)
+	}
+	defer fs.Config.Filter.Clear()
+
+	// Use the fallback of deleting the files in the listing.
+	// This can't remove the local directories so ignore the error.
+	_ = fs.Purge(notPurger{r.fremote})
+	fs.Stats.ResetCounters()
+	fs.Config.Filter.Clear()
+	fstest.CheckItems(t, r.fremote)
+}
+
+// Sync a tree of directories, some only on one side
+func TestSyncNestedDirectories(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteBoth("top", "potato", t1)
+	file2 := r.WriteFile("a/b/c/deep", "carrot", t2)
+	file3 := r.WriteFile("a/new", "turnip", t2)
+	file4 := r.WriteBoth("a/b/same", "parsnip", t3)
+	r.WriteObject("a/old", "swede", t1)
+	r.WriteObject("z/y/x/gone", "leek", t1)
+	r.WriteObject("z/gone too", "onion", t1)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3, file4)
+}
+
+// listOnlyFs hides any optional interfaces so the whole tree has to be
+// listed with List
+type listOnlyFs struct {
+	fs.Fs
+}
+
+// Sync a tree of directories from and to an Fs which isn't a DirLister
+func TestSyncNestedDirectoriesWithoutDirLister(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteBoth("top", "potato", t1)
+	file2 := r.WriteFile("a/b/c/deep", "carrot", t2)
+	file3 := r.WriteBoth("a/b/same", "parsnip", t3)
+	r.WriteObject("a/old", "swede", t1)
+	r.WriteObject("z/y/gone", "leek", t1)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(listOnlyFs{r.fremote}, listOnlyFs{r.flocal})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+}
+
+// noListFs is a DirLister which fails the test if the whole tree is
+// listed with List
+type noListFs struct {
+	fs.Fs
+	t *testing.T
+}
+
+// List fails the test
+func (f noListFs) List() fs.ObjectsChan {
+	f.t.Errorf("%v: List called on a DirLister", f.Fs)
+	out := make(fs.ObjectsChan)
+	close(out)
+	return out
+}
+
+// ListDirectory lists dir in the wrapped Fs
+func (f noListFs) ListDirectory(ctx context.Context, dir string) ([]fs.Object, []*fs.Dir, error) {
+	return f.Fs.(fs.DirLister).ListDirectory(ctx, dir)
+}
+
+// Sync a tree of directories between DirListers a directory at a time
+func TestSyncDirListerDoesntList(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	for _, f := range []fs.Fs{r.flocal, r.fremote} {
+		if _, ok := f.(fs.DirLister); !ok {
+			t.Skipf("%v isn't a DirLister", f)
+		}
+	}
+	file1 := r.WriteBoth("top", "potato", t1)
+	file2 := r.WriteFile("a/b/c/deep", "carrot", t2)
+	file3 := r.WriteBoth("a/b/same", "parsnip", t3)
+	r.WriteObject("a/old", "swede", t1)
+	r.WriteObject("z/y/gone", "leek", t1)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(noListFs{r.fremote, t}, noListFs{r.flocal, t})
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+}
+
+// Sync after moving files around in the source with --track-renames
+func TestSyncWithTrackRenames(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+
+	fs.Config.TrackRenames = true
+	defer func() {
+		fs.Config.TrackRenames = false
+	}()
+
+	file1 := r.WriteFile("yam/potato", "Potato Content", t1)
+	file2 := r.WriteFile("turnip", "Turnip Content", t2)
+	file3 := r.WriteBoth("same", "Same Content", t3)
+	r.WriteObject("potato", "Potato Content", t1)
+	r.WriteObject("carrot", "Carrot Content", t2)
+	fstest.CheckItems(t, r.fremote, fstest.NewItem("potato", "Potato Content", t1), fstest.NewItem("carrot", "Carrot Content", t2), file3)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+	if fs.Stats.GetTransfers() != 1 {
+		t.Errorf("Expecting only turnip to be transferred but %d were", fs.Stats.GetTransfers())
+	}
+}
+
+// limitsSetup clears the limits returning a function to restore them
+func limitsSetup() func() {
+	fs.Limits.Reset()
+	return func() {
+		fs.Config.MaxDelete = -1
+		fs.Config.MaxDeletePercent = -1
+		fs.Config.MaxTransfer = 0
+		fs.Config.MaxDuration = 0
+		fs.Limits.Reset()
+	}
+}
+
+func TestSyncWithMaxDelete(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer limitsSetup()()
+	fs.Config.MaxDelete = 1
+
+	file1 := r.WriteBoth("potato", "Potato Content", t1)
+	file2 := r.WriteObject("carrot", "Carrot Content", t2)
+	file3 := r.WriteObject("turnip", "Turnip Content", t3)
+	file4 := r.WriteFile("parsnip", "Parsnip Content", t3)
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != fs.ErrorMaxDeleteExceeded {
+		t.Fatalf("Expecting %v but got %v", fs.ErrorMaxDeleteExceeded, err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3, file4)
+
+	// One deletion is within the limit
+	fs.Limits.Reset()
+	r.WriteFile("carrot", "Carrot Content", t2)
+	fs.Stats.ResetCounters()
+	err = fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file4)
+}
+
+func TestSyncWithMaxDeletePercent(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer limitsSetup()()
+	fs.Config.MaxDeletePercent = 50
+	fs.Config.DeleteBefore = true
+	defer func() {
+		fs.Config.DeleteBefore = false
+	}()
+
+	file1 := r.WriteBoth("potato", "Potato Content", t1)
+	file2 := r.WriteObject("carrot", "Carrot Content", t2)
+	file3 := r.WriteObject("turnip", "Turnip Content", t3)
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+
+	// Deleting 2 of the 3 files is too many
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != fs.ErrorMaxDeleteExceeded {
+		t.Fatalf("Expecting %v but got %v", fs.ErrorMaxDeleteExceeded, err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+
+	// Deleting 1 of the 3 files is fine
+	fs.Limits.Reset()
+	r.WriteFile("carrot", "Carrot Content", t2)
+	fs.Stats.ResetCounters()
+	err = fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2)
+}
+
+func TestSyncWithMaxTransfer(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer limitsSetup()()
+	fs.Config.MaxTransfer = 1
+	fs.Config.DeleteAfter = true
+	defer func() {
+		fs.Config.DeleteAfter = false
+	}()
+
+	r.WriteFile("potato", "Potato Content", t1)
+	r.WriteFile("carrot", "Carrot Content", t2)
+	file3 := r.WriteObject("turnip", "Turnip Content", t3)
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != fs.ErrorMaxTransferReached {
+		t.Fatalf("Expecting %v but got %v", fs.ErrorMaxTransferReached, err)
+	}
+	if fs.Stats.GetTransfers() != 1 {
+		t.Errorf("Expecting 1 transfer but %d were done", fs.Stats.GetTransfers())
+	}
+	if fs.Limits.String() == "" {
+		t.Errorf("Expecting a summary of the skipped work")
+	}
+	// Nothing is deleted once a limit is reached
+	if r.fremote.NewFsObject(file3.Path) == nil {
+		t.Errorf("%q was deleted", file3.Path)
+	}
+}
+
+// listDirs returns the modification times of all the directories in
+// f which must be a DirLister
+func listDirs(t *testing.T, f fs.Fs) map[string]time.Time {
+	lister := f.(fs.DirLister)
+	dirs := make(map[string]time.Time)
+	var list func(dir string)
+	list = func(dir string) {
+		_, subDirs, err := lister.ListDirectory(context.Background(), dir)
+		if err != nil {
+			t.Fatalf("Failed to list %q: %v", dir, err)
+		}
+		for _, subDir := range subDirs {
+			dirs[subDir.Name] = subDir.When
+			list(subDir.Name)
+		}
+	}
+	list("")
+	return dirs
+}
+
+func TestSyncEmptyDirectories(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	_, isMaker := r.fremote.(fs.DirMaker)
+	_, isLister := r.fremote.(fs.DirLister)
+	if !isMaker || !isLister {
+		t.Skip("Skipping as the remote hasn't got real directories")
+	}
+
+	file1 := r.WriteFile("a/potato", "Potato Content", t1)
+	for _, dir := range []string{"a/empty", "b/c"} {
+		err := os.MkdirAll(path.Join(r.localName, dir), 0777)
+		if err != nil {
+			t.Fatalf("Failed to make %q: %v", dir, err)
+		}
+	}
+	for _, dir := range []string{"a", "a/empty"} {
+		err := os.Chtimes(path.Join(r.localName, dir), t2, t2)
+		if err != nil {
+			t.Fatalf("Failed to chtimes %q: %v", dir, err)
+		}
+	}
+	file2 := r.WriteObject("old/dir/carrot", "Carrot Content", t3)
+	fstest.CheckItems(t, r.fremote, file2)
+
+	fs.Config.CreateEmptyDirs = true
+	defer func() {
+		fs.Config.CreateEmptyDirs = false
+	}()
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1)
+
+	dirs := listDirs(t, r.fremote)
+	for _, dir := range []string{"a", "a/empty", "b", "b/c"} {
+		if _, ok := dirs[dir]; !ok {
+			t.Errorf("Directory %q wasn't made", dir)
+		}
+	}
+	for _, dir := range []string{"old", "old/dir"} {
+		if _, ok := dirs[dir]; ok {
+			t.Errorf("Directory %q wasn't removed", dir)
+		}
+	}
+	for _, dir := range []string{"a", "a/empty"} {
+		if dt, ok := fstest.CheckTimeEqualWithPrecision(dirs[dir], t2, fs.Config.ModifyWindow); !ok {
+			t.Errorf("Directory %q modification time wrong by %v", dir, dt)
+		}
+	}
+}
+
+// Without --create-empty-src-dirs only the directories objects are
+// copied into are made
+func TestSyncNoEmptyDirectories(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	_, isMaker := r.fremote.(fs.DirMaker)
+	_, isLister := r.fremote.(fs.DirLister)
+	if !isMaker || !isLister {
+		t.Skip("Skipping as the remote hasn't got real directories")
+	}
+
+	file1 := r.WriteFile("a/b/potato", "Potato Content", t1)
+	r.WriteFile("excluded/big", "This file is too big to pass the --max-size filter", t1)
+	err := os.MkdirAll(path.Join(r.localName, "empty"), 0777)
+	if err != nil {
+		t.Fatalf("Failed to make empty: %v", err)
+	}
+	for _, dir := range []string{"a", "a/b"} {
+		err := os.Chtimes(path.Join(r.localName, dir), t2, t2)
+		if err != nil {
+			t.Fatalf("Failed to chtimes %q: %v", dir, err)
+		}
+	}
+
+	fs.Config.Filter.MaxSize = 40
+	defer func() {
+		fs.Config.Filter.MaxSize = 0
+	}()
+	fs.Stats.ResetCounters()
+	err = fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if fs.Stats.Errored() {
+		t.Errorf("Sync had %d errors", fs.Stats.GetErrors())
+	}
+	fstest.CheckItems(t, r.fremote, file1)
+
+	dirs := listDirs(t, r.fremote)
+	for _, dir := range []string{"empty", "excluded"} {
+		if _, ok := dirs[dir]; ok {
+			t.Errorf("Directory %q was made", dir)
+		}
+	}
+	for _, dir := range []string{"a", "a/b"} {
+		if dt, ok := fstest.CheckTimeEqualWithPrecision(dirs[dir], t2, fs.Config.ModifyWindow); !ok {
+			t.Errorf("Directory %q modification time wrong by %v", dir, dt)
+		}
+	}
+}
+
+// orderFs records the order objects are Put in
+type orderFs struct {
+	fs.Fs
+	mu      sync.Mutex
+	remotes []string
+}
+
+// Put records the remote of the object being Put
+func (f *orderFs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	f.mu.Lock()
+	f.remotes = append(f.remotes, src.Remote())
+	f.mu.Unlock()
+	return f.Fs.Put(in, src)
+}
+
+func TestSyncWithOrderBy(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	oldTransfers := fs.Config.Transfers
+	fs.Config.Transfers = 1
+	fs.Config.OrderBy = "size,descending"
+	fs.Config.Priority = &fs.Filter{}
+	err := fs.Config.Priority.Add(true, "*.conf")
+	if err != nil {
+		t.Fatalf("Failed to add priority rule: %v", err)
+	}
+	err = fs.Config.Priority.Add(false, "*")
+	if err != nil {
+		t.Fatalf("Failed to add priority rule: %v", err)
+	}
+	defer func() {
+		fs.Config.Transfers = oldTransfers
+		fs.Config.OrderBy = ""
+		fs.Config.Priority = nil
+	}()
+
+	file1 := r.WriteFile("a/small", "1", t1)
+	file2 := r.WriteFile("b/big", "1234567890", t1)
+	file3 := r.WriteFile("medium", "12345", t1)
+	file4 := r.WriteFile("z/small.conf", "12", t1)
+	file5 := r.WriteFile("big.conf", "123456", t1)
+
+	fs.Stats.ResetCounters()
+	fremote := &orderFs{Fs: r.fremote}
+	err = fs.Sync(fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3, file4, file5)
+	want := []string{"big.conf", "z/small.conf", "b/big", "medium", "a/small"}
+	if strings.Join(fremote.remotes, ",") != strings.Join(want, ",") {
+		t.Errorf("Expecting transfers in order %q but got %q", want, fremote.remotes)
+	}
+}
+
+// referenceSetup makes a reference remote with file1 unchanged and
+// file2 changed compared to the local files, returning it and the
+// name to pass to --compare-dest or --copy-dest
+func referenceSetup(t *testing.T, r *Run) (fref fs.Fs, refName string, file1, file2, file3 fstest.Item) {
+	refName, _, err := fstest.RandomRemoteName(*RemoteName)
+	if err != nil {
+		t.Fatalf("Failed to make reference remote name: %v", err)
+	}
+	fref, err = fs.NewFs(refName)
+	if err != nil {
+		t.Fatalf("Failed to open reference remote %q: %v", refName, err)
+	}
+	file1 = r.WriteFile("one", "one", t1)
+	file2 = r.WriteFile("sub dir/two", "twoB", t2)
+	file3 = r.WriteFile("three", "three", t1)
+	ref1 := r.WriteObjectTo(fref, "one", "one", t1)
+	ref2 := r.WriteObjectTo(fref, "sub dir/two", "twoA", t1)
+	fstest.CheckItems(t, fref, ref1, ref2)
+	return fref, refName, file1, file2, file3
+}
+
+// Test with --compare-dest
+func TestSyncCompareDest(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	fref, refName, _, file2, file3 := referenceSetup(t, r)
+	defer func() {
+		_ = fs.Purge(fref) // ignore error
+	}()
+
+	fs.Config.CompareDest = []string{refName}
+	defer func() {
+		fs.Config.CompareDest = nil
+	}()
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file2, file3)
+	if transfers := fs.Stats.GetTransfers(); transfers != 2 {
+		t.Errorf("Expecting 2 transfers but got %d", transfers)
+	}
+}
+
+// Test with --copy-dest
+func TestSyncCopyDest(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	fref, refName, file1, file2, file3 := referenceSetup(t, r)
+	defer func() {
+		_ = fs.Purge(fref) // ignore error
+	}()
+
+	fs.Config.CopyDest = []string{refName}
+	defer func() {
+		fs.Config.CopyDest = nil
+	}()
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+}
+
+// Test with a --compare-dest which doesn't exist
+func TestSyncCompareDestMissing(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteFile("one", "one", t1)
+	file2 := r.WriteFile("sub dir/two", "two", t2)
+
+	fs.Config.CompareDest = []string{r.localName + "/potato"}
+	defer func() {
+		fs.Config.CompareDest = nil
+	}()
+
+	fs.Stats.ResetCounters()
+	err := fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	fstest.CheckItems(t, r.fremote, file1, file2)
+	if errors := fs.Stats.GetErrors(); errors != 0 {
+		t.Errorf("Expecting no errors but got %d", errors)
+	}
+}
+
+func TestSyncCompareDestOverlapping(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	r.WriteFile("one", "one", t1)
+	fstest.CheckItems(t, r.flocal, fstest.NewItem("one", "one", t1))
+
+	fdst, err := fs.NewFs(r.localName + "/dst")
+	if err != nil {
+		t.Fatalf("Failed to open %q: %v", r.localName+"/dst", err)
+	}
+	fs.Config.CompareDest = []string{r.localName + "/dst/ref"}
+	defer func() {
+		fs.Config.CompareDest = nil
+	}()
+
+	fs.Stats.ResetCounters()
+	err = fs.Sync(fdst, r.flocal)
+	if err == nil {
+		t.Fatalf("Expecting error for overlapping --compare-dest")
+	}
+	fs.Stats.ResetCounters()
+}
+
 // Test a server side move if possible, or the backup path if not
 func TestServerSideMove(t *testing.T) {
 	r := NewRun(t)
@@ -730,6 +1540,98 @@ func TestServerSideMove(t *testing.T) {
 	fstest.CheckItems(t, fremoteMove)
 }
 
+// Test with --backup-dir and --suffix
+func TestSyncBackupDir(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteObject("one", "one", t1)
+	file2 := r.WriteBoth("two", "two", t1)
+	file3 := r.WriteObject("sub dir/three", "three", t1)
+	file1a := r.WriteFile("one", "oneA", t2)
+
+	fstest.CheckItems(t, r.fremote, file1, file2, file3)
+	fstest.CheckItems(t, r.flocal, file1a, file2)
+
+	backupName, _, err := fstest.RandomRemoteName(*RemoteName)
+	if err != nil {
+		t.Fatalf("Failed to make backup remote name: %v", err)
+	}
+	fbackup, err := fs.NewFs(backupName)
+	if err != nil {
+		t.Fatalf("Failed to open backup remote %q: %v", backupName, err)
+	}
+	defer func() {
+		_ = fs.Purge(fbackup) // ignore error
+	}()
+
+	fs.Config.BackupDir = backupName
+	fs.Config.Suffix = ".bak"
+	defer func() {
+		fs.Config.BackupDir = ""
+		fs.Config.Suffix = ""
+	}()
+
+	fs.Stats.ResetCounters()
+	err = fs.Sync(r.fremote, r.flocal)
+	if err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+
+	fstest.CheckItems(t, r.fremote, file1a, file2)
+	file1.Path = "one.bak"
+	file3.Path = "sub dir/three.bak"
+	fstest.CheckItems(t, fbackup, file1, file3)
+}
+
+func TestSyncBackupDirOverlapping(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	r.WriteFile("one", "one", t1)
+	fstest.CheckItems(t, r.flocal, fstest.NewItem("one", "one", t1))
+
+	fdst, err := fs.NewFs(r.localName + "/dst")
+	if err != nil {
+		t.Fatalf("Failed to open %q: %v", r.localName+"/dst", err)
+	}
+	fs.Config.BackupDir = r.localName + "/dst/backup"
+	defer func() {
+		fs.Config.BackupDir = ""
+	}()
+
+	fs.Stats.ResetCounters()
+	err = fs.Sync(fdst, r.flocal)
+	if err == nil {
+		t.Fatalf("Expecting error for overlapping --backup-dir")
+	}
+	fs.Stats.ResetCounters()
+}
+
+func TestOverlapping(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want bool
+	}{
+		{"/tmp/a", "/tmp/a", true},
+		{"/tmp/a", "/tmp/a/b", true},
+		{"/tmp/a/b", "/tmp/a", true},
+		{"/tmp/a", "/tmp/ab", false},
+		{"/tmp/a", "/tmp/b", false},
+	} {
+		fa, err := fs.NewFs(test.a)
+		if err != nil {
+			t.Fatalf("Failed to open %q: %v", test.a, err)
+		}
+		fb, err := fs.NewFs(test.b)
+		if err != nil {
+			t.Fatalf("Failed to open %q: %v", test.b, err)
+		}
+		got := fs.Overlapping(fa, fb)
+		if got != test.want {
+			t.Errorf("Overlapping(%q, %q): want %v got %v", test.a, test.b, test.want, got)
+		}
+	}
+}
+
 func TestLs(t *testing.T) {
 	r := NewRun(t)
 	defer r.Finalise()
@@ -853,6 +1755,109 @@ func TestSha1sum(t *testing.T) {
 	}
 }
 
+func TestListJSON(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteBoth("potato2", "------------------------------------------------------------", t1)
+	file2 := r.WriteBoth("empty space", "", t2)
+
+	fstest.CheckItems(t, r.fremote, file1, file2)
+
+	for _, test := range []struct {
+		name    string
+		modTime bool
+		ht      fs.HashType
+	}{
+		{"ls", false, fs.HashNone},
+		{"lsl", true, fs.HashNone},
+		{"md5sum", false, fs.HashMD5},
+	} {
+		var buf bytes.Buffer
+		err := fs.ListJSON(r.fremote, &buf, test.modTime, test.ht)
+		if err != nil {
+			t.Fatalf("%s: ListJSON failed: %v", test.name, err)
+		}
+		items := map[string]fs.ListJSONItem{}
+		decoder := json.NewDecoder(&buf)
+		for decoder.More() {
+			var item fs.ListJSONItem
+			if err := decoder.Decode(&item); err != nil {
+				t.Fatalf("%s: Decode failed: %v", test.name, err)
+			}
+			items[item.Path] = item
+		}
+		if len(items) != 2 {
+			t.Fatalf("%s: want 2 items got %d: %v", test.name, len(items), items)
+		}
+		for _, want := range []struct {
+			file    fstest.Item
+			size    int64
+			modTime time.Time
+			md5     string
+		}{
+			{file1, 60, t1, "d6548b156ea68a4e003e786df99eee76"},
+			{file2, 0, t2, "d41d8cd98f00b204e9800998ecf8427e"},
+		} {
+			item, ok := items[want.file.Path]
+			if !ok {
+				t.Errorf("%s: %q missing", test.name, want.file.Path)
+				continue
+			}
+			if item.Size != want.size {
+				t.Errorf("%s: %q: want size %d got %d", test.name, item.Path, want.size, item.Size)
+			}
+			if item.IsDir {
+				t.Errorf("%s: %q: want isDir false", test.name, item.Path)
+			}
+			if !test.modTime {
+				if item.ModTime != nil {
+					t.Errorf("%s: %q: want no modTime got %v", test.name, item.Path, *item.ModTime)
+				}
+			} else if item.ModTime == nil {
+				t.Errorf("%s: %q: modTime missing", test.name, item.Path)
+			} else if dt := item.ModTime.Sub(want.modTime); dt > fs.Config.ModifyWindow || dt < -fs.Config.ModifyWindow {
+				t.Errorf("%s: %q: want modTime %v got %v", test.name, item.Path, want.modTime, *item.ModTime)
+			}
+			if test.ht == fs.HashNone {
+				if item.Hashes != nil {
+					t.Errorf("%s: %q: want no hashes got %v", test.name, item.Path, item.Hashes)
+				}
+				continue
+			}
+			if len(item.Hashes) > 1 {
+				t.Errorf("%s: %q: want at most one hash got %v", test.name, item.Path, item.Hashes)
+			}
+			if md5 := item.Hashes["MD5"]; md5 != "" && md5 != want.md5 {
+				t.Errorf("%s: %q: want MD5 %q got %q", test.name, item.Path, want.md5, md5)
+			}
+		}
+	}
+}
+
+func TestListDirJSON(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	file1 := r.WriteObject("sub dir/hello world", "hello world", t1)
+
+	fstest.CheckItems(t, r.fremote, file1)
+
+	var buf bytes.Buffer
+	err := fs.ListDirJSON(r.fremote, &buf)
+	if err != nil {
+		t.Fatalf("ListDirJSON failed: %v", err)
+	}
+	var item fs.ListJSONItem
+	if err := json.Unmarshal(buf.Bytes(), &item); err != nil {
+		t.Fatalf("Unmarshal failed: %v: %q", err, buf.String())
+	}
+	if item.Path != "sub dir" || !item.IsDir {
+		t.Errorf("Result wrong %q", buf.String())
+	}
+	if item.Bytes == nil && item.Size != -1 {
+		t.Errorf("want size -1 for unknown bytes got %d", item.Size)
+	}
+}
+
 func TestCount(t *testing.T) {
 	r := NewRun(t)
 	defer r.Finalise()
@@ -0,0 +1,152 @@
+// Order the transfers of a sync
+
+package fs
+
+import (
+	"container/heap"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// pairLess returns whether the transfer of a should come before b
+type pairLess func(a, b ObjectPair) bool
+
+// parseOrderBy parses the --order-by string which is one of size,
+// modtime or name optionally followed by ",ascending" or
+// ",descending", returning nil for ""
+func parseOrderBy(orderBy string) (pairLess, error) {
+	if orderBy == "" {
+		return nil, nil
+	}
+	parts := strings.Split(strings.ToLower(orderBy), ",")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("too many parts in --order-by %q", orderBy)
+	}
+	var less pairLess
+	switch parts[0] {
+	case "size":
+		less = func(a, b ObjectPair) bool {
+			return a.src.Size() < b.src.Size()
+		}
+	case "modtime":
+		less = func(a, b ObjectPair) bool {
+			return a.src.ModTime().Before(b.src.ModTime())
+		}
+	case "name":
+		less = func(a, b ObjectPair) bool {
+			return a.src.Remote() < b.src.Remote()
+		}
+	default:
+		return nil, fmt.Errorf("unknown --order-by %q - expecting size, modtime or name", parts[0])
+	}
+	if len(parts) == 1 {
+		return less, nil
+	}
+	switch parts[1] {
+	case "ascending", "asc":
+	case "descending", "desc":
+		ascending := less
+		less = func(a, b ObjectPair) bool {
+			return ascending(b, a)
+		}
+	default:
+		return nil, fmt.Errorf("unknown --order-by direction %q - expecting ascending or descending", parts[1])
+	}
+	return less, nil
+}
+
+// orderedPair is a transfer, whether it matched the --priority rules
+// and the order it arrived in
+type orderedPair struct {
+	ObjectPair
+	priority bool
+	seq      int
+}
+
+// orderedPairs implements heap.Interface putting the priority
+// transfers first, then in the order given by less, then in the order
+// they arrived
+type orderedPairs struct {
+	pairs []orderedPair
+	less  pairLess
+}
+
+func (o *orderedPairs) Len() int      { return len(o.pairs) }
+func (o *orderedPairs) Swap(i, j int) { o.pairs[i], o.pairs[j] = o.pairs[j], o.pairs[i] }
+func (o *orderedPairs) Less(i, j int) bool {
+	a, b := o.pairs[i], o.pairs[j]
+	if a.priority != b.priority {
+		return a.priority
+	}
+	if o.less != nil {
+		if o.less(a.ObjectPair, b.ObjectPair) {
+			return true
+		}
+		if o.less(b.ObjectPair, a.ObjectPair) {
+			return false
+		}
+	}
+	return a.seq < b.seq
+}
+
+func (o *orderedPairs) Push(x interface{}) { o.pairs = append(o.pairs, x.(orderedPair)) }
+func (o *orderedPairs) Pop() interface{} {
+	last := len(o.pairs) - 1
+	pair := o.pairs[last]
+	o.pairs = o.pairs[:last]
+	return pair
+}
+
+// transfersOrdered returns whether the transfers need ordering with
+// orderTransfers
+func transfersOrdered() bool {
+	return Config.OrderBy != "" || Config.Priority != nil
+}
+
+// orderTransfers reads the transfers from in, then sends them on to
+// out in the order given by --priority and --order-by, and closes
+// out.
+//
+// At most Config.MaxBacklog transfers are held.  Until that many have
+// been read none are sent so the first transfer waits for them or for
+// the checks to finish.  Once the backlog is full the first of those
+// held is sent for each one read, so the transfers are only in order
+// within the backlog.
+//
+// Once ctx is cancelled the remaining transfers are discarded.
+func orderTransfers(ctx context.Context, in, out ObjectPairChan) {
+	defer close(out)
+	less, err := parseOrderBy(Config.OrderBy)
+	if err != nil {
+		// Config.OrderBy was checked by LoadConfig
+		ErrorLog(nil, "Ignoring --order-by: %v", err)
+	}
+	o := &orderedPairs{less: less}
+	seq := 0
+	full := false
+	for pair := range in {
+		if ctx.Err() != nil {
+			// Carry on reading so the checkers don't block
+			continue
+		}
+		priority := Config.Priority != nil && Config.Priority.IncludeObject(pair.src)
+		heap.Push(o, orderedPair{ObjectPair: pair, priority: priority, seq: seq})
+		seq++
+		if o.Len() >= Config.MaxBacklog {
+			if !full {
+				Log(nil, "Backlog of %d transfers is full so they are only ordered within it - see --max-backlog", Config.MaxBacklog)
+				full = true
+			}
+			out <- heap.Pop(o).(orderedPair).ObjectPair
+		}
+	}
+	Debug(nil, "Ordered %d transfers", seq)
+	for o.Len() > 0 {
+		if ctx.Err() != nil {
+			return
+		}
+		out <- heap.Pop(o).(orderedPair).ObjectPair
+	}
+}
@@ -0,0 +1,55 @@
+package fs
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+// sizeObject is an Object with just a name and a size
+type sizeObject struct {
+	Object
+	remote string
+	size   int64
+}
+
+func (o sizeObject) Remote() string { return o.remote }
+func (o sizeObject) Size() int64    { return o.size }
+
+func TestOrderTransfers(t *testing.T) {
+	oldOrderBy, oldMaxBacklog, oldPriority := Config.OrderBy, Config.MaxBacklog, Config.Priority
+	defer func() {
+		Config.OrderBy, Config.MaxBacklog, Config.Priority = oldOrderBy, oldMaxBacklog, oldPriority
+	}()
+	Config.OrderBy = "size"
+	Config.Priority = nil
+	for _, test := range []struct {
+		maxBacklog int
+		sizes      []int64
+		want       []string
+	}{
+		{10, []int64{5, 4, 3, 2, 1}, []string{"file4", "file3", "file2", "file1", "file0"}},
+		{2, []int64{5, 4, 3, 2, 1}, []string{"file1", "file2", "file3", "file4", "file0"}},
+		{1, []int64{5, 4, 3, 2, 1}, []string{"file0", "file1", "file2", "file3", "file4"}},
+		// Equal sizes keep the order they arrived in
+		{10, []int64{2, 1, 2, 1}, []string{"file1", "file3", "file0", "file2"}},
+	} {
+		Config.MaxBacklog = test.maxBacklog
+		in := make(ObjectPairChan, len(test.sizes))
+		for i, size := range test.sizes {
+			in <- ObjectPair{src: sizeObject{remote: fmt.Sprintf("file%d", i), size: size}}
+		}
+		close(in)
+		out := make(ObjectPairChan)
+		go orderTransfers(context.Background(), in, out)
+		var got []string
+		for pair := range out {
+			got = append(got, pair.src.Remote())
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("max backlog %d sizes %v: want %v got %v", test.maxBacklog, test.sizes, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,309 @@
+// Resumable upload state which survives rclone being restarted
+
+package fs
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UploadState is the state of a resumable upload.
+//
+// Remotes which upload big files in sessions save this in
+// Config.UploadStateDir as the upload progresses so that if rclone
+// is stopped a later copy of the same source to the same destination
+// can carry on with the session rather than starting again.
+//
+// The source is identified by its size, modification time and, if
+// its Fs has one, a hash, so a source which has changed isn't
+// resumed even if its size and modification time are the same.
+type UploadState struct {
+	Remote  string       `json:"remote"`  // where the upload is going as remote:path
+	Fs      string       `json:"fs"`      // the UploadAborter the upload is to as remote:path, "" if none
+	Object  string       `json:"object"`  // the remote of the object in Fs
+	Size    int64        `json:"size"`    // size of the source
+	ModTime time.Time    `json:"modTime"` // modification time of the source
+	Hash    string       `json:"hash"`    // hash of the source as type:sum, "" if unknown
+	Session string       `json:"session"` // upload session URL or upload ID
+	Offset  int64        `json:"offset"`  // bytes uploaded so far
+	Parts   []UploadPart `json:"parts"`   // the parts uploaded so far
+	Created time.Time    `json:"created"` // when the session was started
+	path    string       // where the state is saved
+	saved   bool         // set if the state has been saved
+}
+
+// UploadPart is a part of an upload which has been uploaded
+type UploadPart struct {
+	Number int64  `json:"number"` // part number starting from 1
+	ID     string `json:"id"`     // ID of the part, eg its ETag
+}
+
+// uploadPartsByNumber implements sort.Interface sorting by Number
+type uploadPartsByNumber []UploadPart
+
+func (parts uploadPartsByNumber) Len() int           { return len(parts) }
+func (parts uploadPartsByNumber) Swap(i, j int)      { parts[i], parts[j] = parts[j], parts[i] }
+func (parts uploadPartsByNumber) Less(i, j int) bool { return parts[i].Number < parts[j].Number }
+
+// cleanUploadStatesOnce makes sure the stale states are only removed
+// once
+var cleanUploadStatesOnce sync.Once
+
+// uploadStateRemote returns the remote:path of remote on f
+func uploadStateRemote(f Info, remote string) string {
+	return f.Name() + ":" + path.Join(f.Root(), remote)
+}
+
+// expired returns whether the state is too old to be resumed
+func (s *UploadState) expired() bool {
+	return time.Since(s.Created) > Config.UploadStateMaxAge
+}
+
+// NewUploadState returns the saved state of an upload of src to
+// remote on f if there is one which can be resumed, otherwise a new
+// state with an empty Session for the caller to fill in and Save.
+//
+// Saved states for a different version of src, or which are too old,
+// are removed and their sessions aborted if f is an UploadAborter.
+func NewUploadState(f Info, remote string, src ObjectInfo) *UploadState {
+	cleanUploadStatesOnce.Do(CleanUploadStates)
+	s := &UploadState{
+		Remote:  uploadStateRemote(f, remote),
+		Object:  remote,
+		Size:    src.Size(),
+		ModTime: src.ModTime(),
+		Created: time.Now(),
+	}
+	if _, ok := f.(UploadAborter); ok {
+		s.Fs = f.Name() + ":" + f.Root()
+	}
+	if Config.UploadStateDir == "" {
+		return s
+	}
+	s.Hash = uploadStateHash(src)
+	sum := md5.Sum([]byte(s.Remote))
+	s.path = filepath.Join(Config.UploadStateDir, hex.EncodeToString(sum[:])+".json")
+	saved, err := loadUploadState(s.path)
+	if err != nil {
+		ErrorLog(src, "Ignoring saved upload state: %v", err)
+		s.Remove()
+		return s
+	}
+	switch {
+	case saved == nil:
+	case saved.Remote != s.Remote, saved.Size != s.Size, !saved.ModTime.Equal(s.ModTime), saved.Hash != s.Hash:
+		Debug(src, "Not resuming upload as the source has changed")
+		saved.abort(f)
+	case saved.expired():
+		Debug(src, "Not resuming upload as it was started at %v", saved.Created)
+		saved.abort(f)
+	default:
+		Log(src, "Resuming upload from offset %d", saved.Offset)
+		sort.Sort(uploadPartsByNumber(saved.Parts))
+		return saved
+	}
+	return s
+}
+
+// uploadStateHash returns a hash of src as type:sum to identify it,
+// or "" if its Fs doesn't have one.  This may read all of src if the
+// hash isn't stored, eg for local files.
+func uploadStateHash(src ObjectInfo) string {
+	f := src.Fs()
+	if f == nil {
+		return ""
+	}
+	ht := f.Hashes().GetOne()
+	if ht == HashNone {
+		return ""
+	}
+	sum, err := src.Hash(ht)
+	if err != nil {
+		Debug(src, "Failed to read %v for upload state: %v", ht, err)
+		return ""
+	}
+	if sum == "" {
+		return ""
+	}
+	return ht.String() + ":" + sum
+}
+
+// abort cancels the upload session of the state on the remote so it
+// doesn't use up space there and removes the state.
+//
+// The session is cancelled with f if it is the Fs the upload was to,
+// otherwise with an Fs made from the state.
+func (s *UploadState) abort(f Info) {
+	if s.Session != "" && s.Fs != "" {
+		err := s.abortSession(f)
+		if err != nil {
+			ErrorLog(nil, "Failed to abort upload to %q: %v", s.Remote, err)
+		} else {
+			Debug(nil, "Aborted upload to %q", s.Remote)
+		}
+	}
+	s.Remove()
+}
+
+// abortSession cancels the upload session of the state with f or an
+// Fs made from the state
+func (s *UploadState) abortSession(f Info) error {
+	do, ok := f.(UploadAborter)
+	if !ok || f.Name()+":"+f.Root() != s.Fs {
+		newF, err := NewFs(s.Fs)
+		if err != nil {
+			return err
+		}
+		do, ok = newF.(UploadAborter)
+		if !ok {
+			return fmt.Errorf("%v can't abort uploads", newF)
+		}
+	}
+	return do.AbortUpload(s)
+}
+
+// loadUploadState reads the state from statePath returning nil if
+// there isn't one
+func loadUploadState(statePath string) (*UploadState, error) {
+	data, err := ioutil.ReadFile(statePath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read upload state: %v", err)
+	}
+	s := &UploadState{
+		path:  statePath,
+		saved: true,
+	}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode upload state %q: %v", statePath, err)
+	}
+	return s, nil
+}
+
+// Resuming returns whether s is a saved upload to carry on with
+func (s *UploadState) Resuming() bool {
+	return s.Session != ""
+}
+
+// Reset clears the session from the state so a new one can be
+// started
+func (s *UploadState) Reset() {
+	s.Session = ""
+	s.Offset = 0
+	s.Parts = nil
+	s.Created = time.Now()
+}
+
+// Resumable returns whether the upload should be kept on the remote
+// if it fails so a later rclone can resume it.  This is only the case
+// if the state has been saved with some of the data uploaded,
+// otherwise the session should be aborted.
+//
+// Kept sessions are aborted when their state expires or the source
+// changes.
+func (s *UploadState) Resumable() bool {
+	return s.saved && s.Offset > 0
+}
+
+// PartsDone returns the number of parts starting from part 1 which
+// have all been uploaded
+func (s *UploadState) PartsDone() int64 {
+	sort.Sort(uploadPartsByNumber(s.Parts))
+	done := int64(0)
+	for _, part := range s.Parts {
+		if part.Number != done+1 {
+			break
+		}
+		done++
+	}
+	return done
+}
+
+// Save writes the state to Config.UploadStateDir, replacing the
+// previous one atomically.  It does nothing if there is no
+// Config.UploadStateDir.
+func (s *UploadState) Save() error {
+	if s.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to encode upload state: %v", err)
+	}
+	err = os.MkdirAll(filepath.Dir(s.path), 0700)
+	if err != nil {
+		return fmt.Errorf("failed to make upload state directory: %v", err)
+	}
+	tmpPath := s.path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write upload state: %v", err)
+	}
+	err = os.Rename(tmpPath, s.path)
+	if err != nil {
+		return fmt.Errorf("failed to replace upload state: %v", err)
+	}
+	s.saved = true
+	return nil
+}
+
+// Remove deletes the saved state, which should be done once the
+// upload has finished or the session is no longer usable
+func (s *UploadState) Remove() {
+	if s.path == "" {
+		return
+	}
+	s.saved = false
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		ErrorLog(nil, "Failed to remove upload state: %v", err)
+	}
+}
+
+// CleanUploadStates removes the saved upload states which are older
+// than Config.UploadStateMaxAge, aborting their sessions on the
+// remote, and those which can't be read.
+func CleanUploadStates() {
+	if Config.UploadStateDir == "" {
+		return
+	}
+	files, err := ioutil.ReadDir(Config.UploadStateDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			ErrorLog(nil, "Failed to read upload states: %v", err)
+		}
+		return
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		statePath := filepath.Join(Config.UploadStateDir, file.Name())
+		s, err := loadUploadState(statePath)
+		if err == nil && s != nil && !s.expired() {
+			continue
+		}
+		Debug(nil, "Removing stale upload state %q", statePath)
+		if s != nil {
+			s.abort(nil)
+			continue
+		}
+		err = os.Remove(statePath)
+		if err != nil {
+			ErrorLog(nil, "Failed to remove upload state: %v", err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Test resumable upload state
+//
+// The states are for uploads from the remote to the local Fs, though
+// only the name of the destination matters
+
+package fs_test
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+)
+
+// uploadStateSetup points the upload state at a temporary directory
+// returning a function to restore it
+func uploadStateSetup(t *testing.T) func() {
+	oldStateDir, oldMaxAge := fs.Config.UploadStateDir, fs.Config.UploadStateMaxAge
+	stateDir, err := ioutil.TempDir("", "rclone-uploads")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	fs.Config.UploadStateDir = stateDir
+	fs.Config.UploadStateMaxAge = time.Hour
+	return func() {
+		fs.Config.UploadStateDir, fs.Config.UploadStateMaxAge = oldStateDir, oldMaxAge
+		err := os.RemoveAll(stateDir)
+		if err != nil {
+			t.Errorf("Failed to remove %q: %v", stateDir, err)
+		}
+	}
+}
+
+// countUploadStates returns the number of saved upload states
+func countUploadStates(t *testing.T) int {
+	files, err := ioutil.ReadDir(fs.Config.UploadStateDir)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatalf("Failed to read upload states: %v", err)
+	}
+	return len(files)
+}
+
+func TestUploadStateResume(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	if state.Resuming() {
+		t.Fatalf("Resuming a new upload")
+	}
+	state.Session = "session"
+	state.Offset = 6
+	state.Parts = []fs.UploadPart{{Number: 1, ID: "part1"}}
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	files, err := ioutil.ReadDir(fs.Config.UploadStateDir)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Expecting 1 upload state got %d: %v", len(files), err)
+	}
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Errorf("Expecting upload state to be private but mode is %v", mode)
+	}
+
+	state = fs.NewUploadState(r.flocal, "potato", src)
+	if !state.Resuming() {
+		t.Fatalf("Not resuming a saved upload")
+	}
+	if state.Session != "session" || state.Offset != 6 || len(state.Parts) != 1 {
+		t.Errorf("Wrong state resumed: %+v", state)
+	}
+
+	// A different destination shouldn't resume
+	other := fs.NewUploadState(r.flocal, "potato2", src)
+	if other.Resuming() {
+		t.Errorf("Resuming upload to a different destination")
+	}
+
+	state.Remove()
+	if n := countUploadStates(t); n != 0 {
+		t.Errorf("Expecting no upload states but found %d", n)
+	}
+}
+
+func TestUploadStateSourceChanged(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	state.Session = "session"
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	r.WriteObject("potato", "new potato content", t2)
+	src = r.fremote.NewFsObject("potato")
+	state = fs.NewUploadState(r.flocal, "potato", src)
+	if state.Resuming() {
+		t.Errorf("Resuming upload of a changed source")
+	}
+	if n := countUploadStates(t); n != 0 {
+		t.Errorf("Expecting stale upload state to be removed but found %d", n)
+	}
+}
+
+func TestUploadStateSourceContentChanged(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	if r.fremote.Hashes().GetOne() == fs.HashNone {
+		t.Skip("Remote doesn't support hashes")
+	}
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	state.Session = "session"
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Same size and modification time but different content
+	r.WriteObject("potato", "POTATO CONTENT", t1)
+	src = r.fremote.NewFsObject("potato")
+	state = fs.NewUploadState(r.flocal, "potato", src)
+	if state.Resuming() {
+		t.Errorf("Resuming upload of a source with changed content")
+	}
+}
+
+func TestUploadStateDisabled(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	stateDir := fs.Config.UploadStateDir
+	fs.Config.UploadStateDir = ""
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	state.Session = "session"
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	fs.Config.UploadStateDir = stateDir
+	if n := countUploadStates(t); n != 0 {
+		t.Errorf("Expecting no upload states to be saved but found %d", n)
+	}
+}
+
+func TestUploadStateExpired(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	state.Session = "session"
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	fs.Config.UploadStateMaxAge = 0
+	fs.CleanUploadStates()
+	if n := countUploadStates(t); n != 0 {
+		t.Errorf("Expecting expired upload state to be removed but found %d", n)
+	}
+}
+
+// abortFs is an UploadAborter which records the sessions it aborts
+type abortFs struct {
+	fs.Fs
+	aborted []string
+}
+
+// AbortUpload records the session
+func (f *abortFs) AbortUpload(s *fs.UploadState) error {
+	f.aborted = append(f.aborted, s.Session)
+	return nil
+}
+
+func TestUploadStateAbort(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+	f := &abortFs{Fs: r.flocal}
+
+	state := fs.NewUploadState(f, "potato", src)
+	state.Session = "session1"
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Expired sessions are aborted
+	fs.Config.UploadStateMaxAge = 0
+	state = fs.NewUploadState(f, "potato", src)
+	if state.Resuming() {
+		t.Errorf("Resuming an expired upload")
+	}
+	state.Session = "session2"
+	err = state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	// Sessions for a changed source are aborted
+	fs.Config.UploadStateMaxAge = time.Hour
+	r.WriteObject("potato", "new potato content", t2)
+	src = r.fremote.NewFsObject("potato")
+	state = fs.NewUploadState(f, "potato", src)
+	if state.Resuming() {
+		t.Errorf("Resuming upload of a changed source")
+	}
+
+	if want := []string{"session1", "session2"}; !reflect.DeepEqual(f.aborted, want) {
+		t.Errorf("Aborted sessions want %q got %q", want, f.aborted)
+	}
+	if n := countUploadStates(t); n != 0 {
+		t.Errorf("Expecting aborted upload states to be removed but found %d", n)
+	}
+}
+
+func TestUploadStateResumable(t *testing.T) {
+	r := NewRun(t)
+	defer r.Finalise()
+	defer uploadStateSetup(t)()
+	r.WriteObject("potato", "potato content", t1)
+	src := r.fremote.NewFsObject("potato")
+
+	state := fs.NewUploadState(r.flocal, "potato", src)
+	state.Session = "session"
+	state.Offset = 6
+	if state.Resumable() {
+		t.Errorf("Resumable before being saved")
+	}
+	err := state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if !state.Resumable() {
+		t.Errorf("Not resumable once saved")
+	}
+	state.Remove()
+	if state.Resumable() {
+		t.Errorf("Resumable once removed")
+	}
+
+	state.Offset = 0
+	err = state.Save()
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	if state.Resumable() {
+		t.Errorf("Resumable with nothing uploaded")
+	}
+	state.Remove()
+}
+
+func TestUploadStatePartsDone(t *testing.T) {
+	for _, test := range []struct {
+		parts []int64
+		want  int64
+	}{
+		{nil, 0},
+		{[]int64{1}, 1},
+		{[]int64{2}, 0},
+		{[]int64{3, 1, 2}, 3},
+		{[]int64{1, 2, 4, 5}, 2},
+	} {
+		state := new(fs.UploadState)
+		for _, number := range test.parts {
+			state.Parts = append(state.Parts, fs.UploadPart{Number: number})
+		}
+		if got := state.PartsDone(); got != test.want {
+			t.Errorf("%v: want %d got %d", test.parts, test.want, got)
+		}
+	}
+}
@@ -31,6 +31,10 @@ var (
 		"TestHubic:",
 		"TestB2:",
 		"TestYandex:",
+		"TestCrypt:",
+		"TestSftp:",
+		"TestWebdav:",
+		"TestFTP:",
 	}
 	binary = "fs.test"
 	// Flags
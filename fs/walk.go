@@ -0,0 +1,328 @@
+// Walk two file systems together a directory at a time
+
+package fs
+
+import (
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/context"
+	"golang.org/x/text/unicode/norm"
+)
+
+// dirEntries is the contents of one directory
+type dirEntries struct {
+	objects []Object
+	dirs    []*Dir
+}
+
+// dirLister lists an Fs one directory at a time
+//
+// If the Fs doesn't implement DirLister then the whole tree is read
+// with List the first time it is needed and handed out a directory at
+// a time.
+type dirLister struct {
+	f    Fs
+	once sync.Once
+	mu   sync.Mutex
+	tree map[string]*dirEntries
+}
+
+// newDirLister makes a dirLister for f
+func newDirLister(f Fs) *dirLister {
+	return &dirLister{f: f}
+}
+
+// readTree reads the whole tree of an Fs which isn't a DirLister
+func (l *dirLister) readTree() {
+	l.tree = make(map[string]*dirEntries)
+	for o := range l.f.List() {
+		dir := parentDir(o.Remote())
+		l.addDir(dir)
+		entry := l.tree[dir]
+		entry.objects = append(entry.objects, o)
+	}
+}
+
+// addDir adds dir and its parents to the tree if they aren't there
+// already
+func (l *dirLister) addDir(dir string) {
+	if _, ok := l.tree[dir]; ok {
+		return
+	}
+	l.tree[dir] = new(dirEntries)
+	if dir == "" {
+		return
+	}
+	parent := parentDir(dir)
+	l.addDir(parent)
+	l.tree[parent].dirs = append(l.tree[parent].dirs, &Dir{
+		Name:  dir,
+		Bytes: -1,
+		Count: -1,
+	})
+}
+
+// parentDir returns the directory remote is in, "" for the root
+func parentDir(remote string) string {
+	dir := path.Dir(remote)
+	if dir == "." || dir == "/" {
+		return ""
+	}
+	return dir
+}
+
+// list returns the objects and directories in dir sorted by name
+func (l *dirLister) list(ctx context.Context, dir string) (objects []Object, dirs []*Dir, err error) {
+	if do, ok := l.f.(DirLister); ok {
+		objects, dirs, err = do.ListDirectory(ctx, dir)
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		l.once.Do(l.readTree)
+		l.mu.Lock()
+		if entry := l.tree[dir]; entry != nil {
+			objects, dirs = entry.objects, entry.dirs
+			// each directory is only listed once so free it
+			delete(l.tree, dir)
+		}
+		l.mu.Unlock()
+	}
+	sort.Sort(objectsByRemote(objects))
+	sort.Sort(dirsByName(dirs))
+	return objects, dirs, nil
+}
+
+// objectsByRemote implements sort.Interface sorting by Remote
+type objectsByRemote []Object
+
+func (objs objectsByRemote) Len() int           { return len(objs) }
+func (objs objectsByRemote) Swap(i, j int)      { objs[i], objs[j] = objs[j], objs[i] }
+func (objs objectsByRemote) Less(i, j int) bool { return objs[i].Remote() < objs[j].Remote() }
+
+// dirsByName implements sort.Interface sorting by Name
+type dirsByName []*Dir
+
+func (dirs dirsByName) Len() int           { return len(dirs) }
+func (dirs dirsByName) Swap(i, j int)      { dirs[i], dirs[j] = dirs[j], dirs[i] }
+func (dirs dirsByName) Less(i, j int) bool { return dirs[i].Name < dirs[j].Name }
+
+// matchDirs calls fn for each pair of directories with the same Name
+// in the sorted srcDirs and dstDirs, with src or dst nil if it is
+// only on one side
+func matchDirs(srcDirs, dstDirs []*Dir, fn func(src, dst *Dir)) {
+	for i, j := 0, 0; i < len(srcDirs) || j < len(dstDirs); {
+		switch {
+		case j >= len(dstDirs) || (i < len(srcDirs) && srcDirs[i].Name < dstDirs[j].Name):
+			fn(srcDirs[i], nil)
+			i++
+		case i >= len(srcDirs) || dstDirs[j].Name < srcDirs[i].Name:
+			fn(nil, dstDirs[j])
+			j++
+		default:
+			fn(srcDirs[i], dstDirs[j])
+			i++
+			j++
+		}
+	}
+}
+
+// walkDir is a directory to be walked and which sides it is on
+type walkDir struct {
+	dir    string
+	inSrc  bool
+	inDst  bool
+	inRefs []bool // which of the reference Fs it is in
+}
+
+// walkFn is called by walkDirs with the sorted objects and
+// directories in each directory of the source and destination, and
+// the sorted objects in the same directory of each reference Fs
+type walkFn func(dir string, srcObjects, dstObjects []Object, srcDirs, dstDirs []*Dir, refObjects [][]Object)
+
+// walkDirs walks fsrc and fdst together a directory at a time calling
+// fn with the objects in each, using Config.Checkers go routines.
+// Only the listings of the directories being worked on are held in
+// memory, so fn is called as soon as the first directory is read.
+//
+// Directories which are only in fdst are walked only if walkDstOnly
+// is set.  Directories which the filters exclude everything in are
+// neither walked nor passed to fn.
+//
+// The directories in the source are also read from each of refs, if
+// they exist there, so the source can be compared with them.  A
+// reference directory which can't be listed is logged and treated as
+// empty.
+//
+// If a directory can't be listed then the error is counted and logged
+// and neither it nor the directories inside it are passed to fn.
+//
+// Once ctx is cancelled no more directories are read.
+func walkDirs(ctx context.Context, fsrc, fdst Fs, refs []Fs, walkDstOnly bool, fn walkFn) {
+	srcLister, dstLister := newDirLister(fsrc), newDirLister(fdst)
+	refListers := make([]*dirLister, len(refs))
+	inRefs := make([]bool, len(refs))
+	for i, f := range refs {
+		refListers[i] = newDirLister(f)
+		inRefs[i] = true
+	}
+	var (
+		mu      sync.Mutex
+		cond    = sync.NewCond(&mu)
+		pending = []walkDir{{dir: "", inSrc: true, inDst: true, inRefs: inRefs}}
+		active  int
+		wg      sync.WaitGroup
+	)
+	walkOne := func(d walkDir) (subDirs []walkDir) {
+		var srcObjects, dstObjects []Object
+		var srcDirs, dstDirs []*Dir
+		var err error
+		if d.inSrc {
+			srcObjects, srcDirs, err = srcLister.list(ctx, d.dir)
+			if err != nil {
+				Stats.Error()
+				ErrorLog(fsrc, "Failed to list %q: %v", d.dir, err)
+				return nil
+			}
+		}
+		if d.inDst {
+			dstObjects, dstDirs, err = dstLister.list(ctx, d.dir)
+			if err != nil {
+				Stats.Error()
+				ErrorLog(fdst, "Failed to list %q: %v", d.dir, err)
+				return nil
+			}
+		}
+		var refObjects [][]Object
+		var refDirs []map[string]bool
+		if d.inSrc && len(refs) > 0 {
+			refObjects = make([][]Object, len(refs))
+			refDirs = make([]map[string]bool, len(refs))
+			for i, l := range refListers {
+				if !d.inRefs[i] {
+					continue
+				}
+				objects, dirs, err := l.list(ctx, d.dir)
+				if err != nil {
+					Log(refs[i], "Failed to list %q: %v", d.dir, err)
+					continue
+				}
+				refObjects[i] = objects
+				refDirs[i] = make(map[string]bool, len(dirs))
+				for _, dir := range dirs {
+					refDirs[i][dir.Name] = true
+				}
+			}
+		}
+		srcDirs, dstDirs = pruneDirs(fsrc, srcDirs), pruneDirs(fdst, dstDirs)
+		fn(d.dir, srcObjects, dstObjects, srcDirs, dstDirs, refObjects)
+		// subInRefs returns which reference Fs have the source
+		// directory dir
+		subInRefs := func(dir string) []bool {
+			if len(refs) == 0 {
+				return nil
+			}
+			in := make([]bool, len(refs))
+			for i := range refDirs {
+				in[i] = refDirs[i][dir]
+			}
+			return in
+		}
+		matchDirs(srcDirs, dstDirs, func(src, dst *Dir) {
+			switch {
+			case dst == nil:
+				subDirs = append(subDirs, walkDir{dir: src.Name, inSrc: true, inRefs: subInRefs(src.Name)})
+			case src == nil:
+				if walkDstOnly {
+					subDirs = append(subDirs, walkDir{dir: dst.Name, inDst: true})
+				}
+			default:
+				subDirs = append(subDirs, walkDir{dir: src.Name, inSrc: true, inDst: true, inRefs: subInRefs(src.Name)})
+			}
+		})
+		return subDirs
+	}
+	wg.Add(Config.Checkers)
+	for i := 0; i < Config.Checkers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				mu.Lock()
+				for len(pending) == 0 && active > 0 {
+					cond.Wait()
+				}
+				if len(pending) == 0 {
+					mu.Unlock()
+					return
+				}
+				// Take the last directory to walk depth first
+				// which keeps the pending list short
+				d := pending[len(pending)-1]
+				pending = pending[:len(pending)-1]
+				active++
+				mu.Unlock()
+
+				var subDirs []walkDir
+				if ctx.Err() == nil {
+					subDirs = walkOne(d)
+				}
+
+				mu.Lock()
+				for i := len(subDirs) - 1; i >= 0; i-- {
+					pending = append(pending, subDirs[i])
+				}
+				active--
+				cond.Broadcast()
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// pruneDirs removes the directories which the filters exclude
+// everything in from dirs
+func pruneDirs(f Fs, dirs []*Dir) []*Dir {
+	if !pruning() {
+		return dirs
+	}
+	pruned := dirs[:0]
+	for _, dir := range dirs {
+		if !pruneDirectory(f, dir.Name) {
+			pruned = append(pruned, dir)
+		}
+	}
+	return pruned
+}
+
+// filterObjects removes objects which don't pass the filters and the
+// rules from the per-directory filter files in dirs, unless
+// includeAll is set, and duplicates from the sorted objects
+func filterObjects(objects []Object, includeAll bool, dirs *dirFilters) []Object {
+	filtered := objects[:0]
+	normalised := make(map[string]struct{}, len(objects))
+	previous := ""
+	for i, o := range objects {
+		remote := o.Remote()
+		if i > 0 && remote == previous {
+			Log(o, "Duplicate file detected")
+			continue
+		}
+		previous = remote
+		normalisedRemote := strings.ToLower(norm.NFC.String(remote))
+		if _, ok := normalised[normalisedRemote]; ok {
+			Log(o, "Warning: File found with same name but different case on %v", o.Fs())
+		}
+		normalised[normalisedRemote] = struct{}{}
+		// Make sure we don't delete excluded files if not required
+		if includeAll || Config.Filter.includeObject(o, dirs) {
+			filtered = append(filtered, o)
+		} else {
+			Debug(o, "Excluded from sync (and deletion)")
+		}
+	}
+	return filtered
+}
@@ -9,6 +9,7 @@ import (
 	"bytes"
 	"flag"
 	"io"
+	"io/ioutil"
 	"log"
 	"os"
 	"strings"
@@ -27,7 +28,9 @@ var (
 	subRemoteLeaf = ""
 	// NilObject should be set to a nil Object from the Fs under test
 	NilObject fs.Object
-	file1     = fstest.Item{
+	// ExtraConfig is for adding config to a remote
+	ExtraConfig = []ExtraConfigItem{}
+	file1       = fstest.Item{
 		ModTime: fstest.Time("2001-02-03T04:05:06.499999999Z"),
 		Path:    "file name.txt",
 	}
@@ -36,11 +39,15 @@ var (
 		Path:    `hello? sausage/êé/Hello, 世界/ " ' @ < > & ?/z.txt`,
 		WinPath: `hello_ sausage/êé/Hello, 世界/ _ ' @ _ _ & _/z.txt`,
 	}
-	verbose     = flag.Bool("verbose", false, "Set to enable logging")
-	dumpHeaders = flag.Bool("dump-headers", false, "Dump HTTP headers - may contain sensitive info")
-	dumpBodies  = flag.Bool("dump-bodies", false, "Dump HTTP headers and bodies - may contain sensitive info")
+	file1Contents = ""
+	verbose       = flag.Bool("verbose", false, "Set to enable logging")
+	dumpHeaders   = flag.Bool("dump-headers", false, "Dump HTTP headers - may contain sensitive info")
+	dumpBodies    = flag.Bool("dump-bodies", false, "Dump HTTP headers and bodies - may contain sensitive info")
 )
 
+// ExtraConfigItem describes a config item added on the fly while testing
+type ExtraConfigItem struct{ Name, Key, Value string }
+
 const eventualConsistencyRetries = 10
 
 func init() {
@@ -60,6 +67,9 @@ func TestInit(t *testing.T) {
 	fs.Config.Quiet = !*verbose
 	fs.Config.DumpHeaders = *dumpHeaders
 	fs.Config.DumpBodies = *dumpBodies
+	for _, item := range ExtraConfig {
+		fs.ConfigFile.SetValue(item.Name, item.Key, item.Value)
+	}
 	t.Logf("Using remote %q", RemoteName)
 	if RemoteName == "" {
 		RemoteName, err = fstest.LocalRemote()
@@ -158,8 +168,9 @@ func findObject(t *testing.T, Name string) fs.Object {
 	return obj
 }
 
-func testPut(t *testing.T, file *fstest.Item) {
-	buf := bytes.NewBufferString(fstest.RandomString(100))
+func testPut(t *testing.T, file *fstest.Item) string {
+	contents := fstest.RandomString(100)
+	buf := bytes.NewBufferString(contents)
 	hash := fs.NewMultiHasher()
 	in := io.TeeReader(buf, hash)
 
@@ -174,12 +185,13 @@ func testPut(t *testing.T, file *fstest.Item) {
 	// Re-read the object and check again
 	obj = findObject(t, file.Path)
 	file.Check(t, obj, remote.Precision())
+	return contents
 }
 
 // TestFsPutFile1 tests putting a file
 func TestFsPutFile1(t *testing.T) {
 	skipIfNotOk(t)
-	testPut(t, &file1)
+	file1Contents = testPut(t, &file1)
 }
 
 // TestFsPutFile2 tests putting a file into a subdirectory
@@ -304,6 +316,9 @@ func TestFsCopy(t *testing.T) {
 	// do the copy
 	src := findObject(t, file1.Path)
 	dst, err := remote.(fs.Copier).Copy(src, file1Copy.Path)
+	if err == fs.ErrorCantCopy {
+		t.Skip("FS can't copy")
+	}
 	if err != nil {
 		t.Fatalf("Copy failed: %v (%#v)", err, err)
 	}
@@ -340,6 +355,9 @@ func TestFsMove(t *testing.T) {
 	// do the move
 	src := findObject(t, file1.Path)
 	dst, err := remote.(fs.Mover).Move(src, file1Move.Path)
+	if err == fs.ErrorCantMove {
+		t.Skip("FS can't move")
+	}
 	if err != nil {
 		t.Fatalf("Move failed: %v", err)
 	}
@@ -383,6 +401,9 @@ func TestFsDirMove(t *testing.T) {
 
 	// Check it can't move onto itself
 	err := remote.(fs.DirMover).DirMove(remote)
+	if err == fs.ErrorCantDirMove {
+		t.Skip("FS can't move directories")
+	}
 	if err != fs.ErrorDirExists {
 		t.Errorf("Expecting fs.ErrorDirExists got: %v", err)
 	}
@@ -543,10 +564,56 @@ func TestObjectOpen(t *testing.T) {
 
 }
 
+// TestObjectOpenRange tests that OpenRange works
+func TestObjectOpenRange(t *testing.T) {
+	skipIfNotOk(t)
+	obj := findObject(t, file1.Path)
+	size := int64(len(file1Contents))
+	for _, test := range []struct {
+		offset, length int64
+	}{
+		{0, -1},
+		{0, size},
+		{1, -1},
+		{0, 1},
+		{size / 2, 10},
+		{size - 1, -1},
+		{size - 10, 100},
+		{size, -1},
+		{size + 1, 1},
+		{3, 0},
+	} {
+		in, err := fs.OpenRange(obj, test.offset, test.length)
+		if err != nil {
+			t.Fatalf("OpenRange(%d, %d) return error: %v", test.offset, test.length, err)
+		}
+		got, err := ioutil.ReadAll(in)
+		if err != nil {
+			t.Fatalf("OpenRange(%d, %d) read error: %v", test.offset, test.length, err)
+		}
+		err = in.Close()
+		if err != nil {
+			t.Fatalf("OpenRange(%d, %d) close error: %v", test.offset, test.length, err)
+		}
+		start, end := test.offset, size
+		if start > size {
+			start = size
+		}
+		if test.length >= 0 && start+test.length < end {
+			end = start + test.length
+		}
+		want := file1Contents[start:end]
+		if string(got) != want {
+			t.Errorf("OpenRange(%d, %d) read %q, want %q", test.offset, test.length, got, want)
+		}
+	}
+}
+
 // TestObjectUpdate tests that Update works
 func TestObjectUpdate(t *testing.T) {
 	skipIfNotOk(t)
-	buf := bytes.NewBufferString(fstest.RandomString(200))
+	contents := fstest.RandomString(200)
+	buf := bytes.NewBufferString(contents)
 	hash := fs.NewMultiHasher()
 	in := io.TeeReader(buf, hash)
 
@@ -558,6 +625,7 @@ func TestObjectUpdate(t *testing.T) {
 		t.Fatal("Update error", err)
 	}
 	file1.Hashes = hash.Sums()
+	file1Contents = contents
 	file1.Check(t, obj, remote.Precision())
 	// Re-read the object and check again
 	obj = findObject(t, file1.Path)
@@ -135,5 +135,9 @@ func main() {
 	generateTestProgram(t, fns, "Hubic")
 	generateTestProgram(t, fns, "B2")
 	generateTestProgram(t, fns, "Yandex")
+	generateTestProgram(t, fns, "Crypt")
+	generateTestProgram(t, fns, "Sftp")
+	generateTestProgram(t, fns, "Webdav")
+	generateTestProgram(t, fns, "FTP")
 	log.Printf("Done")
 }
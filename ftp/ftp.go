@@ -0,0 +1,710 @@
+// Package ftp interfaces with FTP servers
+package ftp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"os"
+	"os/user"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+	"github.com/ncw/rclone/fs"
+)
+
+// Globals
+var (
+	errorNotFound = errors.New("not found")
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "ftp",
+		Description: "FTP Connection",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "host",
+			Help: "FTP host to connect to",
+			Examples: []fs.OptionExample{{
+				Value: "ftp.example.com",
+				Help:  "Connect to ftp.example.com",
+			}},
+		}, {
+			Name:     "user",
+			Help:     "FTP username, leave blank for current username",
+			Optional: true,
+		}, {
+			Name:     "port",
+			Help:     "FTP port, leave blank to use default (21)",
+			Optional: true,
+		}, {
+			Name:       "pass",
+			Help:       "FTP password",
+			IsPassword: true,
+		}},
+	})
+}
+
+// Fs represents a remote FTP server
+type Fs struct {
+	name     string // name of this remote
+	root     string // the path we are working on if any
+	url      string // description of the connection for String()
+	user     string // user to log in as
+	pass     string // password to log in with
+	dialAddr string // host:port to connect to
+	poolMu   sync.Mutex
+	pool     []*ftp.ServerConn // idle connections
+	tokens   chan struct{}     // a token for each connection allowed to be open
+}
+
+// Object describes an FTP file
+type Object struct {
+	fs     *Fs
+	remote string
+	info   *FileInfo
+}
+
+// FileInfo is the metadata known about an FTP file
+type FileInfo struct {
+	Name    string
+	Size    uint64
+	ModTime time.Time
+	IsDir   bool
+}
+
+// ------------------------------------------------------------
+
+// Name of this fs
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String returns a description of the FS
+func (f *Fs) String() string {
+	return f.url
+}
+
+// readCurrentUser finds the current user name or "" if not found
+func readCurrentUser() (userName string) {
+	usr, err := user.Current()
+	if err == nil {
+		return usr.Username
+	}
+	// Fall back to reading $USER then $LOGNAME
+	userName = os.Getenv("USER")
+	if userName != "" {
+		return userName
+	}
+	return os.Getenv("LOGNAME")
+}
+
+// ftpConnection opens a new FTP connection and logs in
+func (f *Fs) ftpConnection() (*ftp.ServerConn, error) {
+	fs.Debug(f, "Connecting to FTP server")
+	c, err := ftp.DialTimeout(f.dialAddr, fs.Config.ConnectTimeout)
+	if err != nil {
+		fs.ErrorLog(f, "Error while Dialing %s: %s", f.dialAddr, err)
+		return nil, err
+	}
+	err = c.Login(f.user, f.pass)
+	if err != nil {
+		_ = c.Quit()
+		fs.ErrorLog(f, "Error while Logging in into %s: %s", f.dialAddr, err)
+		return nil, err
+	}
+	return c, nil
+}
+
+// getFtpConnection gets an FTP connection from the pool, or opens a
+// new one
+//
+// It waits until there are fewer than the maximum number of
+// connections in use.  The connection must be given back with
+// putFtpConnection or closeFtpConnection.
+func (f *Fs) getFtpConnection() (c *ftp.ServerConn, err error) {
+	<-f.tokens
+	f.poolMu.Lock()
+	if len(f.pool) > 0 {
+		c = f.pool[0]
+		f.pool = f.pool[1:]
+	}
+	f.poolMu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	c, err = f.ftpConnection()
+	if err != nil {
+		f.tokens <- struct{}{}
+		return nil, err
+	}
+	return c, nil
+}
+
+// putFtpConnection returns an FTP connection to the pool
+//
+// It is passed the error from the last operation on the connection.
+// If this isn't an error the FTP server sent then the connection is
+// checked and discarded if it is broken.
+func (f *Fs) putFtpConnection(pc **ftp.ServerConn, err error) {
+	if err != nil {
+		if _, isRegularError := err.(*textproto.Error); !isRegularError {
+			nopErr := (*pc).NoOp()
+			if nopErr != nil {
+				fs.Debug(f, "Connection failed, closing: %v", nopErr)
+				f.closeFtpConnection(pc)
+				return
+			}
+		}
+	}
+	f.poolMu.Lock()
+	f.pool = append(f.pool, *pc)
+	f.poolMu.Unlock()
+	*pc = nil
+	f.tokens <- struct{}{}
+}
+
+// closeFtpConnection closes an FTP connection which can't be reused
+// instead of returning it to the pool
+func (f *Fs) closeFtpConnection(pc **ftp.ServerConn) {
+	_ = (*pc).Quit()
+	*pc = nil
+	f.tokens <- struct{}{}
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(name, root string) (fs.Fs, error) {
+	host := fs.ConfigFile.MustValue(name, "host")
+	if host == "" {
+		return nil, fmt.Errorf("host not set in config file")
+	}
+	userName := fs.ConfigFile.MustValue(name, "user")
+	if userName == "" {
+		userName = readCurrentUser()
+	}
+	pass := fs.ConfigFile.MustValue(name, "pass")
+	if pass != "" {
+		pass = fs.Reveal(pass)
+	}
+	port := fs.ConfigFile.MustValue(name, "port", "21")
+	dialAddr := net.JoinHostPort(host, port)
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:     name,
+		root:     root,
+		url:      "ftp://" + userName + "@" + dialAddr + "/" + root,
+		user:     userName,
+		pass:     pass,
+		dialAddr: dialAddr,
+	}
+	// Allow a connection for each checker and transfer
+	maxConnections := fs.Config.Checkers + fs.Config.Transfers
+	f.tokens = make(chan struct{}, maxConnections)
+	for i := 0; i < maxConnections; i++ {
+		f.tokens <- struct{}{}
+	}
+	// Make a connection and pool it to check the credentials
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return nil, fmt.Errorf("NewFs: %v", err)
+	}
+	f.putFtpConnection(&c, nil)
+	if root != "" {
+		// Check to see if the root is actually an existing file
+		remote := path.Base(root)
+		f.root = path.Dir(root)
+		if f.root == "." {
+			f.root = ""
+		}
+		obj := f.NewFsObject(remote)
+		if obj != nil {
+			// return a Fs Limited to this object
+			return fs.NewLimited(f, obj), nil
+		}
+		f.root = root
+	}
+	return f, nil
+}
+
+// absPath returns the path of remote on the server
+func (f *Fs) absPath(remote string) string {
+	return path.Join(f.root, remote)
+}
+
+// dirPath returns a directory path suitable for listing
+func dirPath(p string) string {
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+// readDir reads the entries in the directory at absPath
+func (f *Fs) readDir(absPath string) (infos []*FileInfo, err error) {
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return nil, fmt.Errorf("readDir: %v", err)
+	}
+	entries, err := c.List(dirPath(absPath))
+	f.putFtpConnection(&c, err)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		infos = append(infos, &FileInfo{
+			Name:    entry.Name,
+			Size:    entry.Size,
+			ModTime: entry.Time,
+			IsDir:   entry.Type == ftp.EntryTypeFolder,
+		})
+	}
+	return infos, nil
+}
+
+// getInfo reads the FileInfo for absPath by listing its parent
+//
+// It returns errorNotFound if it doesn't exist
+func (f *Fs) getInfo(absPath string) (*FileInfo, error) {
+	dir, leaf := path.Split(absPath)
+	dir = strings.TrimSuffix(dir, "/")
+	infos, err := f.readDir(dir)
+	if err != nil {
+		if ftpErr, ok := err.(*textproto.Error); ok && ftpErr.Code == ftp.StatusFileUnavailable {
+			// The parent directory doesn't exist
+			return nil, errorNotFound
+		}
+		return nil, err
+	}
+	for _, info := range infos {
+		if info.Name == leaf {
+			return info, nil
+		}
+	}
+	return nil, errorNotFound
+}
+
+// NewFsObject finds the Object at remote.  If it can't be found
+// it returns nil
+func (f *Fs) NewFsObject(remote string) fs.Object {
+	info, err := f.getInfo(f.absPath(remote))
+	if err != nil {
+		fs.Debug(remote, "Failed to find object: %v", err)
+		return nil
+	}
+	if info.IsDir {
+		fs.Debug(remote, "Found directory not file")
+		return nil
+	}
+	return &Object{
+		fs:     f,
+		remote: remote,
+		info:   info,
+	}
+}
+
+// list the objects in dir recursively into out
+func (f *Fs) list(out fs.ObjectsChan, dir string) {
+	infos, err := f.readDir(f.absPath(dir))
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Couldn't list directory %q: %v", dir, err)
+		return
+	}
+	for _, info := range infos {
+		remote := path.Join(dir, info.Name)
+		if info.IsDir {
+			f.list(out, remote)
+			continue
+		}
+		out <- &Object{
+			fs:     f,
+			remote: remote,
+			info:   info,
+		}
+	}
+}
+
+// List the objects and directories of the Fs starting from the root
+func (f *Fs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		f.list(out, "")
+	}()
+	return out
+}
+
+// ListDir lists the directories
+func (f *Fs) ListDir() fs.DirChan {
+	out := make(fs.DirChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		infos, err := f.readDir(f.absPath(""))
+		if err != nil {
+			fs.Stats.Error()
+			fs.ErrorLog(f, "Couldn't list directories: %v", err)
+			return
+		}
+		for _, info := range infos {
+			if info.IsDir {
+				out <- &fs.Dir{
+					Name:  info.Name,
+					When:  info.ModTime,
+					Bytes: -1,
+					Count: -1,
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Hashes are not supported
+func (f *Fs) Hashes() fs.HashSet {
+	return fs.HashSet(fs.HashNone)
+}
+
+// Precision shows that modified times can't be set over FTP
+func (f *Fs) Precision() time.Duration {
+	return fs.ModTimeNotSupported
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// May create the object even if it returns an error - if so
+// will return the object and the error, otherwise will return
+// nil and the error
+func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	err := o.Update(in, src)
+	return o, err
+}
+
+// mkdir makes the directory absPath and any parent directories which
+// don't exist
+func (f *Fs) mkdir(absPath string) error {
+	if absPath == "" || absPath == "." || absPath == "/" {
+		return nil
+	}
+	info, err := f.getInfo(absPath)
+	if err == nil {
+		if info.IsDir {
+			return nil
+		}
+		return fmt.Errorf("%q already exists and is not a directory", absPath)
+	} else if err != errorNotFound {
+		return fmt.Errorf("mkdir %q failed: %v", absPath, err)
+	}
+	err = f.mkdir(path.Dir(absPath))
+	if err != nil {
+		return err
+	}
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("mkdir: %v", err)
+	}
+	err = c.MakeDir(absPath)
+	f.putFtpConnection(&c, err)
+	return err
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir() error {
+	return f.mkdir(f.root)
+}
+
+// Rmdir removes the root directory of the Fs object
+//
+// If it isn't empty it will return an error
+func (f *Fs) Rmdir() error {
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("Rmdir: %v", err)
+	}
+	err = c.RemoveDir(dirPath(f.root))
+	f.putFtpConnection(&c, err)
+	return err
+}
+
+// removeAll removes the directory absPath and all of its contents
+func (f *Fs) removeAll(absPath string) error {
+	infos, err := f.readDir(absPath)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		p := path.Join(absPath, info.Name)
+		if info.IsDir {
+			err = f.removeAll(p)
+		} else {
+			err = f.remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("removeAll: %v", err)
+	}
+	err = c.RemoveDir(dirPath(absPath))
+	f.putFtpConnection(&c, err)
+	return err
+}
+
+// remove deletes the file at absPath
+func (f *Fs) remove(absPath string) error {
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("remove: %v", err)
+	}
+	err = c.Delete(absPath)
+	f.putFtpConnection(&c, err)
+	return err
+}
+
+// Purge deletes all the files and directories
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge() error {
+	return f.removeAll(f.root)
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debug(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	dstPath := f.absPath(remote)
+	err := f.mkdir(path.Dir(dstPath))
+	if err != nil {
+		return nil, fmt.Errorf("Move mkdir failed: %v", err)
+	}
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return nil, fmt.Errorf("Move: %v", err)
+	}
+	err = c.Rename(srcObj.path(), dstPath)
+	f.putFtpConnection(&c, err)
+	if err != nil {
+		return nil, fmt.Errorf("Move Rename failed: %v", err)
+	}
+	dstObj := f.NewFsObject(remote)
+	if dstObj == nil {
+		return nil, fmt.Errorf("Move: couldn't find moved object")
+	}
+	return dstObj, nil
+}
+
+// DirMove moves src directory to this remote using server side move
+// operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(src fs.Fs) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debug(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+
+	// Check if destination exists
+	_, err := f.getInfo(f.root)
+	if err == nil {
+		return fs.ErrorDirExists
+	} else if err != errorNotFound {
+		return fmt.Errorf("DirMove getInfo failed: %v", err)
+	}
+
+	// Make sure the parent directory exists
+	err = f.mkdir(path.Dir(f.root))
+	if err != nil {
+		return fmt.Errorf("DirMove mkdir failed: %v", err)
+	}
+
+	// Do the move
+	c, err := f.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("DirMove: %v", err)
+	}
+	err = c.Rename(dirPath(srcFs.root), f.root)
+	f.putFtpConnection(&c, err)
+	if err != nil {
+		return fmt.Errorf("DirMove Rename failed: %v", err)
+	}
+	return nil
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// String version of o
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// path returns the path of the object on the server
+func (o *Object) path() string {
+	return o.fs.absPath(o.remote)
+}
+
+// Hash returns the hash of an object returning a lowercase hex string
+func (o *Object) Hash(t fs.HashType) (string, error) {
+	return "", fs.ErrHashUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	return int64(o.info.Size)
+}
+
+// ModTime returns the modification time of the object
+func (o *Object) ModTime() time.Time {
+	return o.info.ModTime
+}
+
+// SetModTime sets the modification time of the object
+//
+// This isn't supported over FTP so it just logs a message
+func (o *Object) SetModTime(modTime time.Time) {
+	fs.Debug(o, "Can't set modification time over FTP")
+}
+
+// Storable returns a boolean as to whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// ftpReadCloser returns the connection to the pool when it is closed
+type ftpReadCloser struct {
+	rc  io.ReadCloser
+	c   *ftp.ServerConn
+	f   *Fs
+	err error // errors found during read
+}
+
+// Read bytes into p
+func (f *ftpReadCloser) Read(p []byte) (n int, err error) {
+	n, err = f.rc.Read(p)
+	if err != nil && err != io.EOF {
+		f.err = err // store any errors for Close to examine
+	}
+	return
+}
+
+// Close the FTP reader and return the connection to the pool
+func (f *ftpReadCloser) Close() error {
+	err := f.rc.Close()
+	// if errors while reading or closing, dump the connection
+	if err != nil || f.err != nil {
+		f.f.closeFtpConnection(&f.c)
+	} else {
+		f.f.putFtpConnection(&f.c, nil)
+	}
+	return err
+}
+
+// Open an object for read
+func (o *Object) Open() (io.ReadCloser, error) {
+	c, err := o.fs.getFtpConnection()
+	if err != nil {
+		return nil, fmt.Errorf("Open: %v", err)
+	}
+	fd, err := c.Retr(o.path())
+	if err != nil {
+		o.fs.putFtpConnection(&c, err)
+		return nil, fmt.Errorf("Open Retr failed: %v", err)
+	}
+	return &ftpReadCloser{rc: fd, c: c, f: o.fs}, nil
+}
+
+// Update the already existing object
+//
+// Copy the reader into the object updating modTime and size
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	filePath := o.path()
+	err := o.fs.mkdir(path.Dir(filePath))
+	if err != nil {
+		return fmt.Errorf("Update mkdir failed: %v", err)
+	}
+	c, err := o.fs.getFtpConnection()
+	if err != nil {
+		return fmt.Errorf("Update: %v", err)
+	}
+	err = c.Stor(filePath, in)
+	if err != nil {
+		o.fs.closeFtpConnection(&c)
+		// Try to remove the partial file
+		if removeErr := o.Remove(); removeErr != nil {
+			fs.Debug(o, "Failed to remove partial file: %v", removeErr)
+		}
+		return fmt.Errorf("Update Stor failed: %v", err)
+	}
+	o.fs.putFtpConnection(&c, nil)
+	o.info, err = o.fs.getInfo(filePath)
+	if err != nil {
+		return fmt.Errorf("Update getInfo failed: %v", err)
+	}
+	return nil
+}
+
+// Remove an object
+func (o *Object) Remove() error {
+	return o.fs.remove(o.path())
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs       = &Fs{}
+	_ fs.Purger   = &Fs{}
+	_ fs.Mover    = &Fs{}
+	_ fs.DirMover = &Fs{}
+	_ fs.Object   = &Object{}
+)
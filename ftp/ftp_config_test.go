@@ -0,0 +1,360 @@
+package ftp_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fstest/fstests"
+)
+
+// Credentials for the test server
+const (
+	testUser = "rclone"
+	testPass = "potato"
+)
+
+// Create the TestFTP: remote pointing at an FTP server started in
+// this process which serves files from a temporary directory.
+func init() {
+	dir, err := ioutil.TempDir("", "rclone-ftp-test")
+	if err != nil {
+		log.Fatalf("Failed to make temp dir: %v", err)
+	}
+	srv, err := startServer(dir, true)
+	if err != nil {
+		log.Fatalf("Failed to start FTP server: %v", err)
+	}
+	fstests.ExtraConfig = serverConfig("TestFTP", srv)
+}
+
+// serverConfig returns the config for a remote called name using srv
+func serverConfig(name string, srv *server) []fstests.ExtraConfigItem {
+	host, port, err := net.SplitHostPort(srv.addr)
+	if err != nil {
+		log.Fatalf("Bad server address: %v", err)
+	}
+	return []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "ftp"},
+		{Name: name, Key: "host", Value: host},
+		{Name: name, Key: "port", Value: port},
+		{Name: name, Key: "user", Value: testUser},
+		{Name: name, Key: "pass", Value: fs.Obscure(testPass)},
+	}
+}
+
+// server is a minimal FTP server
+type server struct {
+	addr string // address it is listening on
+	mlsd bool   // whether MLSD is supported or just LIST
+
+	mu       sync.Mutex
+	conns    int // number of control connections open
+	maxConns int // most control connections open at once
+}
+
+// startServer starts a minimal FTP server on a random local port
+// serving dir.
+//
+// It implements just enough of RFC 959 and RFC 3659 (MLSD) for the
+// backend to work.  If mlsd isn't set then it doesn't support MLSD so
+// directories are listed with LIST in the style of "ls -l".
+func startServer(dir string, mlsd bool) (*server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	srv := &server{
+		addr: listener.Addr().String(),
+		mlsd: mlsd,
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("FTP server accept failed: %v", err)
+				return
+			}
+			s := &session{
+				srv:  srv,
+				root: dir,
+				conn: textproto.NewConn(conn),
+			}
+			go s.serve()
+		}
+	}()
+	return srv, nil
+}
+
+// connected counts a control connection being opened (+1) or
+// closed (-1)
+func (srv *server) connected(delta int) {
+	srv.mu.Lock()
+	srv.conns += delta
+	if srv.conns > srv.maxConns {
+		srv.maxConns = srv.conns
+	}
+	srv.mu.Unlock()
+}
+
+// maxConnections returns the most control connections open at once
+func (srv *server) maxConnections() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.maxConns
+}
+
+// session is a single FTP control connection
+type session struct {
+	srv        *server
+	root       string
+	conn       *textproto.Conn
+	user       string
+	loggedIn   bool
+	renameFrom string
+	pasv       net.Listener // listener for the next data connection
+}
+
+// reply sends a response to the client
+func (s *session) reply(code int, format string, args ...interface{}) {
+	_ = s.conn.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+// localPath converts an FTP path into a path in the root
+func (s *session) localPath(p string) string {
+	return filepath.Join(s.root, filepath.FromSlash(path.Clean("/"+p)))
+}
+
+// dataConn accepts the data connection set up by EPSV/PASV
+func (s *session) dataConn() (net.Conn, error) {
+	if s.pasv == nil {
+		return nil, fmt.Errorf("no EPSV or PASV command")
+	}
+	defer func() {
+		_ = s.pasv.Close()
+		s.pasv = nil
+	}()
+	return s.pasv.Accept()
+}
+
+// closePasv closes any listener for a data connection which won't be
+// used
+func (s *session) closePasv() {
+	if s.pasv != nil {
+		_ = s.pasv.Close()
+		s.pasv = nil
+	}
+}
+
+// transfer runs fn on a data connection reporting the result
+func (s *session) transfer(fn func(conn net.Conn) error) {
+	s.reply(150, "Opening data connection")
+	conn, err := s.dataConn()
+	if err != nil {
+		s.reply(425, "Can't open data connection: %v", err)
+		return
+	}
+	err = fn(conn)
+	closeErr := conn.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		s.reply(451, "Transfer failed: %v", err)
+		return
+	}
+	s.reply(226, "Transfer complete")
+}
+
+// listLine formats info as a line of "ls -l" output for LIST, with
+// the time of day for files modified in the last six months and the
+// year for older ones
+func listLine(info os.FileInfo) string {
+	mode := "-rw-r--r--"
+	if info.IsDir() {
+		mode = "drwxr-xr-x"
+	}
+	modTime := info.ModTime().UTC()
+	format := "Jan _2 15:04"
+	if time.Since(modTime) > 180*24*time.Hour {
+		format = "Jan _2  2006"
+	}
+	return fmt.Sprintf("%s 1 rclone rclone %12d %s %s", mode, info.Size(), modTime.Format(format), info.Name())
+}
+
+// serve reads commands from the control connection until QUIT
+func (s *session) serve() {
+	s.srv.connected(1)
+	defer func() {
+		s.closePasv()
+		_ = s.conn.Close()
+		s.srv.connected(-1)
+	}()
+	s.reply(220, "rclone test FTP server ready")
+	for {
+		line, err := s.conn.ReadLine()
+		if err != nil {
+			return
+		}
+		command, arg := line, ""
+		if i := strings.IndexByte(line, ' '); i >= 0 {
+			command, arg = line[:i], line[i+1:]
+		}
+		command = strings.ToUpper(command)
+		if !s.loggedIn && command != "USER" && command != "PASS" && command != "QUIT" {
+			s.reply(530, "Not logged in")
+			continue
+		}
+		switch command {
+		case "USER":
+			s.user = arg
+			s.reply(331, "Password required")
+		case "PASS":
+			if s.user != testUser || arg != testPass {
+				s.reply(530, "Login incorrect")
+				continue
+			}
+			s.loggedIn = true
+			s.reply(230, "Logged in")
+		case "FEAT":
+			if s.srv.mlsd {
+				_ = s.conn.PrintfLine("211-Features:\r\n MLST type*;size*;modify*;\r\n UTF8\r\n211 End")
+			} else {
+				_ = s.conn.PrintfLine("211-Features:\r\n UTF8\r\n211 End")
+			}
+		case "OPTS", "TYPE", "NOOP":
+			s.reply(200, "OK")
+		case "QUIT":
+			s.reply(221, "Goodbye")
+			return
+		case "EPSV":
+			s.closePasv()
+			s.pasv, err = net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				s.reply(425, "Can't open passive connection: %v", err)
+				continue
+			}
+			s.reply(229, "Entering Extended Passive Mode (|||%d|)", s.pasv.Addr().(*net.TCPAddr).Port)
+		case "MLSD", "LIST":
+			if command == "MLSD" && !s.srv.mlsd {
+				s.closePasv()
+				s.reply(502, "Command not implemented")
+				continue
+			}
+			infos, err := ioutil.ReadDir(s.localPath(arg))
+			if err != nil {
+				s.closePasv()
+				s.reply(550, "Can't list: %v", err)
+				continue
+			}
+			s.transfer(func(conn net.Conn) error {
+				w := bufio.NewWriter(conn)
+				for _, info := range infos {
+					if command == "LIST" {
+						_, _ = fmt.Fprintf(w, "%s\r\n", listLine(info))
+						continue
+					}
+					modify := info.ModTime().UTC().Format("20060102150405")
+					if info.IsDir() {
+						_, _ = fmt.Fprintf(w, "type=dir;modify=%s; %s\r\n", modify, info.Name())
+					} else {
+						_, _ = fmt.Fprintf(w, "type=file;size=%d;modify=%s; %s\r\n", info.Size(), modify, info.Name())
+					}
+				}
+				return w.Flush()
+			})
+		case "RETR":
+			in, err := os.Open(s.localPath(arg))
+			if err != nil {
+				s.closePasv()
+				s.reply(550, "Can't open: %v", err)
+				continue
+			}
+			s.transfer(func(conn net.Conn) error {
+				_, err := io.Copy(conn, in)
+				return err
+			})
+			_ = in.Close()
+		case "STOR":
+			out, err := os.Create(s.localPath(arg))
+			if err != nil {
+				s.closePasv()
+				s.reply(550, "Can't create: %v", err)
+				continue
+			}
+			s.transfer(func(conn net.Conn) error {
+				_, err := io.Copy(out, conn)
+				return err
+			})
+			_ = out.Close()
+		case "DELE":
+			p := s.localPath(arg)
+			info, err := os.Stat(p)
+			if err == nil && info.IsDir() {
+				err = fmt.Errorf("is a directory")
+			}
+			if err == nil {
+				err = os.Remove(p)
+			}
+			if err != nil {
+				s.reply(550, "Can't delete: %v", err)
+				continue
+			}
+			s.reply(250, "Deleted")
+		case "MKD":
+			err := os.Mkdir(s.localPath(arg), 0777)
+			if err != nil {
+				s.reply(550, "Can't make directory: %v", err)
+				continue
+			}
+			s.reply(257, "%q created", arg)
+		case "RMD":
+			p := s.localPath(arg)
+			info, err := os.Stat(p)
+			if err == nil && !info.IsDir() {
+				err = fmt.Errorf("not a directory")
+			}
+			if err == nil {
+				err = os.Remove(p)
+			}
+			if err != nil {
+				s.reply(550, "Can't remove directory: %v", err)
+				continue
+			}
+			s.reply(250, "Removed")
+		case "RNFR":
+			_, err := os.Stat(s.localPath(arg))
+			if err != nil {
+				s.reply(550, "Can't rename: %v", err)
+				continue
+			}
+			s.renameFrom = arg
+			s.reply(350, "Ready for RNTO")
+		case "RNTO":
+			if s.renameFrom == "" {
+				s.reply(503, "RNFR required first")
+				continue
+			}
+			err := os.Rename(s.localPath(s.renameFrom), s.localPath(arg))
+			s.renameFrom = ""
+			if err != nil {
+				s.reply(550, "Can't rename: %v", err)
+				continue
+			}
+			s.reply(250, "Renamed")
+		default:
+			s.reply(502, "Command not implemented")
+		}
+	}
+}
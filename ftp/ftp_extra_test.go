@@ -0,0 +1,104 @@
+package ftp_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeFile writes a file into dir with the modification time given
+func writeFile(t *testing.T, dir, name, contents string, modTime time.Time) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	require.NoError(t, os.MkdirAll(filepath.Dir(p), 0777))
+	require.NoError(t, ioutil.WriteFile(p, []byte(contents), 0666))
+	require.NoError(t, os.Chtimes(p, modTime, modTime))
+}
+
+// newTestServer starts a server serving a new temporary directory
+// and returns it, the directory and a function to remove it
+func newTestServer(t *testing.T, mlsd bool) (*server, string, func()) {
+	dir, err := ioutil.TempDir("", "rclone-ftp-extra-test")
+	require.NoError(t, err)
+	srv, err := startServer(dir, mlsd)
+	require.NoError(t, err)
+	return srv, dir, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+// newRemote makes a remote called name using srv
+func newRemote(t *testing.T, name string, srv *server) fs.Fs {
+	for _, item := range serverConfig(name, srv) {
+		fs.ConfigFile.SetValue(item.Name, item.Key, item.Value)
+	}
+	f, err := fs.NewFs(name + ":")
+	require.NoError(t, err)
+	return f
+}
+
+// Test listing a server which only supports LIST
+func TestListLIST(t *testing.T) {
+	fs.LoadConfig()
+	srv, dir, cleanup := newTestServer(t, false)
+	defer cleanup()
+	old := time.Date(2016, 3, 4, 5, 6, 7, 0, time.UTC)
+	recent := time.Now().Add(-time.Hour).Truncate(time.Minute)
+	writeFile(t, dir, "old file", "hello", old)
+	writeFile(t, dir, "recent", "potato", recent)
+	writeFile(t, dir, "sub/deep", "deeper", recent)
+
+	f := newRemote(t, "TestFTPList", srv)
+	objects := map[string]fs.Object{}
+	for o := range f.List() {
+		objects[o.Remote()] = o
+	}
+	require.Len(t, objects, 3)
+
+	o := objects["old file"]
+	require.NotNil(t, o)
+	assert.Equal(t, int64(5), o.Size())
+	// Only the day is listed for old files
+	assert.Equal(t, time.Date(2016, 3, 4, 0, 0, 0, 0, time.UTC), o.ModTime().UTC())
+
+	o = objects["recent"]
+	require.NotNil(t, o)
+	assert.Equal(t, int64(6), o.Size())
+	assert.Equal(t, recent.UTC(), o.ModTime().UTC())
+
+	o = objects["sub/deep"]
+	require.NotNil(t, o)
+	assert.Equal(t, int64(6), o.Size())
+}
+
+// Test the number of connections open at once is limited
+func TestMaxConnections(t *testing.T) {
+	fs.LoadConfig()
+	oldCheckers, oldTransfers := fs.Config.Checkers, fs.Config.Transfers
+	defer func() {
+		fs.Config.Checkers, fs.Config.Transfers = oldCheckers, oldTransfers
+	}()
+	fs.Config.Checkers, fs.Config.Transfers = 2, 1
+
+	srv, dir, cleanup := newTestServer(t, true)
+	defer cleanup()
+	writeFile(t, dir, "file", "hello", time.Now())
+	f := newRemote(t, "TestFTPConnections", srv)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NotNil(t, f.NewFsObject("file"))
+		}()
+	}
+	wg.Wait()
+	assert.True(t, srv.maxConnections() <= 3, "%d connections open at once", srv.maxConnections())
+}
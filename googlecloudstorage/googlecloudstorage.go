@@ -15,6 +15,7 @@ FIXME Patch/Delete/Get isn't working with files with spaces in - giving 404 erro
 import (
 	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -24,6 +25,7 @@ import (
 	"strings"
 	"time"
 
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
@@ -266,38 +268,58 @@ func (f *Fs) NewFsObject(remote string) fs.Object {
 //
 // If directories is set it only sends directories
 func (f *Fs) list(directories bool, fn func(string, *storage.Object)) {
-	list := f.svc.Objects.List(f.bucket).Prefix(f.root).MaxResults(listChunks)
+	var err error
+	ctx := context.Background()
 	if directories {
+		var object storage.Object
+		err = f.listPrefix(ctx, f.root, true, nil, func(dir string) {
+			fn(f.root+dir, &object)
+		})
+	} else {
+		err = f.listPrefix(ctx, f.root, false, fn, nil)
+	}
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Couldn't read bucket %q: %s", f.bucket, err)
+	}
+}
+
+// listPrefix lists the objects whose names start with prefix into fn,
+// if it isn't nil.
+//
+// If delimited is set then only the objects directly under prefix
+// are listed and the directories under it are sent to fnDir.
+func (f *Fs) listPrefix(ctx context.Context, prefix string, delimited bool, fn func(string, *storage.Object), fnDir func(string)) error {
+	list := f.svc.Objects.List(f.bucket).Prefix(prefix).MaxResults(listChunks)
+	if delimited {
 		list = list.Delimiter("/")
 	}
 	rootLength := len(f.root)
 	for {
-		objects, err := list.Do()
+		objects, err := list.Context(ctx).Do()
 		if err != nil {
-			fs.Stats.Error()
-			fs.ErrorLog(f, "Couldn't read bucket %q: %s", f.bucket, err)
-			return
+			return err
 		}
-		if !directories {
+		if fn != nil {
 			for _, object := range objects.Items {
-				if !strings.HasPrefix(object.Name, f.root) {
+				if !strings.HasPrefix(object.Name, prefix) {
 					fs.Log(f, "Odd name received %q", object.Name)
 					continue
 				}
 				remote := object.Name[rootLength:]
 				fn(remote, object)
 			}
-		} else {
-			var object storage.Object
-			for _, prefix := range objects.Prefixes {
-				if !strings.HasSuffix(prefix, "/") {
+		}
+		if delimited {
+			for _, dir := range objects.Prefixes {
+				if !strings.HasSuffix(dir, "/") || !strings.HasPrefix(dir, prefix) {
 					continue
 				}
-				fn(prefix[:len(prefix)-1], &object)
+				fnDir(dir[rootLength : len(dir)-1])
 			}
 		}
 		if objects.NextPageToken == "" {
-			break
+			return nil
 		}
 		list.PageToken(objects.NextPageToken)
 	}
@@ -325,6 +347,33 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// using a delimited listing of its prefix
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	if f.bucket == "" {
+		return nil, nil, errors.New("can't list objects at root - choose a bucket using lsd")
+	}
+	prefix := f.root
+	if dir != "" {
+		prefix += dir + "/"
+	}
+	err = f.listPrefix(ctx, prefix, true, func(remote string, object *storage.Object) {
+		if o := f.newFsObjectWithInfo(remote, object); o != nil {
+			objects = append(objects, o)
+		}
+	}, func(remote string) {
+		dirs = append(dirs, &fs.Dir{
+			Name:  remote,
+			Bytes: -1,
+			Count: -1,
+		})
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read bucket %q: %v", f.bucket, err)
+	}
+	return objects, dirs, nil
+}
+
 // ListDir lists the buckets
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -589,6 +638,17 @@ func (o *Object) Storable() bool {
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
+	return o.open("")
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	return o.open(fs.RangeHeader(offset, length))
+}
+
+// open the object for read, reading only byteRange if it isn't empty
+func (o *Object) open(byteRange string) (in io.ReadCloser, err error) {
 	// This is slightly complicated by Go here insisting on
 	// decoding the %2F in URLs into / which is legal in http, but
 	// unfortunately not what the storage server wants.
@@ -604,11 +664,16 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	// alter any hex-escaped characters
 	googleapi.SetOpaque(req.URL)
 	req.Header.Set("User-Agent", fs.UserAgent)
+	wantStatus := 200
+	if byteRange != "" {
+		req.Header.Set("Range", byteRange)
+		wantStatus = 206
+	}
 	res, err := o.fs.client.Do(req)
 	if err != nil {
 		return nil, err
 	}
-	if res.StatusCode != 200 {
+	if res.StatusCode != wantStatus {
 		_ = res.Body.Close() // ignore error
 		return nil, fmt.Errorf("Bad response: %d: %s", res.StatusCode, res.Status)
 	}
@@ -646,7 +711,9 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = &Fs{}
-	_ fs.Copier = &Fs{}
-	_ fs.Object = &Object{}
+	_ fs.Fs          = &Fs{}
+	_ fs.Copier      = &Fs{}
+	_ fs.DirLister   = &Fs{}
+	_ fs.Object      = &Object{}
+	_ fs.RangeOpener = &Object{}
 )
@@ -0,0 +1,433 @@
+// Package http provides a read only filesystem interface to web
+// servers which serve directory listings as HTML index pages, eg
+// nginx or Apache with autoindex turned on.
+package http
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/html"
+)
+
+var (
+	errorReadOnly = errors.New("http remote is read only")
+)
+
+// Register with Fs
+func init() {
+	fsi := &fs.RegInfo{
+		Name:        "http",
+		Description: "HTTP (read only directory listings)",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "url",
+			Help: "URL of the directory listing to connect to",
+			Examples: []fs.OptionExample{{
+				Value: "https://example.com/pub/",
+				Help:  "Connect to example.com",
+			}},
+		}},
+	}
+	fs.Register(fsi)
+}
+
+// Fs stores the interface to the remote HTTP files
+type Fs struct {
+	name     string
+	root     string
+	endpoint *url.URL     // URL of the root directory, ending in /
+	client   *http.Client // client to make requests with
+}
+
+// Object is a remote object that has been stat'd (so it exists, but
+// is not necessarily open for reading)
+type Object struct {
+	fs      *Fs
+	remote  string
+	size    int64
+	modTime time.Time
+}
+
+// ------------------------------------------------------------
+
+// NewFs creates a new Fs object from the name and root. It connects to
+// the URL specified in the config file.
+func NewFs(name, root string) (fs.Fs, error) {
+	endpoint := fs.ConfigFile.MustValue(name, "url")
+	if endpoint == "" {
+		return nil, fmt.Errorf("url not set in config file")
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url %q: %v", endpoint, err)
+	}
+	if base.Scheme != "http" && base.Scheme != "https" {
+		return nil, fmt.Errorf("url %q must start with http:// or https://", endpoint)
+	}
+	root = strings.Trim(root, "/")
+	f := &Fs{
+		name:   name,
+		root:   root,
+		client: fs.Config.Client(),
+	}
+	if root != "" {
+		// Check to see if the root points to a file
+		f.endpoint = base
+		obj := f.NewFsObject(root)
+		if obj != nil {
+			// It is a file, so use the parent as the root
+			var remote string
+			f.root, remote = path.Split(root)
+			f.root = strings.TrimSuffix(f.root, "/")
+			f.endpoint = base.ResolveReference(&url.URL{Path: f.root + "/"})
+			obj.(*Object).remote = remote
+			// return a Fs Limited to this object
+			return fs.NewLimited(f, obj), nil
+		}
+		base = base.ResolveReference(&url.URL{Path: root + "/"})
+	}
+	f.endpoint = base
+	return f, nil
+}
+
+// Name returns the configured name of the file system
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root returns the root for the filesystem
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String returns the URL for the filesystem
+func (f *Fs) String() string {
+	return f.endpoint.String()
+}
+
+// url returns the URL of remote, adding a trailing / if it is a
+// directory
+func (f *Fs) url(remote string, isDir bool) *url.URL {
+	if isDir && remote != "" {
+		remote += "/"
+	}
+	return f.endpoint.ResolveReference(&url.URL{Path: remote})
+}
+
+// NewFsObject finds the Object at remote.  Returns nil if can't be found
+func (f *Fs) NewFsObject(remote string) fs.Object {
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	err := o.stat()
+	if err != nil {
+		fs.Debug(o, "Failed to stat: %v", err)
+		return nil
+	}
+	return o
+}
+
+// parseIndex parses the HTML index page in body which was read from
+// base returning the names of the entries found in it.  Directories
+// have a trailing /.
+//
+// Only links to the direct children of base are returned which
+// ignores links to parent directories, sort order links and links to
+// other sites.
+func parseIndex(base *url.URL, body io.Reader) (names []string, err error) {
+	doc, err := html.Parse(body)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode && n.Data == "a" {
+			for _, a := range n.Attr {
+				if a.Key != "href" {
+					continue
+				}
+				name, ok := parseLink(base, a.Val)
+				if ok && !seen[name] {
+					seen[name] = true
+					names = append(names, name)
+				}
+				break
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return names, nil
+}
+
+// parseLink returns the name of the entry href points to if it is a
+// direct child of base
+func parseLink(base *url.URL, href string) (name string, ok bool) {
+	u, err := url.Parse(href)
+	if err != nil || u.RawQuery != "" {
+		return "", false
+	}
+	u = base.ResolveReference(u)
+	if u.Scheme != base.Scheme || u.Host != base.Host || !strings.HasPrefix(u.Path, base.Path) {
+		return "", false
+	}
+	name = u.Path[len(base.Path):]
+	leaf := strings.TrimSuffix(name, "/")
+	if leaf == "" || leaf == "." || leaf == ".." || strings.Contains(leaf, "/") {
+		return "", false
+	}
+	return name, true
+}
+
+// readDir reads the index page of dir returning the names in it
+func (f *Fs) readDir(dir string) (names []string, err error) {
+	u := f.url(dir, true)
+	resp, err := f.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer fs.CheckClose(resp.Body, &err)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to read directory: %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" && !strings.HasPrefix(contentType, "text/html") {
+		return nil, fmt.Errorf("can't parse directory listing with Content-Type %q", contentType)
+	}
+	// Parse relative to where we ended up in case of redirects
+	return parseIndex(resp.Request.URL, resp.Body)
+}
+
+// list the objects in dir recursively into out
+//
+// The objects in each directory are stat'd in parallel
+func (f *Fs) list(out fs.ObjectsChan, dir string) {
+	names, err := f.readDir(dir)
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Couldn't list directory %q: %v", dir, err)
+		return
+	}
+	var (
+		wg     sync.WaitGroup
+		tokens = make(chan struct{}, fs.Config.Checkers)
+		dirs   []string
+	)
+	for _, name := range names {
+		remote := path.Join(dir, name)
+		if strings.HasSuffix(name, "/") {
+			dirs = append(dirs, remote)
+			continue
+		}
+		wg.Add(1)
+		tokens <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-tokens }()
+			o := &Object{
+				fs:     f,
+				remote: remote,
+			}
+			err := o.stat()
+			if err != nil {
+				fs.Stats.Error()
+				fs.ErrorLog(o, "Couldn't read info: %v", err)
+				return
+			}
+			out <- o
+		}()
+	}
+	wg.Wait()
+	for _, dir := range dirs {
+		f.list(out, dir)
+	}
+}
+
+// List the files and directories starting at <f.root>
+func (f *Fs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		f.list(out, "")
+	}()
+	return out
+}
+
+// ListDir lists the directories
+func (f *Fs) ListDir() fs.DirChan {
+	out := make(fs.DirChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		names, err := f.readDir("")
+		if err != nil {
+			fs.Stats.Error()
+			fs.ErrorLog(f, "Couldn't list directories: %v", err)
+			return
+		}
+		for _, name := range names {
+			if strings.HasSuffix(name, "/") {
+				out <- &fs.Dir{
+					Name:  strings.TrimSuffix(name, "/"),
+					Bytes: -1,
+					Count: -1,
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Put in to the remote path with the modTime given of the given size
+//
+// The http remote is read only so this always returns an error
+func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return nil, errorReadOnly
+}
+
+// Mkdir makes the root directory of the Fs object
+//
+// The http remote is read only so this always returns an error
+func (f *Fs) Mkdir() error {
+	return errorReadOnly
+}
+
+// Rmdir removes the root directory of the Fs object
+//
+// The http remote is read only so this always returns an error
+func (f *Fs) Rmdir() error {
+	return errorReadOnly
+}
+
+// Precision is the precision of the Last-Modified header
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() fs.HashSet {
+	return fs.HashSet(fs.HashNone)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash is not supported by the http remote
+func (o *Object) Hash(r fs.HashType) (string, error) {
+	return "", fs.ErrHashUnsupported
+}
+
+// Size returns the size in bytes of the remote http file
+func (o *Object) Size() int64 {
+	return o.size
+}
+
+// ModTime returns the modification time of the remote http file
+func (o *Object) ModTime() time.Time {
+	return o.modTime
+}
+
+// stat updates the info in the Object with a HEAD request
+func (o *Object) stat() error {
+	u := o.fs.url(o.remote, false)
+	resp, err := o.fs.client.Head(u.String())
+	if err != nil {
+		return err
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("HEAD failed: %s", resp.Status)
+	}
+	// Servers redirect directories to the URL with a trailing /
+	if strings.HasSuffix(resp.Request.URL.Path, "/") {
+		return fmt.Errorf("is a directory")
+	}
+	o.size = resp.ContentLength
+	o.modTime, err = http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		fs.Debug(o, "Couldn't parse Last-Modified: %v", err)
+		o.modTime = time.Now()
+	}
+	return nil
+}
+
+// SetModTime sets the modification time of the remote http file
+//
+// The http remote is read only so this just logs a message
+func (o *Object) SetModTime(modTime time.Time) {
+	fs.Debug(o, "Can't set modification time: %v", errorReadOnly)
+}
+
+// Storable returns whether this object is storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open a remote http file object for reading
+func (o *Object) Open() (in io.ReadCloser, err error) {
+	u := o.fs.url(o.remote, false)
+	resp, err := o.fs.client.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("open failed: %s", resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Update in to the object with the modTime given of the given size
+//
+// The http remote is read only so this always returns an error
+func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	return errorReadOnly
+}
+
+// Remove an object
+//
+// The http remote is read only so this always returns an error
+func (o *Object) Remove() error {
+	return errorReadOnly
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs     = &Fs{}
+	_ fs.Object = &Object{}
+)
@@ -0,0 +1,168 @@
+package http
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const remoteName = "TestHTTP"
+
+var (
+	testDir    string
+	testServer *httptest.Server
+	testTime   = time.Date(2016, 11, 14, 10, 20, 30, 0, time.UTC)
+)
+
+// TestMain serves a temporary directory over HTTP and makes a remote
+// pointing at it
+func TestMain(m *testing.M) {
+	var err error
+	testDir, err = ioutil.TempDir("", "rclone-http-test")
+	if err != nil {
+		panic(err)
+	}
+	for _, file := range []struct {
+		path    string
+		content string
+	}{
+		{"one.txt", "one"},
+		{"two.html", "<a href=\"one.txt\">two</a>"},
+		{"three/four.txt", "four"},
+		{"three/five one.txt", "five"},
+		{"three/six/seven.txt", "seven"},
+	} {
+		filePath := filepath.Join(testDir, filepath.FromSlash(file.path))
+		must(os.MkdirAll(filepath.Dir(filePath), 0777))
+		must(ioutil.WriteFile(filePath, []byte(file.content), 0666))
+		must(os.Chtimes(filePath, testTime, testTime))
+	}
+	testServer = httptest.NewServer(http.FileServer(http.Dir(testDir)))
+
+	fs.LoadConfig()
+	fs.ConfigFile.SetValue(remoteName, "type", "http")
+	fs.ConfigFile.SetValue(remoteName, "url", testServer.URL)
+
+	rc := m.Run()
+
+	testServer.Close()
+	fs.ConfigFile.DeleteSection(remoteName)
+	_ = os.RemoveAll(testDir)
+	os.Exit(rc)
+}
+
+func must(err error) {
+	if err != nil {
+		panic(err)
+	}
+}
+
+// newFs makes an Fs for root
+func newFs(t *testing.T, root string) fs.Fs {
+	f, err := NewFs(remoteName, root)
+	require.NoError(t, err)
+	return f
+}
+
+// listNames lists f returning the sorted remotes
+func listNames(f fs.Fs) (names []string) {
+	for o := range f.List() {
+		names = append(names, o.Remote())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestParseIndex(t *testing.T) {
+	base, err := url.Parse("http://example.com/pub/dir/")
+	require.NoError(t, err)
+	// Links as generated by Apache and nginx autoindex
+	page := `<html><body><h1>Index of /pub/dir</h1>
+<a href="?C=N;O=D">Name</a> <a href="?C=M;O=A">Last modified</a>
+<a href="/pub/">Parent Directory</a>
+<a href="../">../</a>
+<a href="./">./</a>
+<a href="sub/">sub/</a>
+<a href="file.txt">file.txt</a>
+<a href="file%20space.txt">file space.txt</a>
+<a href="/pub/dir/absolute.txt">absolute.txt</a>
+<a href="http://example.com/pub/dir/full.txt">full.txt</a>
+<a href="http://other.com/pub/dir/other.txt">other.txt</a>
+<a href="sub/deep.txt">deep.txt</a>
+<a href="file.txt">file.txt again</a>
+<a name="anchor">no href</a>
+</body></html>`
+	names, err := parseIndex(base, strings.NewReader(page))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"sub/", "file.txt", "file space.txt", "absolute.txt", "full.txt"}, names)
+}
+
+func TestList(t *testing.T) {
+	f := newFs(t, "")
+	assert.Equal(t, []string{"one.txt", "three/five one.txt", "three/four.txt", "three/six/seven.txt", "two.html"}, listNames(f))
+
+	f = newFs(t, "three")
+	assert.Equal(t, []string{"five one.txt", "four.txt", "six/seven.txt"}, listNames(f))
+}
+
+func TestListDir(t *testing.T) {
+	f := newFs(t, "")
+	var dirs []string
+	for dir := range f.ListDir() {
+		dirs = append(dirs, dir.Name)
+	}
+	assert.Equal(t, []string{"three"}, dirs)
+}
+
+func TestNewFsObject(t *testing.T) {
+	f := newFs(t, "")
+	o := f.NewFsObject("three/five one.txt")
+	require.NotNil(t, o)
+	assert.Equal(t, "three/five one.txt", o.Remote())
+	assert.Equal(t, int64(4), o.Size())
+	assert.True(t, o.ModTime().Equal(testTime), o.ModTime())
+	_, err := o.Hash(fs.HashMD5)
+	assert.Equal(t, fs.ErrHashUnsupported, err)
+
+	in, err := o.Open()
+	require.NoError(t, err)
+	data, err := ioutil.ReadAll(in)
+	require.NoError(t, err)
+	require.NoError(t, in.Close())
+	assert.Equal(t, "five", string(data))
+
+	assert.Nil(t, f.NewFsObject("notfound.txt"))
+	assert.Nil(t, f.NewFsObject("three"))
+}
+
+func TestNewFsFile(t *testing.T) {
+	f := newFs(t, "three/four.txt")
+	_, ok := f.(*fs.Limited)
+	require.True(t, ok)
+	assert.Equal(t, "three", f.Root())
+	assert.Equal(t, []string{"four.txt"}, listNames(f))
+}
+
+func TestReadOnly(t *testing.T) {
+	f := newFs(t, "")
+	assert.Equal(t, errorReadOnly, f.Mkdir())
+	assert.Equal(t, errorReadOnly, f.Rmdir())
+	src := fs.NewStaticObjectInfo("new.txt", testTime, 3, true, nil, nil)
+	_, err := f.Put(strings.NewReader("new"), src)
+	assert.Equal(t, errorReadOnly, err)
+	o := f.NewFsObject("one.txt")
+	require.NotNil(t, o)
+	assert.Equal(t, errorReadOnly, o.Update(strings.NewReader("new"), src))
+	assert.Equal(t, errorReadOnly, o.Remove())
+}
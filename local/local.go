@@ -6,6 +6,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
@@ -15,6 +16,7 @@ import (
 	"unicode/utf8"
 
 	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
 )
 
 // Register with Fs
@@ -92,6 +94,11 @@ func (f *Fs) Root() string {
 	return f.root
 }
 
+// LocalDisk returns true as the objects are on a local disk
+func (f *Fs) LocalDisk() bool {
+	return true
+}
+
 // String converts this Fs to a string
 func (f *Fs) String() string {
 	return fmt.Sprintf("Local file system at %s", f.root)
@@ -230,13 +237,44 @@ func (f *Fs) ListDir() fs.DirChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+//
+// Ignores everything which isn't Storable, eg links etc
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	dirpath := f.dirPath(dir)
+	items, err := ioutil.ReadDir(dirpath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read directory %q: %v", dirpath, err)
+	}
+	for _, item := range items {
+		remote := path.Join(dir, item.Name())
+		if item.IsDir() {
+			dirs = append(dirs, &fs.Dir{
+				Name:  remote,
+				When:  item.ModTime(),
+				Bytes: -1,
+				Count: -1,
+			})
+		} else if o := f.newFsObjectWithInfo(remote, item); o != nil && o.Storable() {
+			objects = append(objects, o)
+		}
+	}
+	return objects, dirs, nil
+}
+
 // Put the FsObject to the local filesystem
+//
+// If the copy fails part way through then the partially written file
+// is returned with the error so it can be removed.
 func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	remote := src.Remote()
 	// Temporary FsObject under construction - info filled in by Update()
 	o := f.newFsObject(remote)
 	err := o.Update(in, src)
 	if err != nil {
+		if _, statErr := os.Lstat(o.path); statErr == nil {
+			return o, err
+		}
 		return nil, err
 	}
 	return o, nil
@@ -255,6 +293,28 @@ func (f *Fs) Rmdir() error {
 	return os.Remove(f.root)
 }
 
+// dirPath returns the local path of the directory dir
+func (f *Fs) dirPath(dir string) string {
+	return f.filterPath(filepath.Join(f.root, dir))
+}
+
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	return os.MkdirAll(f.dirPath(dir), 0777)
+}
+
+// RmdirPath removes the directory dir
+//
+// If it isn't empty it will return an error
+func (f *Fs) RmdirPath(dir string) error {
+	return os.Remove(f.dirPath(dir))
+}
+
+// SetDirModTime sets the modification time of the directory dir
+func (f *Fs) SetDirModTime(dir string, modTime time.Time) error {
+	return os.Chtimes(f.dirPath(dir), modTime, modTime)
+}
+
 // Precision of the file system
 func (f *Fs) Precision() (precision time.Duration) {
 	f.precisionOk.Do(func() {
@@ -522,9 +582,10 @@ func (o *Object) Storable() bool {
 // localOpenFile wraps an io.ReadCloser and updates the md5sum of the
 // object that is read
 type localOpenFile struct {
-	o    *Object         // object that is open
-	in   io.ReadCloser   // handle we are wrapping
-	hash *fs.MultiHasher // currently accumulating hashes
+	o     *Object         // object that is open
+	in    io.ReadCloser   // handle we are wrapping
+	hash  *fs.MultiHasher // currently accumulating hashes
+	bytes int64           // bytes read so far
 }
 
 // Read bytes from the object - see io.Reader
@@ -533,14 +594,15 @@ func (file *localOpenFile) Read(p []byte) (n int, err error) {
 	if n > 0 {
 		// Hash routines never return an error
 		_, _ = file.hash.Write(p[:n])
+		file.bytes += int64(n)
 	}
 	return
 }
 
-// Close the object and update the md5sum
+// Close the object and update the md5sum if all of it was read
 func (file *localOpenFile) Close() (err error) {
 	err = file.in.Close()
-	if err == nil {
+	if err == nil && file.bytes == file.o.Size() {
 		file.o.hashes = file.hash.Sums()
 	} else {
 		file.o.hashes = nil
@@ -563,6 +625,27 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	return
 }
 
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (io.ReadCloser, error) {
+	fd, err := os.Open(o.path)
+	if err != nil {
+		return nil, err
+	}
+	_, err = fd.Seek(offset, 0)
+	if err != nil {
+		_ = fd.Close() // ignore error
+		return nil, err
+	}
+	if length < 0 {
+		return fd, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(fd, length), fd}, nil
+}
+
 // mkdirAll makes all the directories needed to store the object
 func (o *Object) mkdirAll() error {
 	dir, _ := getDirFile(o.path)
@@ -716,9 +799,14 @@ func cleanWindowsName(f *Fs, name string) string {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs       = &Fs{}
-	_ fs.Purger   = &Fs{}
-	_ fs.Mover    = &Fs{}
-	_ fs.DirMover = &Fs{}
-	_ fs.Object   = &Object{}
+	_ fs.Fs               = &Fs{}
+	_ fs.Purger           = &Fs{}
+	_ fs.Mover            = &Fs{}
+	_ fs.DirMover         = &Fs{}
+	_ fs.DirLister        = &Fs{}
+	_ fs.DirMaker         = &Fs{}
+	_ fs.DirModTimeSetter = &Fs{}
+	_ fs.LocalDisk        = &Fs{}
+	_ fs.Object           = &Object{}
+	_ fs.RangeOpener      = &Object{}
 )
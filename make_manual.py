@@ -18,17 +18,23 @@ docs = [
     "docs.md",
     "remote_setup.md",
     "filtering.md",
+    "rc.md",
     "overview.md",
     "drive.md",
     "s3.md",
     "swift.md",
     "dropbox.md",
+    "ftp.md",
     "googlecloudstorage.md",
     "amazonclouddrive.md",
     "onedrive.md",
     "hubic.md",
     "b2.md",
     "yandex.md",
+    "sftp.md",
+    "http.md",
+    "webdav.md",
+    "crypt.md",
     "local.md",
     "changelog.md",
     "bugs.md",
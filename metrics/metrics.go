@@ -0,0 +1,155 @@
+// Package metrics serves the transfer stats and the pacer stats over
+// HTTP in the Prometheus text format so that they can be scraped.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/pacer"
+	"github.com/spf13/pflag"
+)
+
+// Globals
+var (
+	// Flags
+	addr = pflag.StringP("metrics-addr", "", "", "Address to serve Prometheus metrics on, eg localhost:9250 (off if empty).")
+)
+
+// contentType is the Prometheus text exposition format
+const contentType = "text/plain; version=0.0.4"
+
+// Server serves the metrics on /metrics
+type Server struct {
+	mux *http.ServeMux
+}
+
+// NewServer makes a new metrics Server
+func NewServer() *Server {
+	s := &Server{
+		mux: http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/metrics", s.metrics)
+	return s
+}
+
+// Start serving the metrics on --metrics-addr in the background if it
+// is set
+func Start() {
+	if *addr == "" {
+		return
+	}
+	fs.Log(nil, "Serving metrics on http://%s/metrics", *addr)
+	go func() {
+		err := http.ListenAndServe(*addr, NewServer())
+		fs.ErrorLog(nil, "Failed to serve metrics: %v", err)
+	}()
+}
+
+// ServeHTTP serves the metrics
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// metrics writes the current metrics
+func (s *Server) metrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		http.Error(w, fmt.Sprintf("method %q not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+	out := new(writer)
+	out.stats(fs.Stats.Snapshot())
+	out.pacers(pacer.Stats())
+	w.Header().Set("Content-Type", contentType)
+	if _, err := w.Write(out.Bytes()); err != nil {
+		fs.ErrorLog(nil, "metrics: failed to write output: %v", err)
+	}
+}
+
+// writer builds up the metrics in the text format
+type writer struct {
+	bytes.Buffer
+}
+
+// metric writes the HELP and TYPE lines for a metric
+func (out *writer) metric(name, kind, help string) {
+	fmt.Fprintf(out, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(out, "# TYPE %s %s\n", name, kind)
+}
+
+// labelEscaper escapes label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// value writes a sample of the metric with the label set to
+// labelValue if label isn't empty
+func (out *writer) value(name, label, labelValue string, value float64) {
+	out.WriteString(name)
+	if label != "" {
+		fmt.Fprintf(out, `{%s="%s"}`, label, labelEscaper.Replace(labelValue))
+	}
+	fmt.Fprintf(out, " %s\n", strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+// single writes a metric with a single unlabelled sample
+func (out *writer) single(name, kind, help string, value float64) {
+	out.metric(name, kind, help)
+	out.value(name, "", "", value)
+}
+
+// stats writes the metrics for the transfer stats
+func (out *writer) stats(stats fs.StatsSnapshot) {
+	out.single("rclone_bytes_transferred_total", "counter", "Bytes transferred.", float64(stats.Bytes))
+	out.single("rclone_errors_total", "counter", "Errors.", float64(stats.Errors))
+	out.single("rclone_checks_total", "counter", "Files checked.", float64(stats.Checks))
+	out.single("rclone_transfers_total", "counter", "Files transferred.", float64(stats.Transfers))
+	out.single("rclone_speed_bytes_per_second", "gauge", "Average transfer speed since the start.", stats.Speed)
+	out.single("rclone_elapsed_seconds", "gauge", "Time since the start.", stats.ElapsedTime)
+	out.single("rclone_checks_in_progress", "gauge", "Files being checked.", float64(len(stats.Checking)))
+	out.single("rclone_transfers_in_progress", "gauge", "Files being transferred.", float64(len(stats.Transferring)))
+
+	out.metric("rclone_transfer_bytes", "gauge", "Bytes transferred so far for each file being transferred.")
+	for _, tr := range stats.Transferring {
+		out.value("rclone_transfer_bytes", "name", tr.Name, float64(tr.Bytes))
+	}
+	out.metric("rclone_transfer_size_bytes", "gauge", "Size of each file being transferred if known.")
+	for _, tr := range stats.Transferring {
+		if tr.Size > 0 {
+			out.value("rclone_transfer_size_bytes", "name", tr.Name, float64(tr.Size))
+		}
+	}
+	out.metric("rclone_transfer_speed_bytes_per_second", "gauge", "Average speed of each file being transferred.")
+	for _, tr := range stats.Transferring {
+		out.value("rclone_transfer_speed_bytes_per_second", "name", tr.Name, tr.Speed)
+	}
+	out.metric("rclone_transfer_current_speed_bytes_per_second", "gauge", "Moving average of the speed of each file being transferred.")
+	for _, tr := range stats.Transferring {
+		out.value("rclone_transfer_current_speed_bytes_per_second", "name", tr.Name, tr.SpeedAvg)
+	}
+}
+
+// pacers writes the metrics for the calls made through the pacers of
+// each backend
+func (out *writer) pacers(stats map[string]pacer.CallStats) {
+	backends := make([]string, 0, len(stats))
+	for backend := range stats {
+		backends = append(backends, backend)
+	}
+	sort.Strings(backends)
+	out.metric("rclone_pacer_calls_total", "counter", "API calls made through the pacer.")
+	for _, backend := range backends {
+		out.value("rclone_pacer_calls_total", "backend", backend, float64(stats[backend].Calls))
+	}
+	out.metric("rclone_pacer_retries_total", "counter", "API calls which were rate limited or failed and asked to be retried.")
+	for _, backend := range backends {
+		out.value("rclone_pacer_retries_total", "backend", backend, float64(stats[backend].Retries))
+	}
+	out.metric("rclone_pacer_sleep_seconds_total", "counter", "Time spent waiting for the pacer before API calls.")
+	for _, backend := range backends {
+		out.value("rclone_pacer_sleep_seconds_total", "backend", backend, stats[backend].Sleep.Seconds())
+	}
+}
@@ -0,0 +1,128 @@
+package metrics_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	_ "github.com/ncw/rclone/local"
+	"github.com/ncw/rclone/metrics"
+	"github.com/ncw/rclone/pacer"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	fs.LoadConfig()
+}
+
+// metricsRuns counts the runs of TestMetrics so each uses a new pacer
+// name as the pacer stats are kept for the life of the process
+var metricsRuns int
+
+// get GETs path from the server returning the status, the content
+// type and the body
+func get(t *testing.T, server *httptest.Server, path string) (int, string, string) {
+	resp, err := http.Get(server.URL + "/" + path)
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return resp.StatusCode, resp.Header.Get("Content-Type"), string(body)
+}
+
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(metrics.NewServer())
+	defer server.Close()
+
+	// Start a transfer of a local file
+	dir, err := ioutil.TempDir("", "rclone-metrics-test")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file.txt"), []byte("hello world"), 0666))
+	f, err := fs.NewFs(dir)
+	require.NoError(t, err)
+	o := f.NewFsObject("file.txt")
+	require.NotNil(t, o)
+	in, err := o.Open()
+	require.NoError(t, err)
+	fs.Stats.ResetCounters()
+	fs.Stats.Transferring(o)
+	acc := fs.NewAccount(in, o)
+	buf := make([]byte, 5)
+	_, err = acc.Read(buf)
+	require.NoError(t, err)
+	fs.Stats.Error()
+
+	// Make some calls through a pacer
+	metricsRuns++
+	name := fmt.Sprintf(`test "backend" %d`, metricsRuns)
+	backend := strings.Replace(name, `"`, `\"`, -1)
+	p := pacer.New().SetMinSleep(time.Millisecond).SetRetries(2).SetName(name)
+	_ = p.Call(func() (bool, error) {
+		return true, nil
+	})
+
+	status, contentType, body := get(t, server, "metrics")
+	require.NoError(t, acc.Close())
+	fs.Stats.DoneTransferring(o)
+	fs.Stats.ResetCounters()
+
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "text/plain; version=0.0.4", contentType)
+	lines := strings.Split(body, "\n")
+	for _, want := range []string{
+		"# TYPE rclone_bytes_transferred_total counter",
+		"rclone_bytes_transferred_total 5",
+		"rclone_errors_total 1",
+		"rclone_checks_total 0",
+		"rclone_transfers_in_progress 1",
+		"# TYPE rclone_transfer_speed_bytes_per_second gauge",
+		`rclone_transfer_bytes{name="file.txt"} 5`,
+		`rclone_transfer_size_bytes{name="file.txt"} 11`,
+		`rclone_pacer_calls_total{backend="` + backend + `"} 2`,
+		`rclone_pacer_retries_total{backend="` + backend + `"} 2`,
+	} {
+		assert.Contains(t, lines, want)
+	}
+	for _, prefix := range []string{
+		`rclone_transfer_speed_bytes_per_second{name="file.txt"} `,
+		`rclone_transfer_current_speed_bytes_per_second{name="file.txt"} `,
+		`rclone_pacer_sleep_seconds_total{backend="` + backend + `"} `,
+	} {
+		found := false
+		for _, line := range lines {
+			if strings.HasPrefix(line, prefix) {
+				found = true
+			}
+		}
+		assert.True(t, found, "missing %q", prefix)
+	}
+}
+
+func TestMetricsNotFound(t *testing.T) {
+	server := httptest.NewServer(metrics.NewServer())
+	defer server.Close()
+	status, _, _ := get(t, server, "potato")
+	assert.Equal(t, http.StatusNotFound, status)
+}
+
+func TestMetricsMethodNotAllowed(t *testing.T) {
+	server := httptest.NewServer(metrics.NewServer())
+	defer server.Close()
+	resp, err := http.Post(server.URL+"/metrics", "text/plain", nil)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
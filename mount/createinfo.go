@@ -0,0 +1,59 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"time"
+
+	"github.com/ncw/rclone/fs"
+)
+
+// info to create a new object
+type createInfo struct {
+	f       fs.Fs
+	remote  string
+	modTime time.Time
+}
+
+func newCreateInfo(f fs.Fs, remote string) *createInfo {
+	return &createInfo{
+		f:       f,
+		remote:  remote,
+		modTime: time.Now(),
+	}
+}
+
+// Fs returns read only access to the Fs that this object is part of
+func (ci *createInfo) Fs() fs.Info {
+	return ci.f
+}
+
+// Remote returns the remote path
+func (ci *createInfo) Remote() string {
+	return ci.remote
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (ci *createInfo) Hash(fs.HashType) (string, error) {
+	return "", nil
+}
+
+// ModTime returns the modification date of the file
+func (ci *createInfo) ModTime() time.Time {
+	return ci.modTime
+}
+
+// Size returns the size of the file - this isn't known in advance as
+// the data is streamed from the kernel so -1 is returned
+func (ci *createInfo) Size() int64 {
+	return -1
+}
+
+// Storable says whether this object can be stored
+func (ci *createInfo) Storable() bool {
+	return true
+}
+
+// Check interface satisfied
+var _ fs.ObjectInfo = (*createInfo)(nil)
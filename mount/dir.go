@@ -0,0 +1,283 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// Dir represents a directory entry
+type Dir struct {
+	fsys    *FS
+	path    string
+	modTime time.Time
+}
+
+func newDir(fsys *FS, path string, modTime time.Time) *Dir {
+	return &Dir{
+		fsys:    fsys,
+		path:    path,
+		modTime: modTime,
+	}
+}
+
+// Check interface satsified
+var _ fusefs.Node = (*Dir)(nil)
+
+// Attr updates the attribes of a directory
+func (d *Dir) Attr(ctx context.Context, a *fuse.Attr) error {
+	fs.Debug(d.path, "Dir.Attr")
+	a.Gid = gid
+	a.Uid = uid
+	a.Mode = os.ModeDir | dirPerm
+	modTime := d.modTime
+	if *noModTime || modTime.IsZero() {
+		modTime = d.fsys.mountTime
+	}
+	a.Atime = modTime
+	a.Mtime = modTime
+	a.Ctime = modTime
+	a.Crtime = modTime
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeStringLookuper = (*Dir)(nil)
+
+// Lookup looks up a specific entry in the receiver.
+//
+// Lookup should return a Node corresponding to the entry.  If the
+// name does not exist in the directory, Lookup should return ENOENT.
+//
+// Lookup need not to handle the names "." and "..".
+func (d *Dir) Lookup(ctx context.Context, name string) (fusefs.Node, error) {
+	node, err := d.fsys.lookup(d.path, name)
+	if err != nil {
+		fs.ErrorLog(d.path, "Dir.Lookup %q failed: %v", name, err)
+		return nil, lookupError(err)
+	}
+	if node == nil {
+		fs.Debug(d.path, "Dir.Lookup %q not found", name)
+		return nil, fuse.ENOENT
+	}
+	fs.Debug(d.path, "Dir.Lookup %q OK", name)
+	return node, nil
+}
+
+// Check interface satisfied
+var _ fusefs.HandleReadDirAller = (*Dir)(nil)
+
+// ReadDirAll reads the contents of the directory
+func (d *Dir) ReadDirAll(ctx context.Context) ([]fuse.Dirent, error) {
+	fs.Debug(d.path, "Dir.ReadDirAll")
+	names, isDir, err := d.fsys.list(d.path)
+	if err != nil {
+		fs.ErrorLog(d.path, "Dir.ReadDirAll failed: %v", err)
+		return nil, lookupError(err)
+	}
+	dirents := make([]fuse.Dirent, 0, len(names))
+	for _, name := range names {
+		dirent := fuse.Dirent{
+			Type: fuse.DT_File,
+			Name: name,
+		}
+		if isDir[name] {
+			dirent.Type = fuse.DT_Dir
+		}
+		dirents = append(dirents, dirent)
+	}
+	fs.Debug(d.path, "Dir.ReadDirAll OK with %d entries", len(dirents))
+	return dirents, nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeCreater = (*Dir)(nil)
+
+// Create makes a new file
+func (d *Dir) Create(ctx context.Context, req *fuse.CreateRequest, resp *fuse.CreateResponse) (fusefs.Node, fusefs.Handle, error) {
+	remote := joinPath(d.path, req.Name)
+	fs.Debug(remote, "Dir.Create")
+	file := newFile(d.fsys, remote, nil)
+	fh := newWriteFileHandle(file, nil)
+	fs.Debug(remote, "Dir.Create OK")
+	return file, fh, nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeMkdirer = (*Dir)(nil)
+
+// Mkdir creates a new directory
+func (d *Dir) Mkdir(ctx context.Context, req *fuse.MkdirRequest) (fusefs.Node, error) {
+	dirPath := joinPath(d.path, req.Name)
+	fs.Debug(dirPath, "Dir.Mkdir")
+	f, err := d.fsys.subFs(dirPath)
+	if err == nil {
+		err = f.Mkdir()
+	}
+	if err != nil {
+		fs.ErrorLog(dirPath, "Dir.Mkdir failed to create directory: %v", err)
+		return nil, err
+	}
+	modTime := time.Now()
+	d.fsys.addDir(dirPath, modTime)
+	fs.Debug(dirPath, "Dir.Mkdir OK")
+	return newDir(d.fsys, dirPath, modTime), nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeRemover = (*Dir)(nil)
+
+// Remove removes the entry with the given name from
+// the receiver, which must be a directory.  The entry to be removed
+// may correspond to a file (unlink) or to a directory (rmdir).
+func (d *Dir) Remove(ctx context.Context, req *fuse.RemoveRequest) error {
+	remote := joinPath(d.path, req.Name)
+	fs.Debug(remote, "Dir.Remove")
+	node, err := d.fsys.lookup(d.path, req.Name)
+	if err != nil {
+		fs.ErrorLog(remote, "Dir.Remove failed: %v", err)
+		return lookupError(err)
+	}
+	switch node := node.(type) {
+	case nil:
+		fs.Debug(remote, "Dir.Remove not found")
+		return fuse.ENOENT
+	case *File:
+		if req.Dir {
+			return fuse.Errno(syscall.ENOTDIR)
+		}
+		o := node.object()
+		if o == nil {
+			fs.Debug(remote, "Dir.Remove file is still being written")
+			return fuse.Errno(syscall.EBUSY)
+		}
+		err := o.Remove()
+		if err != nil {
+			fs.ErrorLog(remote, "Dir.Remove file error: %v", err)
+			return err
+		}
+	case *Dir:
+		if !req.Dir {
+			return fuse.Errno(syscall.EISDIR)
+		}
+		names, _, err := d.fsys.list(remote)
+		if err != nil {
+			fs.ErrorLog(remote, "Dir.Remove directory error: %v", err)
+			return lookupError(err)
+		}
+		if len(names) != 0 {
+			fs.Debug(remote, "Dir.Remove directory not empty")
+			return fuse.Errno(syscall.ENOTEMPTY)
+		}
+		f, err := d.fsys.subFs(remote)
+		if err == nil {
+			err = f.Rmdir()
+		}
+		if err != nil {
+			fs.ErrorLog(remote, "Dir.Remove directory error: %v", err)
+			return err
+		}
+	}
+	d.fsys.remove(remote)
+	fs.Debug(remote, "Dir.Remove OK")
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeRenamer = (*Dir)(nil)
+
+// Rename the file
+//
+// If the remote can't do the rename server side then EXDEV is
+// returned which makes tools like mv fall back to copy and delete.
+func (d *Dir) Rename(ctx context.Context, req *fuse.RenameRequest, newDir fusefs.Node) error {
+	oldPath := joinPath(d.path, req.OldName)
+	destDir, ok := newDir.(*Dir)
+	if !ok {
+		fs.ErrorLog(oldPath, "Dir.Rename %T is not a directory", newDir)
+		return fuse.EIO
+	}
+	newPath := joinPath(destDir.path, req.NewName)
+	fs.Debug(oldPath, "Dir.Rename to %q", newPath)
+	node, err := d.fsys.lookup(d.path, req.OldName)
+	if err != nil {
+		fs.ErrorLog(oldPath, "Dir.Rename failed: %v", err)
+		return lookupError(err)
+	}
+	switch node := node.(type) {
+	case nil:
+		fs.Debug(oldPath, "Dir.Rename not found")
+		return fuse.ENOENT
+	case *File:
+		o := node.object()
+		if o == nil {
+			fs.Debug(oldPath, "Dir.Rename file is still being written")
+			return fuse.Errno(syscall.EBUSY)
+		}
+		do, ok := d.fsys.f.(fs.Mover)
+		if !ok {
+			fs.Debug(oldPath, "Dir.Rename can't move files on this remote")
+			return fuse.Errno(syscall.EXDEV)
+		}
+		newObject, err := do.Move(o, newPath)
+		if err == fs.ErrorCantMove {
+			fs.Debug(oldPath, "Dir.Rename can't move this file")
+			return fuse.Errno(syscall.EXDEV)
+		}
+		if err != nil {
+			fs.ErrorLog(oldPath, "Dir.Rename error: %v", err)
+			return err
+		}
+		d.fsys.remove(oldPath)
+		d.fsys.addObject(newObject)
+	case *Dir:
+		dstFs, err := d.fsys.subFs(newPath)
+		if err != nil {
+			fs.ErrorLog(newPath, "Dir.Rename error: %v", err)
+			return err
+		}
+		do, ok := dstFs.(fs.DirMover)
+		if !ok {
+			fs.Debug(oldPath, "Dir.Rename can't move directories on this remote")
+			return fuse.Errno(syscall.EXDEV)
+		}
+		srcFs, err := d.fsys.subFs(oldPath)
+		if err != nil {
+			fs.ErrorLog(oldPath, "Dir.Rename error: %v", err)
+			return err
+		}
+		err = do.DirMove(srcFs)
+		switch err {
+		case nil:
+		case fs.ErrorCantDirMove:
+			fs.Debug(oldPath, "Dir.Rename can't move this directory")
+			return fuse.Errno(syscall.EXDEV)
+		case fs.ErrorDirExists:
+			fs.Debug(oldPath, "Dir.Rename destination exists")
+			return fuse.EEXIST
+		default:
+			fs.ErrorLog(oldPath, "Dir.Rename error: %v", err)
+			return err
+		}
+		// Too many things have moved to patch the listing up
+		d.fsys.invalidate()
+	}
+	fs.Debug(newPath, "Dir.Rename OK")
+	return nil
+}
+
+// lookupError converts an error from reading a directory into one
+// for FUSE
+func lookupError(err error) error {
+	if err == errDirNotFound {
+		return fuse.ENOENT
+	}
+	return err
+}
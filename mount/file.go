@@ -0,0 +1,179 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"sync"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// File represents a file
+type File struct {
+	fsys   *FS
+	remote string
+
+	mu      sync.Mutex
+	o       fs.Object // nil until the first upload has finished
+	writers int       // number of write handles open
+	size    int64     // bytes written so far if writers > 0
+	modTime time.Time // modification time while o is nil
+}
+
+// newFile makes a new File
+func newFile(fsys *FS, remote string, o fs.Object) *File {
+	return &File{
+		fsys:    fsys,
+		remote:  remote,
+		o:       o,
+		modTime: time.Now(),
+	}
+}
+
+// object returns the current object for the file or nil if it hasn't
+// been uploaded yet
+func (f *File) object() fs.Object {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.o
+}
+
+// openWriter notes that a write handle has been opened
+func (f *File) openWriter() {
+	f.mu.Lock()
+	f.writers++
+	f.size = 0
+	f.mu.Unlock()
+	f.fsys.openFile(f)
+}
+
+// written notes that n more bytes have been written
+func (f *File) written(n int) {
+	f.mu.Lock()
+	f.size += int64(n)
+	f.mu.Unlock()
+}
+
+// closeWriter notes that a write handle has been closed and that o
+// is the result of the upload (nil if it failed)
+func (f *File) closeWriter(o fs.Object) {
+	f.mu.Lock()
+	f.writers--
+	if o != nil {
+		f.o = o
+	}
+	writers := f.writers
+	f.mu.Unlock()
+	if o != nil {
+		f.fsys.addObject(o)
+	}
+	if writers == 0 {
+		f.fsys.closeFile(f)
+	}
+}
+
+// Check interface satisfied
+var _ fusefs.Node = (*File)(nil)
+
+// Attr fills out the attributes for the file
+func (f *File) Attr(ctx context.Context, a *fuse.Attr) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fs.Debug(f.remote, "File.Attr")
+	a.Gid = gid
+	a.Uid = uid
+	a.Mode = filePerm
+	modTime := f.modTime
+	switch {
+	case f.writers > 0 || f.o == nil:
+		a.Size = uint64(f.size)
+	default:
+		a.Size = uint64(f.o.Size())
+		modTime = f.o.ModTime()
+	}
+	if *noModTime {
+		modTime = f.fsys.mountTime
+	}
+	a.Atime = modTime
+	a.Mtime = modTime
+	a.Ctime = modTime
+	a.Crtime = modTime
+	a.Blocks = (a.Size + 511) / 512
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeSetattrer = (*File)(nil)
+
+// Setattr handles attribute changes from FUSE. Currently supports
+// ModTime only.  Size changes are ignored as files are always written
+// from the start.
+func (f *File) Setattr(ctx context.Context, req *fuse.SetattrRequest, resp *fuse.SetattrResponse) error {
+	fs.Debug(f.remote, "File.Setattr %v", req)
+	if *noModTime {
+		return nil
+	}
+	var newTime time.Time
+	switch {
+	case req.Valid.MtimeNow():
+		newTime = time.Now()
+	case req.Valid.Mtime():
+		newTime = req.Mtime
+	default:
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.o == nil || f.writers > 0 {
+		f.modTime = newTime
+		return nil
+	}
+	f.o.SetModTime(newTime)
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.NodeOpener = (*File)(nil)
+
+// Open the file for read or write
+func (f *File) Open(ctx context.Context, req *fuse.OpenRequest, resp *fuse.OpenResponse) (fusefs.Handle, error) {
+	fs.Debug(f.remote, "File.Open %v", req.Flags)
+	o := f.object()
+	switch {
+	case req.Flags.IsReadOnly():
+		if o == nil {
+			fs.Debug(f.remote, "File.Open can't read a file which is still being written")
+			return nil, fuse.Errno(syscall.EBUSY)
+		}
+		fh, err := newReadFileHandle(o)
+		if err != nil {
+			fs.ErrorLog(f.remote, "File.Open failed: %v", err)
+			return nil, err
+		}
+		return fh, nil
+	case req.Flags.IsWriteOnly():
+		resp.Flags |= fuse.OpenNonSeekable
+		return newWriteFileHandle(f, o), nil
+	case req.Flags.IsReadWrite():
+		fs.Debug(f.remote, "File.Open can't open for read and write simultaneously")
+		return nil, fuse.ENOSYS
+	}
+	fs.ErrorLog(f.remote, "File.Open can't figure out how to open with flags %v", req.Flags)
+	return nil, fuse.EIO
+}
+
+// Check interface satisfied
+var _ fusefs.NodeFsyncer = (*File)(nil)
+
+// Fsync the file
+//
+// Note that we don't do anything except return OK as the data is
+// uploaded when the file handle is flushed
+func (f *File) Fsync(ctx context.Context, req *fuse.FsyncRequest) error {
+	return nil
+}
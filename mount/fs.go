@@ -0,0 +1,346 @@
+// FUSE main Fs
+
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// FS represents the top level filing system
+type FS struct {
+	f         fs.Fs
+	cacheTime time.Duration // how long to cache directory listings for
+	mountTime time.Time     // used as the time when there isn't a better one
+
+	mu        sync.Mutex
+	dirs      map[string]*dirListing // cached listings by directory path
+	changes   int                    // incremented each time dirs is changed
+	openFiles map[string]*File       // files currently open for write by remote
+}
+
+// Check interface satistfied
+var _ fusefs.FS = (*FS)(nil)
+
+// errDirNotFound is returned when a directory isn't on the remote
+var errDirNotFound = fmt.Errorf("directory not found")
+
+// newFS makes a new FS for the remote
+func newFS(f fs.Fs) *FS {
+	return &FS{
+		f:         f,
+		cacheTime: *dirCacheTime,
+		mountTime: time.Now(),
+		dirs:      make(map[string]*dirListing),
+		openFiles: make(map[string]*File),
+	}
+}
+
+// Root returns the root node
+func (f *FS) Root() (fusefs.Node, error) {
+	fs.Debug(f.f, "Root()")
+	return newDir(f, "", f.mountTime), nil
+}
+
+// subFs returns an fs.Fs rooted at dirPath within the remote being
+// mounted.
+//
+// This is needed for the directory operations as Mkdir, Rmdir and
+// DirMove only work on the root of an fs.Fs
+func (f *FS) subFs(dirPath string) (fs.Fs, error) {
+	return fs.NewSubFs(f.f, dirPath)
+}
+
+// getDir returns the entries in the directory dirPath, reading it
+// from the remote if it isn't cached or is older than cacheTime
+//
+// Call with the lock held - it is released while the remote is read
+func (f *FS) getDir(dirPath string) (map[string]*dirEntry, error) {
+	if dir, ok := f.dirs[dirPath]; ok && time.Since(dir.readTime) <= f.cacheTime {
+		return dir.entries, nil
+	}
+	changes := f.changes
+	f.mu.Unlock()
+	fs.Debug(f.f, "Reading directory %q", dirPath)
+	l, err := readDir(f.f, dirPath)
+	f.mu.Lock()
+	if err != nil {
+		return nil, err
+	}
+	// Only cache the listing if nothing changed while it was
+	// being read, otherwise it may be missing the changes
+	if f.changes == changes {
+		for dir, entries := range l.dirs {
+			f.dirs[dir] = &dirListing{readTime: l.readTime, entries: entries}
+		}
+	}
+	entries, ok := l.dirs[dirPath]
+	if !ok {
+		return nil, errDirNotFound
+	}
+	return entries, nil
+}
+
+// invalidate makes sure the directories are read from the remote
+// again the next time they are needed
+func (f *FS) invalidate() {
+	f.mu.Lock()
+	f.dirs = make(map[string]*dirListing)
+	f.changes++
+	f.mu.Unlock()
+}
+
+// lookup finds leaf in the directory dirPath
+//
+// It returns a nil node if it wasn't found
+func (f *FS) lookup(dirPath, leaf string) (fusefs.Node, error) {
+	remote := joinPath(dirPath, leaf)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if file, ok := f.openFiles[remote]; ok {
+		return file, nil
+	}
+	entries, err := f.getDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := entries[leaf]
+	if !ok {
+		return nil, nil
+	}
+	if entry.o == nil {
+		return newDir(f, remote, entry.modTime), nil
+	}
+	return newFile(f, remote, entry.o), nil
+}
+
+// list returns the sorted contents of the directory dirPath
+//
+// It returns errDirNotFound if the directory doesn't exist
+func (f *FS) list(dirPath string) (names []string, isDir map[string]bool, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	entries, err := f.getDir(dirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	isDir = make(map[string]bool, len(entries))
+	for leaf, entry := range entries {
+		isDir[leaf] = entry.o == nil
+	}
+	for remote := range f.openFiles {
+		parent, leaf := splitPath(remote)
+		if parent == dirPath {
+			isDir[leaf] = false
+		}
+	}
+	names = make([]string, 0, len(isDir))
+	for leaf := range isDir {
+		names = append(names, leaf)
+	}
+	sort.Strings(names)
+	return names, isDir, nil
+}
+
+// addEntry adds entry to the listing of dirPath if it is cached
+//
+// Call with the lock held
+func (f *FS) addEntry(remote string, entry *dirEntry) {
+	dirPath, leaf := splitPath(remote)
+	if dir, ok := f.dirs[dirPath]; ok {
+		dir.entries[leaf] = entry
+	}
+	f.changes++
+}
+
+// addObject adds a new or updated object to the cached listings
+func (f *FS) addObject(o fs.Object) {
+	f.mu.Lock()
+	f.addEntry(o.Remote(), &dirEntry{o: o})
+	f.mu.Unlock()
+}
+
+// addDir adds a new empty directory to the cached listings
+func (f *FS) addDir(dirPath string, modTime time.Time) {
+	f.mu.Lock()
+	f.addEntry(dirPath, &dirEntry{modTime: modTime})
+	f.dirs[dirPath] = &dirListing{
+		readTime: time.Now(),
+		entries:  make(map[string]*dirEntry),
+	}
+	f.mu.Unlock()
+}
+
+// remove removes the file or directory at remote from the cached
+// listings
+func (f *FS) remove(remote string) {
+	f.mu.Lock()
+	dirPath, leaf := splitPath(remote)
+	if dir, ok := f.dirs[dirPath]; ok {
+		delete(dir.entries, leaf)
+	}
+	prefix := remote + "/"
+	for dir := range f.dirs {
+		if dir == remote || strings.HasPrefix(dir, prefix) {
+			delete(f.dirs, dir)
+		}
+	}
+	f.changes++
+	f.mu.Unlock()
+}
+
+// openFile marks file as open for write so it appears in the
+// listings before it has been uploaded
+func (f *FS) openFile(file *File) {
+	f.mu.Lock()
+	f.openFiles[file.remote] = file
+	f.mu.Unlock()
+}
+
+// closeFile marks file as no longer open for write
+func (f *FS) closeFile(file *File) {
+	f.mu.Lock()
+	if f.openFiles[file.remote] == file {
+		delete(f.openFiles, file.remote)
+	}
+	f.mu.Unlock()
+}
+
+// dirEntry is an item in a directory listing
+type dirEntry struct {
+	o       fs.Object // the object, or nil if this is a directory
+	modTime time.Time // modification time if this is a directory
+}
+
+// dirListing is the cached contents of one directory
+type dirListing struct {
+	readTime time.Time            // when the directory was read
+	entries  map[string]*dirEntry // leaf name -> entry
+}
+
+// listing is a set of directories read from the remote
+type listing struct {
+	readTime time.Time                       // when the listing was read
+	dirs     map[string]map[string]*dirEntry // directory path -> leaf name -> entry
+}
+
+// newListing makes an empty listing with just the root directory in
+func newListing() *listing {
+	return &listing{
+		readTime: time.Now(),
+		dirs: map[string]map[string]*dirEntry{
+			"": {},
+		},
+	}
+}
+
+// readDir reads the directory dirPath from the remote
+//
+// If the remote is a fs.DirLister then only dirPath is read,
+// otherwise the whole remote is read and all of its directories are
+// returned in the listing.
+func readDir(f fs.Fs, dirPath string) (*listing, error) {
+	do, ok := f.(fs.DirLister)
+	if !ok {
+		return readListing(f), nil
+	}
+	objects, dirs, err := do.ListDirectory(context.Background(), dirPath)
+	if err != nil {
+		return nil, err
+	}
+	l := &listing{
+		readTime: time.Now(),
+		dirs:     make(map[string]map[string]*dirEntry, 1),
+	}
+	entries := l.entries(dirPath)
+	for _, dir := range dirs {
+		modTime := dir.When
+		if modTime.IsZero() {
+			modTime = l.readTime
+		}
+		_, leaf := splitPath(dir.Name)
+		entries[leaf] = &dirEntry{modTime: modTime}
+	}
+	for _, o := range objects {
+		_, leaf := splitPath(o.Remote())
+		entries[leaf] = &dirEntry{o: o}
+	}
+	return l, nil
+}
+
+// readListing reads the whole remote into a new listing
+func readListing(f fs.Fs) *listing {
+	l := newListing()
+	for o := range f.List() {
+		l.addObject(o)
+	}
+	// Pick up any empty directories or buckets at the root
+	for dir := range f.ListDir() {
+		l.addDir(dir.Name, dir.When)
+	}
+	return l
+}
+
+// entries returns the entries for dirPath, making an empty directory
+// if it doesn't exist
+func (l *listing) entries(dirPath string) map[string]*dirEntry {
+	entries, ok := l.dirs[dirPath]
+	if !ok {
+		entries = make(map[string]*dirEntry)
+		l.dirs[dirPath] = entries
+	}
+	return entries
+}
+
+// addDir adds the directory dirPath and any missing parents to the
+// listing
+func (l *listing) addDir(dirPath string, modTime time.Time) {
+	if modTime.IsZero() {
+		modTime = l.readTime
+	}
+	for dirPath != "" {
+		l.entries(dirPath)
+		parent, leaf := splitPath(dirPath)
+		entries := l.entries(parent)
+		if _, found := entries[leaf]; found {
+			// parents must exist already
+			return
+		}
+		entries[leaf] = &dirEntry{modTime: modTime}
+		dirPath = parent
+	}
+}
+
+// addObject adds o to the listing along with any missing parent
+// directories
+func (l *listing) addObject(o fs.Object) {
+	dirPath, leaf := splitPath(o.Remote())
+	l.addDir(dirPath, time.Time{})
+	l.entries(dirPath)[leaf] = &dirEntry{o: o}
+}
+
+// splitPath splits remote into its parent directory and leaf name
+func splitPath(remote string) (dirPath, leaf string) {
+	i := strings.LastIndex(remote, "/")
+	if i < 0 {
+		return "", remote
+	}
+	return remote[:i], remote[i+1:]
+}
+
+// joinPath joins a leaf name onto a directory path
+func joinPath(dirPath, leaf string) string {
+	if dirPath == "" {
+		return leaf
+	}
+	return dirPath + "/" + leaf
+}
@@ -0,0 +1,181 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// entryNames returns the names in entries with a trailing / for
+// directories
+func entryNames(entries map[string]*dirEntry) (names []string) {
+	for leaf, entry := range entries {
+		if entry.o == nil {
+			leaf += "/"
+		}
+		names = append(names, leaf)
+	}
+	return names
+}
+
+// makeTestDir makes a temporary directory with some files and
+// directories in returning it and a function to remove it
+func makeTestDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "rclone-mount-listing")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "b"), 0777))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "empty"), 0777))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "top"), []byte("top"), 0666))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a", "b", "deep"), []byte("deep"), 0666))
+	return dir, func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+// notDirLister hides the ListDirectory method of the Fs it wraps
+type notDirLister struct {
+	fs.Fs
+}
+
+func TestSplitJoinPath(t *testing.T) {
+	for _, test := range []struct {
+		remote  string
+		dirPath string
+		leaf    string
+	}{
+		{"", "", ""},
+		{"file", "", "file"},
+		{"dir/file", "dir", "file"},
+		{"dir/sub/file", "dir/sub", "file"},
+	} {
+		dirPath, leaf := splitPath(test.remote)
+		assert.Equal(t, test.dirPath, dirPath, test.remote)
+		assert.Equal(t, test.leaf, leaf, test.remote)
+		assert.Equal(t, test.remote, joinPath(dirPath, leaf), test.remote)
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+	f, err := fs.NewFs(dir)
+	require.NoError(t, err)
+	_, ok := f.(fs.DirLister)
+	require.True(t, ok)
+
+	// A DirLister only has the directory asked for read
+	l, err := readDir(f, "")
+	require.NoError(t, err)
+	assert.Len(t, l.dirs, 1)
+	assert.ElementsMatch(t, []string{"a/", "empty/", "top"}, entryNames(l.dirs[""]))
+
+	l, err = readDir(f, "a/b")
+	require.NoError(t, err)
+	assert.Len(t, l.dirs, 1)
+	assert.ElementsMatch(t, []string{"deep"}, entryNames(l.dirs["a/b"]))
+	assert.Equal(t, "a/b/deep", l.dirs["a/b"]["deep"].o.Remote())
+
+	_, err = readDir(f, "notfound")
+	assert.Error(t, err)
+
+	// Otherwise the whole remote is read
+	l, err = readDir(notDirLister{f}, "a")
+	require.NoError(t, err)
+	assert.Len(t, l.dirs, 4)
+	assert.ElementsMatch(t, []string{"a/", "empty/", "top"}, entryNames(l.dirs[""]))
+	assert.ElementsMatch(t, []string{"b/"}, entryNames(l.dirs["a"]))
+	assert.ElementsMatch(t, []string{"deep"}, entryNames(l.dirs["a/b"]))
+	assert.ElementsMatch(t, []string(nil), entryNames(l.dirs["empty"]))
+	assert.Equal(t, "a/b/deep", l.dirs["a/b"]["deep"].o.Remote())
+}
+
+func TestFSChanges(t *testing.T) {
+	dir, cleanup := makeTestDir(t)
+	defer cleanup()
+	f, err := fs.NewFs(dir)
+	require.NoError(t, err)
+	fsys := newFS(f)
+	fsys.cacheTime = time.Hour
+
+	names, _, err := fsys.list("a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, names)
+	assert.Len(t, fsys.dirs, 1)
+
+	// Adding a directory updates its parent and makes it empty
+	fsys.addDir("a/c", time.Time{})
+	names, isDir, err := fsys.list("a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "c"}, names)
+	assert.True(t, isDir["c"])
+	names, _, err = fsys.list("a/c")
+	require.NoError(t, err)
+	assert.Len(t, names, 0)
+
+	// Removing a directory removes it and the directories inside it
+	_, _, err = fsys.list("a/b")
+	require.NoError(t, err)
+	fsys.remove("a/b")
+	names, _, err = fsys.list("a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c"}, names)
+	_, found := fsys.dirs["a/b"]
+	assert.False(t, found)
+}
+
+func TestFSCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rclone-mount-cache")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	f, err := fs.NewFs(dir)
+	require.NoError(t, err)
+
+	fsys := newFS(f)
+	fsys.cacheTime = time.Hour
+	names, _, err := fsys.list("")
+	require.NoError(t, err)
+	assert.Len(t, names, 0)
+
+	// Cached so the new file won't be seen
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("hello"), 0666))
+	names, _, _ = fsys.list("")
+	assert.Len(t, names, 0)
+	node, err := fsys.lookup("", "file")
+	require.NoError(t, err)
+	assert.Nil(t, node)
+
+	// Until the cache is invalidated
+	fsys.invalidate()
+	names, isDir, _ := fsys.list("")
+	assert.Equal(t, []string{"file"}, names)
+	assert.False(t, isDir["file"])
+	node, err = fsys.lookup("", "file")
+	require.NoError(t, err)
+	file, ok := node.(*File)
+	require.True(t, ok)
+	assert.Equal(t, "file", file.remote)
+
+	// Or it expires
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "dir"), 0777))
+	fsys.cacheTime = 0
+	names, isDir, _ = fsys.list("")
+	assert.Equal(t, []string{"dir", "file"}, names)
+	assert.True(t, isDir["dir"])
+	node, err = fsys.lookup("", "dir")
+	require.NoError(t, err)
+	_, ok = node.(*Dir)
+	assert.True(t, ok)
+
+	_, _, err = fsys.list("notfound")
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+// +build linux darwin freebsd
+
+// Package mount implements a FUSE mounting system for rclone remotes.
+package mount
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"github.com/spf13/pflag"
+)
+
+// Globals
+var (
+	// Flags
+	noModTime     = pflag.BoolP("no-modtime", "", false, "Don't read or set the modification time (mount only).")
+	debugFUSE     = pflag.BoolP("debug-fuse", "", false, "Debug the FUSE internals - needs -v (mount only).")
+	readOnly      = pflag.BoolP("read-only", "", false, "Mount read-only (mount only).")
+	allowNonEmpty = pflag.BoolP("allow-non-empty", "", false, "Allow mounting over a non-empty directory (mount only).")
+	allowOther    = pflag.BoolP("allow-other", "", false, "Allow access to other users (mount only).")
+	dirCacheTime  = pflag.DurationP("dir-cache-time", "", 5*time.Minute, "Time to cache directory entries for (mount only).")
+	// Ownership of the files and directories in the mount
+	uid = uint32(os.Getuid())
+	gid = uint32(os.Getgid())
+)
+
+// Permissions of the files and directories in the mount
+const (
+	dirPerm  = 0777
+	filePerm = 0666
+)
+
+// mountOptions configures the options from the command line flags
+func mountOptions(device string) (options []fuse.MountOption) {
+	options = []fuse.MountOption{
+		fuse.MaxReadahead(128 * 1024),
+		fuse.Subtype("rclone"),
+		fuse.FSName(device),
+		fuse.VolumeName(device),
+		fuse.NoAppleDouble(),
+		fuse.NoAppleXattr(),
+	}
+	if *allowNonEmpty {
+		options = append(options, fuse.AllowNonEmptyMount())
+	}
+	if *allowOther {
+		options = append(options, fuse.AllowOther())
+	}
+	if *readOnly {
+		options = append(options, fuse.ReadOnly())
+	}
+	return options
+}
+
+// mount the file system
+//
+// The mount point will be ready when this returns.
+//
+// returns an error, and an error channel for the serve process to
+// report an error when fusermount is called.
+func mount(f fs.Fs, mountpoint string) (<-chan error, error) {
+	fs.Debug(f, "Mounting on %q", mountpoint)
+	c, err := fuse.Mount(mountpoint, mountOptions(f.Name()+":"+f.Root())...)
+	if err != nil {
+		return nil, err
+	}
+
+	filesys := newFS(f)
+	server := fusefs.New(c, nil)
+
+	// Serve the mount point in the background returning error to errChan
+	errChan := make(chan error, 1)
+	go func() {
+		err := server.Serve(filesys)
+		closeErr := c.Close()
+		if err == nil {
+			err = closeErr
+		}
+		errChan <- err
+	}()
+
+	// check if the mount process has an error to report
+	<-c.Ready
+	if err := c.MountError; err != nil {
+		return nil, err
+	}
+
+	return errChan, nil
+}
+
+// Mount mounts the remote at mountpoint and serves it until it is
+// unmounted or rclone is interrupted.
+func Mount(f fs.Fs, mountpoint string) error {
+	if *debugFUSE {
+		fuse.Debug = func(msg interface{}) {
+			fs.Debug("fuse", "%v", msg)
+		}
+	}
+
+	// Mount it
+	errChan, err := mount(f, mountpoint)
+	if err != nil {
+		return fmt.Errorf("failed to mount FUSE fs: %v", err)
+	}
+
+	// Unmount on interrupt so we don't leave a dead mount behind
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	for {
+		select {
+		case sig := <-sigChan:
+			fs.Log(f, "Received %v - unmounting %q", sig, mountpoint)
+			err = fuse.Unmount(mountpoint)
+			if err != nil {
+				fs.ErrorLog(f, "Failed to unmount %q: %v", mountpoint, err)
+			}
+		case err = <-errChan:
+			if err != nil {
+				return fmt.Errorf("failed to umount FUSE fs: %v", err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,253 @@
+// Test suite for the mount command
+//
+// This mounts a local remote so needs FUSE to be available - the
+// tests are skipped if it isn't.
+
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+
+	"bazil.org/fuse"
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fstest"
+	_ "github.com/ncw/rclone/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Globals
+var (
+	run *Run
+)
+
+// Run holds the remotes for a test run
+type Run struct {
+	mountPath    string
+	fremote      fs.Fs
+	cleanRemote  func()
+	umountResult <-chan error
+	skip         error // set if the mount failed
+}
+
+// TestMain drives the tests
+func TestMain(m *testing.M) {
+	fs.LoadConfig()
+	run = newRun()
+	rc := m.Run()
+	run.Finalise()
+	os.Exit(rc)
+}
+
+// newRun mounts a random local remote
+func newRun() *Run {
+	r := new(Run)
+	var err error
+	r.fremote, r.cleanRemote, err = fstest.RandomRemote("", false)
+	if err != nil {
+		log.Fatalf("Failed to open remote: %v", err)
+	}
+	err = fs.Mkdir(r.fremote)
+	if err != nil {
+		log.Fatalf("Failed to mkdir remote: %v", err)
+	}
+	r.mountPath, err = ioutil.TempDir("", "rclone-mount-test")
+	if err != nil {
+		log.Fatalf("Failed to create mount dir: %v", err)
+	}
+	// Don't cache the listings so changes to the remote show
+	// straight away
+	*dirCacheTime = 0
+	r.umountResult, err = mount(r.fremote, r.mountPath)
+	if err != nil {
+		log.Printf("Failed to mount %q - skipping mount tests: %v", r.mountPath, err)
+		r.skip = err
+	}
+	return r
+}
+
+// Finalise unmounts and tidies up
+func (r *Run) Finalise() {
+	if r.skip == nil {
+		err := fuse.Unmount(r.mountPath)
+		if err != nil {
+			log.Printf("Failed to umount: %v", err)
+		}
+		err = <-r.umountResult
+		if err != nil {
+			log.Printf("umount failed: %v", err)
+		}
+	}
+	r.cleanRemote()
+	err := os.RemoveAll(r.mountPath)
+	if err != nil {
+		log.Printf("Failed to remove mount dir %q: %v", r.mountPath, err)
+	}
+}
+
+// skipIfNoFUSE skips the test if the mount failed
+func (r *Run) skipIfNoFUSE(t *testing.T) {
+	if r.skip != nil {
+		t.Skipf("FUSE not available: %v", r.skip)
+	}
+}
+
+// path returns the path of remote in the mount
+func (r *Run) path(remote string) string {
+	return filepath.Join(r.mountPath, filepath.FromSlash(remote))
+}
+
+// remotePath returns the path of remote in the local remote
+func (r *Run) remotePath(remote string) string {
+	return filepath.Join(r.fremote.Root(), filepath.FromSlash(remote))
+}
+
+// readDir returns the sorted names in the mounted directory
+func (r *Run) readDir(t *testing.T, remote string) []string {
+	infos, err := ioutil.ReadDir(r.path(remote))
+	require.NoError(t, err)
+	var names []string
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestMount(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	fi, err := os.Stat(run.mountPath)
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, []string(nil), run.readDir(t, ""))
+}
+
+func TestDirLs(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	require.NoError(t, os.MkdirAll(run.remotePath("dir/sub"), 0777))
+	require.NoError(t, ioutil.WriteFile(run.remotePath("dir/file1"), []byte("hello"), 0666))
+	require.NoError(t, ioutil.WriteFile(run.remotePath("dir/sub/file2"), []byte("potato"), 0666))
+	defer func() {
+		require.NoError(t, os.RemoveAll(run.remotePath("dir")))
+	}()
+
+	assert.Equal(t, []string{"dir/"}, run.readDir(t, ""))
+	assert.Equal(t, []string{"file1", "sub/"}, run.readDir(t, "dir"))
+	assert.Equal(t, []string{"file2"}, run.readDir(t, "dir/sub"))
+
+	fi, err := os.Stat(run.path("dir/sub/file2"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(6), fi.Size())
+	assert.False(t, fi.IsDir())
+
+	_, err = os.Stat(run.path("dir/notfound"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestFileWriteRead(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	data := []byte("hello world, this is a test of writing through the mount")
+	require.NoError(t, ioutil.WriteFile(run.path("written"), data, 0666))
+	defer func() {
+		require.NoError(t, os.Remove(run.path("written")))
+		_, err := os.Stat(run.remotePath("written"))
+		assert.True(t, os.IsNotExist(err))
+	}()
+
+	// check it got to the remote
+	got, err := ioutil.ReadFile(run.remotePath("written"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// read it back through the mount
+	got, err = ioutil.ReadFile(run.path("written"))
+	require.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	// read it out of order
+	fd, err := os.Open(run.path("written"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	_, err = fd.ReadAt(buf, 6)
+	require.NoError(t, err)
+	assert.Equal(t, "world", string(buf))
+	_, err = fd.ReadAt(buf, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf))
+	require.NoError(t, fd.Close())
+
+	// overwrite it
+	require.NoError(t, ioutil.WriteFile(run.path("written"), []byte("potato"), 0666))
+	got, err = ioutil.ReadFile(run.remotePath("written"))
+	require.NoError(t, err)
+	assert.Equal(t, "potato", string(got))
+}
+
+func TestFileModTime(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	require.NoError(t, ioutil.WriteFile(run.path("modtime"), []byte("hello"), 0666))
+	defer func() {
+		require.NoError(t, os.Remove(run.path("modtime")))
+	}()
+
+	mtime := time.Date(2012, 11, 18, 17, 32, 31, 0, time.UTC)
+	require.NoError(t, os.Chtimes(run.path("modtime"), mtime, mtime))
+
+	fi, err := os.Stat(run.remotePath("modtime"))
+	require.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(mtime), fi.ModTime())
+}
+
+func TestDirMkdirRmdir(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	require.NoError(t, os.Mkdir(run.path("newdir"), 0777))
+	fi, err := os.Stat(run.remotePath("newdir"))
+	require.NoError(t, err)
+	assert.True(t, fi.IsDir())
+	assert.Equal(t, []string{"newdir/"}, run.readDir(t, ""))
+
+	require.NoError(t, os.Mkdir(run.path("newdir/subdir"), 0777))
+	require.NoError(t, ioutil.WriteFile(run.path("newdir/subdir/file"), []byte("hello"), 0666))
+
+	// can't remove a non empty directory
+	assert.Error(t, os.Remove(run.path("newdir/subdir")))
+
+	require.NoError(t, os.Remove(run.path("newdir/subdir/file")))
+	require.NoError(t, os.Remove(run.path("newdir/subdir")))
+	require.NoError(t, os.Remove(run.path("newdir")))
+	_, err = os.Stat(run.remotePath("newdir"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, []string(nil), run.readDir(t, ""))
+}
+
+func TestRename(t *testing.T) {
+	run.skipIfNoFUSE(t)
+	require.NoError(t, os.Mkdir(run.path("dir"), 0777))
+	require.NoError(t, ioutil.WriteFile(run.path("dir/file"), []byte("hello"), 0666))
+	defer func() {
+		require.NoError(t, os.RemoveAll(run.remotePath("dir2")))
+	}()
+
+	// rename a file
+	require.NoError(t, os.Rename(run.path("dir/file"), run.path("dir/file2")))
+	assert.Equal(t, []string{"file2"}, run.readDir(t, "dir"))
+
+	// rename a directory
+	require.NoError(t, os.Rename(run.path("dir"), run.path("dir2")))
+	assert.Equal(t, []string{"dir2/"}, run.readDir(t, ""))
+	got, err := ioutil.ReadFile(run.remotePath("dir2/file2"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
@@ -0,0 +1,18 @@
+// +build !linux,!darwin,!freebsd
+
+// Package mount implements a FUSE mounting system for rclone remotes.
+//
+// FUSE isn't available on this platform so this is just a stub.
+package mount
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/ncw/rclone/fs"
+)
+
+// Mount isn't supported on this platform
+func Mount(f fs.Fs, mountpoint string) error {
+	return fmt.Errorf("mount is not supported on %s", runtime.GOOS)
+}
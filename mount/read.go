@@ -0,0 +1,147 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"io"
+	"io/ioutil"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// ReadFileHandle is an open for read file handle on a File
+type ReadFileHandle struct {
+	mu     sync.Mutex
+	closed bool // set if handle has been closed
+	r      io.ReadCloser
+	o      fs.Object
+	offset int64
+}
+
+func newReadFileHandle(o fs.Object) (*ReadFileHandle, error) {
+	r, err := o.Open()
+	if err != nil {
+		return nil, err
+	}
+	fh := &ReadFileHandle{
+		o: o,
+		r: r,
+	}
+	return fh, nil
+}
+
+// Check interface satisfied
+var _ fusefs.Handle = (*ReadFileHandle)(nil)
+
+// seek to a new offset
+//
+// If the object can be opened at an offset then it is reopened there.
+// Otherwise seeking forwards discards the data in between, seeking
+// backwards reopens the object and discards up to the offset
+func (fh *ReadFileHandle) seek(offset int64) error {
+	fs.Debug(fh.o, "ReadFileHandle.seek from %d to %d", fh.offset, offset)
+	if _, ok := fh.o.(fs.RangeOpener); ok {
+		err := fh.r.Close()
+		if err != nil {
+			return err
+		}
+		fh.r, err = fs.OpenRange(fh.o, offset, -1)
+		if err != nil {
+			fh.closed = true
+			return err
+		}
+		fh.offset = offset
+		return nil
+	}
+	if offset < fh.offset {
+		err := fh.r.Close()
+		if err != nil {
+			return err
+		}
+		fh.r, err = fh.o.Open()
+		if err != nil {
+			fh.closed = true
+			return err
+		}
+		fh.offset = 0
+	}
+	n, err := io.CopyN(ioutil.Discard, fh.r, offset-fh.offset)
+	fh.offset += n
+	if err == io.EOF {
+		// Seeking beyond the end of the file is OK
+		err = nil
+	}
+	return err
+}
+
+// Check interface satisfied
+var _ fusefs.HandleReader = (*ReadFileHandle)(nil)
+
+// Read from the file handle
+func (fh *ReadFileHandle) Read(ctx context.Context, req *fuse.ReadRequest, resp *fuse.ReadResponse) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fs.Debug(fh.o, "ReadFileHandle.Read size %d offset %d", req.Size, req.Offset)
+	if fh.closed {
+		fs.ErrorLog(fh.o, "ReadFileHandle.Read error: file already closed")
+		return fuse.Errno(syscall.EBADF)
+	}
+	if req.Offset != fh.offset {
+		err := fh.seek(req.Offset)
+		if err != nil {
+			fs.ErrorLog(fh.o, "ReadFileHandle.Read seek error: %v", err)
+			return err
+		}
+	}
+	buf := make([]byte, req.Size)
+	n, err := io.ReadFull(fh.r, buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	resp.Data = buf[:n]
+	fh.offset += int64(n)
+	if err != nil {
+		fs.ErrorLog(fh.o, "ReadFileHandle.Read error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// close the file handle returning fuse.EBADF if it has been
+// closed already.
+//
+// Must be called with fh.mu held
+func (fh *ReadFileHandle) close() error {
+	if fh.closed {
+		return fuse.Errno(syscall.EBADF)
+	}
+	fh.closed = true
+	return fh.r.Close()
+}
+
+// Check interface satisfied
+var _ fusefs.HandleReleaser = (*ReadFileHandle)(nil)
+
+// Release is called when we are finished with the file handle
+//
+// It isn't called directly from userspace so the error is ignored by
+// the kernel
+func (fh *ReadFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.closed {
+		fs.Debug(fh.o, "ReadFileHandle.Release nothing to do")
+		return nil
+	}
+	fs.Debug(fh.o, "ReadFileHandle.Release closing")
+	err := fh.close()
+	if err != nil {
+		fs.ErrorLog(fh.o, "ReadFileHandle.Release error: %v", err)
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+// +build linux darwin freebsd
+
+package mount
+
+import (
+	"io"
+	"sync"
+	"syscall"
+
+	"bazil.org/fuse"
+	fusefs "bazil.org/fuse/fs"
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// WriteFileHandle is an open for write handle on a File
+//
+// The data is streamed to the remote as it is written so writes must
+// be sequential.
+type WriteFileHandle struct {
+	mu         sync.Mutex
+	closed     bool // set if handle has been closed
+	remote     string
+	pipeWriter *io.PipeWriter
+	o          fs.Object // the result of the upload
+	result     chan error
+	file       *File
+	offset     int64
+}
+
+// newWriteFileHandle starts uploading to the file.  If o is nil a new
+// object is created otherwise o is updated.
+func newWriteFileHandle(file *File, o fs.Object) *WriteFileHandle {
+	src := newCreateInfo(file.fsys.f, file.remote)
+	fh := &WriteFileHandle{
+		remote: file.remote,
+		result: make(chan error, 1),
+		file:   file,
+	}
+	var pipeReader *io.PipeReader
+	pipeReader, fh.pipeWriter = io.Pipe()
+	file.openWriter()
+	go func() {
+		var err error
+		if o == nil {
+			o, err = file.fsys.f.Put(pipeReader, src)
+		} else {
+			err = o.Update(pipeReader, src)
+		}
+		if err == nil {
+			fh.o = o
+		}
+		// Unblock any writers if the upload finished early
+		_ = pipeReader.CloseWithError(err)
+		fh.result <- err
+	}()
+	return fh
+}
+
+// Check interface satisfied
+var _ fusefs.Handle = (*WriteFileHandle)(nil)
+
+// Check interface satisfied
+var _ fusefs.HandleWriter = (*WriteFileHandle)(nil)
+
+// Write data to the file handle
+func (fh *WriteFileHandle) Write(ctx context.Context, req *fuse.WriteRequest, resp *fuse.WriteResponse) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	fs.Debug(fh.remote, "WriteFileHandle.Write len=%d offset=%d", len(req.Data), req.Offset)
+	if fh.closed {
+		fs.ErrorLog(fh.remote, "WriteFileHandle.Write error: file already closed")
+		return fuse.Errno(syscall.EBADF)
+	}
+	if fh.offset != req.Offset {
+		fs.ErrorLog(fh.remote, "WriteFileHandle.Write can't seek in file - only sequential writes are supported")
+		return fuse.Errno(syscall.ESPIPE)
+	}
+	n, err := fh.pipeWriter.Write(req.Data)
+	resp.Size = n
+	fh.offset += int64(n)
+	fh.file.written(n)
+	if err != nil {
+		fs.ErrorLog(fh.remote, "WriteFileHandle.Write error: %v", err)
+		return err
+	}
+	return nil
+}
+
+// close the file handle returning fuse.EBADF if it has been
+// closed already.
+//
+// This waits for the upload to finish and returns its error.
+//
+// Must be called with fh.mu held
+func (fh *WriteFileHandle) close() error {
+	if fh.closed {
+		return fuse.Errno(syscall.EBADF)
+	}
+	fh.closed = true
+	writeCloseErr := fh.pipeWriter.Close()
+	err := <-fh.result
+	fh.file.closeWriter(fh.o)
+	if err == nil {
+		err = writeCloseErr
+	}
+	return err
+}
+
+// Check interface satisfied
+var _ fusefs.HandleFlusher = (*WriteFileHandle)(nil)
+
+// Flush is called on each close() of a file descriptor. So if a
+// filesystem wants to return write errors in close() and the file has
+// cached dirty data, this is a good place to write back data and
+// return any errors. Since many applications ignore close() errors
+// this is not always useful.
+//
+// NOTE: The flush() method may be called more than once for each
+// open(). This happens if more than one file descriptor refers to an
+// opened file due to dup(), dup2() or fork() calls. It is not possible
+// to determine if a flush is final, so each flush should be treated
+// equally. Multiple write-flush sequences are relatively rare, so this
+// shouldn't be a problem.
+//
+// Filesystems shouldn't assume that flush will always be called after
+// some writes, or that if will be called at all.
+func (fh *WriteFileHandle) Flush(ctx context.Context, req *fuse.FlushRequest) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.closed {
+		fs.Debug(fh.remote, "WriteFileHandle.Flush nothing to do")
+		return nil
+	}
+	fs.Debug(fh.remote, "WriteFileHandle.Flush closing")
+	err := fh.close()
+	if err != nil {
+		fs.ErrorLog(fh.remote, "WriteFileHandle.Flush error: %v", err)
+		return err
+	}
+	fs.Debug(fh.remote, "WriteFileHandle.Flush OK")
+	return nil
+}
+
+// Check interface satisfied
+var _ fusefs.HandleReleaser = (*WriteFileHandle)(nil)
+
+// Release is called when we are finished with the file handle
+//
+// It isn't called directly from userspace so the error is ignored by
+// the kernel
+func (fh *WriteFileHandle) Release(ctx context.Context, req *fuse.ReleaseRequest) error {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.closed {
+		fs.Debug(fh.remote, "WriteFileHandle.Release nothing to do")
+		return nil
+	}
+	fs.Debug(fh.remote, "WriteFileHandle.Release closing")
+	err := fh.close()
+	if err != nil {
+		fs.ErrorLog(fh.remote, "WriteFileHandle.Release error: %v", err)
+	}
+	return err
+}
@@ -20,6 +20,7 @@ import (
 	"github.com/ncw/rclone/pacer"
 	"github.com/ncw/rclone/rest"
 	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
@@ -180,7 +181,7 @@ func NewFs(name, root string) (fs.Fs, error) {
 		name:  name,
 		root:  root,
 		srv:   rest.NewClient(oAuthClient).SetRoot(rootURL),
-		pacer: pacer.New().SetMinSleep(minSleep).SetMaxSleep(maxSleep).SetDecayConstant(decayConstant),
+		pacer: pacer.New().SetMinSleep(minSleep).SetMaxSleep(maxSleep).SetDecayConstant(decayConstant).SetName("onedrive"),
 	}
 	f.srv.SetErrorHandler(errorHandler)
 
@@ -316,7 +317,9 @@ type listAllFn func(*api.Item) bool
 // Lists the directory required calling the user function on each item found
 //
 // If the user fn ever returns true then it early exits with found = true
-func (f *Fs) listAll(dirID string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
+//
+// If ctx is cancelled then it gives up with ctx.Err()
+func (f *Fs) listAll(ctx context.Context, dirID string, directoriesOnly bool, filesOnly bool, fn listAllFn) (found bool, err error) {
 	// Top parameter asks for bigger pages of data
 	// https://dev.onedrive.com/odata/optional-query-parameters.htm
 	opts := rest.Opts{
@@ -327,14 +330,12 @@ OUTER:
 	for {
 		var result api.ListChildrenResponse
 		var resp *http.Response
-		err = f.pacer.Call(func() (bool, error) {
-			resp, err = f.srv.CallJSON(&opts, nil, &result)
+		err = f.pacer.CallContext(ctx, func() (bool, error) {
+			resp, err = f.srv.CallJSONContext(ctx, &opts, nil, &result)
 			return shouldRetry(resp, err)
 		})
 		if err != nil {
-			fs.Stats.Error()
-			fs.ErrorLog(f, "Couldn't list files: %v", err)
-			break
+			return found, fmt.Errorf("couldn't list files: %v", err)
 		}
 		if len(result.Value) == 0 {
 			break
@@ -379,7 +380,7 @@ func (f *Fs) listDirRecursive(dirID string, path string, out fs.ObjectsChan) err
 	var subError error
 	// Make the API request
 	var wg sync.WaitGroup
-	_, err := f.listAll(dirID, false, false, func(info *api.Item) bool {
+	_, err := f.listAll(context.Background(), dirID, false, false, func(info *api.Item) bool {
 		// Recurse on directories
 		if info.Folder != nil {
 			wg.Add(1)
@@ -431,6 +432,43 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// with a single listing of its folder
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	err = f.dirCache.FindRoot(false)
+	if err != nil {
+		return nil, nil, err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+	_, err = f.listAll(ctx, directoryID, false, false, func(info *api.Item) bool {
+		remote := prefix + info.Name
+		if info.Folder != nil {
+			// cache the ID so listing the directory doesn't look it up
+			f.dirCache.Put(remote, info.ID)
+			dirs = append(dirs, &fs.Dir{
+				Name:  remote,
+				When:  time.Time(info.LastModifiedDateTime),
+				Bytes: -1,
+				Count: info.Folder.ChildCount,
+			})
+		} else if o := f.newObjectWithInfo(remote, info); o != nil {
+			objects = append(objects, o)
+		}
+		return false
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return objects, dirs, nil
+}
+
 // ListDir lists the directories
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -441,7 +479,7 @@ func (f *Fs) ListDir() fs.DirChan {
 			fs.Stats.Error()
 			fs.ErrorLog(f, "Couldn't find root: %s", err)
 		} else {
-			_, err := f.listAll(f.dirCache.RootID(), true, false, func(item *api.Item) bool {
+			_, err := f.listAll(context.Background(), f.dirCache.RootID(), true, false, func(item *api.Item) bool {
 				dir := &fs.Dir{
 					Name:  item.Name,
 					Bytes: -1,
@@ -489,6 +527,11 @@ func (f *Fs) createObject(remote string, modTime time.Time, size int64) (o *Obje
 //
 // The new object may have been created if an error is returned
 func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but the upload is aborted if ctx is cancelled
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	remote := src.Remote()
 	size := src.Size()
 	modTime := src.ModTime()
@@ -497,7 +540,7 @@ func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
 	if err != nil {
 		return nil, err
 	}
-	return o, o.Update(in, src)
+	return o, o.UpdateContext(ctx, in, src)
 }
 
 // Mkdir creates the container if it doesn't exist
@@ -558,6 +601,64 @@ func (f *Fs) Rmdir() error {
 	return f.purgeCheck(true)
 }
 
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	err := f.dirCache.FindRoot(true)
+	if err != nil {
+		return err
+	}
+	_, err = f.dirCache.FindDir(dir, true)
+	return err
+}
+
+// RmdirPath removes the directory dir
+//
+// Returns an error if it isn't empty
+func (f *Fs) RmdirPath(dir string) error {
+	err := f.dirCache.FindRoot(false)
+	if err != nil {
+		return err
+	}
+	directoryID, err := f.dirCache.FindDir(dir, false)
+	if err != nil {
+		return err
+	}
+	item, _, err := f.readMetaDataForPath(f.rootSlash() + dir)
+	if err != nil {
+		return err
+	}
+	if item.Folder == nil {
+		return fmt.Errorf("Not a folder")
+	}
+	if item.Folder.ChildCount != 0 {
+		return fmt.Errorf("Folder not empty")
+	}
+	err = f.deleteObject(directoryID)
+	if err != nil {
+		return err
+	}
+	f.dirCache.FlushDir(dir)
+	return nil
+}
+
+// SetDirModTime sets the modification time of the directory dir
+func (f *Fs) SetDirModTime(dir string, modTime time.Time) error {
+	opts := rest.Opts{
+		Method: "PATCH",
+		Path:   "/drive/root:/" + replaceReservedChars(f.rootSlash()+dir),
+	}
+	update := api.SetFileSystemInfo{
+		FileSystemInfo: api.FileSystemInfoFacet{
+			CreatedDateTime:      api.Timestamp(modTime),
+			LastModifiedDateTime: api.Timestamp(modTime),
+		},
+	}
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.CallJSON(&opts, &update, nil)
+		return shouldRetry(resp, err)
+	})
+}
+
 // Precision return the precision of this Fs
 func (f *Fs) Precision() time.Duration {
 	return time.Second
@@ -818,13 +919,27 @@ func (o *Object) Storable() bool {
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
+	return o.open(nil)
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	return o.open(map[string]string{
+		"Range": fs.RangeHeader(offset, length),
+	})
+}
+
+// open the object for read with the extra headers passed in
+func (o *Object) open(headers map[string]string) (in io.ReadCloser, err error) {
 	if o.id == "" {
 		return nil, fmt.Errorf("Can't download no id")
 	}
 	var resp *http.Response
 	opts := rest.Opts{
-		Method: "GET",
-		Path:   "/drive/items/" + o.id + "/content",
+		Method:       "GET",
+		Path:         "/drive/items/" + o.id + "/content",
+		ExtraHeaders: headers,
 	}
 	err = o.fs.pacer.Call(func() (bool, error) {
 		resp, err = o.fs.srv.Call(&opts)
@@ -837,21 +952,21 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 }
 
 // createUploadSession creates an upload session for the object
-func (o *Object) createUploadSession() (response *api.CreateUploadResponse, err error) {
+func (o *Object) createUploadSession(ctx context.Context) (response *api.CreateUploadResponse, err error) {
 	opts := rest.Opts{
 		Method: "POST",
 		Path:   "/drive/root:/" + o.srvPath() + ":/upload.createSession",
 	}
 	var resp *http.Response
-	err = o.fs.pacer.Call(func() (bool, error) {
-		resp, err = o.fs.srv.CallJSON(&opts, nil, &response)
+	err = o.fs.pacer.CallContext(ctx, func() (bool, error) {
+		resp, err = o.fs.srv.CallJSONContext(ctx, &opts, nil, &response)
 		return shouldRetry(resp, err)
 	})
 	return
 }
 
 // uploadFragment uploads a part
-func (o *Object) uploadFragment(url string, start int64, totalSize int64, buf []byte) (err error) {
+func (o *Object) uploadFragment(ctx context.Context, url string, start int64, totalSize int64, buf []byte) (err error) {
 	bufSize := int64(len(buf))
 	opts := rest.Opts{
 		Method:        "PUT",
@@ -863,57 +978,118 @@ func (o *Object) uploadFragment(url string, start int64, totalSize int64, buf []
 	}
 	var response api.UploadFragmentResponse
 	var resp *http.Response
-	err = o.fs.pacer.Call(func() (bool, error) {
-		resp, err = o.fs.srv.CallJSON(&opts, nil, &response)
+	err = o.fs.pacer.CallContext(ctx, func() (bool, error) {
+		resp, err = o.fs.srv.CallJSONContext(ctx, &opts, nil, &response)
 		return shouldRetry(resp, err)
 	})
 	return err
 }
 
-// cancelUploadSession cancels an upload session
-func (o *Object) cancelUploadSession(url string) (err error) {
+// AbortUpload cancels the upload session in the saved state s
+func (f *Fs) AbortUpload(s *fs.UploadState) (err error) {
 	opts := rest.Opts{
 		Method:     "DELETE",
-		Path:       url,
+		Path:       s.Session,
 		Absolute:   true,
 		NoResponse: true,
 	}
 	var resp *http.Response
-	err = o.fs.pacer.Call(func() (bool, error) {
-		resp, err = o.fs.srv.Call(&opts)
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.Call(&opts)
 		return shouldRetry(resp, err)
 	})
 	return
 }
 
+// uploadSessionOffset returns the offset the upload session at url
+// expects the next fragment to start at
+func (o *Object) uploadSessionOffset(ctx context.Context, url string) (offset int64, err error) {
+	opts := rest.Opts{
+		Method:   "GET",
+		Path:     url,
+		Absolute: true,
+	}
+	var response api.UploadFragmentResponse
+	var resp *http.Response
+	err = o.fs.pacer.CallContext(ctx, func() (bool, error) {
+		resp, err = o.fs.srv.CallJSONContext(ctx, &opts, nil, &response)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(response.NextExpectedRanges) == 0 {
+		return 0, fmt.Errorf("upload session isn't expecting any more data")
+	}
+	_, err = fmt.Sscanf(response.NextExpectedRanges[0], "%d-", &offset)
+	if err != nil {
+		return 0, fmt.Errorf("couldn't parse expected range %q: %v", response.NextExpectedRanges[0], err)
+	}
+	return offset, nil
+}
+
 // uploadMultipart uploads a file using multipart upload
-func (o *Object) uploadMultipart(in io.Reader, size int64) (err error) {
+//
+// The upload session is saved so that if the upload is interrupted it
+// can be resumed by a later upload of the same src, otherwise it is
+// cancelled if the upload fails
+func (o *Object) uploadMultipart(ctx context.Context, in io.Reader, src fs.ObjectInfo, size int64) (err error) {
 	if chunkSize%(320*1024) != 0 {
 		return fmt.Errorf("Chunk size %d is not a multiple of 320k", chunkSize)
 	}
 
+	// Resume the saved upload session if there is one
+	state := fs.NewUploadState(o.fs, o.remote, src)
+	position := int64(0)
+	if state.Resuming() {
+		position, err = o.uploadSessionOffset(ctx, state.Session)
+		if err != nil {
+			fs.Log(o, "Can't resume multipart upload, starting again: %v", err)
+			state.Reset()
+			position = 0
+		} else {
+			// Skip the part of the file already uploaded
+			err = fs.Skip(in, position)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// Create upload session
-	fs.Debug(o, "Starting multipart upload")
-	session, err := o.createUploadSession()
-	if err != nil {
-		return err
+	if !state.Resuming() {
+		fs.Debug(o, "Starting multipart upload")
+		session, err := o.createUploadSession(ctx)
+		if err != nil {
+			return err
+		}
+		state.Session = session.UploadURL
+		saveErr := state.Save()
+		if saveErr != nil {
+			fs.Log(o, "Failed to save multipart upload state: %v", saveErr)
+		}
 	}
-	uploadURL := session.UploadURL
+	uploadURL := state.Session
 
-	// Cancel the session if something went wrong
+	// Cancel the session if something went wrong unless it can be
+	// resumed
 	defer func() {
 		if err != nil {
+			if state.Resumable() {
+				fs.Debug(o, "Keeping multipart upload session to resume")
+				return
+			}
 			fs.Debug(o, "Cancelling multipart upload")
-			cancelErr := o.cancelUploadSession(uploadURL)
+			cancelErr := o.fs.AbortUpload(state)
 			if cancelErr != nil {
-				fs.Log(o, "Failed to cancel multipart upload: %v", err)
+				fs.Log(o, "Failed to cancel multipart upload: %v", cancelErr)
 			}
+			state.Remove()
 		}
 	}()
 
 	// Upload the chunks
-	remaining := size
-	position := int64(0)
+	remaining := size - position
 	buf := make([]byte, int64(chunkSize))
 	for remaining > 0 {
 		n := int64(chunkSize)
@@ -926,13 +1102,19 @@ func (o *Object) uploadMultipart(in io.Reader, size int64) (err error) {
 			return err
 		}
 		fs.Debug(o, "Uploading segment %d/%d size %d", position, size, n)
-		err = o.uploadFragment(uploadURL, position, size, buf)
+		err = o.uploadFragment(ctx, uploadURL, position, size, buf)
 		if err != nil {
 			return err
 		}
 		remaining -= n
 		position += n
+		state.Offset = position
+		saveErr := state.Save()
+		if saveErr != nil {
+			fs.Log(o, "Failed to save multipart upload state: %v", saveErr)
+		}
 	}
+	state.Remove()
 
 	return nil
 }
@@ -941,6 +1123,12 @@ func (o *Object) uploadMultipart(in io.Reader, size int64) (err error) {
 //
 // The new object may have been created if an error is returned
 func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but the upload is aborted if ctx is
+// cancelled
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (err error) {
 	size := src.Size()
 	modTime := src.ModTime()
 
@@ -953,8 +1141,8 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
 			Path:   "/drive/root:/" + o.srvPath() + ":/content",
 			Body:   in,
 		}
-		err = o.fs.pacer.CallNoRetry(func() (bool, error) {
-			resp, err = o.fs.srv.CallJSON(&opts, nil, &info)
+		err = o.fs.pacer.CallNoRetryContext(ctx, func() (bool, error) {
+			resp, err = o.fs.srv.CallJSONContext(ctx, &opts, nil, &info)
 			return shouldRetry(resp, err)
 		})
 		if err != nil {
@@ -962,7 +1150,7 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
 		}
 		o.setMetaData(info)
 	} else {
-		err = o.uploadMultipart(in, size)
+		err = o.uploadMultipart(ctx, in, src, size)
 		if err != nil {
 			return err
 		}
@@ -983,10 +1171,17 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = (*Fs)(nil)
-	_ fs.Purger = (*Fs)(nil)
-	_ fs.Copier = (*Fs)(nil)
+	_ fs.Fs               = (*Fs)(nil)
+	_ fs.Purger           = (*Fs)(nil)
+	_ fs.Copier           = (*Fs)(nil)
+	_ fs.DirMaker         = (*Fs)(nil)
+	_ fs.DirLister        = (*Fs)(nil)
+	_ fs.DirModTimeSetter = (*Fs)(nil)
+	_ fs.ContextPutter    = (*Fs)(nil)
+	_ fs.UploadAborter    = (*Fs)(nil)
 	// _ fs.Mover    = (*Fs)(nil)
 	// _ fs.DirMover = (*Fs)(nil)
-	_ fs.Object = (*Object)(nil)
+	_ fs.Object         = (*Object)(nil)
+	_ fs.RangeOpener    = (*Object)(nil)
+	_ fs.ContextUpdater = (*Object)(nil)
 )
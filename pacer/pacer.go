@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
 )
 
 // Pacer state
@@ -22,6 +23,32 @@ type Pacer struct {
 	connTokens         chan struct{} // Connection tokens
 	calculatePace      func(bool)    // switchable pacing algorithm - call with mu held
 	consecutiveRetries int           // number of consecutive retries
+	name               string        // name to record the stats under
+}
+
+// CallStats are the counts of the calls made through the pacers with
+// the same name
+type CallStats struct {
+	Calls   int64         // number of times the Paced function was called
+	Retries int64         // number of those calls which asked to be retried
+	Sleep   time.Duration // total time waiting for the pacer before the calls
+}
+
+// Globals
+var (
+	statsMu sync.Mutex
+	stats   = map[string]*CallStats{}
+)
+
+// Stats returns a copy of the CallStats for each pacer name
+func Stats() map[string]CallStats {
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	out := make(map[string]CallStats, len(stats))
+	for name, s := range stats {
+		out[name] = *s
+	}
+	return out
 }
 
 // Type is for selecting different pacing algorithms
@@ -63,6 +90,7 @@ func New() *Pacer {
 		decayConstant: 2,
 		retries:       10,
 		pacer:         make(chan struct{}, 1),
+		name:          "unknown",
 	}
 	p.sleepTime = p.minSleep
 	p.SetPacer(DefaultPacer)
@@ -132,6 +160,15 @@ func (p *Pacer) SetRetries(retries int) *Pacer {
 	return p
 }
 
+// SetName sets the name the stats of the calls are recorded under,
+// usually the type of the remote
+func (p *Pacer) SetName(name string) *Pacer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.name = name
+	return p
+}
+
 // SetPacer sets the pacing algorithm
 //
 // It will choose the default algorithm if an incorrect value is
@@ -150,17 +187,32 @@ func (p *Pacer) SetPacer(t Type) *Pacer {
 
 // Start a call to the API
 //
-// This must be called as a pair with endCall
+// This must be called as a pair with endCall unless it returns an
+// error
 //
-// This waits for the pacer token
-func (p *Pacer) beginCall() {
+// This waits for the pacer token, returning ctx.Err() if ctx is
+// cancelled while waiting
+func (p *Pacer) beginCall(ctx context.Context) error {
 	// pacer starts with a token in and whenever we take one out
 	// XXX ms later we put another in.  We could do this with a
 	// Ticker more accurately, but then we'd have to work out how
 	// not to run it when it wasn't needed
-	<-p.pacer
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	select {
+	case <-p.pacer:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 	if p.maxConnections > 0 {
-		<-p.connTokens
+		select {
+		case <-p.connTokens:
+		case <-ctx.Done():
+			// give back the pacer token we didn't use
+			p.pacer <- struct{}{}
+			return ctx.Err()
+		}
 	}
 
 	p.mu.Lock()
@@ -171,6 +223,7 @@ func (p *Pacer) beginCall() {
 		p.pacer <- struct{}{}
 	}(p.sleepTime)
 	p.mu.Unlock()
+	return nil
 }
 
 // exponentialImplementation implements a exponentialImplementation up
@@ -253,13 +306,38 @@ func (p *Pacer) endCall(retry bool) {
 	p.mu.Unlock()
 }
 
+// record adds a call which waited for sleep for the pacer to the
+// stats
+func (p *Pacer) record(sleep time.Duration, retry bool) {
+	p.mu.Lock()
+	name := p.name
+	p.mu.Unlock()
+	statsMu.Lock()
+	defer statsMu.Unlock()
+	s := stats[name]
+	if s == nil {
+		s = &CallStats{}
+		stats[name] = s
+	}
+	s.Calls++
+	if retry {
+		s.Retries++
+	}
+	s.Sleep += sleep
+}
+
 // call implements Call but with settable retries
-func (p *Pacer) call(fn Paced, retries int) (err error) {
+func (p *Pacer) call(ctx context.Context, fn Paced, retries int) (err error) {
 	var retry bool
 	for i := 0; i < retries; i++ {
-		p.beginCall()
+		start := time.Now()
+		if ctxErr := p.beginCall(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		sleep := time.Now().Sub(start)
 		retry, err = fn()
 		p.endCall(retry)
+		p.record(sleep, retry)
 		if !retry {
 			break
 		}
@@ -277,10 +355,16 @@ func (p *Pacer) call(fn Paced, retries int) (err error) {
 // error. This error may be returned wrapped in a RetryError if the
 // number of retries is exceeded.
 func (p *Pacer) Call(fn Paced) (err error) {
+	return p.CallContext(context.Background(), fn)
+}
+
+// CallContext is like Call but stops waiting and retrying when ctx is
+// cancelled, returning ctx.Err()
+func (p *Pacer) CallContext(ctx context.Context, fn Paced) (err error) {
 	p.mu.Lock()
 	retries := p.retries
 	p.mu.Unlock()
-	return p.call(fn, retries)
+	return p.call(ctx, fn, retries)
 }
 
 // CallNoRetry paces the remote operations to not exceed the limits
@@ -289,5 +373,11 @@ func (p *Pacer) Call(fn Paced) (err error) {
 // This calls fn and wraps the output in a RetryError if it would like
 // it to be retried
 func (p *Pacer) CallNoRetry(fn Paced) error {
-	return p.call(fn, 1)
+	return p.CallNoRetryContext(context.Background(), fn)
+}
+
+// CallNoRetryContext is like CallNoRetry but returns ctx.Err()
+// without calling fn if ctx is cancelled while waiting to call it
+func (p *Pacer) CallNoRetryContext(ctx context.Context, fn Paced) error {
+	return p.call(ctx, fn, 1)
 }
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
 )
 
 func TestNew(t *testing.T) {
@@ -125,7 +126,7 @@ func waitForPace(p *Pacer, duration time.Duration) (when time.Time) {
 func TestBeginCall(t *testing.T) {
 	p := New().SetMaxConnections(10).SetMinSleep(1 * time.Millisecond)
 	emptyTokens(p)
-	go p.beginCall()
+	go p.beginCall(context.Background())
 	if !waitForPace(p, 10*time.Millisecond).IsZero() {
 		t.Errorf("beginSleep fired too early #1")
 	}
@@ -148,7 +149,7 @@ func TestBeginCall(t *testing.T) {
 func TestBeginCallZeroConnections(t *testing.T) {
 	p := New().SetMaxConnections(0).SetMinSleep(1 * time.Millisecond)
 	emptyTokens(p)
-	go p.beginCall()
+	go p.beginCall(context.Background())
 	if !waitForPace(p, 10*time.Millisecond).IsZero() {
 		t.Errorf("beginSleep fired too early #1")
 	}
@@ -269,7 +270,7 @@ func Test_callNoRetry(t *testing.T) {
 	p := New().SetMinSleep(time.Millisecond).SetMaxSleep(2 * time.Millisecond)
 
 	dp := &dummyPaced{retry: false}
-	err := p.call(dp.fn, 10)
+	err := p.call(context.Background(), dp.fn, 10)
 	if dp.called != 1 {
 		t.Errorf("called want %d got %d", 1, dp.called)
 	}
@@ -282,7 +283,7 @@ func Test_callRetry(t *testing.T) {
 	p := New().SetMinSleep(time.Millisecond).SetMaxSleep(2 * time.Millisecond)
 
 	dp := &dummyPaced{retry: true}
-	err := p.call(dp.fn, 10)
+	err := p.call(context.Background(), dp.fn, 10)
 	if dp.called != 10 {
 		t.Errorf("called want %d got %d", 10, dp.called)
 	}
@@ -322,3 +323,79 @@ func TestCallNoRetry(t *testing.T) {
 		t.Errorf("didn't return a retry error")
 	}
 }
+
+func TestCallContextCancelled(t *testing.T) {
+	p := New().SetMinSleep(time.Millisecond).SetMaxSleep(2 * time.Millisecond).SetRetries(20)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dp := &dummyPaced{retry: true}
+	err := p.CallContext(ctx, func() (bool, error) {
+		if dp.called == 2 {
+			cancel()
+		}
+		return dp.fn()
+	})
+	if dp.called != 3 {
+		t.Errorf("called want %d got %d", 3, dp.called)
+	}
+	if err != context.Canceled {
+		t.Errorf("err want %v got %v", context.Canceled, err)
+	}
+}
+
+func TestBeginCallCancelled(t *testing.T) {
+	p := New().SetMaxConnections(10).SetMinSleep(1 * time.Millisecond)
+	emptyTokens(p)
+	p.pacer <- struct{}{}
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	// Blocks waiting for a connection token until cancelled
+	start := time.Now()
+	err := p.beginCall(ctx)
+	if err != context.Canceled {
+		t.Errorf("err want %v got %v", context.Canceled, err)
+	}
+	if dt := time.Now().Sub(start); dt > time.Second {
+		t.Errorf("took %v to notice the cancel", dt)
+	}
+	if waitForPace(p, 10*time.Millisecond).IsZero() {
+		t.Errorf("pacer token wasn't returned")
+	}
+}
+
+func TestSetName(t *testing.T) {
+	p := New()
+	if p.name != "unknown" {
+		t.Errorf("default name want %q got %q", "unknown", p.name)
+	}
+	p.SetName("potato")
+	if p.name != "potato" {
+		t.Errorf("didn't set")
+	}
+}
+
+func TestStats(t *testing.T) {
+	p := New().SetMinSleep(time.Millisecond).SetMaxSleep(2 * time.Millisecond).SetRetries(3).SetName("TestStats")
+
+	// The stats are kept for the life of the process so check the
+	// change in them in case the test is run more than once
+	before := Stats()["TestStats"]
+	dp := &dummyPaced{retry: true}
+	_ = p.Call(dp.fn)
+	dp.retry = false
+	_ = p.Call(dp.fn)
+
+	after := Stats()["TestStats"]
+	if got := after.Calls - before.Calls; got != 4 {
+		t.Errorf("Calls want %d got %d", 4, got)
+	}
+	if got := after.Retries - before.Retries; got != 3 {
+		t.Errorf("Retries want %d got %d", 3, got)
+	}
+	if got := after.Sleep - before.Sleep; got < 3*time.Millisecond {
+		t.Errorf("Sleep want at least %v got %v", 3*time.Millisecond, got)
+	}
+}
@@ -0,0 +1,186 @@
+package rc
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+)
+
+// How long finished jobs are kept for so their status can be read
+const jobExpireDuration = time.Hour
+
+// jobFn is the function a Job runs, returning the output for the
+// caller or an error
+type jobFn func(ctx context.Context) (interface{}, error)
+
+// Job describes an operation running in the background
+type Job struct {
+	mu        sync.Mutex
+	ID        int64       `json:"id"`
+	Name      string      `json:"name"`
+	StartTime time.Time   `json:"startTime"`
+	EndTime   time.Time   `json:"endTime"`
+	Finished  bool        `json:"finished"`
+	Success   bool        `json:"success"`
+	Error     string      `json:"error"`
+	Output    interface{} `json:"output"`
+	cancel    context.CancelFunc
+}
+
+// runMu makes the jobs run one at a time as they share fs.Stats and
+// fs.Limits
+var runMu sync.Mutex
+
+// run fn then mark the job as finished
+//
+// The stats counters and the limits are reset first so errors or
+// limits left over from an earlier job don't stop a sync deleting or
+// transferring files, and the job fails if any errors were counted
+// while it ran.
+//
+// If the job was stopped while it waited for the jobs before it then
+// fn isn't run at all.
+func (job *Job) run(ctx context.Context, fn jobFn) {
+	var (
+		out interface{}
+		err error
+	)
+	runMu.Lock()
+	if ctx.Err() != nil {
+		err = fmt.Errorf("stopped before it started: %v", ctx.Err())
+	} else {
+		fs.Stats.ResetCounters()
+		fs.Limits.Reset()
+		out, err = fn(ctx)
+		if err == nil && fs.Stats.Errored() {
+			err = fmt.Errorf("%d errors", fs.Stats.GetErrors())
+		}
+	}
+	runMu.Unlock()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	job.EndTime = time.Now()
+	job.Finished = true
+	job.Output = out
+	if err != nil {
+		job.Error = err.Error()
+		fs.ErrorLog(nil, "rc: job %d (%s) failed: %v", job.ID, job.Name, err)
+	} else {
+		job.Success = true
+		fs.Debug(nil, "rc: job %d (%s) finished", job.ID, job.Name)
+	}
+	job.cancel()
+}
+
+// Stop cancels the job if it is still running
+//
+// A job which hasn't started yet never runs.  A running job stops
+// before it changes anything else and fails, except for mkdir, rmdir
+// and purge on remotes which can purge in one call as these are a
+// single call to the remote which can't be interrupted.
+func (job *Job) Stop() {
+	job.cancel()
+}
+
+// status returns a copy of the job which is safe to encode
+func (job *Job) status() *Job {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return &Job{
+		ID:        job.ID,
+		Name:      job.Name,
+		StartTime: job.StartTime,
+		EndTime:   job.EndTime,
+		Finished:  job.Finished,
+		Success:   job.Success,
+		Error:     job.Error,
+		Output:    job.Output,
+	}
+}
+
+// expired returns whether the job finished long enough before now
+// to be forgotten
+func (job *Job) expired(now time.Time) bool {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	return job.Finished && now.Sub(job.EndTime) > jobExpireDuration
+}
+
+// jobs holds all the running and recently finished jobs
+type jobs struct {
+	mu     sync.Mutex
+	jobs   map[int64]*Job
+	lastID int64
+}
+
+// newJobs makes an empty set of jobs
+func newJobs() *jobs {
+	return &jobs{
+		jobs: make(map[int64]*Job),
+	}
+}
+
+// start runs fn in the background as a new job called name
+func (js *jobs) start(name string, fn jobFn) *Job {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	js.expire()
+	js.lastID++
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &Job{
+		ID:        js.lastID,
+		Name:      name,
+		StartTime: time.Now(),
+		cancel:    cancel,
+	}
+	js.jobs[job.ID] = job
+	fs.Debug(nil, "rc: job %d (%s) started", job.ID, job.Name)
+	go job.run(ctx, fn)
+	return job
+}
+
+// expire removes old finished jobs
+//
+// Call with js.mu held
+func (js *jobs) expire() {
+	now := time.Now()
+	for id, job := range js.jobs {
+		if job.expired(now) {
+			delete(js.jobs, id)
+		}
+	}
+}
+
+// get finds the job with the ID given
+func (js *jobs) get(id int64) (*Job, error) {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	job, ok := js.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("job %d not found", id)
+	}
+	return job, nil
+}
+
+// ids returns the IDs of all the jobs in order
+func (js *jobs) ids() []int64 {
+	js.mu.Lock()
+	defer js.mu.Unlock()
+	ids := make([]int64, 0, len(js.jobs))
+	for id := range js.jobs {
+		ids = append(ids, id)
+	}
+	sort.Sort(int64s(ids))
+	return ids
+}
+
+// int64s implements sort.Interface for a slice of int64
+type int64s []int64
+
+func (xs int64s) Len() int           { return len(xs) }
+func (xs int64s) Swap(i, j int)      { xs[i], xs[j] = xs[j], xs[i] }
+func (xs int64s) Less(i, j int) bool { return xs[i] < xs[j] }
@@ -0,0 +1,335 @@
+// Package rc implements a remote control server which serves a JSON
+// over HTTP API for running rclone operations as jobs.
+package rc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
+)
+
+// Globals
+var (
+	// Flags
+	addr = pflag.StringP("rc-addr", "", "localhost:5572", "Address to serve the remote control API on (rcd only).")
+)
+
+// Params are the input or output parameters of a call
+type Params map[string]interface{}
+
+// GetString gets the string parameter called key
+func (p Params) GetString(key string) (string, error) {
+	value, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("missing parameter %q", key)
+	}
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("parameter %q must be a string", key)
+	}
+	return s, nil
+}
+
+// GetInt64 gets the integer parameter called key
+func (p Params) GetInt64(key string) (int64, error) {
+	value, ok := p[key]
+	if !ok {
+		return 0, fmt.Errorf("missing parameter %q", key)
+	}
+	// JSON decodes all numbers as float64
+	f, ok := value.(float64)
+	if !ok || f != float64(int64(f)) {
+		return 0, fmt.Errorf("parameter %q must be an integer", key)
+	}
+	return int64(f), nil
+}
+
+// getFs makes an Fs from the remote in the parameter called key
+func (p Params) getFs(key string) (fs.Fs, error) {
+	remote, err := p.GetString(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.NewFs(remote)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create file system for %q: %v", remote, err)
+	}
+	return f, nil
+}
+
+// getSrcDst makes the source and destination Fs from the srcFs and
+// dstFs parameters
+func (p Params) getSrcDst() (fsrc, fdst fs.Fs, err error) {
+	fsrc, err = p.getFs("srcFs")
+	if err != nil {
+		return nil, nil, err
+	}
+	fdst, err = p.getFs("dstFs")
+	if err != nil {
+		return nil, nil, err
+	}
+	fs.CalculateModifyWindow(fdst, fsrc)
+	return fsrc, fdst, nil
+}
+
+// Object describes an object in the output of list
+type Object struct {
+	Path    string    `json:"path"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// syncFn is a sync, copy or move operation
+type syncFn func(ctx context.Context, fdst, fsrc fs.Fs) error
+
+// syncJob makes a job from a syncFn
+func syncJob(fn syncFn) func(in Params) (jobFn, error) {
+	return func(in Params) (jobFn, error) {
+		fsrc, fdst, err := in.getSrcDst()
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (interface{}, error) {
+			return nil, fn(ctx, fdst, fsrc)
+		}, nil
+	}
+}
+
+// fsFn is an operation on a single Fs
+type fsFn func(ctx context.Context, f fs.Fs) error
+
+// singleCall makes an fsFn from an operation which is a single call
+// to the remote so can't be stopped part way through
+func singleCall(fn func(f fs.Fs) error) fsFn {
+	return func(ctx context.Context, f fs.Fs) error {
+		return fn(f)
+	}
+}
+
+// fsJob makes a job from an fsFn
+func fsJob(fn fsFn) func(in Params) (jobFn, error) {
+	return func(in Params) (jobFn, error) {
+		f, err := in.getFs("fs")
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context) (interface{}, error) {
+			return nil, fn(ctx, f)
+		}, nil
+	}
+}
+
+// checkJob makes a job which checks the files in srcFs and dstFs
+func checkJob(in Params) (jobFn, error) {
+	fsrc, fdst, err := in.getSrcDst()
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (interface{}, error) {
+		return nil, fs.CheckContext(ctx, fdst, fsrc)
+	}, nil
+}
+
+// listJob makes a job which lists the objects in fs
+func listJob(in Params) (jobFn, error) {
+	f, err := in.getFs("fs")
+	if err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) (interface{}, error) {
+		var mu sync.Mutex
+		list := []Object{}
+		err := fs.ListFnContext(ctx, f, func(o fs.Object) {
+			object := Object{
+				Path:    o.Remote(),
+				Size:    o.Size(),
+				ModTime: o.ModTime(),
+			}
+			mu.Lock()
+			list = append(list, object)
+			mu.Unlock()
+		})
+		return Params{"list": list}, err
+	}, nil
+}
+
+// operations are the calls under /operations/ which run as jobs
+//
+// Each reads its parameters and returns the function for the job to
+// run
+var operations = map[string]func(in Params) (jobFn, error){
+	"sync":   syncJob(fs.SyncContext),
+	"copy":   syncJob(fs.CopyDirContext),
+	"move":   syncJob(fs.MoveDirContext),
+	"check":  checkJob,
+	"list":   listJob,
+	"purge":  fsJob(fs.PurgeContext),
+	"delete": fsJob(fs.DeleteContext),
+	"mkdir":  fsJob(singleCall(fs.Mkdir)),
+	"rmdir":  fsJob(singleCall(fs.Rmdir)),
+}
+
+// Server serves the remote control API
+type Server struct {
+	jobs *jobs
+	mux  *http.ServeMux
+}
+
+// NewServer makes a new remote control Server
+func NewServer() *Server {
+	s := &Server{
+		jobs: newJobs(),
+		mux:  http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/operations/", s.handle(s.operation))
+	s.mux.HandleFunc("/job/status", s.handle(s.jobStatus))
+	s.mux.HandleFunc("/job/stop", s.handle(s.jobStop))
+	s.mux.HandleFunc("/job/list", s.handle(s.jobList))
+	s.mux.HandleFunc("/core/stats", s.handle(s.coreStats))
+	s.mux.HandleFunc("/", s.handle(s.notFound))
+	return s
+}
+
+// Serve the remote control API on --rc-addr until an error occurs
+func Serve() error {
+	fs.Log(nil, "Serving remote control API on http://%s/", *addr)
+	return http.ListenAndServe(*addr, NewServer())
+}
+
+// ServeHTTP serves the remote control API
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// callError is an error with the HTTP status code to return
+type callError struct {
+	status int
+	err    error
+}
+
+// Error satisfies the error interface
+func (e *callError) Error() string {
+	return e.err.Error()
+}
+
+// badRequest marks err as being caused by the parameters
+func badRequest(err error) error {
+	return &callError{status: http.StatusBadRequest, err: err}
+}
+
+// notFoundError marks err as being caused by something missing
+func notFoundError(err error) error {
+	return &callError{status: http.StatusNotFound, err: err}
+}
+
+// callFn is a handler for a call which is passed the path and the
+// input parameters and returns the output parameters
+type callFn func(path string, in Params) (Params, error)
+
+// handle makes an http.HandlerFunc which decodes the JSON input
+// parameters, calls fn and encodes its output or error as JSON
+func (s *Server) handle(fn callFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+		fs.Debug(nil, "rc: %s %s", r.Method, path)
+		var out Params
+		var err error
+		in := Params{}
+		if r.Method != "POST" && r.Method != "GET" {
+			err = &callError{status: http.StatusMethodNotAllowed, err: fmt.Errorf("method %q not allowed", r.Method)}
+		} else if err = json.NewDecoder(r.Body).Decode(&in); err != nil && err != io.EOF {
+			err = badRequest(fmt.Errorf("failed to read input JSON: %v", err))
+		} else {
+			out, err = fn(path, in)
+		}
+		status := http.StatusOK
+		if err != nil {
+			status = http.StatusInternalServerError
+			if e, ok := err.(*callError); ok {
+				status = e.status
+			}
+			fs.ErrorLog(nil, "rc: %s failed: %v", path, err)
+			out = Params{
+				"error":  err.Error(),
+				"path":   path,
+				"status": status,
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		encoder := json.NewEncoder(w)
+		if err := encoder.Encode(out); err != nil {
+			fs.ErrorLog(nil, "rc: failed to write output JSON: %v", err)
+		}
+	}
+}
+
+// operation starts the job for operations/name returning its ID
+func (s *Server) operation(path string, in Params) (Params, error) {
+	name := strings.TrimPrefix(path, "operations/")
+	newJob, ok := operations[name]
+	if !ok {
+		return nil, notFoundError(fmt.Errorf("unknown operation %q", name))
+	}
+	fn, err := newJob(in)
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	job := s.jobs.start(name, fn)
+	return Params{"jobid": job.ID}, nil
+}
+
+// getJob finds the job from the jobid parameter
+func (s *Server) getJob(in Params) (*Job, error) {
+	id, err := in.GetInt64("jobid")
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	job, err := s.jobs.get(id)
+	if err != nil {
+		return nil, notFoundError(err)
+	}
+	return job, nil
+}
+
+// jobStatus returns the status of a job
+func (s *Server) jobStatus(path string, in Params) (Params, error) {
+	job, err := s.getJob(in)
+	if err != nil {
+		return nil, err
+	}
+	return Params{"job": job.status()}, nil
+}
+
+// jobStop cancels a job
+func (s *Server) jobStop(path string, in Params) (Params, error) {
+	job, err := s.getJob(in)
+	if err != nil {
+		return nil, err
+	}
+	job.Stop()
+	return Params{}, nil
+}
+
+// jobList returns the IDs of the jobs
+func (s *Server) jobList(path string, in Params) (Params, error) {
+	return Params{"jobids": s.jobs.ids()}, nil
+}
+
+// coreStats returns the transfer stats
+func (s *Server) coreStats(path string, in Params) (Params, error) {
+	return Params{"stats": fs.Stats.Snapshot()}, nil
+}
+
+// notFound is called for unknown paths
+func (s *Server) notFound(path string, in Params) (Params, error) {
+	return nil, notFoundError(fmt.Errorf("couldn't find %q", path))
+}
@@ -0,0 +1,50 @@
+package rc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	_ "github.com/ncw/rclone/local"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+)
+
+// A job stopped while it waits for the job before it must never run
+func TestStopQueuedJob(t *testing.T) {
+	fs.LoadConfig()
+	dir, err := ioutil.TempDir("", "rclone-rc-queued")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	file := filepath.Join(dir, "file.txt")
+	require.NoError(t, ioutil.WriteFile(file, []byte("hello"), 0666))
+	makeJob, err := operations["purge"](Params{"fs": dir})
+	require.NoError(t, err)
+	ran := false
+
+	// Hold the lock as if another job were running
+	runMu.Lock()
+	job := newJobs().start("purge", func(ctx context.Context) (interface{}, error) {
+		ran = true
+		return makeJob(ctx)
+	})
+	job.Stop()
+	runMu.Unlock()
+
+	for i := 0; i < 100 && !job.status().Finished; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	status := job.status()
+	require.True(t, status.Finished)
+	assert.False(t, status.Success)
+	assert.Contains(t, status.Error, "stopped before it started")
+	assert.False(t, ran)
+	_, err = os.Stat(file)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,249 @@
+package rc_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	_ "github.com/ncw/rclone/local"
+	"github.com/ncw/rclone/rc"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func init() {
+	fs.LoadConfig()
+}
+
+// call POSTs in to path on the server returning the status and the
+// decoded output
+func call(t *testing.T, server *httptest.Server, path string, in rc.Params) (int, rc.Params) {
+	body, err := json.Marshal(in)
+	require.NoError(t, err)
+	resp, err := http.Post(server.URL+"/"+path, "application/json", bytes.NewBuffer(body))
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, resp.Body.Close())
+	}()
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var out rc.Params
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&out))
+	return resp.StatusCode, out
+}
+
+// runJob starts the operation and waits for it to finish returning
+// the job status
+func runJob(t *testing.T, server *httptest.Server, operation string, in rc.Params) map[string]interface{} {
+	status, out := call(t, server, "operations/"+operation, in)
+	require.Equal(t, http.StatusOK, status, "output %v", out)
+	jobid := out["jobid"]
+	require.NotNil(t, jobid)
+	for i := 0; i < 100; i++ {
+		status, out = call(t, server, "job/status", rc.Params{"jobid": jobid})
+		require.Equal(t, http.StatusOK, status, "output %v", out)
+		job := out["job"].(map[string]interface{})
+		assert.Equal(t, operation, job["name"])
+		if job["finished"] == true {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %v didn't finish", jobid)
+	return nil
+}
+
+// makeDir makes a temporary directory with the files given in
+func makeDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "rclone-rc-test")
+	require.NoError(t, err)
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0777))
+		require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0666))
+	}
+	return dir
+}
+
+func TestCopyListPurge(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+	src := makeDir(t, map[string]string{
+		"one.txt":     "hello",
+		"sub/two.txt": "potatoes",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(src))
+	}()
+	dst := filepath.Join(src, "..", filepath.Base(src)+"-dst")
+	defer func() {
+		require.NoError(t, os.RemoveAll(dst))
+	}()
+
+	job := runJob(t, server, "copy", rc.Params{"srcFs": src, "dstFs": dst})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "sub", "two.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "potatoes", string(contents))
+
+	job = runJob(t, server, "check", rc.Params{"srcFs": src, "dstFs": dst})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+
+	job = runJob(t, server, "list", rc.Params{"fs": dst})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+	list := job["output"].(map[string]interface{})["list"].([]interface{})
+	sizes := map[string]float64{}
+	for _, item := range list {
+		object := item.(map[string]interface{})
+		sizes[object["path"].(string)] = object["size"].(float64)
+	}
+	assert.Equal(t, map[string]float64{"one.txt": 5, "sub/two.txt": 8}, sizes)
+
+	job = runJob(t, server, "purge", rc.Params{"fs": dst})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+	_, err = os.Stat(dst)
+	assert.True(t, os.IsNotExist(err))
+
+	job = runJob(t, server, "rmdir", rc.Params{"fs": dst})
+	assert.Equal(t, false, job["success"])
+	assert.NotEqual(t, "", job["error"])
+}
+
+func TestErrorsDontCarryOver(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+	src := makeDir(t, map[string]string{
+		"one.txt": "hello",
+		"two.txt": "potatoes",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(src))
+	}()
+	// two.txt can't be copied as it is a directory in dst
+	dst := makeDir(t, map[string]string{
+		"two.txt/three.txt": "chips",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(dst))
+	}()
+
+	job := runJob(t, server, "copy", rc.Params{"srcFs": src, "dstFs": dst})
+	assert.Equal(t, false, job["success"])
+	assert.Equal(t, "1 errors", job["error"])
+	contents, err := ioutil.ReadFile(filepath.Join(dst, "one.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(contents))
+
+	// The error from the copy mustn't stop this sync deleting
+	syncDst := makeDir(t, map[string]string{
+		"one.txt":   "hello",
+		"extra.txt": "delete me",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(syncDst))
+	}()
+	job = runJob(t, server, "sync", rc.Params{"srcFs": filepath.Join(dst, "two.txt"), "dstFs": syncDst})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+	_, err = os.Stat(filepath.Join(syncDst, "extra.txt"))
+	assert.True(t, os.IsNotExist(err))
+	contents, err = ioutil.ReadFile(filepath.Join(syncDst, "three.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "chips", string(contents))
+}
+
+func TestLimitsPerJob(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+	oldMaxTransfer := fs.Config.MaxTransfer
+	fs.Config.MaxTransfer = 1
+	defer func() {
+		fs.Config.MaxTransfer = oldMaxTransfer
+	}()
+	dir := makeDir(t, map[string]string{
+		"a/one.txt": "hello",
+		"b/two.txt": "potatoes",
+	})
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	// Each copy is allowed to start one transfer
+	for _, name := range []string{"a/one.txt", "b/two.txt"} {
+		src := filepath.Join(dir, filepath.Dir(name))
+		dst := filepath.Join(dir, "dst")
+		job := runJob(t, server, "copy", rc.Params{"srcFs": src, "dstFs": dst})
+		assert.Equal(t, true, job["success"], "error %v", job["error"])
+		_, err := os.Stat(filepath.Join(dst, filepath.Base(name)))
+		assert.NoError(t, err)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+	dir := makeDir(t, nil)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+
+	job := runJob(t, server, "mkdir", rc.Params{"fs": filepath.Join(dir, "a")})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+	job = runJob(t, server, "mkdir", rc.Params{"fs": filepath.Join(dir, "b")})
+	assert.Equal(t, true, job["success"], "error %v", job["error"])
+
+	status, out := call(t, server, "job/list", nil)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, []interface{}{1.0, 2.0}, out["jobids"])
+
+	status, out = call(t, server, "job/stop", rc.Params{"jobid": 2})
+	assert.Equal(t, http.StatusOK, status, "output %v", out)
+
+	status, out = call(t, server, "job/status", rc.Params{"jobid": 3})
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "job 3 not found", out["error"])
+
+	status, out = call(t, server, "job/status", rc.Params{"jobid": "potato"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, `parameter "jobid" must be an integer`, out["error"])
+}
+
+func TestErrors(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+
+	status, out := call(t, server, "operations/sync", rc.Params{"srcFs": "/tmp"})
+	assert.Equal(t, http.StatusBadRequest, status)
+	assert.Equal(t, `missing parameter "dstFs"`, out["error"])
+	assert.Equal(t, "operations/sync", out["path"])
+
+	status, out = call(t, server, "operations/potato", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, `unknown operation "potato"`, out["error"])
+
+	status, out = call(t, server, "potato", nil)
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, `couldn't find "potato"`, out["error"])
+
+	resp, err := http.Post(server.URL+"/job/list", "application/json", bytes.NewBufferString("{"))
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestStats(t *testing.T) {
+	server := httptest.NewServer(rc.NewServer())
+	defer server.Close()
+
+	status, out := call(t, server, "core/stats", nil)
+	assert.Equal(t, http.StatusOK, status)
+	stats := out["stats"].(map[string]interface{})
+	for _, key := range []string{"bytes", "speed", "errors", "checks", "transfers", "elapsedTime", "checking", "transferring"} {
+		assert.Contains(t, stats, key)
+	}
+}
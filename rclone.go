@@ -4,9 +4,11 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"runtime"
 	"runtime/pprof"
 	"strings"
@@ -16,8 +18,16 @@ import (
 
 	"github.com/ncw/rclone/fs"
 	_ "github.com/ncw/rclone/fs/all" // import all fs
+	"github.com/ncw/rclone/metrics"
+	"github.com/ncw/rclone/mount"
+	"github.com/ncw/rclone/rc"
+	"golang.org/x/net/context"
 )
 
+// exitCodeLimitReached is the exit status when --max-delete,
+// --max-transfer or --max-duration stopped the command
+const exitCodeLimitReached = 8
+
 // Globals
 var (
 	// Flags
@@ -27,6 +37,9 @@ var (
 	version       = pflag.BoolP("version", "V", false, "Print the version number")
 	logFile       = pflag.StringP("log-file", "", "", "Log everything to this file")
 	retries       = pflag.IntP("retries", "", 3, "Retry operations this many times if they fail")
+	jsonOutput    = pflag.BoolP("json", "", false, "Output ls, lsl, lsd, md5sum, sha1sum and size as JSON")
+	// Cancelled on interrupt for commands with Cancel set
+	ctx, cancel = context.WithCancel(context.Background())
 )
 
 // Command holds info about the current running command
@@ -39,6 +52,7 @@ type Command struct {
 	MaxArgs  int
 	NoStats  bool
 	Retry    bool
+	Cancel   bool // cancel ctx on the first interrupt rather than exiting
 }
 
 // checkArgs checks there are enough arguments and prints a message if not
@@ -64,11 +78,12 @@ var Commands = []Command{
         unchanged files, testing by size and modification time or
         MD5SUM.  Doesn't delete files from the destination.`,
 		Run: func(fdst, fsrc fs.Fs) error {
-			return fs.CopyDir(fdst, fsrc)
+			return fs.CopyDirContext(ctx, fdst, fsrc)
 		},
 		MinArgs: 2,
 		MaxArgs: 2,
 		Retry:   true,
+		Cancel:  true,
 	},
 	{
 		Name:     "sync",
@@ -80,11 +95,12 @@ var Commands = []Command{
         source, including deleting files if necessary.  Since this can
         cause data loss, test first with the --dry-run flag.`,
 		Run: func(fdst, fsrc fs.Fs) error {
-			return fs.Sync(fdst, fsrc)
+			return fs.SyncContext(ctx, fdst, fsrc)
 		},
 		MinArgs: 2,
 		MaxArgs: 2,
 		Retry:   true,
+		Cancel:  true,
 	},
 	{
 		Name:     "move",
@@ -95,11 +111,30 @@ var Commands = []Command{
         to speed it up. Since this can cause data loss, test first
         with the --dry-run flag.`,
 		Run: func(fdst, fsrc fs.Fs) error {
-			return fs.MoveDir(fdst, fsrc)
+			return fs.MoveDirContext(ctx, fdst, fsrc)
+		},
+		MinArgs: 2,
+		MaxArgs: 2,
+		Retry:   true,
+		Cancel:  true,
+	},
+	{
+		Name:     "bisync",
+		ArgsHelp: "path1:path path2:path",
+		Help: `
+        Sync the two paths in both directions.  New and modified files
+        are copied and deleted files are deleted on the other side,
+        found by comparing with the listings saved by the last run.
+        Files changed on both sides are resolved with
+        --bisync-conflict.  Since this can cause data loss, test first
+        with the --dry-run flag.`,
+		Run: func(fdst, fsrc fs.Fs) error {
+			return fs.Bisync(ctx, fsrc, fdst)
 		},
 		MinArgs: 2,
 		MaxArgs: 2,
 		Retry:   true,
+		Cancel:  true,
 	},
 	{
 		Name:     "ls",
@@ -107,6 +142,9 @@ var Commands = []Command{
 		Help: `
         List all the objects in the the path with size and path.`,
 		Run: func(fdst, fsrc fs.Fs) error {
+			if *jsonOutput {
+				return fs.ListJSON(fdst, os.Stdout, false, fs.HashNone)
+			}
 			return fs.List(fdst, os.Stdout)
 		},
 		MinArgs: 1,
@@ -118,6 +156,9 @@ var Commands = []Command{
 		Help: `
         List all directories/containers/buckets in the the path.`,
 		Run: func(fdst, fsrc fs.Fs) error {
+			if *jsonOutput {
+				return fs.ListDirJSON(fdst, os.Stdout)
+			}
 			return fs.ListDir(fdst, os.Stdout)
 		},
 		MinArgs: 1,
@@ -130,6 +171,9 @@ var Commands = []Command{
         List all the objects in the the path with modification time,
         size and path.`,
 		Run: func(fdst, fsrc fs.Fs) error {
+			if *jsonOutput {
+				return fs.ListJSON(fdst, os.Stdout, true, fs.HashNone)
+			}
 			return fs.ListLong(fdst, os.Stdout)
 		},
 		MinArgs: 1,
@@ -142,6 +186,9 @@ var Commands = []Command{
         Produces an md5sum file for all the objects in the path.  This
         is in the same format as the standard md5sum tool produces.`,
 		Run: func(fdst, fsrc fs.Fs) error {
+			if *jsonOutput {
+				return fs.ListJSON(fdst, os.Stdout, false, fs.HashMD5)
+			}
 			return fs.Md5sum(fdst, os.Stdout)
 		},
 		MinArgs: 1,
@@ -154,6 +201,9 @@ var Commands = []Command{
         Produces an sha1sum file for all the objects in the path.  This
         is in the same format as the standard sha1sum tool produces.`,
 		Run: func(fdst, fsrc fs.Fs) error {
+			if *jsonOutput {
+				return fs.ListJSON(fdst, os.Stdout, false, fs.HashSHA1)
+			}
 			return fs.Sha1sum(fdst, os.Stdout)
 		},
 		MinArgs: 1,
@@ -170,6 +220,12 @@ var Commands = []Command{
 			if err != nil {
 				return err
 			}
+			if *jsonOutput {
+				return json.NewEncoder(os.Stdout).Encode(map[string]int64{
+					"count": objects,
+					"bytes": size,
+				})
+			}
 			fmt.Printf("Total objects: %d\n", objects)
 			fmt.Printf("Total size: %v (%d bytes)\n", fs.SizeSuffix(size), size)
 			return nil
@@ -253,6 +309,35 @@ var Commands = []Command{
 		MinArgs: 1,
 		MaxArgs: 1,
 	},
+	{
+		Name:     "mount",
+		ArgsHelp: "remote:path /path/to/mountpoint",
+		Help: `
+        Mount the remote as a FUSE filesystem on the mountpoint given.
+        Directory listings are cached for --dir-cache-time.  Files
+        can only be written sequentially and can't be opened for read
+        and write at the same time.  Use fusermount -u
+        /path/to/mountpoint or Ctrl-C to unmount.  Linux, FreeBSD and
+        macOS only.`,
+		Run: func(fdst, fsrc fs.Fs) error {
+			return mount.Mount(fsrc, pflag.Args()[2])
+		},
+		MinArgs: 2,
+		MaxArgs: 2,
+		NoStats: true,
+	},
+	{
+		Name: "rcd",
+		Help: `
+        Run rclone as a daemon serving the remote control API on
+        --rc-addr.  This accepts JSON over HTTP to run sync, copy,
+        move, check, list, purge, delete, mkdir and rmdir as jobs
+        which can be polled and stopped, and to read the stats.`,
+		Run: func(fdst, fsrc fs.Fs) error {
+			return rc.Serve()
+		},
+		NoStats: true,
+	},
 	{
 		Name: "config",
 		Help: `
@@ -393,6 +478,22 @@ func StartStats() {
 	}()
 }
 
+// CancelOnInterrupt cancels ctx on the first interrupt so that
+// transfers in progress are stopped and cleaned up, and exits on the
+// second
+func CancelOnInterrupt() {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		fs.Log(nil, "Interrupted - stopping transfers (interrupt again to exit immediately)")
+		cancel()
+		<-interrupts
+		fs.Stats.Error()
+		log.Fatalf("Interrupted again - exiting")
+	}()
+}
+
 func main() {
 	ParseFlags()
 	if *version {
@@ -468,17 +569,22 @@ func main() {
 	if !command.NoStats {
 		StartStats()
 	}
+	metrics.Start()
 
 	// Exit if no command to run
 	if command.Run == nil {
 		return
 	}
 
+	if command.Cancel {
+		CancelOnInterrupt()
+	}
+
 	// Run the actual command
 	var err error
 	for try := 1; try <= *retries; try++ {
 		err = command.Run(fdst, fsrc)
-		if !command.Retry || (err == nil && !fs.Stats.Errored()) {
+		if !command.Retry || (err == nil && !fs.Stats.Errored()) || ctx.Err() != nil || fs.Limits.Err() != nil {
 			break
 		}
 		if err != nil {
@@ -490,6 +596,14 @@ func main() {
 			fs.Stats.ResetErrors()
 		}
 	}
+	if limitErr := fs.Limits.Err(); limitErr != nil {
+		if !command.NoStats {
+			fmt.Fprintln(os.Stderr, fs.Stats)
+		}
+		fmt.Fprintln(os.Stderr, fs.Limits)
+		log.Printf("Failed to %s: %v", command.Name, limitErr)
+		os.Exit(exitCodeLimitReached)
+	}
 	if err != nil {
 		log.Fatalf("Failed to %s: %v", command.Name, err)
 	}
@@ -4,12 +4,15 @@ package rest
 import (
 	"bytes"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 
 	"github.com/ncw/rclone/fs"
+	"golang.org/x/net/context"
+	"golang.org/x/net/context/ctxhttp"
 )
 
 // Client contains the info to sustain the API
@@ -18,6 +21,8 @@ type Client struct {
 	rootURL      string
 	errorHandler func(resp *http.Response) error
 	headers      map[string]string
+	userName     string // default username for Basic Auth
+	password     string // default password for Basic Auth
 }
 
 // NewClient takes an oauth http.Client and makes a new api instance
@@ -61,6 +66,13 @@ func (api *Client) SetHeader(key, value string) *Client {
 	return api
 }
 
+// SetUserPass sets the default username and password for Basic Auth
+// which is used unless overridden in the Opts
+func (api *Client) SetUserPass(UserName, Password string) *Client {
+	api.userName, api.password = UserName, Password
+	return api
+}
+
 // Opts contains parameters for Call, CallJSON etc
 type Opts struct {
 	Method        string
@@ -83,12 +95,25 @@ func DecodeJSON(resp *http.Response, result interface{}) (err error) {
 	return decoder.Decode(result)
 }
 
+// DecodeXML decodes resp.Body into result
+func DecodeXML(resp *http.Response, result interface{}) (err error) {
+	defer fs.CheckClose(resp.Body, &err)
+	decoder := xml.NewDecoder(resp.Body)
+	return decoder.Decode(result)
+}
+
 // Call makes the call and returns the http.Response
 //
 // if err != nil then resp.Body will need to be closed
 //
 // it will return resp if at all possible, even if err is set
 func (api *Client) Call(opts *Opts) (resp *http.Response, err error) {
+	return api.CallContext(context.Background(), opts)
+}
+
+// CallContext is like Call but the request is aborted if ctx is
+// cancelled
+func (api *Client) CallContext(ctx context.Context, opts *Opts) (resp *http.Response, err error) {
 	if opts == nil {
 		return nil, fmt.Errorf("call() called with nil opts")
 	}
@@ -131,8 +156,10 @@ func (api *Client) Call(opts *Opts) (resp *http.Response, err error) {
 	}
 	if opts.UserName != "" || opts.Password != "" {
 		req.SetBasicAuth(opts.UserName, opts.Password)
+	} else if api.userName != "" || api.password != "" {
+		req.SetBasicAuth(api.userName, api.password)
 	}
-	resp, err = api.c.Do(req)
+	resp, err = ctxhttp.Do(ctx, api.c, req)
 	if err != nil {
 		return nil, err
 	}
@@ -151,6 +178,12 @@ func (api *Client) Call(opts *Opts) (resp *http.Response, err error) {
 //
 // It will return resp if at all possible, even if err is set
 func (api *Client) CallJSON(opts *Opts, request interface{}, response interface{}) (resp *http.Response, err error) {
+	return api.CallJSONContext(context.Background(), opts, request, response)
+}
+
+// CallJSONContext is like CallJSON but the request is aborted if ctx
+// is cancelled
+func (api *Client) CallJSONContext(ctx context.Context, opts *Opts, request interface{}, response interface{}) (resp *http.Response, err error) {
 	// Set the body up as a JSON object if required
 	if opts.Body == nil && request != nil {
 		body, err := json.Marshal(request)
@@ -162,7 +195,7 @@ func (api *Client) CallJSON(opts *Opts, request interface{}, response interface{
 		newOpts.ContentType = "application/json"
 		opts = &newOpts
 	}
-	resp, err = api.Call(opts)
+	resp, err = api.CallContext(ctx, opts)
 	if err != nil {
 		return resp, err
 	}
@@ -172,3 +205,39 @@ func (api *Client) CallJSON(opts *Opts, request interface{}, response interface{
 	err = DecodeJSON(resp, response)
 	return resp, err
 }
+
+// CallXML runs Call and decodes the body as an XML object into response (if not nil)
+//
+// If request is not nil then it will be XML encoded as the body of the request
+//
+// It will return resp if at all possible, even if err is set
+func (api *Client) CallXML(opts *Opts, request interface{}, response interface{}) (resp *http.Response, err error) {
+	return api.CallXMLContext(context.Background(), opts, request, response)
+}
+
+// CallXMLContext is like CallXML but the request is aborted if ctx is
+// cancelled
+func (api *Client) CallXMLContext(ctx context.Context, opts *Opts, request interface{}, response interface{}) (resp *http.Response, err error) {
+	// Set the body up as an XML object if required
+	if opts.Body == nil && request != nil {
+		body, err := xml.Marshal(request)
+		if err != nil {
+			return nil, err
+		}
+		var newOpts = *opts
+		newOpts.Body = bytes.NewBuffer(append([]byte(xml.Header), body...))
+		if newOpts.ContentType == "" {
+			newOpts.ContentType = "application/xml; charset=utf-8"
+		}
+		opts = &newOpts
+	}
+	resp, err = api.CallContext(ctx, opts)
+	if err != nil {
+		return resp, err
+	}
+	if response == nil || opts.NoResponse {
+		return resp, nil
+	}
+	err = DecodeXML(resp, response)
+	return resp, err
+}
@@ -14,6 +14,7 @@ What happens if you CTRL-C a multipart upload
 */
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io"
@@ -22,6 +23,7 @@ import (
 	"path"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -36,6 +38,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/swift"
+	"golang.org/x/net/context"
 )
 
 // Register with Fs
@@ -140,9 +143,11 @@ func init() {
 
 // Constants
 const (
-	metaMtime     = "Mtime" // the meta key to store mtime in - eg X-Amz-Meta-Mtime
-	listChunkSize = 1024    // number of items to read at once
-	maxRetries    = 10      // number of retries to make of operations
+	metaMtime         = "Mtime"           // the meta key to store mtime in - eg X-Amz-Meta-Mtime
+	listChunkSize     = 1024              // number of items to read at once
+	maxRetries        = 10                // number of retries to make of operations
+	resumeCutoff      = 200 * 1024 * 1024 // files bigger than this are uploaded so they can be resumed
+	uploadConcurrency = 4                 // number of parts of those uploaded at once
 )
 
 // Fs represents a remote s3 server
@@ -368,65 +373,91 @@ func (f *Fs) NewFsObject(remote string) fs.Object {
 //
 // If directories is set it only sends directories
 func (f *Fs) list(directories bool, fn func(string, *s3.Object)) {
+	var err error
+	ctx := context.Background()
+	if directories {
+		err = f.listPrefix(ctx, f.root, true, nil, func(remote string) {
+			fn(remote, &s3.Object{Key: &remote})
+		})
+	} else {
+		err = f.listPrefix(ctx, f.root, false, fn, nil)
+	}
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Couldn't read bucket %q: %s", f.bucket, err)
+	}
+}
+
+// listPrefix lists the objects whose keys start with prefix into fn,
+// if it isn't nil.
+//
+// If delimited is set then only the objects directly under prefix
+// are listed and the directories under it are sent to fnDir.
+//
+// It stops with ctx.Err() if ctx is cancelled between pages.
+func (f *Fs) listPrefix(ctx context.Context, prefix string, delimited bool, fn func(string, *s3.Object), fnDir func(string)) error {
 	maxKeys := int64(listChunkSize)
 	delimiter := ""
-	if directories {
+	if delimited {
 		delimiter = "/"
 	}
 	var marker *string
 	for {
-		// FIXME need to implement ALL loop
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 		req := s3.ListObjectsInput{
 			Bucket:    &f.bucket,
 			Delimiter: &delimiter,
-			Prefix:    &f.root,
+			Prefix:    &prefix,
 			MaxKeys:   &maxKeys,
 			Marker:    marker,
 		}
 		resp, err := f.c.ListObjects(&req)
 		if err != nil {
-			fs.Stats.Error()
-			fs.ErrorLog(f, "Couldn't read bucket %q: %s", f.bucket, err)
-			break
-		} else {
-			rootLength := len(f.root)
-			if directories {
-				for _, commonPrefix := range resp.CommonPrefixes {
-					if commonPrefix.Prefix == nil {
-						fs.Log(f, "Nil common prefix received")
-						continue
-					}
-					remote := *commonPrefix.Prefix
-					if !strings.HasPrefix(remote, f.root) {
-						fs.Log(f, "Odd name received %q", remote)
-						continue
-					}
-					remote = remote[rootLength:]
-					if strings.HasSuffix(remote, "/") {
-						remote = remote[:len(remote)-1]
-					}
-					fn(remote, &s3.Object{Key: &remote})
+			return err
+		}
+		rootLength := len(f.root)
+		if delimited {
+			for _, commonPrefix := range resp.CommonPrefixes {
+				if commonPrefix.Prefix == nil {
+					fs.Log(f, "Nil common prefix received")
+					continue
 				}
-			} else {
-				for _, object := range resp.Contents {
-					key := aws.StringValue(object.Key)
-					if !strings.HasPrefix(key, f.root) {
-						fs.Log(f, "Odd name received %q", key)
-						continue
-					}
-					remote := key[rootLength:]
-					fn(remote, object)
+				remote := *commonPrefix.Prefix
+				if !strings.HasPrefix(remote, prefix) {
+					fs.Log(f, "Odd name received %q", remote)
+					continue
+				}
+				remote = remote[rootLength:]
+				if strings.HasSuffix(remote, "/") {
+					remote = remote[:len(remote)-1]
 				}
+				fnDir(remote)
 			}
-			if !aws.BoolValue(resp.IsTruncated) {
-				break
+		}
+		if fn != nil {
+			for _, object := range resp.Contents {
+				key := aws.StringValue(object.Key)
+				if !strings.HasPrefix(key, prefix) {
+					fs.Log(f, "Odd name received %q", key)
+					continue
+				}
+				remote := key[rootLength:]
+				fn(remote, object)
 			}
-			// Use NextMarker if set, otherwise use last Key
-			if resp.NextMarker == nil || *resp.NextMarker == "" {
-				marker = resp.Contents[len(resp.Contents)-1].Key
-			} else {
-				marker = resp.NextMarker
+		}
+		if !aws.BoolValue(resp.IsTruncated) {
+			return nil
+		}
+		// Use NextMarker if set, otherwise use last Key
+		if resp.NextMarker == nil || *resp.NextMarker == "" {
+			if len(resp.Contents) == 0 {
+				return nil
 			}
+			marker = resp.Contents[len(resp.Contents)-1].Key
+		} else {
+			marker = resp.NextMarker
 		}
 	}
 }
@@ -452,6 +483,33 @@ func (f *Fs) List() fs.ObjectsChan {
 	return out
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// using a delimited listing of its prefix
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	if f.bucket == "" {
+		return nil, nil, errors.New("can't list objects at root - choose a bucket using lsd")
+	}
+	prefix := f.root
+	if dir != "" {
+		prefix += dir + "/"
+	}
+	err = f.listPrefix(ctx, prefix, true, func(remote string, object *s3.Object) {
+		if o := f.newFsObjectWithInfo(remote, object); o != nil {
+			objects = append(objects, o)
+		}
+	}, func(remote string) {
+		dirs = append(dirs, &fs.Dir{
+			Name:  remote,
+			Bytes: -1,
+			Count: -1,
+		})
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read bucket %q: %v", f.bucket, err)
+	}
+	return objects, dirs, nil
+}
+
 // ListDir lists the buckets
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -732,10 +790,22 @@ func (o *Object) Storable() bool {
 
 // Open an object for read
 func (o *Object) Open() (in io.ReadCloser, err error) {
+	return o.open(nil)
+}
+
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	return o.open(aws.String(fs.RangeHeader(offset, length)))
+}
+
+// open the object for read, reading only byteRange if it is set
+func (o *Object) open(byteRange *string) (in io.ReadCloser, err error) {
 	key := o.fs.root + o.remote
 	req := s3.GetObjectInput{
 		Bucket: &o.fs.bucket,
 		Key:    &key,
+		Range:  byteRange,
 	}
 	resp, err := o.fs.c.GetObject(&req)
 	if err != nil {
@@ -744,6 +814,195 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	return resp.Body, nil
 }
 
+// uploadMultipart uploads in to key in parts, uploadConcurrency at a
+// time
+//
+// The upload ID and the parts uploaded are saved so that if the
+// upload is interrupted it can be resumed by a later upload of the
+// same src, otherwise it is aborted if the upload fails
+func (o *Object) uploadMultipart(in io.Reader, src fs.ObjectInfo, key, contentType string, metadata map[string]*string) (err error) {
+	size := src.Size()
+	// Make the parts big enough to fit the file into the maximum
+	// number of parts
+	partSize := int64(s3manager.MinUploadPartSize)
+	if size > partSize*s3manager.MaxUploadParts {
+		partSize = (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts
+	}
+
+	// Resume the saved upload if there is one and it still exists
+	state := fs.NewUploadState(o.fs, o.remote, src)
+	if state.Resuming() {
+		_, err = o.fs.c.ListParts(&s3.ListPartsInput{
+			Bucket:   &o.fs.bucket,
+			Key:      &key,
+			UploadId: &state.Session,
+			MaxParts: aws.Int64(1),
+		})
+		if err != nil {
+			fs.Log(o, "Can't resume multipart upload, starting again: %v", err)
+			state.Reset()
+		} else {
+			// Carry on after the parts uploaded in order - any
+			// parts after a gap are uploaded again
+			done := state.PartsDone()
+			state.Parts = state.Parts[:done]
+			state.Offset = done * partSize
+			if state.Offset > size {
+				state.Offset = size
+			}
+			// Skip the part of the file already uploaded
+			err = fs.Skip(in, state.Offset)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if !state.Resuming() {
+		fs.Debug(o, "Starting multipart upload")
+		resp, err := o.fs.c.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:      &o.fs.bucket,
+			ACL:         &o.fs.perm,
+			Key:         &key,
+			ContentType: &contentType,
+			Metadata:    metadata,
+		})
+		if err != nil {
+			return err
+		}
+		state.Session = *resp.UploadId
+		o.saveUploadState(state)
+	}
+	uploadID := state.Session
+
+	// Abort the upload if something went wrong unless it can be
+	// resumed
+	defer func() {
+		if err != nil {
+			if state.Resumable() {
+				fs.Debug(o, "Keeping multipart upload to resume")
+				return
+			}
+			fs.Debug(o, "Aborting multipart upload")
+			abortErr := o.fs.AbortUpload(state)
+			if abortErr != nil {
+				fs.Log(o, "Failed to abort multipart upload: %v", abortErr)
+			}
+			state.Remove()
+		}
+	}()
+
+	// Upload the parts
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex // protects state and uploadErr
+		uploadErr error
+		tokens    = make(chan struct{}, uploadConcurrency)
+	)
+	partNumber := int64(len(state.Parts))
+	for offset := state.Offset; offset < size; offset += partSize {
+		tokens <- struct{}{}
+		mu.Lock()
+		failed := uploadErr != nil
+		mu.Unlock()
+		if failed {
+			<-tokens
+			break
+		}
+		n := size - offset
+		if n > partSize {
+			n = partSize
+		}
+		buf := make([]byte, n)
+		_, err = io.ReadFull(in, buf)
+		if err != nil {
+			<-tokens
+			break
+		}
+		partNumber++
+		wg.Add(1)
+		go func(partNumber, offset, n int64, buf []byte) {
+			defer func() {
+				<-tokens
+				wg.Done()
+			}()
+			fs.Debug(o, "Uploading part %d offset %d/%d size %d", partNumber, offset, size, n)
+			resp, err := o.fs.c.UploadPart(&s3.UploadPartInput{
+				Bucket:        &o.fs.bucket,
+				Key:           &key,
+				UploadId:      &uploadID,
+				PartNumber:    &partNumber,
+				Body:          bytes.NewReader(buf),
+				ContentLength: &n,
+			})
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if uploadErr == nil {
+					uploadErr = err
+				}
+				return
+			}
+			// Record the part and how far the parts are
+			// uploaded in order
+			state.Parts = append(state.Parts, fs.UploadPart{Number: partNumber, ID: *resp.ETag})
+			state.Offset = state.PartsDone() * partSize
+			if state.Offset > size {
+				state.Offset = size
+			}
+			o.saveUploadState(state)
+		}(partNumber, offset, n, buf)
+	}
+	wg.Wait()
+	if err == nil {
+		err = uploadErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// Put the parts together
+	state.PartsDone() // sorts the parts
+	parts := make([]*s3.CompletedPart, len(state.Parts))
+	for i := range state.Parts {
+		parts[i] = &s3.CompletedPart{
+			ETag:       &state.Parts[i].ID,
+			PartNumber: &state.Parts[i].Number,
+		}
+	}
+	_, err = o.fs.c.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:   &o.fs.bucket,
+		Key:      &key,
+		UploadId: &uploadID,
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: parts,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	state.Remove()
+	return nil
+}
+
+// AbortUpload aborts the multipart upload in the saved state s
+func (f *Fs) AbortUpload(s *fs.UploadState) error {
+	key := f.root + s.Object
+	_, err := f.c.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+		Bucket:   &f.bucket,
+		Key:      &key,
+		UploadId: &s.Session,
+	})
+	return err
+}
+
+// saveUploadState saves the progress of a multipart upload
+func (o *Object) saveUploadState(state *fs.UploadState) {
+	err := state.Save()
+	if err != nil {
+		fs.Log(o, "Failed to save multipart upload state: %v", err)
+	}
+}
+
 // Update the Object from in with modTime and size
 func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
 	modTime := src.ModTime()
@@ -763,16 +1022,21 @@ func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
 	contentType := fs.MimeType(o)
 
 	key := o.fs.root + o.remote
-	req := s3manager.UploadInput{
-		Bucket:      &o.fs.bucket,
-		ACL:         &o.fs.perm,
-		Key:         &key,
-		Body:        in,
-		ContentType: &contentType,
-		Metadata:    metadata,
-		//ContentLength: &size,
-	}
-	_, err := uploader.Upload(&req)
+	var err error
+	if src.Size() > resumeCutoff {
+		err = o.uploadMultipart(in, src, key, contentType, metadata)
+	} else {
+		req := s3manager.UploadInput{
+			Bucket:      &o.fs.bucket,
+			ACL:         &o.fs.perm,
+			Key:         &key,
+			Body:        in,
+			ContentType: &contentType,
+			Metadata:    metadata,
+			//ContentLength: &size,
+		}
+		_, err = uploader.Upload(&req)
+	}
 	if err != nil {
 		return err
 	}
@@ -796,7 +1060,10 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = &Fs{}
-	_ fs.Copier = &Fs{}
-	_ fs.Object = &Object{}
+	_ fs.Fs            = &Fs{}
+	_ fs.Copier        = &Fs{}
+	_ fs.DirLister     = &Fs{}
+	_ fs.UploadAborter = &Fs{}
+	_ fs.Object        = &Object{}
+	_ fs.RangeOpener   = &Object{}
 )
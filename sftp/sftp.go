@@ -0,0 +1,703 @@
+// Package sftp provides a filesystem interface using github.com/pkg/sftp
+package sftp
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Register with Fs
+func init() {
+	fsi := &fs.RegInfo{
+		Name:        "sftp",
+		Description: "SSH/SFTP Connection",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "host",
+			Help: "SSH host to connect to",
+			Examples: []fs.OptionExample{{
+				Value: "example.com",
+				Help:  "Connect to example.com",
+			}},
+		}, {
+			Name:     "user",
+			Help:     "SSH username, leave blank for current username",
+			Optional: true,
+		}, {
+			Name:     "port",
+			Help:     "SSH port, leave blank to use default (22)",
+			Optional: true,
+		}, {
+			Name:       "pass",
+			Help:       "SSH password, leave blank to use ssh-agent or key_file",
+			IsPassword: true,
+			Optional:   true,
+		}, {
+			Name:     "key_file",
+			Help:     "Path to unencrypted PEM-encoded private key file, leave blank to use ssh-agent",
+			Optional: true,
+		}, {
+			Name:     "host_key",
+			Help:     "Public key the server must have in authorized_keys format, leave blank to check known_hosts_file",
+			Optional: true,
+		}, {
+			Name:     "known_hosts_file",
+			Help:     "Path to the known_hosts file to check the server's key against, leave blank to use ~/.ssh/known_hosts",
+			Optional: true,
+		}, {
+			Name:     "md5sum_command",
+			Help:     "Command run on the server to read MD5 hashes, leave blank to disable",
+			Optional: true,
+			Examples: []fs.OptionExample{{
+				Value: "md5sum",
+				Help:  "Use md5sum on Linux or similar",
+			}, {
+				Value: "md5 -r",
+				Help:  "Use md5 on BSD or macOS",
+			}},
+		}, {
+			Name:     "sha1sum_command",
+			Help:     "Command run on the server to read SHA1 hashes, leave blank to disable",
+			Optional: true,
+			Examples: []fs.OptionExample{{
+				Value: "sha1sum",
+				Help:  "Use sha1sum on Linux or similar",
+			}, {
+				Value: "shasum -a 1",
+				Help:  "Use shasum on BSD or macOS",
+			}},
+		}},
+	}
+	fs.Register(fsi)
+}
+
+// Fs stores the interface to the remote SFTP files
+type Fs struct {
+	name           string       // name of this remote
+	root           string       // the path we are working on
+	url            string       // description of the connection for String()
+	sshClient      *ssh.Client  // the SSH connection
+	sftpClient     *sftp.Client // the SFTP session over the SSH connection
+	md5sumCommand  string       // command to read MD5 hashes or ""
+	sha1sumCommand string       // command to read SHA1 hashes or ""
+}
+
+// Object is a remote SFTP file that has been stat'd (so it exists, but is not necessarily open for reading)
+type Object struct {
+	fs     *Fs
+	remote string
+	info   os.FileInfo
+	mu     sync.Mutex             // protects hashes
+	hashes map[fs.HashType]string // cached hashes
+}
+
+// ------------------------------------------------------------
+
+// readCurrentUser finds the current user name or "" if not found
+func readCurrentUser() (userName string) {
+	usr, err := user.Current()
+	if err == nil {
+		return usr.Username
+	}
+	// Fall back to reading $USER then $LOGNAME
+	userName = os.Getenv("USER")
+	if userName != "" {
+		return userName
+	}
+	return os.Getenv("LOGNAME")
+}
+
+// authMethods works out how to authenticate from the config
+//
+// A key file is preferred, then a password and finally ssh-agent
+func authMethods(name string) ([]ssh.AuthMethod, error) {
+	keyFile := fs.ConfigFile.MustValue(name, "key_file")
+	if keyFile != "" {
+		key, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key file: %v", err)
+		}
+		signer, err := ssh.ParsePrivateKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse private key file: %v", err)
+		}
+		return []ssh.AuthMethod{ssh.PublicKeys(signer)}, nil
+	}
+	pass := fs.ConfigFile.MustValue(name, "pass")
+	if pass != "" {
+		return []ssh.AuthMethod{ssh.Password(fs.Reveal(pass))}, nil
+	}
+	sshAgentSock := os.Getenv("SSH_AUTH_SOCK")
+	if sshAgentSock == "" {
+		return nil, fmt.Errorf("no key_file or pass configured and SSH_AUTH_SOCK not set for ssh-agent")
+	}
+	sshAgent, err := net.Dial("unix", sshAgentSock)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to ssh-agent: %v", err)
+	}
+	return []ssh.AuthMethod{ssh.PublicKeysCallback(agent.NewClient(sshAgent).Signers)}, nil
+}
+
+// hostKeyCallback works out how to check the server's host key from
+// the config
+//
+// If host_key is set then the server must have that key, otherwise
+// the key must be in known_hosts_file or ~/.ssh/known_hosts.  Servers
+// with unknown or changed keys are refused.
+func hostKeyCallback(name string) (ssh.HostKeyCallback, error) {
+	hostKey := fs.ConfigFile.MustValue(name, "host_key")
+	if hostKey != "" {
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(hostKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse host_key: %v", err)
+		}
+		return ssh.FixedHostKey(key), nil
+	}
+	knownHostsFile := fs.ConfigFile.MustValue(name, "known_hosts_file")
+	if knownHostsFile == "" {
+		knownHostsFile = filepath.Join(fs.HomeDir, ".ssh", "known_hosts")
+	}
+	callback, err := knownhosts.New(knownHostsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts to check the host key - set host_key or known_hosts_file: %v", err)
+	}
+	return callback, nil
+}
+
+// NewFs creates a new Fs object from the name and root. It connects to
+// the host specified in the config file.
+func NewFs(name, root string) (fs.Fs, error) {
+	host := fs.ConfigFile.MustValue(name, "host")
+	if host == "" {
+		return nil, fmt.Errorf("host not set in config file")
+	}
+	userName := fs.ConfigFile.MustValue(name, "user")
+	if userName == "" {
+		userName = readCurrentUser()
+	}
+	port := fs.ConfigFile.MustValue(name, "port", "22")
+	auth, err := authMethods(name)
+	if err != nil {
+		return nil, err
+	}
+	checkHostKey, err := hostKeyCallback(name)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ClientConfig{
+		User:            userName,
+		Auth:            auth,
+		HostKeyCallback: checkHostKey,
+		Timeout:         fs.Config.ConnectTimeout,
+	}
+	addr := net.JoinHostPort(host, port)
+	sshClient, err := ssh.Dial("tcp", addr, config)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't connect to %q: %v", addr, err)
+	}
+	sftpClient, err := sftp.NewClient(sshClient)
+	if err != nil {
+		_ = sshClient.Close()
+		return nil, fmt.Errorf("couldn't start SFTP session on %q: %v", addr, err)
+	}
+	f := &Fs{
+		name:           name,
+		root:           strings.TrimSuffix(root, "/"),
+		url:            "sftp://" + userName + "@" + addr + "/" + root,
+		sshClient:      sshClient,
+		sftpClient:     sftpClient,
+		md5sumCommand:  fs.ConfigFile.MustValue(name, "md5sum_command"),
+		sha1sumCommand: fs.ConfigFile.MustValue(name, "sha1sum_command"),
+	}
+	if f.root != "" {
+		// Check to see if the root points to a file
+		info, err := f.sftpClient.Stat(f.root)
+		if err == nil && info.Mode().IsRegular() {
+			// It is a file, so use the parent as the root
+			var remote string
+			f.root, remote = path.Split(f.root)
+			f.root = strings.TrimSuffix(f.root, "/")
+			obj := f.NewFsObject(remote)
+			// return a Fs Limited to this object
+			return fs.NewLimited(f, obj), nil
+		}
+	}
+	return f, nil
+}
+
+// Name returns the configured name of the file system
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root returns the root for the filesystem
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String returns the URL for the filesystem
+func (f *Fs) String() string {
+	return f.url
+}
+
+// absPath returns the path of remote on the server
+//
+// Paths which aren't absolute are relative to the login directory
+func (f *Fs) absPath(remote string) string {
+	p := path.Join(f.root, remote)
+	if p == "" {
+		p = "."
+	}
+	return p
+}
+
+// newObject makes an Object from remote and its info
+func (f *Fs) newObject(remote string, info os.FileInfo) *Object {
+	return &Object{
+		fs:     f,
+		remote: remote,
+		info:   info,
+	}
+}
+
+// NewFsObject finds the Object at remote.  Returns nil if can't be found
+func (f *Fs) NewFsObject(remote string) fs.Object {
+	o := f.newObject(remote, nil)
+	err := o.stat()
+	if err != nil {
+		fs.Debug(o, "Failed to stat: %v", err)
+		return nil
+	}
+	if !o.info.Mode().IsRegular() {
+		fs.Debug(o, "Not a regular file")
+		return nil
+	}
+	return o
+}
+
+// list the objects in dir recursively into out
+func (f *Fs) list(out fs.ObjectsChan, dir string) {
+	infos, err := f.sftpClient.ReadDir(f.absPath(dir))
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Failed to read directory %q: %v", dir, err)
+		return
+	}
+	for _, info := range infos {
+		remote := path.Join(dir, info.Name())
+		switch {
+		case info.IsDir():
+			f.list(out, remote)
+		case info.Mode().IsRegular():
+			out <- f.newObject(remote, info)
+		default:
+			fs.Debug(remote, "Can't transfer non file/directory")
+		}
+	}
+}
+
+// List the files and directories starting at <f.root>
+func (f *Fs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		f.list(out, "")
+	}()
+	return out
+}
+
+// ListDir lists the directories
+func (f *Fs) ListDir() fs.DirChan {
+	out := make(fs.DirChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		infos, err := f.sftpClient.ReadDir(f.absPath(""))
+		if err != nil {
+			fs.Stats.Error()
+			fs.ErrorLog(f, "Failed to read directory: %v", err)
+			return
+		}
+		for _, info := range infos {
+			if info.IsDir() {
+				out <- &fs.Dir{
+					Name:  info.Name(),
+					When:  info.ModTime(),
+					Bytes: -1,
+					Count: -1,
+				}
+			}
+		}
+	}()
+	return out
+}
+
+// Put data from <in> into a new remote sftp file object described by <src.Remote()> and <src.ModTime()>
+func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	o := f.newObject(src.Remote(), nil)
+	err := o.Update(in, src)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// mkdirAll makes dir and any parent directories needed
+func (f *Fs) mkdirAll(dir string) error {
+	if dir == "" || dir == "." || dir == "/" {
+		return nil
+	}
+	info, err := f.sftpClient.Stat(dir)
+	if err == nil {
+		if info.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%q already exists and is not a directory", dir)
+	}
+	err = f.mkdirAll(path.Dir(dir))
+	if err != nil {
+		return err
+	}
+	err = f.sftpClient.Mkdir(dir)
+	if err != nil {
+		return fmt.Errorf("mkdir %q failed: %v", dir, err)
+	}
+	return nil
+}
+
+// Mkdir makes the root directory of the Fs object
+func (f *Fs) Mkdir() error {
+	return f.mkdirAll(f.absPath(""))
+}
+
+// Rmdir removes the root directory of the Fs object
+//
+// If it isn't empty it will return an error
+func (f *Fs) Rmdir() error {
+	return f.sftpClient.Remove(f.absPath(""))
+}
+
+// Precision is the remote sftp file system's modtime precision
+func (f *Fs) Precision() time.Duration {
+	return time.Second
+}
+
+// removeAll removes dir and all of its contents
+func (f *Fs) removeAll(dir string) error {
+	infos, err := f.sftpClient.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		p := path.Join(dir, info.Name())
+		if info.IsDir() {
+			err = f.removeAll(p)
+		} else {
+			err = f.sftpClient.Remove(p)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return f.sftpClient.Remove(dir)
+}
+
+// Purge deletes all the files and directories
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge() error {
+	return f.removeAll(f.absPath(""))
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(src fs.Object, remote string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debug(src, "Can't move - not same remote type")
+		return nil, fs.ErrorCantMove
+	}
+	dstObj := f.newObject(remote, nil)
+	dstPath := dstObj.path()
+	err := f.mkdirAll(path.Dir(dstPath))
+	if err != nil {
+		return nil, err
+	}
+	// SFTP rename fails if the destination exists so remove it
+	// first if it is a file
+	err = dstObj.stat()
+	if err == nil {
+		if !dstObj.info.Mode().IsRegular() {
+			return nil, fmt.Errorf("can't move file onto non-file")
+		}
+		err = f.sftpClient.Remove(dstPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove existing file: %v", err)
+		}
+	}
+	err = f.sftpClient.Rename(srcObj.path(), dstPath)
+	if err != nil {
+		return nil, fmt.Errorf("move failed: %v", err)
+	}
+	err = dstObj.stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat moved file: %v", err)
+	}
+	return dstObj, nil
+}
+
+// DirMove moves src directory to this remote using server side move
+// operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(src fs.Fs) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debug(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	srcPath, dstPath := srcFs.absPath(""), f.absPath("")
+
+	// Check if source exists and is a directory
+	info, err := f.sftpClient.Stat(srcPath)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fs.ErrorCantDirMove
+	}
+
+	// Check if destination exists
+	_, err = f.sftpClient.Stat(dstPath)
+	if err == nil {
+		return fs.ErrorDirExists
+	}
+
+	// Make sure the parent exists then do the move
+	err = f.mkdirAll(path.Dir(dstPath))
+	if err != nil {
+		return err
+	}
+	err = f.sftpClient.Rename(srcPath, dstPath)
+	if err != nil {
+		return fmt.Errorf("dir move failed: %v", err)
+	}
+	return nil
+}
+
+// Hashes returns the supported hash types of the filesystem
+//
+// This depends on which hash commands have been configured
+func (f *Fs) Hashes() fs.HashSet {
+	set := fs.HashSet(fs.HashNone)
+	if f.md5sumCommand != "" {
+		set.Add(fs.HashMD5)
+	}
+	if f.sha1sumCommand != "" {
+		set.Add(fs.HashSHA1)
+	}
+	return set
+}
+
+// ------------------------------------------------------------
+
+// Fs is the filesystem this remote sftp file object is located within
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// String returns the URL to the remote SFTP file
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote the name of the remote SFTP file, relative to the fs root
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// path returns the path of the object on the server
+func (o *Object) path() string {
+	return o.fs.absPath(o.remote)
+}
+
+// shellEscape quotes s so it can be passed to the remote shell
+func shellEscape(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// runHashCommand runs the command on the server to read the hash of
+// the object, parsing the output in the md5sum/sha1sum format
+func (o *Object) runHashCommand(command string) (string, error) {
+	session, err := o.fs.sshClient.NewSession()
+	if err != nil {
+		return "", fmt.Errorf("failed to start SSH session: %v", err)
+	}
+	defer func() {
+		_ = session.Close()
+	}()
+	output, err := session.Output(command + " " + shellEscape(o.path()))
+	if err != nil {
+		return "", fmt.Errorf("failed to run %q: %v", command, err)
+	}
+	fields := strings.Fields(string(output))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("no output from %q", command)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// Hash returns the selected checksum of the file
+// If no checksum is available it returns ""
+func (o *Object) Hash(r fs.HashType) (string, error) {
+	var command string
+	switch r {
+	case fs.HashMD5:
+		command = o.fs.md5sumCommand
+	case fs.HashSHA1:
+		command = o.fs.sha1sumCommand
+	}
+	if command == "" {
+		return "", fs.ErrHashUnsupported
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if hash, ok := o.hashes[r]; ok {
+		return hash, nil
+	}
+	hash, err := o.runHashCommand(command)
+	if err != nil {
+		return "", err
+	}
+	if o.hashes == nil {
+		o.hashes = make(map[fs.HashType]string)
+	}
+	o.hashes[r] = hash
+	return hash, nil
+}
+
+// Size returns the size in bytes of the remote sftp file
+func (o *Object) Size() int64 {
+	return o.info.Size()
+}
+
+// ModTime returns the modification time of the remote sftp file
+func (o *Object) ModTime() time.Time {
+	return o.info.ModTime()
+}
+
+// stat updates the info field in the Object
+func (o *Object) stat() error {
+	info, err := o.fs.sftpClient.Stat(o.path())
+	if err != nil {
+		return err
+	}
+	o.info = info
+	return nil
+}
+
+// SetModTime sets the modification and access time to the specified time
+func (o *Object) SetModTime(modTime time.Time) {
+	err := o.fs.sftpClient.Chtimes(o.path(), modTime, modTime)
+	if err != nil {
+		fs.Debug(o, "Failed to set mtime on file: %v", err)
+		return
+	}
+	// Re-read metadata
+	err = o.stat()
+	if err != nil {
+		fs.Debug(o, "Failed to stat: %v", err)
+	}
+}
+
+// Storable returns whether the remote sftp file is a regular file (not a directory, symbolic link, block device, character device, named pipe, etc)
+func (o *Object) Storable() bool {
+	return o.info.Mode().IsRegular()
+}
+
+// Open a remote sftp file object for reading
+func (o *Object) Open() (io.ReadCloser, error) {
+	sftpFile, err := o.fs.sftpClient.Open(o.path())
+	if err != nil {
+		return nil, fmt.Errorf("open failed: %v", err)
+	}
+	return sftpFile, nil
+}
+
+// Update a remote sftp file using the data <in> and ModTime from <src>
+func (o *Object) Update(in io.Reader, src fs.ObjectInfo) error {
+	o.mu.Lock()
+	o.hashes = nil
+	o.mu.Unlock()
+	err := o.fs.mkdirAll(path.Dir(o.path()))
+	if err != nil {
+		return err
+	}
+	file, err := o.fs.sftpClient.Create(o.path())
+	if err != nil {
+		return fmt.Errorf("update create failed: %v", err)
+	}
+	remove := func() {
+		removeErr := o.fs.sftpClient.Remove(o.path())
+		if removeErr != nil {
+			fs.Debug(o, "Failed to remove partial file: %v", removeErr)
+		}
+	}
+	_, err = file.ReadFrom(in)
+	if err != nil {
+		_ = file.Close()
+		remove()
+		return fmt.Errorf("update ReadFrom failed: %v", err)
+	}
+	err = file.Close()
+	if err != nil {
+		remove()
+		return fmt.Errorf("update Close failed: %v", err)
+	}
+	o.SetModTime(src.ModTime())
+	return o.stat()
+}
+
+// Remove a remote sftp file object
+func (o *Object) Remove() error {
+	return o.fs.sftpClient.Remove(o.path())
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs       = &Fs{}
+	_ fs.Purger   = &Fs{}
+	_ fs.Mover    = &Fs{}
+	_ fs.DirMover = &Fs{}
+	_ fs.Object   = &Object{}
+)
@@ -0,0 +1,172 @@
+package sftp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fstest/fstests"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Credentials for the test server
+const (
+	testUser = "rclone"
+	testPass = "potato"
+)
+
+// Create the TestSftp: remote pointing at an SSH server started in
+// this process which serves files from a temporary directory.
+func init() {
+	dir, err := ioutil.TempDir("", "rclone-sftp-test")
+	if err != nil {
+		log.Fatalf("Failed to make temp dir: %v", err)
+	}
+	// The server serves relative paths from the current directory
+	err = os.Chdir(dir)
+	if err != nil {
+		log.Fatalf("Failed to change to temp dir: %v", err)
+	}
+	addr, hostKey, err := startServer()
+	if err != nil {
+		log.Fatalf("Failed to start SSH server: %v", err)
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		log.Fatalf("Bad server address: %v", err)
+	}
+	name := "TestSftp"
+	fstests.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "sftp"},
+		{Name: name, Key: "host", Value: host},
+		{Name: name, Key: "port", Value: port},
+		{Name: name, Key: "user", Value: testUser},
+		{Name: name, Key: "pass", Value: fs.Obscure(testPass)},
+		{Name: name, Key: "host_key", Value: hostKey},
+		{Name: name, Key: "md5sum_command", Value: "md5sum"},
+		{Name: name, Key: "sha1sum_command", Value: "sha1sum"},
+	}
+}
+
+// startServer starts an SSH server on a random local port returning
+// its address and its host key in authorized_keys format
+func startServer() (string, string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return "", "", err
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == testUser && string(pass) == testPass {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %q", c.User())
+		},
+	}
+	config.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", "", err
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("SSH server accept failed: %v", err)
+				return
+			}
+			go serveConn(conn, config)
+		}
+	}()
+	hostKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	return listener.Addr().String(), hostKey, nil
+}
+
+// serveConn runs the SSH protocol on conn
+func serveConn(conn net.Conn, config *ssh.ServerConfig) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		log.Printf("SSH handshake failed: %v", err)
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			log.Printf("SSH channel accept failed: %v", err)
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+// readString reads an SSH wire format string from the payload
+func readString(payload []byte) string {
+	if len(payload) < 4 {
+		return ""
+	}
+	n := binary.BigEndian.Uint32(payload)
+	if uint32(len(payload)-4) < n {
+		return ""
+	}
+	return string(payload[4 : 4+n])
+}
+
+// serveSession handles the "sftp" subsystem and "exec" requests on a
+// session channel
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer func() {
+		_ = channel.Close()
+	}()
+	for req := range requests {
+		switch req.Type {
+		case "subsystem":
+			if readString(req.Payload) != "sftp" {
+				_ = req.Reply(false, nil)
+				continue
+			}
+			_ = req.Reply(true, nil)
+			server, err := pkgsftp.NewServer(channel)
+			if err != nil {
+				log.Printf("SFTP server failed to start: %v", err)
+				return
+			}
+			_ = server.Serve()
+			return
+		case "exec":
+			_ = req.Reply(true, nil)
+			cmd := exec.Command("sh", "-c", readString(req.Payload))
+			cmd.Stdout = channel
+			cmd.Stderr = channel.Stderr()
+			status := 0
+			err := cmd.Run()
+			if err != nil {
+				status = 1
+			}
+			exitStatus := make([]byte, 4)
+			binary.BigEndian.PutUint32(exitStatus, uint32(status))
+			_, _ = channel.SendRequest("exit-status", false, exitStatus)
+			return
+		default:
+			_ = req.Reply(false, nil)
+		}
+	}
+}
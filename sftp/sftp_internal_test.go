@@ -0,0 +1,78 @@
+package sftp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const hostKeyRemote = "TestSftpHostKey"
+
+// newHostKey makes a new random host key
+func newHostKey(t *testing.T) ssh.PublicKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer.PublicKey()
+}
+
+// setHostKeyConfig sets the host key config of the test remote
+func setHostKeyConfig(hostKey, knownHostsFile string) {
+	fs.ConfigFile.SetValue(hostKeyRemote, "host_key", hostKey)
+	fs.ConfigFile.SetValue(hostKeyRemote, "known_hosts_file", knownHostsFile)
+}
+
+func TestHostKeyCallbackHostKey(t *testing.T) {
+	fs.LoadConfig()
+	key, otherKey := newHostKey(t), newHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+
+	setHostKeyConfig(string(ssh.MarshalAuthorizedKey(key)), "")
+	callback, err := hostKeyCallback(hostKeyRemote)
+	require.NoError(t, err)
+	assert.NoError(t, callback("127.0.0.1:2222", addr, key))
+	assert.Error(t, callback("127.0.0.1:2222", addr, otherKey))
+
+	setHostKeyConfig("potato", "")
+	_, err = hostKeyCallback(hostKeyRemote)
+	assert.Error(t, err)
+}
+
+func TestHostKeyCallbackKnownHosts(t *testing.T) {
+	fs.LoadConfig()
+	key, otherKey := newHostKey(t), newHostKey(t)
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2222}
+	dir, err := ioutil.TempDir("", "rclone-sftp-known-hosts")
+	require.NoError(t, err)
+	defer func() {
+		require.NoError(t, os.RemoveAll(dir))
+	}()
+	knownHostsFile := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize("127.0.0.1:2222")}, key)
+	require.NoError(t, ioutil.WriteFile(knownHostsFile, []byte(line+"\n"), 0600))
+
+	setHostKeyConfig("", knownHostsFile)
+	callback, err := hostKeyCallback(hostKeyRemote)
+	require.NoError(t, err)
+	assert.NoError(t, callback("127.0.0.1:2222", addr, key))
+	assert.Error(t, callback("127.0.0.1:2222", addr, otherKey), "changed key")
+	otherAddr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 2222}
+	assert.Error(t, callback("127.0.0.2:2222", otherAddr, key), "unknown host")
+
+	// A missing known_hosts file must fail rather than allow any key
+	setHostKeyConfig("", filepath.Join(dir, "potato"))
+	_, err = hostKeyCallback(hostKeyRemote)
+	assert.Error(t, err)
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/swift"
 	"github.com/spf13/pflag"
+	"golang.org/x/net/context"
 )
 
 // Constants
@@ -338,6 +339,53 @@ func (f *Fs) List() fs.ObjectsChan {
 	return f.listFiles(false)
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	if f.container == "" {
+		return nil, nil, errors.New("can't list objects at root - choose a container using lsd")
+	}
+	prefix := f.root
+	if dir != "" {
+		prefix += dir + "/"
+	}
+	opts := swift.ObjectsOpts{
+		Prefix:    prefix,
+		Delimiter: '/',
+		Limit:     256,
+	}
+	rootLength := len(f.root)
+	err = f.c.ObjectsWalk(f.container, &opts, func(opts *swift.ObjectsOpts) (interface{}, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		swiftObjects, err := f.c.Objects(f.container, opts)
+		if err == nil {
+			for i := range swiftObjects {
+				object := &swiftObjects[i]
+				if !strings.HasPrefix(object.Name, prefix) {
+					fs.Log(f, "Odd name received %q", object.Name)
+					continue
+				}
+				remote := object.Name[rootLength:]
+				if strings.HasSuffix(remote, "/") {
+					dirs = append(dirs, &fs.Dir{
+						Name:  remote[:len(remote)-1],
+						Bytes: -1,
+						Count: -1,
+					})
+				} else if o := f.newFsObjectWithInfo(remote, object); o != nil && o.Storable() {
+					objects = append(objects, o)
+				}
+			}
+		}
+		return swiftObjects, err
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("couldn't read container %q: %v", f.container, err)
+	}
+	return objects, dirs, nil
+}
+
 // ListDir lists the containers
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -423,7 +471,7 @@ func (f *Fs) Precision() time.Duration {
 //
 // Implemented here so we can make sure we delete directory markers
 func (f *Fs) Purge() error {
-	fs.DeleteFiles(f.listFiles(true))
+	fs.DeleteFiles(context.Background(), f.listFiles(true))
 	return f.Rmdir()
 }
 
@@ -585,6 +633,16 @@ func (o *Object) Open() (in io.ReadCloser, err error) {
 	return
 }
 
+// OpenRange opens the object for read starting at offset and
+// returning at most length bytes or to the end if length is negative
+//
+// The MD5SUM can't be checked as only part of the object is read
+func (o *Object) OpenRange(offset, length int64) (in io.ReadCloser, err error) {
+	headers := swift.Headers{"Range": fs.RangeHeader(offset, length)}
+	in, _, err = o.fs.c.ObjectOpen(o.fs.container, o.fs.root+o.remote, false, headers)
+	return
+}
+
 // min returns the smallest of x, y
 func min(x, y int64) int64 {
 	if x < y {
@@ -720,8 +778,10 @@ func (o *Object) Remove() error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = &Fs{}
-	_ fs.Purger = &Fs{}
-	_ fs.Copier = &Fs{}
-	_ fs.Object = &Object{}
+	_ fs.Fs          = &Fs{}
+	_ fs.Purger      = &Fs{}
+	_ fs.Copier      = &Fs{}
+	_ fs.DirLister   = &Fs{}
+	_ fs.Object      = &Object{}
+	_ fs.RangeOpener = &Object{}
 )
@@ -0,0 +1,132 @@
+// Package api has type definitions for webdav
+package api
+
+import (
+	"encoding/xml"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Multistatus contains the responses returned from an HTTP 207
+// return code
+type Multistatus struct {
+	Responses []Response `xml:"DAV: response"`
+}
+
+// Response contains an Href the response is about and its properties
+type Response struct {
+	Href     string     `xml:"DAV: href"`
+	Propstat []Propstat `xml:"DAV: propstat"`
+}
+
+// Props returns the properties which were returned with a 2xx status
+// and whether any were found
+func (r *Response) Props() (props *Prop, found bool) {
+	for i := range r.Propstat {
+		if r.Propstat[i].StatusOK() {
+			return &r.Propstat[i].Prop, true
+		}
+	}
+	return nil, false
+}
+
+// Propstat is a set of properties with a common status
+type Propstat struct {
+	Status string `xml:"DAV: status"`
+	Prop   Prop   `xml:"DAV: prop"`
+}
+
+// statusRegexp matches the status line, eg "HTTP/1.1 200 OK"
+var statusRegexp = regexp.MustCompile(`^HTTP/[0-9.]+\s+(\d+)`)
+
+// StatusOK examines the Status and returns an OK flag
+func (p *Propstat) StatusOK() bool {
+	match := statusRegexp.FindStringSubmatch(strings.TrimSpace(p.Status))
+	if match == nil {
+		return false
+	}
+	code, err := strconv.Atoi(match[1])
+	if err != nil {
+		return false
+	}
+	return code >= 200 && code < 300
+}
+
+// Prop is the properties of a response
+type Prop struct {
+	Type     *struct{} `xml:"DAV: resourcetype>collection"`
+	Size     int64     `xml:"DAV: getcontentlength"`
+	Modified Time      `xml:"DAV: getlastmodified"`
+}
+
+// IsCollection returns true if the properties are for a collection
+// (directory)
+func (p *Prop) IsCollection() bool {
+	return p.Type != nil
+}
+
+// Time represents date and time information for the webdav API
+// marshalling to and from http.TimeFormat
+type Time time.Time
+
+// MarshalXML turns a Time into XML
+func (t *Time) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	timeString := (*time.Time)(t).UTC().Format(http.TimeFormat)
+	return e.EncodeElement(timeString, start)
+}
+
+// UnmarshalXML turns XML into a Time
+func (t *Time) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	var v string
+	err := d.DecodeElement(&v, &start)
+	if err != nil {
+		return err
+	}
+	if v == "" {
+		*t = Time(time.Time{})
+		return nil
+	}
+	newT, err := http.ParseTime(v)
+	if err != nil {
+		return err
+	}
+	*t = Time(newT)
+	return nil
+}
+
+// Error is used to describe webdav errors
+//
+// <d:error xmlns:d="DAV:" xmlns:s="http://sabredav.org/ns">
+//   <s:exception>Sabre\DAV\Exception\NotFound</s:exception>
+//   <s:message>File with name Photo could not be located</s:message>
+// </d:error>
+type Error struct {
+	Exception  string `xml:"exception,omitempty"`
+	Message    string `xml:"message,omitempty"`
+	Status     string `xml:"-"` // populated from the HTTP response
+	StatusCode int    `xml:"-"` // populated from the HTTP response
+}
+
+// Error returns a string for the error and satisfies the error interface
+func (e *Error) Error() string {
+	var out []string
+	if e.Message != "" {
+		out = append(out, e.Message)
+	}
+	if e.Exception != "" {
+		out = append(out, e.Exception)
+	}
+	if e.Status != "" {
+		out = append(out, e.Status)
+	}
+	if len(out) == 0 {
+		return "Webdav Error"
+	}
+	return strings.Join(out, ": ")
+}
+
+// Check Error satisfies the error interface
+var _ error = (*Error)(nil)
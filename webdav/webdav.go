@@ -0,0 +1,857 @@
+// Package webdav provides an interface to the Webdav
+// object storage system.
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/pacer"
+	"github.com/ncw/rclone/rest"
+	"github.com/ncw/rclone/webdav/api"
+	"golang.org/x/net/context"
+)
+
+const (
+	minSleep      = 10 * time.Millisecond
+	maxSleep      = 2 * time.Second
+	decayConstant = 2 // bigger for slower decay, exponential
+)
+
+// Register with Fs
+func init() {
+	fs.Register(&fs.RegInfo{
+		Name:        "webdav",
+		Description: "Webdav",
+		NewFs:       NewFs,
+		Options: []fs.Option{{
+			Name: "url",
+			Help: "URL of http host to connect to",
+			Examples: []fs.OptionExample{{
+				Value: "https://example.com",
+				Help:  "Connect to example.com",
+			}},
+		}, {
+			Name: "vendor",
+			Help: "Name of the Webdav site/service/software you are using",
+			Examples: []fs.OptionExample{{
+				Value: "nextcloud",
+				Help:  "Nextcloud",
+			}, {
+				Value: "owncloud",
+				Help:  "Owncloud",
+			}, {
+				Value: "other",
+				Help:  "Other site/service or software",
+			}},
+		}, {
+			Name:     "user",
+			Help:     "User name",
+			Optional: true,
+		}, {
+			Name:       "pass",
+			Help:       "Password.",
+			IsPassword: true,
+			Optional:   true,
+		}},
+	})
+}
+
+// Fs represents a remote webdav
+type Fs struct {
+	name        string       // name of this remote
+	root        string       // the path we are working on
+	endpoint    *url.URL     // URL of the host, ending in /
+	endpointURL string       // endpoint as a string
+	srv         *rest.Client // the connection to the server
+	pacer       *pacer.Pacer // pacer for API calls
+	vendor      string       // name of the vendor
+	canSetTime  bool         // set if the server can set modification times
+}
+
+// Object describes a webdav object
+//
+// Will definitely have info but maybe not meta
+type Object struct {
+	fs          *Fs       // what this object is part of
+	remote      string    // The remote path
+	hasMetaData bool      // whether info below has been set
+	size        int64     // size of the object
+	modTime     time.Time // modification time of the object
+}
+
+// ------------------------------------------------------------
+
+// Name of the remote (as passed into NewFs)
+func (f *Fs) Name() string {
+	return f.name
+}
+
+// Root of the remote (as passed into NewFs)
+func (f *Fs) Root() string {
+	return f.root
+}
+
+// String converts this Fs to a string
+func (f *Fs) String() string {
+	return fmt.Sprintf("webdav root '%s'", f.root)
+}
+
+// retryErrorCodes is a slice of error codes that we will retry
+var retryErrorCodes = []int{
+	423, // Locked
+	429, // Too Many Requests.
+	500, // Internal Server Error
+	502, // Bad Gateway
+	503, // Service Unavailable
+	504, // Gateway Timeout
+	509, // Bandwidth Limit Exceeded
+}
+
+// shouldRetry returns a boolean as to whether this resp and err
+// deserve to be retried.  It returns the err as a convenience
+func shouldRetry(resp *http.Response, err error) (bool, error) {
+	return fs.ShouldRetry(err) || fs.ShouldRetryHTTP(resp, retryErrorCodes), err
+}
+
+// errorHandler parses a non 2xx error response into an error
+func errorHandler(resp *http.Response) error {
+	// Decode error response
+	errResponse := new(api.Error)
+	err := rest.DecodeXML(resp, &errResponse)
+	if err != nil {
+		// Servers don't always return an XML body so ignore that
+		fs.Debug(nil, "Couldn't decode error response: %v", err)
+	}
+	errResponse.Status = resp.Status
+	errResponse.StatusCode = resp.StatusCode
+	return errResponse
+}
+
+// propfindBody is sent with PROPFIND requests to ask for the
+// properties rclone needs
+const propfindBody = `<?xml version="1.0" encoding="utf-8" ?>
+<d:propfind xmlns:d="DAV:">
+ <d:prop>
+  <d:resourcetype />
+  <d:getcontentlength />
+  <d:getlastmodified />
+ </d:prop>
+</d:propfind>
+`
+
+// propfind runs a PROPFIND request on the path with the depth given
+func (f *Fs) propfind(remotePath string, depth string) (result *api.Multistatus, resp *http.Response, err error) {
+	opts := rest.Opts{
+		Method: "PROPFIND",
+		Path:   f.urlPath(remotePath),
+		ExtraHeaders: map[string]string{
+			"Depth": depth,
+		},
+		ContentType: "application/xml; charset=utf-8",
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		opts.Body = strings.NewReader(propfindBody)
+		result = new(api.Multistatus)
+		resp, err = f.srv.CallXML(&opts, nil, result)
+		return shouldRetry(resp, err)
+	})
+	return result, resp, err
+}
+
+// readMetaDataForPath reads the metadata from the path returning
+// found = false if it doesn't exist
+func (f *Fs) readMetaDataForPath(remotePath string) (info *api.Prop, found bool, err error) {
+	result, resp, err := f.propfind(remotePath, "0")
+	if err != nil {
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	if len(result.Responses) < 1 {
+		return nil, false, fmt.Errorf("no responses returned for %q", remotePath)
+	}
+	info, found = result.Responses[0].Props()
+	if !found {
+		return nil, false, fmt.Errorf("no properties returned for %q", remotePath)
+	}
+	return info, true, nil
+}
+
+// NewFs constructs an Fs from the path, container:path
+func NewFs(name, root string) (fs.Fs, error) {
+	endpoint := fs.ConfigFile.MustValue(name, "url")
+	if endpoint == "" {
+		return nil, fmt.Errorf("url not set in config file")
+	}
+	if !strings.HasSuffix(endpoint, "/") {
+		endpoint += "/"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse url %q: %v", endpoint, err)
+	}
+	user := fs.ConfigFile.MustValue(name, "user")
+	pass := fs.ConfigFile.MustValue(name, "pass")
+	if pass != "" {
+		pass = fs.Reveal(pass)
+	}
+	vendor := fs.ConfigFile.MustValue(name, "vendor")
+	root = strings.Trim(root, "/")
+
+	f := &Fs{
+		name:        name,
+		root:        root,
+		endpoint:    u,
+		endpointURL: u.String(),
+		srv:         rest.NewClient(fs.Config.Client()).SetRoot(u.String()).SetUserPass(user, pass),
+		pacer:       pacer.New().SetMinSleep(minSleep).SetMaxSleep(maxSleep).SetDecayConstant(decayConstant).SetName("webdav"),
+	}
+	f.srv.SetErrorHandler(errorHandler)
+	err = f.setVendor(vendor)
+	if err != nil {
+		return nil, err
+	}
+
+	if root != "" {
+		// Check to see if the root is actually an existing file
+		info, found, err := f.readMetaDataForPath("")
+		if err == nil && found && !info.IsCollection() {
+			// It is a file, so use the parent as the root
+			newF := *f
+			var remote string
+			newF.root, remote = path.Split(root)
+			newF.root = strings.TrimSuffix(newF.root, "/")
+			obj := newF.newObjectWithInfo(remote, info)
+			// return a Fs Limited to this object
+			return fs.NewLimited(&newF, obj), nil
+		}
+	}
+	return f, nil
+}
+
+// setVendor sets the vendor dependent config
+func (f *Fs) setVendor(vendor string) error {
+	f.vendor = vendor
+	switch vendor {
+	case "owncloud", "nextcloud":
+		// These support the X-OC-Mtime header on PUT and
+		// PROPPATCH of lastmodified to set the modtime
+		f.canSetTime = true
+	case "other", "":
+	default:
+		return fmt.Errorf("unknown vendor %q", vendor)
+	}
+	return nil
+}
+
+// escapePath URL escapes the path p which is relative to the endpoint
+func escapePath(p string) string {
+	u := url.URL{Path: p}
+	return strings.TrimPrefix(u.EscapedPath(), "/")
+}
+
+// urlPath returns the escaped path of remote relative to the endpoint
+func (f *Fs) urlPath(remote string) string {
+	return escapePath(path.Join(f.root, remote))
+}
+
+// absURL returns the full URL of remote for use in a Destination
+// header
+func (f *Fs) absURL(remote string) string {
+	return f.endpointURL + f.urlPath(remote)
+}
+
+// Return an Object from a path
+//
+// May return nil if an error occurred
+func (f *Fs) newObjectWithInfo(remote string, info *api.Prop) fs.Object {
+	o := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	if info != nil {
+		// Set info
+		o.setMetaData(info)
+	} else {
+		err := o.readMetaData() // reads info and meta, returning an error
+		if err != nil {
+			// logged already fs.Debug("Failed to read info: %s", err)
+			return nil
+		}
+	}
+	return o
+}
+
+// NewFsObject returns an Object from a path
+//
+// May return nil if an error occurred
+func (f *Fs) NewFsObject(remote string) fs.Object {
+	return f.newObjectWithInfo(remote, nil)
+}
+
+// listAllFn is the user function to process an item from listAll
+type listAllFn func(remote string, isDir bool, info *api.Prop)
+
+// listAll lists the directory dir calling fn on each item found
+func (f *Fs) listAll(dir string, fn listAllFn) error {
+	result, _, err := f.propfind(dir, "1")
+	if err != nil {
+		return err
+	}
+	// The hrefs returned are URL paths from the root of the server
+	dirPath := strings.TrimSuffix(path.Join(f.endpoint.Path, f.root, dir), "/") + "/"
+	for i := range result.Responses {
+		item := &result.Responses[i]
+		u, err := url.Parse(item.Href)
+		if err != nil {
+			fs.Debug(f, "Ignoring item with bad href %q: %v", item.Href, err)
+			continue
+		}
+		if !strings.HasPrefix(u.Path, dirPath) {
+			fs.Debug(f, "Ignoring item %q which isn't in %q", u.Path, dirPath)
+			continue
+		}
+		leaf := strings.Trim(u.Path[len(dirPath):], "/")
+		if leaf == "" {
+			// the directory itself
+			continue
+		}
+		info, found := item.Props()
+		if !found {
+			fs.Debug(f, "Ignoring item %q with no properties", leaf)
+			continue
+		}
+		fn(path.Join(dir, leaf), info.IsCollection(), info)
+	}
+	return nil
+}
+
+// list the objects in dir recursively into out
+func (f *Fs) list(out fs.ObjectsChan, dir string) {
+	var dirs []string
+	err := f.listAll(dir, func(remote string, isDir bool, info *api.Prop) {
+		if isDir {
+			dirs = append(dirs, remote)
+			return
+		}
+		if o := f.newObjectWithInfo(remote, info); o != nil {
+			out <- o
+		}
+	})
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(f, "Couldn't list directory %q: %v", dir, err)
+		return
+	}
+	for _, dir := range dirs {
+		f.list(out, dir)
+	}
+}
+
+// List walks the path returning a channel of Objects
+func (f *Fs) List() fs.ObjectsChan {
+	out := make(fs.ObjectsChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		f.list(out, "")
+	}()
+	return out
+}
+
+// ListDir lists the directories
+func (f *Fs) ListDir() fs.DirChan {
+	out := make(fs.DirChan, fs.Config.Checkers)
+	go func() {
+		defer close(out)
+		err := f.listAll("", func(remote string, isDir bool, info *api.Prop) {
+			if !isDir {
+				return
+			}
+			out <- &fs.Dir{
+				Name:  remote,
+				When:  time.Time(info.Modified),
+				Bytes: -1,
+				Count: -1,
+			}
+		})
+		if err != nil {
+			fs.Stats.Error()
+			fs.ErrorLog(f, "ListDir failed: %v", err)
+		}
+	}()
+	return out
+}
+
+// Put the object into the container
+//
+// Copy the reader in to the new object which is returned
+//
+// The new object may have been created if an error is returned
+func (f *Fs) Put(in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	return f.PutContext(context.Background(), in, src)
+}
+
+// PutContext is like Put but the upload is aborted if ctx is cancelled
+func (f *Fs) PutContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (fs.Object, error) {
+	o := &Object{
+		fs:     f,
+		remote: src.Remote(),
+	}
+	return o, o.UpdateContext(ctx, in, src)
+}
+
+// mkcol makes the directory dirPath which is relative to the endpoint
+func (f *Fs) mkcol(dirPath string) (resp *http.Response, err error) {
+	opts := rest.Opts{
+		Method:     "MKCOL",
+		Path:       escapePath(dirPath) + "/",
+		NoResponse: true,
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err = f.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+	return resp, err
+}
+
+// mkdirAll makes dirPath, which is relative to the endpoint, and any
+// parent directories which don't exist
+func (f *Fs) mkdirAll(dirPath string) error {
+	if dirPath == "" || dirPath == "." {
+		return nil
+	}
+	resp, err := f.mkcol(dirPath)
+	if err == nil || resp == nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusMethodNotAllowed:
+		// Directory already exists
+		return nil
+	case http.StatusConflict:
+		// Parent doesn't exist so make it then try again
+		err = f.mkdirAll(path.Dir(dirPath))
+		if err != nil {
+			return err
+		}
+		_, err = f.mkcol(dirPath)
+	}
+	return err
+}
+
+// mkParentDir makes the parent of remote if necessary
+func (f *Fs) mkParentDir(remote string) error {
+	return f.mkdirAll(path.Dir(path.Join(f.root, remote)))
+}
+
+// Mkdir creates the directory if it doesn't exist
+func (f *Fs) Mkdir() error {
+	return f.mkdirAll(f.root)
+}
+
+// purgeCheck removes the root directory, if check is set then it
+// refuses to do so if it has anything in
+func (f *Fs) purgeCheck(check bool) error {
+	if check {
+		info, found, err := f.readMetaDataForPath("")
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("directory not found")
+		}
+		if !info.IsCollection() {
+			return fmt.Errorf("not a directory")
+		}
+		empty := true
+		err = f.listAll("", func(string, bool, *api.Prop) {
+			empty = false
+		})
+		if err != nil {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("directory not empty")
+		}
+	}
+	opts := rest.Opts{
+		Method:     "DELETE",
+		Path:       f.urlPath("") + "/",
+		NoResponse: true,
+	}
+	return f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+}
+
+// Rmdir deletes the root folder
+//
+// Returns an error if it isn't empty
+func (f *Fs) Rmdir() error {
+	return f.purgeCheck(true)
+}
+
+// Precision return the precision of this Fs
+func (f *Fs) Precision() time.Duration {
+	if f.canSetTime {
+		return time.Second
+	}
+	return fs.ModTimeNotSupported
+}
+
+// copyOrMove does a server side COPY or MOVE of src to remote
+func (f *Fs) copyOrMove(src fs.Object, remote string, method string) (fs.Object, error) {
+	srcObj, ok := src.(*Object)
+	if !ok {
+		fs.Debug(src, "Can't %s - not same remote type", strings.ToLower(method))
+		if method == "COPY" {
+			return nil, fs.ErrorCantCopy
+		}
+		return nil, fs.ErrorCantMove
+	}
+	err := f.mkParentDir(remote)
+	if err != nil {
+		return nil, err
+	}
+	opts := rest.Opts{
+		Method:     method,
+		Path:       srcObj.fs.urlPath(srcObj.remote),
+		NoResponse: true,
+		ExtraHeaders: map[string]string{
+			"Destination": f.absURL(remote),
+			"Overwrite":   "T",
+		},
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %v", strings.ToLower(method), err)
+	}
+	dstObj := &Object{
+		fs:     f,
+		remote: remote,
+	}
+	err = dstObj.readMetaData()
+	if err != nil {
+		return nil, err
+	}
+	// Not all servers preserve the modification time on COPY so
+	// set it if possible
+	if method == "COPY" && f.canSetTime && !dstObj.modTime.Equal(srcObj.ModTime()) {
+		dstObj.SetModTime(srcObj.ModTime())
+	}
+	return dstObj, nil
+}
+
+// Copy src to this remote using server side copy operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantCopy
+func (f *Fs) Copy(src fs.Object, remote string) (fs.Object, error) {
+	return f.copyOrMove(src, remote, "COPY")
+}
+
+// Move src to this remote using server side move operations.
+//
+// This is stored with the remote path given
+//
+// It returns the destination Object and a possible error
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantMove
+func (f *Fs) Move(src fs.Object, remote string) (fs.Object, error) {
+	return f.copyOrMove(src, remote, "MOVE")
+}
+
+// DirMove moves src directory to this remote using server side move
+// operations.
+//
+// Will only be called if src.Fs().Name() == f.Name()
+//
+// If it isn't possible then return fs.ErrorCantDirMove
+//
+// If destination exists then return fs.ErrorDirExists
+func (f *Fs) DirMove(src fs.Fs) error {
+	srcFs, ok := src.(*Fs)
+	if !ok {
+		fs.Debug(src, "Can't move directory - not same remote type")
+		return fs.ErrorCantDirMove
+	}
+	// Check if destination exists
+	_, found, err := f.readMetaDataForPath("")
+	if err != nil {
+		return err
+	}
+	if found {
+		return fs.ErrorDirExists
+	}
+	// Make sure the parent directory exists
+	err = f.mkParentDir("")
+	if err != nil {
+		return err
+	}
+	opts := rest.Opts{
+		Method:     "MOVE",
+		Path:       srcFs.urlPath("") + "/",
+		NoResponse: true,
+		ExtraHeaders: map[string]string{
+			"Destination": f.absURL("") + "/",
+			"Overwrite":   "F",
+		},
+	}
+	err = f.pacer.Call(func() (bool, error) {
+		resp, err := f.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return fmt.Errorf("dir move failed: %v", err)
+	}
+	return nil
+}
+
+// Purge deletes all the files and the container
+//
+// Optional interface: Only implement this if you have a way of
+// deleting all the files quicker than just running Remove() on the
+// result of List()
+func (f *Fs) Purge() error {
+	return f.purgeCheck(false)
+}
+
+// Hashes returns the supported hash sets.
+func (f *Fs) Hashes() fs.HashSet {
+	return fs.HashSet(fs.HashNone)
+}
+
+// ------------------------------------------------------------
+
+// Fs returns the parent Fs
+func (o *Object) Fs() fs.Info {
+	return o.fs
+}
+
+// Return a string version
+func (o *Object) String() string {
+	if o == nil {
+		return "<nil>"
+	}
+	return o.remote
+}
+
+// Remote returns the remote path
+func (o *Object) Remote() string {
+	return o.remote
+}
+
+// Hash is not supported by webdav
+func (o *Object) Hash(t fs.HashType) (string, error) {
+	return "", fs.ErrHashUnsupported
+}
+
+// Size returns the size of an object in bytes
+func (o *Object) Size() int64 {
+	err := o.readMetaData()
+	if err != nil {
+		fs.Log(o, "Failed to read metadata: %s", err)
+		return 0
+	}
+	return o.size
+}
+
+// setMetaData sets the metadata from info
+func (o *Object) setMetaData(info *api.Prop) {
+	o.hasMetaData = true
+	o.size = info.Size
+	o.modTime = time.Time(info.Modified)
+}
+
+// readMetaData gets the metadata if it hasn't already been fetched
+//
+// it also sets the info
+func (o *Object) readMetaData() (err error) {
+	if o.hasMetaData {
+		return nil
+	}
+	info, found, err := o.fs.readMetaDataForPath(o.remote)
+	if err == nil && !found {
+		err = fmt.Errorf("object not found")
+	}
+	if err == nil && info.IsCollection() {
+		err = fmt.Errorf("is a directory not a file")
+	}
+	if err != nil {
+		fs.Debug(o, "Failed to read info: %s", err)
+		return err
+	}
+	o.setMetaData(info)
+	return nil
+}
+
+// ModTime returns the modification time of the object
+//
+// It attempts to read the objects mtime and if that isn't present the
+// LastModified returned in the http headers
+func (o *Object) ModTime() time.Time {
+	err := o.readMetaData()
+	if err != nil {
+		fs.Log(o, "Failed to read metadata: %s", err)
+		return time.Now()
+	}
+	return o.modTime
+}
+
+// proppatchBody is sent with PROPPATCH requests to set the
+// modification time - %d is replaced with the unix time
+const proppatchBody = `<?xml version="1.0" encoding="utf-8" ?>
+<d:propertyupdate xmlns:d="DAV:">
+ <d:set>
+  <d:prop>
+   <d:lastmodified>%d</d:lastmodified>
+  </d:prop>
+ </d:set>
+</d:propertyupdate>
+`
+
+// SetModTime sets the modification time of the remote object
+//
+// This is only possible on servers which support it
+func (o *Object) SetModTime(modTime time.Time) {
+	if !o.fs.canSetTime {
+		fs.Debug(o, "Can't set modification time on this webdav server")
+		return
+	}
+	opts := rest.Opts{
+		Method:      "PROPPATCH",
+		Path:        o.fs.urlPath(o.remote),
+		ContentType: "application/xml; charset=utf-8",
+		NoResponse:  true,
+	}
+	err := o.fs.pacer.Call(func() (bool, error) {
+		opts.Body = strings.NewReader(fmt.Sprintf(proppatchBody, modTime.Unix()))
+		resp, err := o.fs.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(o, "Failed to update remote mtime: %v", err)
+		return
+	}
+	// Re-read metadata
+	o.hasMetaData = false
+	err = o.readMetaData()
+	if err != nil {
+		fs.Stats.Error()
+		fs.ErrorLog(o, "Failed to read metadata: %v", err)
+	}
+}
+
+// Storable returns a boolean showing whether this object storable
+func (o *Object) Storable() bool {
+	return true
+}
+
+// Open an object for read
+func (o *Object) Open() (in io.ReadCloser, err error) {
+	var resp *http.Response
+	opts := rest.Opts{
+		Method: "GET",
+		Path:   o.fs.urlPath(o.remote),
+	}
+	err = o.fs.pacer.Call(func() (bool, error) {
+		resp, err = o.fs.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, err
+}
+
+// Update the object with the contents of the io.Reader, modTime and size
+//
+// The modification time is sent in the X-OC-Mtime header if the
+// server supports it
+//
+// The new object may have been created if an error is returned
+func (o *Object) Update(in io.Reader, src fs.ObjectInfo) (err error) {
+	return o.UpdateContext(context.Background(), in, src)
+}
+
+// UpdateContext is like Update but the upload is aborted if ctx is
+// cancelled
+func (o *Object) UpdateContext(ctx context.Context, in io.Reader, src fs.ObjectInfo) (err error) {
+	err = o.fs.mkParentDir(o.remote)
+	if err != nil {
+		return fmt.Errorf("update mkParentDir failed: %v", err)
+	}
+	size := src.Size()
+	opts := rest.Opts{
+		Method:      "PUT",
+		Path:        o.fs.urlPath(o.remote),
+		Body:        in,
+		NoResponse:  true,
+		ContentType: "application/octet-stream",
+	}
+	if size >= 0 {
+		opts.ContentLength = &size
+	}
+	if o.fs.canSetTime {
+		opts.ExtraHeaders = map[string]string{
+			"X-OC-Mtime": strconv.FormatInt(src.ModTime().Unix(), 10),
+		}
+	}
+	// Can't retry the upload as the reader has been consumed
+	err = o.fs.pacer.CallNoRetryContext(ctx, func() (bool, error) {
+		resp, err := o.fs.srv.CallContext(ctx, &opts)
+		return shouldRetry(resp, err)
+	})
+	if err != nil {
+		return err
+	}
+	// Read the metadata back
+	o.hasMetaData = false
+	return o.readMetaData()
+}
+
+// Remove an object
+func (o *Object) Remove() error {
+	opts := rest.Opts{
+		Method:     "DELETE",
+		Path:       o.fs.urlPath(o.remote),
+		NoResponse: true,
+	}
+	return o.fs.pacer.Call(func() (bool, error) {
+		resp, err := o.fs.srv.Call(&opts)
+		return shouldRetry(resp, err)
+	})
+}
+
+// Check the interfaces are satisfied
+var (
+	_ fs.Fs             = (*Fs)(nil)
+	_ fs.Purger         = (*Fs)(nil)
+	_ fs.Copier         = (*Fs)(nil)
+	_ fs.Mover          = (*Fs)(nil)
+	_ fs.DirMover       = (*Fs)(nil)
+	_ fs.ContextPutter  = (*Fs)(nil)
+	_ fs.Object         = (*Object)(nil)
+	_ fs.ContextUpdater = (*Object)(nil)
+)
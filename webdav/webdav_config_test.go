@@ -0,0 +1,132 @@
+package webdav_test
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/ncw/rclone/fs"
+	"github.com/ncw/rclone/fstest/fstests"
+	"golang.org/x/net/webdav"
+)
+
+// Credentials for the test server
+const (
+	testUser = "rclone"
+	testPass = "potato"
+)
+
+// Create the TestWebdav: remote pointing at a webdav server started
+// in this process which serves files from a temporary directory.
+func init() {
+	dir, err := ioutil.TempDir("", "rclone-webdav-test")
+	if err != nil {
+		log.Fatalf("Failed to make temp dir: %v", err)
+	}
+	server := httptest.NewServer(newHandler(dir))
+	name := "TestWebdav"
+	fstests.ExtraConfig = []fstests.ExtraConfigItem{
+		{Name: name, Key: "type", Value: "webdav"},
+		{Name: name, Key: "url", Value: server.URL + "/dav/"},
+		{Name: name, Key: "vendor", Value: "owncloud"},
+		{Name: name, Key: "user", Value: testUser},
+		{Name: name, Key: "pass", Value: fs.Obscure(testPass)},
+	}
+}
+
+// handler serves webdav from a directory, adding the ways of setting
+// the modification time that owncloud supports
+type handler struct {
+	dir string
+	dav *webdav.Handler
+}
+
+func newHandler(dir string) *handler {
+	return &handler{
+		dir: dir,
+		dav: &webdav.Handler{
+			Prefix:     "/dav",
+			FileSystem: webdav.Dir(dir),
+			LockSystem: webdav.NewMemLS(),
+		},
+	}
+}
+
+// localPath returns the path in the directory of the request
+func (h *handler) localPath(r *http.Request) string {
+	return filepath.Join(h.dir, filepath.FromSlash(r.URL.Path[len(h.dav.Prefix):]))
+}
+
+// setModTime sets the modification time of the file for the request
+// from a unix time string
+func (h *handler) setModTime(r *http.Request, unixTime string) error {
+	secs, err := strconv.ParseInt(unixTime, 10, 64)
+	if err != nil {
+		return err
+	}
+	modTime := time.Unix(secs, 0)
+	return os.Chtimes(h.localPath(r), modTime, modTime)
+}
+
+// ServeHTTP checks the credentials then serves the request
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, pass, ok := r.BasicAuth()
+	if !ok || user != testUser || pass != testPass {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch r.Method {
+	case "PUT":
+		mtime := r.Header.Get("X-OC-Mtime")
+		if mtime == "" {
+			break
+		}
+		rw := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		h.dav.ServeHTTP(rw, r)
+		if rw.status >= 200 && rw.status < 300 {
+			err := h.setModTime(r, mtime)
+			if err != nil {
+				log.Printf("Failed to set mtime: %v", err)
+			}
+		}
+		return
+	case "PROPPATCH":
+		var update struct {
+			LastModified string `xml:"DAV: set>prop>lastmodified"`
+		}
+		err := xml.NewDecoder(r.Body).Decode(&update)
+		if err != nil || update.LastModified == "" {
+			http.Error(w, "Bad PROPPATCH", http.StatusBadRequest)
+			return
+		}
+		err = h.setModTime(r, update.LastModified)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = fmt.Fprintf(w, `<?xml version="1.0" encoding="utf-8"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href><d:propstat><d:prop><d:lastmodified/></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, r.URL.Path)
+		return
+	}
+	h.dav.ServeHTTP(w, r)
+}
+
+// statusRecorder records the status written to a ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
@@ -16,6 +16,7 @@ import (
 	"github.com/ncw/rclone/fs"
 	"github.com/ncw/rclone/oauthutil"
 	yandex "github.com/ncw/rclone/yandex/api"
+	"golang.org/x/net/context"
 	"golang.org/x/oauth2"
 )
 
@@ -288,6 +289,57 @@ func (o *Object) readMetaData() (err error) {
 	return nil
 }
 
+// ListDirectory lists the objects and directories directly inside dir
+// a page of items at a time
+func (f *Fs) ListDirectory(ctx context.Context, dir string) (objects []fs.Object, dirs []*fs.Dir, err error) {
+	dirPath, prefix := f.diskRoot, ""
+	if dir != "" {
+		dirPath, prefix = f.diskRoot+dir+"/", dir+"/"
+	}
+	var limit uint32 = 1000 // max number of items per request
+	var offset uint32       // for the next page of request
+	opt := yandex.ResourceInfoRequestOptions{
+		Limit:  &limit,
+		Offset: &offset,
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+		info, err := f.yd.NewResourceInfoRequest(dirPath, opt).Exec()
+		if err != nil {
+			return nil, nil, fmt.Errorf("couldn't list %q: %v", dirPath, err)
+		}
+		if info.ResourceType != "dir" || info.Embedded == nil {
+			return nil, nil, fmt.Errorf("%q is not a directory", dirPath)
+		}
+		items := info.Embedded.Items
+		for i := range items {
+			item := &items[i]
+			remote := prefix + item.Name
+			switch item.ResourceType {
+			case "dir":
+				d := &fs.Dir{
+					Name:  remote,
+					Bytes: -1,
+					Count: -1,
+				}
+				d.When, _ = time.Parse(time.RFC3339Nano, item.Modified)
+				dirs = append(dirs, d)
+			case "file":
+				if o := f.newFsObjectWithInfo(remote, item); o != nil {
+					objects = append(objects, o)
+				}
+			}
+		}
+		offset += uint32(len(items))
+		if uint32(len(items)) < limit {
+			break
+		}
+	}
+	return objects, dirs, nil
+}
+
 // ListDir walks the path returning a channel of FsObjects
 func (f *Fs) ListDir() fs.DirChan {
 	out := make(fs.DirChan, fs.Config.Checkers)
@@ -351,17 +403,29 @@ func (f *Fs) Mkdir() error {
 //
 // Returns an error if it isn't empty
 func (f *Fs) Rmdir() error {
-	return f.purgeCheck(true)
+	return f.purgeCheck(f.diskRoot, true)
+}
+
+// MkdirPath makes the directory dir and any parents it needs
+func (f *Fs) MkdirPath(dir string) error {
+	return mkDirFullPath(f.yd, f.diskRoot+dir+"/")
 }
 
-// purgeCheck remotes the root directory, if check is set then it
-// refuses to do so if it has anything in
-func (f *Fs) purgeCheck(check bool) error {
+// RmdirPath removes the directory dir
+//
+// Returns an error if it isn't empty
+func (f *Fs) RmdirPath(dir string) error {
+	return f.purgeCheck(f.diskRoot+dir+"/", true)
+}
+
+// purgeCheck remotes the directory at dirPath, if check is set then
+// it refuses to do so if it has anything in
+func (f *Fs) purgeCheck(dirPath string, check bool) error {
 	if check {
 		//to comply with rclone logic we check if the directory is empty before delete.
 		//send request to get list of objects in this directory.
 		var opt yandex.ResourceInfoRequestOptions
-		ResourceInfoResponse, err := f.yd.NewResourceInfoRequest(f.diskRoot, opt).Exec()
+		ResourceInfoResponse, err := f.yd.NewResourceInfoRequest(dirPath, opt).Exec()
 		if err != nil {
 			return fmt.Errorf("Rmdir failed: %s", err)
 		}
@@ -370,7 +434,7 @@ func (f *Fs) purgeCheck(check bool) error {
 		}
 	}
 	//delete directory
-	return f.yd.Delete(f.diskRoot, true)
+	return f.yd.Delete(dirPath, true)
 }
 
 // Precision return the precision of this Fs
@@ -384,7 +448,7 @@ func (f *Fs) Precision() time.Duration {
 // deleting all the files quicker than just running Remove() on the
 // result of List()
 func (f *Fs) Purge() error {
-	return f.purgeCheck(false)
+	return f.purgeCheck(f.diskRoot, false)
 }
 
 // Hashes returns the supported hash sets.
@@ -550,8 +614,10 @@ func mkDirFullPath(client *yandex.Client, path string) error {
 
 // Check the interfaces are satisfied
 var (
-	_ fs.Fs     = (*Fs)(nil)
-	_ fs.Purger = (*Fs)(nil)
+	_ fs.Fs        = (*Fs)(nil)
+	_ fs.Purger    = (*Fs)(nil)
+	_ fs.DirLister = (*Fs)(nil)
+	_ fs.DirMaker  = (*Fs)(nil)
 	//_ fs.Copier = (*Fs)(nil)
 	_ fs.Object = (*Object)(nil)
 )